@@ -20,32 +20,154 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ServiceClient interface {
 	// ListUsers returns a paginated list of users, users can be filtered by:
-	// first_name, last_name, nickname, email and country.
+	// first_name, last_name, nickname, email and country, with filters or filter fields.
+	// Deleted users are listed only when show_deleted is set.
 	// In case of invalid params returns: INVALID_ARGUMENT error.
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// SearchUsers finds users whose nickname, first_name, last_name or email match all words of a query,
+	// case-insensitively, by a prefix, a whole word or with a few typos, most relevant users first.
+	// Deleted users are never found. When the query is empty returns INVALID_ARGUMENT error.
+	SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error)
 	// GetUser retrieves a user by its id.
 	// When id is invalid returns INVALID_ARGUMENT and
-	// NOT_FOUND error when user with such id doesn't exist.
+	// NOT_FOUND error when user with such id doesn't exist, or is deleted and show_deleted isn't set.
 	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
-	// CreateUser creates a user.
-	// When request validation failed returns INVALID_ARGUMENT and
-	// ALREADY_EXISTS error when email or nickname are already taken.
+	// CreateUser creates a user and sends an email verification token to its email.
+	// When request validation failed or the password doesn't satisfy the password policy returns INVALID_ARGUMENT and
+	// ALREADY_EXISTS error when email or nickname are already taken, deleted users keep them until they are purged.
 	CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error)
 	// UpdatePassword takes user's email, old password and new password as params and when user is found and
 	// old password matches database password,
 	// updates it with a new password, otherwise returns respectively NOT_FOUND or PERMISSION_DENIED error
-	// or INVALID_ARGUMENT when email is invalid.
+	// or INVALID_ARGUMENT when email is invalid or the new password doesn't satisfy the password policy.
+	// Old passwords are checked like passwords of Authenticate, so RESOURCE_EXHAUSTED is returned too.
+	// Users with two-factor authentication should pass two_factor_code, like to Authenticate.
 	UpdatePassword(ctx context.Context, in *UpdatePasswordRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// UpdateUser updates user's first_name, last_name nickname, email and country
 	// applying field_mask. User is identified using CreateUserRequest.user.id field.
 	// When id is invalid returns INVALID_ARGUMENT and
-	// NOT_FOUND error when user with such id doesn't exist,
+	// NOT_FOUND error when user with such id doesn't exist or is deleted,
 	// and ALREADY_EXISTS error when there a conflict (email or nickname were already taken).
+	// When user.etag is set and the user was changed since, returns ABORTED error without updating it.
+	// A change of the email resets email_verified, which is in update_mask of the published event,
+	// and sends a verification token to the new email.
 	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*User, error)
-	// DeleteUser permanently deletes user with a provided id.
+	// DeleteUser marks user with a provided id deleted, it's permanently deleted with its credentials
+	// after the retention period, until then it can be restored with UndeleteUser.
 	// Returns INVALID_ARGUMENT in case of invalid id and
-	// NOT_FOUND when user with a gived id doesn't exist.
+	// NOT_FOUND when user with a gived id doesn't exist or is already deleted.
+	// When etag is set and the user was changed since, returns ABORTED error without deleting it.
 	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// UndeleteUser restores a deleted user and returns it.
+	// Returns INVALID_ARGUMENT in case of invalid id, NOT_FOUND when user with a given id doesn't exist
+	// and ALREADY_EXISTS when it isn't deleted.
+	// When etag is set and the user was changed since, returns ABORTED error without restoring it.
+	UndeleteUser(ctx context.Context, in *UndeleteUserRequest, opts ...grpc.CallOption) (*User, error)
+	// Authenticate verifies a login, an email or a nickname, and a password of a user which isn't deleted
+	// and returns a new access token and a refresh token.
+	// Returns INVALID_ARGUMENT when login or password is empty and
+	// UNAUTHENTICATED when there is no such user or the password doesn't match.
+	// After a few failed password checks of a user or from a client IP address further checks are delayed,
+	// and after too many of them the user or the address is locked for a while, until then RESOURCE_EXHAUSTED error
+	// is returned with a google.rpc.RetryInfo detail telling when to retry. Passwords of locked users aren't revealed.
+	// Users with two-factor authentication should pass two_factor_code too, FAILED_PRECONDITION is returned
+	// when the password matches but the code is missing and UNAUTHENTICATED when the code is invalid.
+	// Invalid codes count as failed password checks.
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*Token, error)
+	// RefreshToken exchanges a refresh token for a new access token and a new refresh token,
+	// each refresh token can be used once. Reusing a refresh token revokes all tokens rotated from
+	// the same authentication, as it was probably stolen.
+	// Returns INVALID_ARGUMENT when refresh_token is empty and UNAUTHENTICATED when it's invalid,
+	// expired or revoked, or its user doesn't exist or is deleted, and RESOURCE_EXHAUSTED, like Authenticate,
+	// when the user is locked out, the token isn't used then.
+	RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*Token, error)
+	// RevokeToken revokes a refresh token and all tokens rotated from the same authentication,
+	// access tokens are valid until they expire. Revoking an unknown or expired token succeeds,
+	// returns INVALID_ARGUMENT when refresh_token is empty.
+	RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// RequestPasswordReset sends a password reset token to a user which isn't deleted with the email,
+	// a new token replaces the previous one. The response is the same whether there is such user or not,
+	// returns INVALID_ARGUMENT only when email isn't a valid email.
+	RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// ConfirmPasswordReset sets a new password of the user a token was sent to, each token can be used once.
+	// Returns INVALID_ARGUMENT when token or new_password is empty or the new password doesn't satisfy
+	// the password policy, in which case the token can still be used, and
+	// UNAUTHENTICATED when the token is invalid, expired or used, or its user is deleted.
+	ConfirmPasswordReset(ctx context.Context, in *ConfirmPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// VerifyEmail marks the email of a user verified with a token sent to it when the user was created
+	// or the email was changed, each token can be used once and only the latest one is valid.
+	// Returns INVALID_ARGUMENT when token is empty and UNAUTHENTICATED when it's invalid, expired or used,
+	// or its user is deleted.
+	VerifyEmail(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*User, error)
+	// LockUser locks a user out for the duration, or for the configured lockout duration when it's not set,
+	// so its password checks and refreshes of its tokens fail with RESOURCE_EXHAUSTED, and returns its lockout. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id or duration and NOT_FOUND when user with a given id doesn't exist
+	// or is deleted.
+	LockUser(ctx context.Context, in *LockUserRequest, opts ...grpc.CallOption) (*Lockout, error)
+	// UnlockUser lifts the lock of a user and forgets its failed password checks. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id and NOT_FOUND when user with a given id doesn't exist.
+	UnlockUser(ctx context.Context, in *UnlockUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetUserLockout returns failed password checks and the lock of a user. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id and NOT_FOUND when user with a given id doesn't exist.
+	GetUserLockout(ctx context.Context, in *GetUserLockoutRequest, opts ...grpc.CallOption) (*Lockout, error)
+	// EnrollTwoFactor generates a new TOTP secret of a user and returns it with an otpauth URI, usually shown
+	// as a QR code, to add it to an authenticator app. Two-factor authentication is enabled once the enrollment
+	// is confirmed with ConfirmTwoFactor, until then the next enrollment replaces the secret. Users may enroll themselves.
+	// Returns INVALID_ARGUMENT in case of invalid id, NOT_FOUND when user with a given id doesn't exist or is deleted
+	// and FAILED_PRECONDITION when two-factor authentication of the user is already enabled.
+	EnrollTwoFactor(ctx context.Context, in *EnrollTwoFactorRequest, opts ...grpc.CallOption) (*TwoFactorEnrollment, error)
+	// ConfirmTwoFactor enables two-factor authentication of a user with a code of the enrolled secret and returns
+	// its recovery codes. From then on Authenticate and UpdatePassword require two_factor_code.
+	// Returns INVALID_ARGUMENT in case of invalid id or empty code, NOT_FOUND when user with a given id doesn't exist
+	// or is deleted, FAILED_PRECONDITION when the user didn't enroll or is already enabled and
+	// PERMISSION_DENIED when the code is invalid.
+	ConfirmTwoFactor(ctx context.Context, in *ConfirmTwoFactorRequest, opts ...grpc.CallOption) (*RecoveryCodes, error)
+	// GenerateRecoveryCodes replaces recovery codes of a user with enabled two-factor authentication with new ones,
+	// code is a TOTP code or one of the recovery codes. Invalid codes count as failed password checks, so
+	// RESOURCE_EXHAUSTED is returned like by Authenticate.
+	// Returns INVALID_ARGUMENT in case of invalid id or empty code, NOT_FOUND when user with a given id doesn't exist
+	// or is deleted, FAILED_PRECONDITION when two-factor authentication isn't enabled and PERMISSION_DENIED when
+	// the code is invalid.
+	GenerateRecoveryCodes(ctx context.Context, in *GenerateRecoveryCodesRequest, opts ...grpc.CallOption) (*RecoveryCodes, error)
+	// DisableTwoFactor disables two-factor authentication of a user with a TOTP code or one of the recovery codes.
+	// Admins may omit the code, e.g. for users who lost their devices and recovery codes, which also cancels
+	// enrollments which weren't confirmed. Returns errors like GenerateRecoveryCodes.
+	DisableTwoFactor(ctx context.Context, in *DisableTwoFactorRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// WatchUsers streams changes of users as they happen, users can be filtered
+	// by the same fields as in ListUsers. Stream can be resumed after a reconnect
+	// by passing resume_token of the last received change, changes are streamed in order of commits.
+	// Resume tokens expire when changes after them are no longer kept: with MongoDB when they're
+	// out of the oplog, with other stores 7 days after the change of the token was delivered to sinks.
+	// Returns INVALID_ARGUMENT when filters or resume_token are invalid and OUT_OF_RANGE when resume_token expired,
+	// streams of watchers which fall behind that far fail with OUT_OF_RANGE too.
+	WatchUsers(ctx context.Context, in *WatchUsersRequest, opts ...grpc.CallOption) (Service_WatchUsersClient, error)
+	// CreateWebhook registers an endpoint which receives events as HTTP POST requests,
+	// see Webhook message for details.
+	// Returns INVALID_ARGUMENT when url or event_types are invalid.
+	CreateWebhook(ctx context.Context, in *CreateWebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+	// ListWebhooks returns a paginated list of webhooks, their secrets are not returned.
+	// In case of invalid params returns: INVALID_ARGUMENT error.
+	ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (*ListWebhooksResponse, error)
+	// DeleteWebhook deletes webhook with a provided id together with its delivery log.
+	// Returns INVALID_ARGUMENT in case of invalid id and
+	// NOT_FOUND when webhook with a given id doesn't exist.
+	DeleteWebhook(ctx context.Context, in *DeleteWebhookRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// ListWebhookDeliveries returns a paginated delivery log of a webhook, most recent deliveries first.
+	// Returns INVALID_ARGUMENT in case of invalid params and
+	// NOT_FOUND when webhook with a given id doesn't exist.
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error)
+	// CreateApiKey creates an API key of a service calling the service, see ApiKey message for details.
+	// The key is returned only once, only its hash is stored.
+	// Returns INVALID_ARGUMENT when name, scopes or expire_time are invalid.
+	CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error)
+	// ListApiKeys returns a paginated list of API keys, also of revoked and expired ones, without the keys.
+	// In case of invalid params returns: INVALID_ARGUMENT error.
+	ListApiKeys(ctx context.Context, in *ListApiKeysRequest, opts ...grpc.CallOption) (*ListApiKeysResponse, error)
+	// RevokeApiKey revokes an API key with a provided id, so it can't be used anymore, and returns it.
+	// Revoking a revoked key doesn't change it.
+	// Returns INVALID_ARGUMENT in case of invalid id and
+	// NOT_FOUND when API key with a given id doesn't exist.
+	RevokeApiKey(ctx context.Context, in *RevokeApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error)
 	// HealthCheck checks service's health, when db is inavailable returns UNAVAILABLE error.
 	HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
 }
@@ -67,6 +189,15 @@ func (c *serviceClient) ListUsers(ctx context.Context, in *ListUsersRequest, opt
 	return out, nil
 }
 
+func (c *serviceClient) SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error) {
+	out := new(SearchUsersResponse)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/SearchUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *serviceClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
 	out := new(User)
 	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/GetUser", in, out, opts...)
@@ -112,6 +243,227 @@ func (c *serviceClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, o
 	return out, nil
 }
 
+func (c *serviceClient) UndeleteUser(ctx context.Context, in *UndeleteUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/UndeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/Authenticate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/RefreshToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/RevokeToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/RequestPasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ConfirmPasswordReset(ctx context.Context, in *ConfirmPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/ConfirmPasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) VerifyEmail(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/VerifyEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) LockUser(ctx context.Context, in *LockUserRequest, opts ...grpc.CallOption) (*Lockout, error) {
+	out := new(Lockout)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/LockUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) UnlockUser(ctx context.Context, in *UnlockUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/UnlockUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) GetUserLockout(ctx context.Context, in *GetUserLockoutRequest, opts ...grpc.CallOption) (*Lockout, error) {
+	out := new(Lockout)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/GetUserLockout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) EnrollTwoFactor(ctx context.Context, in *EnrollTwoFactorRequest, opts ...grpc.CallOption) (*TwoFactorEnrollment, error) {
+	out := new(TwoFactorEnrollment)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/EnrollTwoFactor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ConfirmTwoFactor(ctx context.Context, in *ConfirmTwoFactorRequest, opts ...grpc.CallOption) (*RecoveryCodes, error) {
+	out := new(RecoveryCodes)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/ConfirmTwoFactor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) GenerateRecoveryCodes(ctx context.Context, in *GenerateRecoveryCodesRequest, opts ...grpc.CallOption) (*RecoveryCodes, error) {
+	out := new(RecoveryCodes)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/GenerateRecoveryCodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) DisableTwoFactor(ctx context.Context, in *DisableTwoFactorRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/DisableTwoFactor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) WatchUsers(ctx context.Context, in *WatchUsersRequest, opts ...grpc.CallOption) (Service_WatchUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[0], "/usersvc.v1.Service/WatchUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceWatchUsersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_WatchUsersClient interface {
+	Recv() (*WatchUsersResponse, error)
+	grpc.ClientStream
+}
+
+type serviceWatchUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceWatchUsersClient) Recv() (*WatchUsersResponse, error) {
+	m := new(WatchUsersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) CreateWebhook(ctx context.Context, in *CreateWebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/CreateWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (*ListWebhooksResponse, error) {
+	out := new(ListWebhooksResponse)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/ListWebhooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) DeleteWebhook(ctx context.Context, in *DeleteWebhookRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/DeleteWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error) {
+	out := new(ListWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/ListWebhookDeliveries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error) {
+	out := new(ApiKey)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/CreateApiKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ListApiKeys(ctx context.Context, in *ListApiKeysRequest, opts ...grpc.CallOption) (*ListApiKeysResponse, error) {
+	out := new(ListApiKeysResponse)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/ListApiKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) RevokeApiKey(ctx context.Context, in *RevokeApiKeyRequest, opts ...grpc.CallOption) (*ApiKey, error) {
+	out := new(ApiKey)
+	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/RevokeApiKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *serviceClient) HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error) {
 	out := new(HealthCheckResponse)
 	err := c.cc.Invoke(ctx, "/usersvc.v1.Service/HealthCheck", in, out, opts...)
@@ -126,32 +478,154 @@ func (c *serviceClient) HealthCheck(ctx context.Context, in *HealthCheckRequest,
 // for forward compatibility
 type ServiceServer interface {
 	// ListUsers returns a paginated list of users, users can be filtered by:
-	// first_name, last_name, nickname, email and country.
+	// first_name, last_name, nickname, email and country, with filters or filter fields.
+	// Deleted users are listed only when show_deleted is set.
 	// In case of invalid params returns: INVALID_ARGUMENT error.
 	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// SearchUsers finds users whose nickname, first_name, last_name or email match all words of a query,
+	// case-insensitively, by a prefix, a whole word or with a few typos, most relevant users first.
+	// Deleted users are never found. When the query is empty returns INVALID_ARGUMENT error.
+	SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error)
 	// GetUser retrieves a user by its id.
 	// When id is invalid returns INVALID_ARGUMENT and
-	// NOT_FOUND error when user with such id doesn't exist.
+	// NOT_FOUND error when user with such id doesn't exist, or is deleted and show_deleted isn't set.
 	GetUser(context.Context, *GetUserRequest) (*User, error)
-	// CreateUser creates a user.
-	// When request validation failed returns INVALID_ARGUMENT and
-	// ALREADY_EXISTS error when email or nickname are already taken.
+	// CreateUser creates a user and sends an email verification token to its email.
+	// When request validation failed or the password doesn't satisfy the password policy returns INVALID_ARGUMENT and
+	// ALREADY_EXISTS error when email or nickname are already taken, deleted users keep them until they are purged.
 	CreateUser(context.Context, *CreateUserRequest) (*User, error)
 	// UpdatePassword takes user's email, old password and new password as params and when user is found and
 	// old password matches database password,
 	// updates it with a new password, otherwise returns respectively NOT_FOUND or PERMISSION_DENIED error
-	// or INVALID_ARGUMENT when email is invalid.
+	// or INVALID_ARGUMENT when email is invalid or the new password doesn't satisfy the password policy.
+	// Old passwords are checked like passwords of Authenticate, so RESOURCE_EXHAUSTED is returned too.
+	// Users with two-factor authentication should pass two_factor_code, like to Authenticate.
 	UpdatePassword(context.Context, *UpdatePasswordRequest) (*emptypb.Empty, error)
 	// UpdateUser updates user's first_name, last_name nickname, email and country
 	// applying field_mask. User is identified using CreateUserRequest.user.id field.
 	// When id is invalid returns INVALID_ARGUMENT and
-	// NOT_FOUND error when user with such id doesn't exist,
+	// NOT_FOUND error when user with such id doesn't exist or is deleted,
 	// and ALREADY_EXISTS error when there a conflict (email or nickname were already taken).
+	// When user.etag is set and the user was changed since, returns ABORTED error without updating it.
+	// A change of the email resets email_verified, which is in update_mask of the published event,
+	// and sends a verification token to the new email.
 	UpdateUser(context.Context, *UpdateUserRequest) (*User, error)
-	// DeleteUser permanently deletes user with a provided id.
+	// DeleteUser marks user with a provided id deleted, it's permanently deleted with its credentials
+	// after the retention period, until then it can be restored with UndeleteUser.
 	// Returns INVALID_ARGUMENT in case of invalid id and
-	// NOT_FOUND when user with a gived id doesn't exist.
+	// NOT_FOUND when user with a gived id doesn't exist or is already deleted.
+	// When etag is set and the user was changed since, returns ABORTED error without deleting it.
 	DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error)
+	// UndeleteUser restores a deleted user and returns it.
+	// Returns INVALID_ARGUMENT in case of invalid id, NOT_FOUND when user with a given id doesn't exist
+	// and ALREADY_EXISTS when it isn't deleted.
+	// When etag is set and the user was changed since, returns ABORTED error without restoring it.
+	UndeleteUser(context.Context, *UndeleteUserRequest) (*User, error)
+	// Authenticate verifies a login, an email or a nickname, and a password of a user which isn't deleted
+	// and returns a new access token and a refresh token.
+	// Returns INVALID_ARGUMENT when login or password is empty and
+	// UNAUTHENTICATED when there is no such user or the password doesn't match.
+	// After a few failed password checks of a user or from a client IP address further checks are delayed,
+	// and after too many of them the user or the address is locked for a while, until then RESOURCE_EXHAUSTED error
+	// is returned with a google.rpc.RetryInfo detail telling when to retry. Passwords of locked users aren't revealed.
+	// Users with two-factor authentication should pass two_factor_code too, FAILED_PRECONDITION is returned
+	// when the password matches but the code is missing and UNAUTHENTICATED when the code is invalid.
+	// Invalid codes count as failed password checks.
+	Authenticate(context.Context, *AuthenticateRequest) (*Token, error)
+	// RefreshToken exchanges a refresh token for a new access token and a new refresh token,
+	// each refresh token can be used once. Reusing a refresh token revokes all tokens rotated from
+	// the same authentication, as it was probably stolen.
+	// Returns INVALID_ARGUMENT when refresh_token is empty and UNAUTHENTICATED when it's invalid,
+	// expired or revoked, or its user doesn't exist or is deleted, and RESOURCE_EXHAUSTED, like Authenticate,
+	// when the user is locked out, the token isn't used then.
+	RefreshToken(context.Context, *RefreshTokenRequest) (*Token, error)
+	// RevokeToken revokes a refresh token and all tokens rotated from the same authentication,
+	// access tokens are valid until they expire. Revoking an unknown or expired token succeeds,
+	// returns INVALID_ARGUMENT when refresh_token is empty.
+	RevokeToken(context.Context, *RevokeTokenRequest) (*emptypb.Empty, error)
+	// RequestPasswordReset sends a password reset token to a user which isn't deleted with the email,
+	// a new token replaces the previous one. The response is the same whether there is such user or not,
+	// returns INVALID_ARGUMENT only when email isn't a valid email.
+	RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*emptypb.Empty, error)
+	// ConfirmPasswordReset sets a new password of the user a token was sent to, each token can be used once.
+	// Returns INVALID_ARGUMENT when token or new_password is empty or the new password doesn't satisfy
+	// the password policy, in which case the token can still be used, and
+	// UNAUTHENTICATED when the token is invalid, expired or used, or its user is deleted.
+	ConfirmPasswordReset(context.Context, *ConfirmPasswordResetRequest) (*emptypb.Empty, error)
+	// VerifyEmail marks the email of a user verified with a token sent to it when the user was created
+	// or the email was changed, each token can be used once and only the latest one is valid.
+	// Returns INVALID_ARGUMENT when token is empty and UNAUTHENTICATED when it's invalid, expired or used,
+	// or its user is deleted.
+	VerifyEmail(context.Context, *VerifyEmailRequest) (*User, error)
+	// LockUser locks a user out for the duration, or for the configured lockout duration when it's not set,
+	// so its password checks and refreshes of its tokens fail with RESOURCE_EXHAUSTED, and returns its lockout. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id or duration and NOT_FOUND when user with a given id doesn't exist
+	// or is deleted.
+	LockUser(context.Context, *LockUserRequest) (*Lockout, error)
+	// UnlockUser lifts the lock of a user and forgets its failed password checks. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id and NOT_FOUND when user with a given id doesn't exist.
+	UnlockUser(context.Context, *UnlockUserRequest) (*emptypb.Empty, error)
+	// GetUserLockout returns failed password checks and the lock of a user. Only admins may call it.
+	// Returns INVALID_ARGUMENT in case of invalid id and NOT_FOUND when user with a given id doesn't exist.
+	GetUserLockout(context.Context, *GetUserLockoutRequest) (*Lockout, error)
+	// EnrollTwoFactor generates a new TOTP secret of a user and returns it with an otpauth URI, usually shown
+	// as a QR code, to add it to an authenticator app. Two-factor authentication is enabled once the enrollment
+	// is confirmed with ConfirmTwoFactor, until then the next enrollment replaces the secret. Users may enroll themselves.
+	// Returns INVALID_ARGUMENT in case of invalid id, NOT_FOUND when user with a given id doesn't exist or is deleted
+	// and FAILED_PRECONDITION when two-factor authentication of the user is already enabled.
+	EnrollTwoFactor(context.Context, *EnrollTwoFactorRequest) (*TwoFactorEnrollment, error)
+	// ConfirmTwoFactor enables two-factor authentication of a user with a code of the enrolled secret and returns
+	// its recovery codes. From then on Authenticate and UpdatePassword require two_factor_code.
+	// Returns INVALID_ARGUMENT in case of invalid id or empty code, NOT_FOUND when user with a given id doesn't exist
+	// or is deleted, FAILED_PRECONDITION when the user didn't enroll or is already enabled and
+	// PERMISSION_DENIED when the code is invalid.
+	ConfirmTwoFactor(context.Context, *ConfirmTwoFactorRequest) (*RecoveryCodes, error)
+	// GenerateRecoveryCodes replaces recovery codes of a user with enabled two-factor authentication with new ones,
+	// code is a TOTP code or one of the recovery codes. Invalid codes count as failed password checks, so
+	// RESOURCE_EXHAUSTED is returned like by Authenticate.
+	// Returns INVALID_ARGUMENT in case of invalid id or empty code, NOT_FOUND when user with a given id doesn't exist
+	// or is deleted, FAILED_PRECONDITION when two-factor authentication isn't enabled and PERMISSION_DENIED when
+	// the code is invalid.
+	GenerateRecoveryCodes(context.Context, *GenerateRecoveryCodesRequest) (*RecoveryCodes, error)
+	// DisableTwoFactor disables two-factor authentication of a user with a TOTP code or one of the recovery codes.
+	// Admins may omit the code, e.g. for users who lost their devices and recovery codes, which also cancels
+	// enrollments which weren't confirmed. Returns errors like GenerateRecoveryCodes.
+	DisableTwoFactor(context.Context, *DisableTwoFactorRequest) (*emptypb.Empty, error)
+	// WatchUsers streams changes of users as they happen, users can be filtered
+	// by the same fields as in ListUsers. Stream can be resumed after a reconnect
+	// by passing resume_token of the last received change, changes are streamed in order of commits.
+	// Resume tokens expire when changes after them are no longer kept: with MongoDB when they're
+	// out of the oplog, with other stores 7 days after the change of the token was delivered to sinks.
+	// Returns INVALID_ARGUMENT when filters or resume_token are invalid and OUT_OF_RANGE when resume_token expired,
+	// streams of watchers which fall behind that far fail with OUT_OF_RANGE too.
+	WatchUsers(*WatchUsersRequest, Service_WatchUsersServer) error
+	// CreateWebhook registers an endpoint which receives events as HTTP POST requests,
+	// see Webhook message for details.
+	// Returns INVALID_ARGUMENT when url or event_types are invalid.
+	CreateWebhook(context.Context, *CreateWebhookRequest) (*Webhook, error)
+	// ListWebhooks returns a paginated list of webhooks, their secrets are not returned.
+	// In case of invalid params returns: INVALID_ARGUMENT error.
+	ListWebhooks(context.Context, *ListWebhooksRequest) (*ListWebhooksResponse, error)
+	// DeleteWebhook deletes webhook with a provided id together with its delivery log.
+	// Returns INVALID_ARGUMENT in case of invalid id and
+	// NOT_FOUND when webhook with a given id doesn't exist.
+	DeleteWebhook(context.Context, *DeleteWebhookRequest) (*emptypb.Empty, error)
+	// ListWebhookDeliveries returns a paginated delivery log of a webhook, most recent deliveries first.
+	// Returns INVALID_ARGUMENT in case of invalid params and
+	// NOT_FOUND when webhook with a given id doesn't exist.
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error)
+	// CreateApiKey creates an API key of a service calling the service, see ApiKey message for details.
+	// The key is returned only once, only its hash is stored.
+	// Returns INVALID_ARGUMENT when name, scopes or expire_time are invalid.
+	CreateApiKey(context.Context, *CreateApiKeyRequest) (*ApiKey, error)
+	// ListApiKeys returns a paginated list of API keys, also of revoked and expired ones, without the keys.
+	// In case of invalid params returns: INVALID_ARGUMENT error.
+	ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error)
+	// RevokeApiKey revokes an API key with a provided id, so it can't be used anymore, and returns it.
+	// Revoking a revoked key doesn't change it.
+	// Returns INVALID_ARGUMENT in case of invalid id and
+	// NOT_FOUND when API key with a given id doesn't exist.
+	RevokeApiKey(context.Context, *RevokeApiKeyRequest) (*ApiKey, error)
 	// HealthCheck checks service's health, when db is inavailable returns UNAVAILABLE error.
 	HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
 }
@@ -163,6 +637,9 @@ type UnimplementedServiceServer struct {
 func (UnimplementedServiceServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
+func (UnimplementedServiceServer) SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchUsers not implemented")
+}
 func (UnimplementedServiceServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
 }
@@ -178,6 +655,72 @@ func (UnimplementedServiceServer) UpdateUser(context.Context, *UpdateUserRequest
 func (UnimplementedServiceServer) DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedServiceServer) UndeleteUser(context.Context, *UndeleteUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UndeleteUser not implemented")
+}
+func (UnimplementedServiceServer) Authenticate(context.Context, *AuthenticateRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
+func (UnimplementedServiceServer) RefreshToken(context.Context, *RefreshTokenRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
+func (UnimplementedServiceServer) RevokeToken(context.Context, *RevokeTokenRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeToken not implemented")
+}
+func (UnimplementedServiceServer) RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestPasswordReset not implemented")
+}
+func (UnimplementedServiceServer) ConfirmPasswordReset(context.Context, *ConfirmPasswordResetRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmPasswordReset not implemented")
+}
+func (UnimplementedServiceServer) VerifyEmail(context.Context, *VerifyEmailRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyEmail not implemented")
+}
+func (UnimplementedServiceServer) LockUser(context.Context, *LockUserRequest) (*Lockout, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LockUser not implemented")
+}
+func (UnimplementedServiceServer) UnlockUser(context.Context, *UnlockUserRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlockUser not implemented")
+}
+func (UnimplementedServiceServer) GetUserLockout(context.Context, *GetUserLockoutRequest) (*Lockout, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserLockout not implemented")
+}
+func (UnimplementedServiceServer) EnrollTwoFactor(context.Context, *EnrollTwoFactorRequest) (*TwoFactorEnrollment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnrollTwoFactor not implemented")
+}
+func (UnimplementedServiceServer) ConfirmTwoFactor(context.Context, *ConfirmTwoFactorRequest) (*RecoveryCodes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmTwoFactor not implemented")
+}
+func (UnimplementedServiceServer) GenerateRecoveryCodes(context.Context, *GenerateRecoveryCodesRequest) (*RecoveryCodes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GenerateRecoveryCodes not implemented")
+}
+func (UnimplementedServiceServer) DisableTwoFactor(context.Context, *DisableTwoFactorRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableTwoFactor not implemented")
+}
+func (UnimplementedServiceServer) WatchUsers(*WatchUsersRequest, Service_WatchUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchUsers not implemented")
+}
+func (UnimplementedServiceServer) CreateWebhook(context.Context, *CreateWebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateWebhook not implemented")
+}
+func (UnimplementedServiceServer) ListWebhooks(context.Context, *ListWebhooksRequest) (*ListWebhooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhooks not implemented")
+}
+func (UnimplementedServiceServer) DeleteWebhook(context.Context, *DeleteWebhookRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedServiceServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedServiceServer) CreateApiKey(context.Context, *CreateApiKeyRequest) (*ApiKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateApiKey not implemented")
+}
+func (UnimplementedServiceServer) ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApiKeys not implemented")
+}
+func (UnimplementedServiceServer) RevokeApiKey(context.Context, *RevokeApiKeyRequest) (*ApiKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeApiKey not implemented")
+}
 func (UnimplementedServiceServer) HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HealthCheck not implemented")
 }
@@ -211,6 +754,24 @@ func _Service_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_SearchUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).SearchUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/SearchUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).SearchUsers(ctx, req.(*SearchUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Service_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetUserRequest)
 	if err := dec(in); err != nil {
@@ -301,60 +862,553 @@ func _Service_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Service_HealthCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(HealthCheckRequest)
+func _Service_UndeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UndeleteUserRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(ServiceServer).HealthCheck(ctx, in)
+		return srv.(ServiceServer).UndeleteUser(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/usersvc.v1.Service/HealthCheck",
+		FullMethod: "/usersvc.v1.Service/UndeleteUser",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ServiceServer).HealthCheck(ctx, req.(*HealthCheckRequest))
+		return srv.(ServiceServer).UndeleteUser(ctx, req.(*UndeleteUserRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-// Service_ServiceDesc is the grpc.ServiceDesc for Service service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var Service_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "usersvc.v1.Service",
-	HandlerType: (*ServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "ListUsers",
-			Handler:    _Service_ListUsers_Handler,
-		},
-		{
-			MethodName: "GetUser",
-			Handler:    _Service_GetUser_Handler,
-		},
-		{
-			MethodName: "CreateUser",
-			Handler:    _Service_CreateUser_Handler,
-		},
-		{
-			MethodName: "UpdatePassword",
-			Handler:    _Service_UpdatePassword_Handler,
-		},
-		{
-			MethodName: "UpdateUser",
-			Handler:    _Service_UpdateUser_Handler,
-		},
-		{
-			MethodName: "DeleteUser",
-			Handler:    _Service_DeleteUser_Handler,
-		},
-		{
-			MethodName: "HealthCheck",
-			Handler:    _Service_HealthCheck_Handler,
+func _Service_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/Authenticate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/RefreshToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).RefreshToken(ctx, req.(*RefreshTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_RevokeToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).RevokeToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/RevokeToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).RevokeToken(ctx, req.(*RevokeTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_RequestPasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestPasswordResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).RequestPasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/RequestPasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).RequestPasswordReset(ctx, req.(*RequestPasswordResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ConfirmPasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmPasswordResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ConfirmPasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/ConfirmPasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ConfirmPasswordReset(ctx, req.(*ConfirmPasswordResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_VerifyEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).VerifyEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/VerifyEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).VerifyEmail(ctx, req.(*VerifyEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_LockUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).LockUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/LockUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).LockUser(ctx, req.(*LockUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_UnlockUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).UnlockUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/UnlockUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).UnlockUser(ctx, req.(*UnlockUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_GetUserLockout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserLockoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).GetUserLockout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/GetUserLockout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).GetUserLockout(ctx, req.(*GetUserLockoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_EnrollTwoFactor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollTwoFactorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).EnrollTwoFactor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/EnrollTwoFactor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).EnrollTwoFactor(ctx, req.(*EnrollTwoFactorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ConfirmTwoFactor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmTwoFactorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ConfirmTwoFactor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/ConfirmTwoFactor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ConfirmTwoFactor(ctx, req.(*ConfirmTwoFactorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_GenerateRecoveryCodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateRecoveryCodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).GenerateRecoveryCodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/GenerateRecoveryCodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).GenerateRecoveryCodes(ctx, req.(*GenerateRecoveryCodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_DisableTwoFactor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableTwoFactorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).DisableTwoFactor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/DisableTwoFactor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).DisableTwoFactor(ctx, req.(*DisableTwoFactorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_WatchUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchUsersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).WatchUsers(m, &serviceWatchUsersServer{stream})
+}
+
+type Service_WatchUsersServer interface {
+	Send(*WatchUsersResponse) error
+	grpc.ServerStream
+}
+
+type serviceWatchUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceWatchUsersServer) Send(m *WatchUsersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_CreateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).CreateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/CreateWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).CreateWebhook(ctx, req.(*CreateWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ListWebhooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ListWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/ListWebhooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ListWebhooks(ctx, req.(*ListWebhooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/DeleteWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).DeleteWebhook(ctx, req.(*DeleteWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/ListWebhookDeliveries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_CreateApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).CreateApiKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/CreateApiKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).CreateApiKey(ctx, req.(*CreateApiKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ListApiKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListApiKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ListApiKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/ListApiKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ListApiKeys(ctx, req.(*ListApiKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_RevokeApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).RevokeApiKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/RevokeApiKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).RevokeApiKey(ctx, req.(*RevokeApiKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_HealthCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).HealthCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usersvc.v1.Service/HealthCheck",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).HealthCheck(ctx, req.(*HealthCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Service_ServiceDesc is the grpc.ServiceDesc for Service service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Service_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "usersvc.v1.Service",
+	HandlerType: (*ServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListUsers",
+			Handler:    _Service_ListUsers_Handler,
+		},
+		{
+			MethodName: "SearchUsers",
+			Handler:    _Service_SearchUsers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Service_GetUser_Handler,
+		},
+		{
+			MethodName: "CreateUser",
+			Handler:    _Service_CreateUser_Handler,
+		},
+		{
+			MethodName: "UpdatePassword",
+			Handler:    _Service_UpdatePassword_Handler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _Service_UpdateUser_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _Service_DeleteUser_Handler,
+		},
+		{
+			MethodName: "UndeleteUser",
+			Handler:    _Service_UndeleteUser_Handler,
+		},
+		{
+			MethodName: "Authenticate",
+			Handler:    _Service_Authenticate_Handler,
+		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _Service_RefreshToken_Handler,
+		},
+		{
+			MethodName: "RevokeToken",
+			Handler:    _Service_RevokeToken_Handler,
+		},
+		{
+			MethodName: "RequestPasswordReset",
+			Handler:    _Service_RequestPasswordReset_Handler,
+		},
+		{
+			MethodName: "ConfirmPasswordReset",
+			Handler:    _Service_ConfirmPasswordReset_Handler,
+		},
+		{
+			MethodName: "VerifyEmail",
+			Handler:    _Service_VerifyEmail_Handler,
+		},
+		{
+			MethodName: "LockUser",
+			Handler:    _Service_LockUser_Handler,
+		},
+		{
+			MethodName: "UnlockUser",
+			Handler:    _Service_UnlockUser_Handler,
+		},
+		{
+			MethodName: "GetUserLockout",
+			Handler:    _Service_GetUserLockout_Handler,
+		},
+		{
+			MethodName: "EnrollTwoFactor",
+			Handler:    _Service_EnrollTwoFactor_Handler,
+		},
+		{
+			MethodName: "ConfirmTwoFactor",
+			Handler:    _Service_ConfirmTwoFactor_Handler,
+		},
+		{
+			MethodName: "GenerateRecoveryCodes",
+			Handler:    _Service_GenerateRecoveryCodes_Handler,
+		},
+		{
+			MethodName: "DisableTwoFactor",
+			Handler:    _Service_DisableTwoFactor_Handler,
+		},
+		{
+			MethodName: "CreateWebhook",
+			Handler:    _Service_CreateWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler:    _Service_ListWebhooks_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _Service_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _Service_ListWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "CreateApiKey",
+			Handler:    _Service_CreateApiKey_Handler,
+		},
+		{
+			MethodName: "ListApiKeys",
+			Handler:    _Service_ListApiKeys_Handler,
+		},
+		{
+			MethodName: "RevokeApiKey",
+			Handler:    _Service_RevokeApiKey_Handler,
+		},
+		{
+			MethodName: "HealthCheck",
+			Handler:    _Service_HealthCheck_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchUsers",
+			Handler:       _Service_WatchUsers_Handler,
+			ServerStreams: true,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "usersvc/v1/proto.proto",
 }
@@ -2,15 +2,123 @@ package appconfig
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/gopher-lib/config"
 )
 
 type Config struct {
-	Port    string
+	Port string
+	// HTTPPort serves the JWKS of access tokens.
+	HTTPPort string `mapstructure:"http_port"`
+	Storage  struct {
+		// Driver is a storage backend: mongodb, postgres, sqlite or memory.
+		Driver string
+	}
 	Mongodb struct {
 		URI string
 	}
+	Postgres struct {
+		DSN string
+	}
+	SQLite struct {
+		Path string
+	}
+	Users struct {
+		Purge struct {
+			Interval time.Duration
+			// Retention is for how long deleted users are kept before they are purged.
+			Retention time.Duration
+			BatchSize int `mapstructure:"batch_size"`
+		}
+	}
+	Pagination struct {
+		// TokenKey is a key page tokens are signed with.
+		TokenKey string `mapstructure:"token_key"`
+	}
+	Auth struct {
+		// Issuer is the iss claim of access tokens.
+		Issuer          string
+		AccessTokenTTL  time.Duration `mapstructure:"access_token_ttl"`
+		RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl"`
+		// KeyFiles are paths of PEM encoded RSA private keys, the first one signs access tokens,
+		// others only verify them, so keys can be rotated.
+		KeyFiles []string `mapstructure:"key_files"`
+		// ServiceAccountsFile is a path of a YAML list of service accounts, optional.
+		ServiceAccountsFile string `mapstructure:"service_accounts_file"`
+		// PasswordResetTTL is for how long password reset tokens are valid.
+		PasswordResetTTL time.Duration `mapstructure:"password_reset_ttl"`
+		// EmailVerificationTTL is for how long email verification tokens are valid.
+		EmailVerificationTTL time.Duration `mapstructure:"email_verification_ttl"`
+		// TwoFactorKey encrypts TOTP secrets of users.
+		TwoFactorKey string `mapstructure:"two_factor_key"`
+	}
+	Passwords struct {
+		// Hasher is an algorithm new password hashes are created with: argon2id or bcrypt.
+		Hasher   string
+		Argon2id struct {
+			Time uint32
+			// Memory is in KiB.
+			Memory     uint32
+			Threads    uint8
+			KeyLength  uint32 `mapstructure:"key_length"`
+			SaltLength uint32 `mapstructure:"salt_length"`
+		}
+		Bcrypt struct {
+			Cost int
+		}
+		// Policy new passwords of users should satisfy.
+		Policy struct {
+			MinLength        int  `mapstructure:"min_length"`
+			MaxLength        int  `mapstructure:"max_length"`
+			RequireLowercase bool `mapstructure:"require_lowercase"`
+			RequireUppercase bool `mapstructure:"require_uppercase"`
+			RequireDigit     bool `mapstructure:"require_digit"`
+			RequireSymbol    bool `mapstructure:"require_symbol"`
+			DisallowUserInfo bool `mapstructure:"disallow_user_info"`
+			DisallowCommon   bool `mapstructure:"disallow_common"`
+		}
+		// Lockout limits failed password checks of users and from client IP addresses.
+		Lockout struct {
+			MaxFailures       int `mapstructure:"max_failures"`
+			MaxClientFailures int `mapstructure:"max_client_failures"`
+			FreeFailures      int `mapstructure:"free_failures"`
+			Delay             time.Duration
+			MaxDelay          time.Duration `mapstructure:"max_delay"`
+			Duration          time.Duration
+			Window            time.Duration
+		}
+	}
+	Notifier struct {
+		// Driver delivers notifications to users: log or file.
+		Driver string
+		// Path of the file notifications are appended to by the file driver.
+		Path string
+	}
+	Events struct {
+		// Sinks are names of sinks events are delivered to.
+		Sinks []string
+		// Format events are encoded in, json or protobuf.
+		Format string
+		// Source is a CloudEvents source attribute of published events.
+		Source string
+		Relay  struct {
+			Interval   time.Duration
+			BatchSize  int `mapstructure:"batch_size"`
+			Lease      time.Duration
+			MinBackoff time.Duration `mapstructure:"min_backoff"`
+			MaxBackoff time.Duration `mapstructure:"max_backoff"`
+		}
+	}
+	Webhooks struct {
+		Interval    time.Duration
+		BatchSize   int `mapstructure:"batch_size"`
+		Lease       time.Duration
+		Timeout     time.Duration
+		MaxAttempts int           `mapstructure:"max_attempts"`
+		MinBackoff  time.Duration `mapstructure:"min_backoff"`
+		MaxBackoff  time.Duration `mapstructure:"max_backoff"`
+	}
 }
 
 // AppConfig contains application configuration.
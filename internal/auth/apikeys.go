@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+)
+
+// APIKeyPrefix starts every API key, so keys are told apart from other tokens, e.g. by secret scanners.
+const APIKeyPrefix = "usk_"
+
+// APIKeyMetadata is a metadata key of API keys, they are passed instead of an authorization metadata.
+const APIKeyMetadata = "x-api-key"
+
+// APIKeys finds API keys by hashes, store.Repository implements it.
+type APIKeys interface {
+	GetAPIKeyByHash(ctx context.Context, hash string) (*store.APIKey, error)
+}
+
+// NewAPIKey returns a new random API key and its hash, which is stored instead of the key.
+func NewAPIKey() (key, hash string, err error) {
+	token, _, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns a hash of an API key under which it's stored.
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
+
+// isAPIKey reports whether the string looks like an API key.
+func isAPIKey(s string) bool {
+	return strings.HasPrefix(s, APIKeyPrefix) && len(s) > len(APIKeyPrefix)
+}
@@ -0,0 +1,161 @@
+// Package auth issues and verifies tokens of authenticated users and authorizes calls of the service.
+// Access tokens are JWTs signed with RS256, keys are identified by their RFC 7638 thumbprints
+// in the kid header, so keys can be rotated: a new key signs tokens while old ones still verify them.
+// Refresh tokens and tokens of service accounts are random strings, only their hashes are stored.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrInvalidToken is returned when a token is malformed, expired or its signature doesn't match.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Options configure the issuer.
+type Options struct {
+	// Issuer is the iss claim of access tokens, usersvc by default.
+	Issuer string
+	// AccessTokenTTL is for how long access tokens are valid, 15 minutes by default.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is for how long refresh tokens are valid, 30 days by default.
+	RefreshTokenTTL time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Issuer == "" {
+		o.Issuer = "usersvc"
+	}
+	if o.AccessTokenTTL <= 0 {
+		o.AccessTokenTTL = 15 * time.Minute
+	}
+	if o.RefreshTokenTTL <= 0 {
+		o.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+}
+
+// Claims are claims of access tokens, the subject is an id of a user.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Role is a role of the user when the token was issued.
+	Role string `json:"role,omitempty"`
+}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// Issuer signs access tokens with the first of its keys and verifies them with any of them.
+type Issuer struct {
+	keys []signingKey
+	opts Options
+}
+
+// NewIssuer creates an issuer, the first key signs tokens, others only verify them.
+func NewIssuer(keys []*rsa.PrivateKey, opts Options) (*Issuer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	opts.setDefaults()
+	i := &Issuer{opts: opts}
+	for _, k := range keys {
+		i.keys = append(i.keys, signingKey{id: thumbprint(&k.PublicKey), key: k})
+	}
+	return i, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func ParsePrivateKey(pem []byte) (*rsa.PrivateKey, error) {
+	return jwt.ParseRSAPrivateKeyFromPEM(pem)
+}
+
+// GenerateKey generates a new 2048 bit RSA key.
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// RefreshTokenTTL is for how long refresh tokens are valid.
+func (i *Issuer) RefreshTokenTTL() time.Duration {
+	return i.opts.RefreshTokenTTL
+}
+
+// AccessToken returns an access token of a user with a role and its expiration time.
+func (i *Issuer) AccessToken(userID, role string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(i.opts.AccessTokenTTL)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.opts.Issuer,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Role: role,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = i.keys[0].id
+	signed, err := token.SignedString(i.keys[0].key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify verifies an access token and returns its claims.
+func (i *Issuer) Verify(token string) (*Claims, error) {
+	var claims Claims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, k := range i.keys {
+			if k.id == kid {
+				return &k.key.PublicKey, nil
+			}
+		}
+		return nil, errors.New("unknown key")
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(time.Now(), true) || !claims.VerifyIssuer(i.opts.Issuer, true) || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// NewRefreshToken returns a new random refresh token and its hash, which is stored instead of the token.
+func NewRefreshToken() (token, hash string, err error) {
+	return newToken()
+}
+
+// HashRefreshToken returns a hash of a refresh token under which it's stored.
+// Tokens are random, so they don't need a slow password hash.
+func HashRefreshToken(token string) string {
+	return hashToken(token)
+}
+
+// NewOneTimeToken returns a new random one-time token, e.g. of a password reset, and its hash.
+func NewOneTimeToken() (token, hash string, err error) {
+	return newToken()
+}
+
+// HashOneTimeToken returns a hash of a one-time token under which it's stored.
+func HashOneTimeToken(token string) string {
+	return hashToken(token)
+}
+
+// newToken returns a new random token, base64url encoded, and its hash.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
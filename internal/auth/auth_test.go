@@ -0,0 +1,130 @@
+// +build unit
+
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	return key
+}
+
+func TestIssuer(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+	old, err := NewIssuer([]*rsa.PrivateKey{oldKey}, Options{})
+	require.NoError(t, err)
+	rotated, err := NewIssuer([]*rsa.PrivateKey{newKey, oldKey}, Options{})
+	require.NoError(t, err)
+
+	token, expiresAt, err := old.AccessToken("60a7b1f2c3d4e5f601234567", "user")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, time.Minute)
+
+	t.Run("verify", func(t *testing.T) {
+		claims, err := old.Verify(token)
+		require.NoError(t, err)
+		assert.Equal(t, "60a7b1f2c3d4e5f601234567", claims.Subject)
+		assert.Equal(t, "usersvc", claims.Issuer)
+		assert.Equal(t, "user", claims.Role)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		// tokens signed with the old key are still valid.
+		_, err := rotated.Verify(token)
+		assert.NoError(t, err)
+		token, _, err := rotated.AccessToken("60a7b1f2c3d4e5f601234567", "user")
+		require.NoError(t, err)
+		_, err = old.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		require.NoError(t, err)
+		assert.Equal(t, rotated.JWKS().Keys[0].Kid, parsed.Header["kid"])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		other, err := NewIssuer([]*rsa.PrivateKey{oldKey}, Options{Issuer: "other"})
+		require.NoError(t, err)
+		_, err = other.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, "issuer doesn't match")
+
+		expired, err := NewIssuer([]*rsa.PrivateKey{oldKey}, Options{AccessTokenTTL: time.Nanosecond})
+		require.NoError(t, err)
+		token, _, err := expired.AccessToken("60a7b1f2c3d4e5f601234567", "user")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = expired.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, "expired")
+
+		unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "usersvc",
+			Subject:   "60a7b1f2c3d4e5f601234567",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}, Role: "admin"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		_, err = old.Verify(unsigned)
+		assert.ErrorIs(t, err, ErrInvalidToken, "unsigned")
+
+		_, err = old.Verify("abc")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	_, err = NewIssuer(nil, Options{})
+	assert.Error(t, err)
+}
+
+// Thumbprint of the example key of RFC 7638, section 3.1.
+func TestThumbprint(t *testing.T) {
+	const n = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	b, err := base64.RawURLEncoding.DecodeString(n)
+	require.NoError(t, err)
+	k := &rsa.PublicKey{N: new(big.Int).SetBytes(b), E: 65537}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint(k))
+	jwk := newJWK(k)
+	assert.Equal(t, n, jwk.N)
+	assert.Equal(t, "AQAB", jwk.E)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+	i, err := NewIssuer([]*rsa.PrivateKey{newKey, oldKey}, Options{})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	i.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var set JWKS
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, newJWK(&newKey.PublicKey), set.Keys[0])
+	assert.Equal(t, newJWK(&oldKey.PublicKey), set.Keys[1])
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+
+	rec = httptest.NewRecorder()
+	i.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, JWKSPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+	assert.Equal(t, HashRefreshToken(token), hash)
+	other, _, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Rule authorizes calls of a method. Admins may call every method,
+// other callers only when the rule allows them.
+type Rule struct {
+	// Public methods may be called without a token.
+	Public bool
+	// Scope allows service accounts with it to call the method.
+	Scope string
+	// Self returns an id of the user a request is about, users may call the method for themselves.
+	// It's not called for streaming methods.
+	Self func(req interface{}) string
+}
+
+// Authorizer authenticates callers with bearer tokens of authorization metadata or with API keys
+// of x-api-key metadata and authorizes their calls with rules of methods,
+// methods without a rule may be called only by admins.
+type Authorizer struct {
+	issuer   *Issuer
+	accounts map[string]*ServiceAccount
+	keys     APIKeys
+	policy   map[string]Rule
+}
+
+// NewAuthorizer creates an authorizer of users with access tokens of the issuer, of service accounts
+// and of API keys found in keys, which may be nil when API keys aren't accepted.
+// Policy maps full names of methods, e.g. /usersvc.v1.Service/GetUser, to their rules.
+func NewAuthorizer(issuer *Issuer, accounts []ServiceAccount, keys APIKeys, policy map[string]Rule) *Authorizer {
+	a := &Authorizer{issuer: issuer, accounts: map[string]*ServiceAccount{}, keys: keys, policy: policy}
+	for i := range accounts {
+		a.accounts[strings.ToLower(accounts[i].TokenSHA256)] = &accounts[i]
+	}
+	return a
+}
+
+// UnaryServerInterceptor authorizes unary calls, principals are passed to handlers in their contexts.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authorizes streaming calls, principals are passed to handlers in their contexts.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+// authorize returns a context with the principal of the call, or UNAUTHENTICATED or PERMISSION_DENIED error.
+// Tokens passed to public methods are verified too, so they are either valid or rejected.
+// The principal is added to the log entry of the call as auth.caller.
+func (a *Authorizer) authorize(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	rule := a.policy[method]
+	p, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "authorization token is missing")
+	}
+	ctx = NewContext(ctx, p)
+	ctxzap.AddFields(ctx, zap.String("auth.caller", p.String()))
+	switch {
+	case rule.Public || p.IsAdmin():
+	case rule.Scope != "" && p.HasScope(rule.Scope):
+	case rule.Self != nil && req != nil && p.UserID != "" && rule.Self(req) == p.UserID:
+	default:
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	return ctx, nil
+}
+
+// authenticate returns a principal of the bearer token or of the API key, nil when there is neither.
+func (a *Authorizer) authenticate(ctx context.Context) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if keys := md.Get(APIKeyMetadata); len(keys) > 0 {
+		if len(values) > 0 {
+			return nil, status.Error(codes.Unauthenticated, "either authorization or x-api-key should be passed")
+		}
+		return a.authenticateAPIKey(ctx, keys[0])
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	scheme, token := values[0], ""
+	if i := strings.IndexByte(scheme, ' '); i >= 0 {
+		scheme, token = scheme[:i], strings.TrimSpace(scheme[i+1:])
+	}
+	if !strings.EqualFold(scheme, "bearer") || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization should be a bearer token")
+	}
+	if sa, ok := a.accounts[hashToken(token)]; ok {
+		return &Principal{ServiceAccount: sa.Name, Scopes: sa.Scopes}, nil
+	}
+	claims, err := a.issuer.Verify(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return &Principal{UserID: claims.Subject, Role: claims.Role}, nil
+}
+
+// authenticateAPIKey returns a principal of the API key, which acts like a service account with its scopes.
+func (a *Authorizer) authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	if a.keys == nil || !isAPIKey(key) {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	k, err := a.keys.GetAPIKeyByHash(ctx, HashAPIKey(key))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !k.IsValid(time.Now()) {
+		return nil, status.Error(codes.Unauthenticated, "API key is revoked or expired")
+	}
+	return &Principal{APIKey: k.ID.Hex(), Scopes: k.Scopes}, nil
+}
@@ -0,0 +1,217 @@
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type request struct {
+	id string
+}
+
+func TestAuthorizer(t *testing.T) {
+	issuer, err := NewIssuer([]*rsa.PrivateKey{generateKey(t)}, Options{})
+	require.NoError(t, err)
+	other, err := NewIssuer([]*rsa.PrivateKey{generateKey(t)}, Options{})
+	require.NoError(t, err)
+	accounts, err := ParseServiceAccounts([]byte(`
+- name: reader
+  token_sha256: ` + hashToken("reader-token") + `
+  scopes: [users.read]
+`))
+	require.NoError(t, err)
+	a := NewAuthorizer(issuer, accounts, nil, map[string]Rule{
+		"/public":  {Public: true},
+		"/read":    {Scope: ScopeUsersRead},
+		"/write":   {Scope: ScopeUsersWrite, Self: func(req interface{}) string { return req.(*request).id }},
+		"/stream":  {Scope: ScopeUsersRead},
+		"/ignored": {},
+	})
+	token := func(issuer *Issuer, id, role string) string {
+		token, _, err := issuer.AccessToken(id, role)
+		require.NoError(t, err)
+		return "Bearer " + token
+	}
+	user := token(issuer, "1", "user")
+	admin := token(issuer, "2", "admin")
+
+	for _, tc := range []struct {
+		name          string
+		method        string
+		authorization string
+		id            string
+		code          codes.Code
+		principal     string
+	}{
+		{"public", "/public", "", "", codes.OK, ""},
+		{"public with a token", "/public", user, "", codes.OK, "users/1"},
+		{"public with an invalid token", "/public", token(other, "1", "user"), "", codes.Unauthenticated, ""},
+		{"missing token", "/read", "", "", codes.Unauthenticated, ""},
+		{"not bearer", "/read", "Basic abc", "", codes.Unauthenticated, ""},
+		{"invalid token", "/read", "Bearer abc", "", codes.Unauthenticated, ""},
+		{"user", "/read", user, "", codes.PermissionDenied, ""},
+		{"self", "/write", user, "1", codes.OK, "users/1"},
+		{"other user", "/write", user, "2", codes.PermissionDenied, ""},
+		{"admin", "/write", admin, "1", codes.OK, "users/2"},
+		{"admin without a rule", "/unknown", admin, "", codes.OK, "users/2"},
+		{"user without a rule", "/unknown", user, "", codes.PermissionDenied, ""},
+		{"empty rule", "/ignored", user, "", codes.PermissionDenied, ""},
+		{"service account", "/read", "bearer reader-token", "", codes.OK, "serviceAccounts/reader"},
+		{"service account without scope", "/write", "Bearer reader-token", "1", codes.PermissionDenied, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tc.authorization))
+			}
+			var principal string
+			_, err := a.UnaryServerInterceptor()(ctx, &request{tc.id}, &grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					if p := FromContext(ctx); p != nil {
+						principal = p.String()
+					}
+					return nil, nil
+				})
+			assert.Equal(t, tc.code, status.Code(err))
+			assert.Equal(t, tc.principal, principal)
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer reader-token"))
+		var p *Principal
+		err := a.StreamServerInterceptor()(nil, &stream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/stream"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				p = FromContext(ss.Context())
+				return nil
+			})
+		require.NoError(t, err)
+		require.NotNil(t, p)
+		assert.Equal(t, "reader", p.ServiceAccount)
+
+		ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", user))
+		err = a.StreamServerInterceptor()(nil, &stream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/stream"},
+			func(srv interface{}, ss grpc.ServerStream) error { return nil })
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+// apiKeys finds API keys by their hashes.
+type apiKeys map[string]*store.APIKey
+
+func (keys apiKeys) GetAPIKeyByHash(ctx context.Context, hash string) (*store.APIKey, error) {
+	if k, ok := keys[hash]; ok {
+		return k, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func TestAuthorizer_APIKeys(t *testing.T) {
+	issuer, err := NewIssuer([]*rsa.PrivateKey{generateKey(t)}, Options{})
+	require.NoError(t, err)
+	keys := apiKeys{}
+	key := func(scopes []string, expiresAt, revokedAt *time.Time) string {
+		key, hash, err := NewAPIKey()
+		require.NoError(t, err)
+		keys[hash] = &store.APIKey{ID: primitive.NewObjectID(), Hash: hash, Scopes: scopes, ExpiresAt: expiresAt, RevokedAt: revokedAt}
+		return key
+	}
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	reader := key([]string{ScopeUsersRead}, &future, nil)
+	expired := key([]string{ScopeUsersRead}, &past, nil)
+	revoked := key([]string{ScopeUsersRead}, nil, &past)
+	user, _, err := issuer.AccessToken("1", "user")
+	require.NoError(t, err)
+	a := NewAuthorizer(issuer, nil, keys, map[string]Rule{
+		"/read":  {Scope: ScopeUsersRead},
+		"/write": {Scope: ScopeUsersWrite},
+	})
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		md     metadata.MD
+		code   codes.Code
+	}{
+		{"valid", "/read", metadata.Pairs(APIKeyMetadata, reader), codes.OK},
+		{"without scope", "/write", metadata.Pairs(APIKeyMetadata, reader), codes.PermissionDenied},
+		{"expired", "/read", metadata.Pairs(APIKeyMetadata, expired), codes.Unauthenticated},
+		{"revoked", "/read", metadata.Pairs(APIKeyMetadata, revoked), codes.Unauthenticated},
+		{"unknown", "/read", metadata.Pairs(APIKeyMetadata, APIKeyPrefix+"abc"), codes.Unauthenticated},
+		{"malformed", "/read", metadata.Pairs(APIKeyMetadata, "abc"), codes.Unauthenticated},
+		{"with a token", "/read", metadata.Pairs(APIKeyMetadata, reader, "authorization", "Bearer "+user), codes.Unauthenticated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			ctx := ctxzap.ToContext(metadata.NewIncomingContext(context.Background(), tc.md), zap.New(core))
+			var p *Principal
+			_, err := a.UnaryServerInterceptor()(ctx, &request{}, &grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					p = FromContext(ctx)
+					ctxzap.Extract(ctx).Info("call")
+					return nil, nil
+				})
+			assert.Equal(t, tc.code, status.Code(err))
+			if tc.code != codes.OK {
+				return
+			}
+			require.NotNil(t, p)
+			k := keys[HashAPIKey(reader)]
+			assert.Equal(t, k.ID.Hex(), p.APIKey)
+			assert.Equal(t, "apiKeys/"+k.ID.Hex(), p.String())
+			require.Equal(t, 1, logs.Len())
+			assert.Equal(t, p.String(), logs.All()[0].ContextMap()["auth.caller"], "the caller should be logged")
+		})
+	}
+
+	t.Run("not accepted", func(t *testing.T) {
+		a := NewAuthorizer(issuer, nil, nil, map[string]Rule{"/read": {Scope: ScopeUsersRead}})
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadata, reader))
+		_, err := a.UnaryServerInterceptor()(ctx, &request{}, &grpc.UnaryServerInfo{FullMethod: "/read"},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func TestParseServiceAccounts(t *testing.T) {
+	hash := hashToken("token")
+	for name, tc := range map[string]struct {
+		yaml  string
+		valid bool
+	}{
+		"valid":         {"- {name: a, token_sha256: " + hash + ", scopes: [users.read, webhooks.write]}", true},
+		"empty":         {"", true},
+		"no name":       {"- {token_sha256: " + hash + "}", false},
+		"invalid hash":  {"- {name: a, token_sha256: abc}", false},
+		"unknown scope": {"- {name: a, token_sha256: " + hash + ", scopes: [users.delete]}", false},
+		"unknown field": {"- {name: a, token: abc}", false},
+	} {
+		_, err := ParseServiceAccounts([]byte(tc.yaml))
+		assert.Equal(t, tc.valid, err == nil, name)
+	}
+}
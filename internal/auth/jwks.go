@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+)
+
+// JWKSPath is a path the JWKS handler is served at.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWK is a public key in the JSON Web Key format, as described by RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a set of public keys access tokens are verified with.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(k *rsa.PublicKey) JWK {
+	n, e := encodeKey(k)
+	return JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: thumbprint(k), N: n, E: e}
+}
+
+// encodeKey returns base64url encoded modulus and exponent of a key.
+func encodeKey(k *rsa.PublicKey) (n, e string) {
+	n = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+	e = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	return n, e
+}
+
+// thumbprint returns RFC 7638 thumbprint of a key, a hash of its required members in lexicographic order.
+func thumbprint(k *rsa.PublicKey) string {
+	n, e := encodeKey(k)
+	b, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{e, "RSA", n})
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS returns public keys of all keys of the issuer, including ones which only verify tokens.
+func (i *Issuer) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range i.keys {
+		set.Keys = append(set.Keys, newJWK(&k.key.PublicKey))
+	}
+	return set
+}
+
+// JWKSHandler serves the JWKS of the issuer as JSON.
+func (i *Issuer) JWKSHandler() http.Handler {
+	body, _ := json.Marshal(i.JWKS())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// keys change only on restart, clients may cache them for a while.
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(body)
+	})
+}
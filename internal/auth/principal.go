@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+)
+
+// Scopes of service accounts and API keys.
+const (
+	ScopeUsersRead     = "users.read"
+	ScopeUsersWrite    = "users.write"
+	ScopeWebhooksRead  = "webhooks.read"
+	ScopeWebhooksWrite = "webhooks.write"
+)
+
+// IsScope reports whether the string is one of the scopes.
+func IsScope(s string) bool {
+	switch s {
+	case ScopeUsersRead, ScopeUsersWrite, ScopeWebhooksRead, ScopeWebhooksWrite:
+		return true
+	}
+	return false
+}
+
+// Principal is an authenticated caller, a user, a service account or an API key.
+type Principal struct {
+	// UserID and Role are set for users.
+	UserID string
+	Role   string
+	// ServiceAccount and Scopes are set for service accounts.
+	ServiceAccount string
+	Scopes         []string
+	// APIKey is an id of an API key, its Scopes are set too, otherwise it's like a service account.
+	APIKey string
+}
+
+// IsAdmin reports whether the principal is a user with the admin role.
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.UserID != "" && p.Role == store.RoleAdmin
+}
+
+// HasScope reports whether the principal is a service account or an API key with the scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil || (p.ServiceAccount == "" && p.APIKey == "") {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the principal, e.g. in events: users/<id>, serviceAccounts/<name> or apiKeys/<id>.
+func (p *Principal) String() string {
+	if p.ServiceAccount != "" {
+		return "serviceAccounts/" + p.ServiceAccount
+	}
+	if p.APIKey != "" {
+		return "apiKeys/" + p.APIKey
+	}
+	return "users/" + p.UserID
+}
+
+type principalKey struct{}
+
+// NewContext returns a context carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the call, nil when the caller is not authenticated.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
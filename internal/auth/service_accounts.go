@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ServiceAccount is a client of the service authenticated with a static token,
+// it may call RPCs allowed by its scopes.
+type ServiceAccount struct {
+	Name string `yaml:"name"`
+	// TokenSHA256 is a hex encoded SHA-256 hash of the token, the token itself is not stored.
+	TokenSHA256 string   `yaml:"token_sha256"`
+	Scopes      []string `yaml:"scopes"`
+}
+
+// ParseServiceAccounts parses a YAML list of service accounts.
+func ParseServiceAccounts(b []byte) ([]ServiceAccount, error) {
+	var accounts []ServiceAccount
+	if err := yaml.UnmarshalStrict(b, &accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if a.Name == "" {
+			return nil, errors.New("service account without a name")
+		}
+		if h, err := hex.DecodeString(a.TokenSHA256); err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("service account %s: invalid token_sha256", a.Name)
+		}
+		for _, s := range a.Scopes {
+			if !IsScope(s) {
+				return nil, fmt.Errorf("service account %s: unknown scope %q", a.Name, s)
+			}
+		}
+	}
+	return accounts, nil
+}
+
+// hashToken returns a hex encoded SHA-256 hash of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
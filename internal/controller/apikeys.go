@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (ctr *Ctr) CreateApiKey(ctx context.Context, req *usersvcv1.CreateApiKeyRequest) (*usersvcv1.ApiKey, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.ApiKey == nil {
+		return nil, status.Error(codes.InvalidArgument, "req.api_key should not be <nil>")
+	}
+	now := time.Now()
+	k := &store.APIKey{
+		Name:      strings.TrimSpace(req.ApiKey.Name),
+		Owner:     strings.TrimSpace(req.ApiKey.Owner),
+		Scopes:    req.ApiKey.Scopes,
+		CreatedAt: now,
+	}
+	if k.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name should not be empty")
+	}
+	if k.Owner == "" {
+		// keys are owned by their creators by default.
+		if p := auth.FromContext(ctx); p != nil {
+			k.Owner = p.String()
+		} else {
+			return nil, status.Error(codes.InvalidArgument, "owner should not be empty")
+		}
+	}
+	if len(k.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "scopes should not be empty")
+	}
+	for _, s := range k.Scopes {
+		if !auth.IsScope(s) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid scope: %q", s)
+		}
+	}
+	if req.ApiKey.ExpireTime != nil {
+		if err := req.ApiKey.ExpireTime.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		expiresAt := req.ApiKey.ExpireTime.AsTime()
+		if !expiresAt.After(now) {
+			return nil, status.Error(codes.InvalidArgument, "expire_time should be in the future")
+		}
+		k.ExpiresAt = &expiresAt
+	}
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	k.Hash = hash
+
+	k, err = ctr.store.CreateAPIKey(ctx, k)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	pb := apiKeyToPb(k)
+	pb.Key = key
+	return pb, nil
+}
+
+func (ctr *Ctr) ListApiKeys(ctx context.Context, req *usersvcv1.ListApiKeysRequest) (*usersvcv1.ListApiKeysResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if err := paginate(&req.Page, &req.Size); err != nil {
+		return nil, err
+	}
+
+	count, err := ctr.store.CountAPIKeys(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	keys, err := ctr.store.ListAPIKeys(ctx, &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &usersvcv1.ListApiKeysResponse{
+		Page:  req.Page,
+		Size:  req.Size,
+		Total: count,
+	}
+	for _, k := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyToPb(k))
+	}
+	return resp, nil
+}
+
+func (ctr *Ctr) RevokeApiKey(ctx context.Context, req *usersvcv1.RevokeApiKeyRequest) (*usersvcv1.ApiKey, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	k, err := ctr.store.RevokeAPIKey(ctx, id, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return apiKeyToPb(k), nil
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errTokenReused is returned from transactions of RefreshToken when a used refresh token is used again,
+// its family is revoked, so the transaction is committed anyway.
+var errTokenReused = errors.New("refresh token was already used")
+
+func (ctr *Ctr) Authenticate(ctx context.Context, req *usersvcv1.AuthenticateRequest) (*usersvcv1.Token, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.Login == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "login and password should not be empty")
+	}
+
+	client := clientIP(ctx)
+	if client != "" {
+		if err := ctr.checkLockouts(ctx, store.ClientLockoutKey(client)); err != nil {
+			return nil, err
+		}
+	}
+	u, err := ctr.store.Authenticate(ctx, req.Login, req.Password)
+	if u != nil {
+		// the user's lockout is checked after its password, so its result doesn't reveal whether the password matches.
+		if err := ctr.checkLockouts(ctx, store.UserLockoutKey(u.ID)); err != nil {
+			return nil, err
+		}
+	}
+	// whether the user exists is not revealed.
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrInvalidCreds) {
+		if err := ctr.recordFailure(ctx, u, client); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid login or password")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// the code is checked once the password matches, so it's not used by guesses of passwords.
+	tf, err := ctr.twoFactor(ctx, u.ID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = ctr.verifyTwoFactor(ctx, u.ID, tf, req.TwoFactorCode)
+	if errors.Is(err, errTwoFactorRequired) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, store.ErrInvalidCreds) {
+		if err := ctr.recordFailure(ctx, u, client); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid two_factor_code")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := ctr.resetFailures(ctx, u); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	token, err := ctr.issueToken(ctx, u, primitive.NewObjectID())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return token, nil
+}
+
+func (ctr *Ctr) RefreshToken(ctx context.Context, req *usersvcv1.RefreshTokenRequest) (*usersvcv1.Token, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token should not be empty")
+	}
+
+	var token *usersvcv1.Token
+	reused := false
+	err := ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		t, err := ctr.store.UseRefreshToken(ctx, auth.HashRefreshToken(req.RefreshToken))
+		if err != nil {
+			return err
+		}
+		if t.RevokedAt != nil {
+			// the token was probably stolen, so all tokens rotated from the same authentication are revoked.
+			reused = true
+			return ctr.store.RevokeRefreshTokens(ctx, t.Family)
+		}
+		u, err := ctr.store.GetUserByID(ctx, t.UserID)
+		if err != nil {
+			return err
+		}
+		if u.DeletedAt != nil {
+			return store.ErrNotFound
+		}
+		// locked users can't refresh their sessions either, the token is used only once they're unlocked.
+		if err := ctr.checkLockouts(ctx, store.UserLockoutKey(u.ID)); err != nil {
+			return err
+		}
+		token, err = ctr.issueToken(ctx, u, t.Family)
+		return err
+	})
+	if err == nil && reused {
+		err = errTokenReused
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if errors.Is(err, errTokenReused) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if _, ok := status.FromError(err); err != nil && ok {
+		// lockout errors.
+		return nil, err
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return token, nil
+}
+
+func (ctr *Ctr) RevokeToken(ctx context.Context, req *usersvcv1.RevokeTokenRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token should not be empty")
+	}
+
+	err := ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		t, err := ctr.store.UseRefreshToken(ctx, auth.HashRefreshToken(req.RefreshToken))
+		if err != nil {
+			return err
+		}
+		return ctr.store.RevokeRefreshTokens(ctx, t.Family)
+	})
+	// like in RFC 7009, invalid tokens are not reported, they can't be used anyway.
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// issueToken returns a new access token of a user and a new refresh token of the family,
+// tokens carry the current role of the user.
+func (ctr *Ctr) issueToken(ctx context.Context, u *store.User, family primitive.ObjectID) (*usersvcv1.Token, error) {
+	accessToken, expiresAt, err := ctr.tokens.AccessToken(u.ID.Hex(), userRole(u))
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	t, err := ctr.store.CreateRefreshToken(ctx, &store.RefreshToken{
+		Hash:      hash,
+		UserID:    u.ID,
+		Family:    family,
+		ExpiresAt: time.Now().Add(ctr.tokens.RefreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &usersvcv1.Token{
+		AccessToken:       accessToken,
+		TokenType:         "Bearer",
+		ExpireTime:        timestamppb.New(expiresAt),
+		RefreshToken:      refreshToken,
+		RefreshExpireTime: timestamppb.New(t.ExpiresAt),
+	}, nil
+}
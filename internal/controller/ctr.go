@@ -2,12 +2,20 @@ package controller
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"time"
 
 	"github.com/gookit/validate"
 	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
 	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
+	"github.com/mlukasik-dev/usersvc/internal/password"
 	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/totp"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"github.com/mlukasik-dev/usersvc/pkg/pagetoken"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
@@ -16,47 +24,147 @@ import (
 )
 
 type Ctr struct {
-	store  *store.Store
-	logger *zap.Logger
-	events events.Client
+	store      store.Repository
+	logger     *zap.Logger
+	events     events.Client
+	pageTokens *pagetoken.Codec
+	tokens     *auth.Issuer
+	notifier   notifier.Notifier
+	passwords  *password.Policy
+	lockout    LockoutOptions
+	secrets    *totp.Cipher
+
+	twoFactorIssuer string
+
+	resetTTL        time.Duration
+	verificationTTL time.Duration
 }
 
-func New(s *store.Store, l *zap.Logger, e events.Client) usersvcv1.ServiceServer {
-	return &Ctr{s, l, e}
+// Options configure the controller.
+type Options struct {
+	// PageTokenKey is a key page tokens are signed with,
+	// it should be the same on all instances of the service.
+	PageTokenKey []byte
+	// Tokens issues access tokens of authenticated users.
+	Tokens *auth.Issuer
+	// Notifier delivers password reset and email verification tokens, it's required.
+	Notifier notifier.Notifier
+	// PasswordResetTTL is for how long password reset tokens are valid, an hour by default.
+	PasswordResetTTL time.Duration
+	// EmailVerificationTTL is for how long email verification tokens are valid, a day by default.
+	EmailVerificationTTL time.Duration
+	// PasswordPolicy is checked by CreateUser, UpdatePassword and ConfirmPasswordReset,
+	// password.DefaultPolicy by default.
+	PasswordPolicy *password.Policy
+	// Lockout limits failed password checks, see LockoutOptions for defaults.
+	Lockout LockoutOptions
+	// TwoFactorKey encrypts TOTP secrets of users, it should be the same on all instances of the service.
+	// A random one is generated by default, so secrets can't be decrypted after a restart.
+	TwoFactorKey []byte
+	// TwoFactorIssuer names the service in authenticator apps, usersvc by default.
+	TwoFactorIssuer string
 }
 
-func (ctr *Ctr) ListUsers(ctx context.Context, req *usersvcv1.ListUsersRequest) (*usersvcv1.ListUsersResponse, error) {
-	if req == nil {
-		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+func (o *Options) setDefaults(l *zap.Logger) {
+	if o.PasswordResetTTL <= 0 {
+		o.PasswordResetTTL = time.Hour
 	}
-	if req.Page < 0 {
-		return nil, status.Error(codes.InvalidArgument, "req.page should be a positive integer")
+	if o.EmailVerificationTTL <= 0 {
+		o.EmailVerificationTTL = 24 * time.Hour
 	}
-	if req.Size < 0 {
-		return nil, status.Error(codes.InvalidArgument, "req.size should be a positive integer")
+	if o.PasswordPolicy == nil {
+		o.PasswordPolicy = &password.DefaultPolicy
 	}
-	if req.Page == 0 {
-		req.Page = 1 // default page.
+	o.Lockout.setDefaults()
+	if len(o.TwoFactorKey) == 0 {
+		o.TwoFactorKey = make([]byte, 32)
+		if _, err := rand.Read(o.TwoFactorKey); err != nil {
+			l.Fatal("generating two-factor key", zap.Error(err))
+		}
 	}
-	if req.Size == 0 {
-		req.Size = 15 // default size.
+	if o.TwoFactorIssuer == "" {
+		o.TwoFactorIssuer = "usersvc"
+	}
+}
+
+func New(s store.Repository, l *zap.Logger, e events.Client, opts Options) (usersvcv1.ServiceServer, error) {
+	if opts.Notifier == nil {
+		return nil, errors.New("notifier is required")
+	}
+	opts.setDefaults(l)
+	return &Ctr{
+		store:           s,
+		logger:          l,
+		events:          e,
+		pageTokens:      pagetoken.New(opts.PageTokenKey),
+		tokens:          opts.Tokens,
+		notifier:        opts.Notifier,
+		passwords:       opts.PasswordPolicy,
+		lockout:         opts.Lockout,
+		secrets:         totp.NewCipher(opts.TwoFactorKey),
+		twoFactorIssuer: opts.TwoFactorIssuer,
+		resetTTL:        opts.PasswordResetTTL,
+		verificationTTL: opts.EmailVerificationTTL,
+	}, nil
+}
+
+func (ctr *Ctr) ListUsers(ctx context.Context, req *usersvcv1.ListUsersRequest) (*usersvcv1.ListUsersResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if err := paginate(&req.Page, &req.Size); err != nil {
+		return nil, err
 	}
 	if req.Filters == nil {
 		req.Filters = &usersvcv1.User{}
 	}
-	filter := pbToUser(req.Filters)
-	if err := filter.Validate(store.FilterValidationKind); err != nil {
+	fields := pbToUser(req.Filters)
+	if err := fields.Validate(store.FilterValidationKind); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.One())
 	}
+	expr, err := store.ParseUserFilter(req.Filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid filter: "+err.Error())
+	}
+	filter := filtering.AndAll(fields.Expr(), expr)
+	if !req.ShowDeleted {
+		filter = filtering.AndAll(filter, notDeleted)
+	}
+	order, err := store.ParseOrder(req.OrderBy)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid order_by: "+err.Error())
+	}
+
+	query := queryHash(filter, order)
+	p := &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)}
+	if req.PageToken != "" {
+		var token userPageToken
+		if err := ctr.pageTokens.Decode(req.PageToken, &token); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		id, err := primitive.ObjectIDFromHex(token.ID)
+		if err != nil || token.Query != query {
+			return nil, status.Error(codes.InvalidArgument, "page_token doesn't match the request")
+		}
+		// one more user is listed to find out whether there is a next page.
+		p = &store.Pagination{Size: uint(req.Size) + 1, After: &store.Cursor{Key: token.Key, ID: id}}
+	}
 
 	count, err := ctr.store.CountUsers(ctx, filter)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	users, err := ctr.store.ListUsers(ctx, filter, &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)})
+	users, err := ctr.store.ListUsers(ctx, filter, order, p)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	more := int64(req.Page-1)*int64(req.Size)+int64(len(users)) < count
+	if p.After != nil {
+		more = len(users) > int(req.Size)
+		if more {
+			users = users[:req.Size]
+		}
+	}
 
 	resp := &usersvcv1.ListUsersResponse{
 		Page:  req.Page,
@@ -66,6 +174,39 @@ func (ctr *Ctr) ListUsers(ctx context.Context, req *usersvcv1.ListUsersRequest)
 	for _, u := range users {
 		resp.Users = append(resp.Users, userToPb(u))
 	}
+	if more && len(users) > 0 {
+		c := order.Cursor(users[len(users)-1])
+		resp.NextPageToken, err = ctr.pageTokens.Encode(userPageToken{Query: query, Key: c.Key, ID: c.ID.Hex()})
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return resp, nil
+}
+
+func (ctr *Ctr) SearchUsers(ctx context.Context, req *usersvcv1.SearchUsersRequest) (*usersvcv1.SearchUsersResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if err := paginate(&req.Page, &req.Size); err != nil {
+		return nil, err
+	}
+	if len(store.SearchTerms(req.Query)) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "query should not be empty")
+	}
+
+	users, total, err := ctr.store.SearchUsers(ctx, req.Query, &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &usersvcv1.SearchUsersResponse{
+		Page:  req.Page,
+		Size:  req.Size,
+		Total: total,
+	}
+	for _, u := range users {
+		resp.Users = append(resp.Users, userToPb(u))
+	}
 	return resp, nil
 }
 
@@ -79,6 +220,9 @@ func (ctr *Ctr) GetUser(ctx context.Context, req *usersvcv1.GetUserRequest) (*us
 	}
 
 	u, err := ctr.store.GetUserByID(ctx, id)
+	if err == nil && u.DeletedAt != nil && !req.ShowDeleted {
+		err = store.ErrNotFound
+	}
 	if errors.Is(err, store.ErrNotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
@@ -99,15 +243,33 @@ func (ctr *Ctr) CreateUser(ctx context.Context, req *usersvcv1.CreateUserRequest
 	if err := u.Validate(store.CreateValidationKind); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.One())
 	}
+	if u.Role != "" && u.Role != store.RoleUser && !canSetRoles(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "only admins can set role")
+	}
+	if err := ctr.checkPassword("password", req.Password, u); err != nil {
+		return nil, err
+	}
 
-	u, err := ctr.store.CreateUser(ctx, u, req.Password)
+	var verification *notifier.Notification
+	err := ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if u, err = ctr.store.CreateUser(ctx, u, req.Password); err != nil {
+			return err
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_CREATE, nil, u, nil)
+		if err := ctr.events.Publish(ctx, events.CreateUserEvent, e); err != nil {
+			return err
+		}
+		verification, err = ctr.newEmailVerification(ctx, u)
+		return err
+	})
 	if errors.Is(err, store.ErrAlreadyExists) {
 		return nil, status.Error(codes.AlreadyExists, err.Error())
 	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	ctr.events.Publish(events.CreateUserEvent, u.ID)
+	ctr.notify(ctx, verification)
 	return userToPb(u), nil
 }
 
@@ -118,17 +280,63 @@ func (ctr *Ctr) UpdatePassword(ctx context.Context, req *usersvcv1.UpdatePasswor
 	if !validate.IsEmail(req.Email) {
 		return nil, status.Error(codes.NotFound, "invalid email")
 	}
+	client := clientIP(ctx)
+	if client != "" {
+		if err := ctr.checkLockouts(ctx, store.ClientLockoutKey(client)); err != nil {
+			return nil, err
+		}
+	}
+	u, err := ctr.store.GetUserByEmail(ctx, req.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		if err := ctr.recordFailure(ctx, nil, client); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := ctr.checkPassword("new_password", req.NewPassword, u); err != nil {
+		return nil, err
+	}
+	if err := ctr.checkLockouts(ctx, store.UserLockoutKey(u.ID)); err != nil {
+		return nil, err
+	}
+
+	tf, err := ctr.twoFactor(ctx, u.ID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
-	err := ctr.store.UpdatePassword(ctx, req.Email, req.OldPassword, req.NewPassword)
+	// the code is used only when the old password matches and the password is changed only when the code is valid.
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := ctr.store.UpdatePassword(ctx, req.Email, req.OldPassword, req.NewPassword); err != nil {
+			return err
+		}
+		if err := ctr.verifyTwoFactor(ctx, u.ID, tf, req.TwoFactorCode); err != nil {
+			return err
+		}
+		// sessions started with the old password are revoked.
+		return ctr.store.RevokeUserRefreshTokens(ctx, u.ID)
+	})
 	if errors.Is(err, store.ErrNotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
+	if errors.Is(err, errTwoFactorRequired) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
 	if errors.Is(err, store.ErrInvalidCreds) {
+		if err := ctr.recordFailure(ctx, u, client); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 		return nil, status.Error(codes.PermissionDenied, err.Error())
 	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if err := ctr.resetFailures(ctx, u); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	return &emptypb.Empty{}, nil
 }
 
@@ -142,27 +350,75 @@ func (ctr *Ctr) UpdateUser(ctx context.Context, req *usersvcv1.UpdateUserRequest
 	if req.UpdateMask == nil {
 		return nil, status.Error(codes.InvalidArgument, "req.update_mask should not be <nil>")
 	}
-	if !req.UpdateMask.IsValid(req.User) || len(req.UpdateMask.Paths) == 0 || contains(req.UpdateMask.Paths, "id") {
+	if !req.UpdateMask.IsValid(req.User) || len(req.UpdateMask.Paths) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "invalid update_mask")
 	}
+	for _, field := range userOutputOnlyFields {
+		if contains(req.UpdateMask.Paths, field) {
+			return nil, status.Error(codes.InvalidArgument, "invalid update_mask")
+		}
+	}
 	u, err := pbToUser(req.User).SetID(req.User.Id)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "invalid id")
 	}
+	version, err := parseEtag(req.User.Etag)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if err := u.Validate(store.UpdateValidationKind); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.One())
 	}
-	u, err = ctr.store.UpdateUser(ctx, u, req.UpdateMask.Paths)
+	if contains(req.UpdateMask.Paths, "role") {
+		if u.Role == "" {
+			return nil, status.Error(codes.InvalidArgument, "role should be specified")
+		}
+		if !canSetRoles(ctx) {
+			return nil, status.Error(codes.PermissionDenied, "only admins can set role")
+		}
+	}
+	var verification *notifier.Notification
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		before, err := ctr.store.GetUserByID(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		paths := req.UpdateMask.Paths
+		emailChanged := contains(paths, "email") && u.Email != before.Email
+		if emailChanged {
+			// the new email isn't verified yet.
+			u.EmailVerified = false
+			paths = append(paths[:len(paths):len(paths)], "email_verified")
+		}
+		after, err := ctr.store.UpdateUser(ctx, u, paths, version)
+		if err != nil {
+			return err
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_UPDATE, before, after, paths)
+		if err := ctr.events.Publish(ctx, events.UpdateUserEvent, e); err != nil {
+			return err
+		}
+		if emailChanged {
+			if verification, err = ctr.newEmailVerification(ctx, after); err != nil {
+				return err
+			}
+		}
+		u = after
+		return nil
+	})
 	if errors.Is(err, store.ErrNotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
 	if errors.Is(err, store.ErrAlreadyExists) {
 		return nil, status.Error(codes.AlreadyExists, err.Error())
 	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted, "etag doesn't match, user was changed")
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	ctr.events.Publish(events.UpdateUserEvent, u.ID)
+	ctr.notify(ctx, verification)
 	return userToPb(u), nil
 }
 
@@ -174,17 +430,69 @@ func (ctr *Ctr) DeleteUser(ctx context.Context, req *usersvcv1.DeleteUserRequest
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	err = ctr.store.DeleteUser(ctx, id)
+	version, err := parseEtag(req.Etag)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		u, err := ctr.store.DeleteUser(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_DELETE, u, nil, nil)
+		return ctr.events.Publish(ctx, events.DeleteUserEvent, e)
+	})
 	if errors.Is(err, store.ErrNotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted, "etag doesn't match, user was changed")
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	ctr.events.Publish(events.DeleteUserEvent, id)
 	return &emptypb.Empty{}, nil
 }
 
+func (ctr *Ctr) UndeleteUser(ctx context.Context, req *usersvcv1.UndeleteUserRequest) (*usersvcv1.User, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	version, err := parseEtag(req.Etag)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var u *store.User
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		before, err := ctr.store.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u, err = ctr.store.UndeleteUser(ctx, id, version); err != nil {
+			return err
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_UNDELETE, before, u, nil)
+		return ctr.events.Publish(ctx, events.UndeleteUserEvent, e)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, store.ErrNotDeleted) {
+		return nil, status.Error(codes.AlreadyExists, "user is not deleted")
+	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted, "etag doesn't match, user was changed")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return userToPb(u), nil
+}
+
 func (ctr *Ctr) HealthCheck(ctx context.Context, _ *usersvcv1.HealthCheckRequest) (*usersvcv1.HealthCheckResponse, error) {
 	if err := ctr.store.Ping(ctx); err != nil {
 		ctr.logger.Error("mongodb ping failed", zap.String("error", err.Error()))
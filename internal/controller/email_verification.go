@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (ctr *Ctr) VerifyEmail(ctx context.Context, req *usersvcv1.VerifyEmailRequest) (*usersvcv1.User, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token should not be empty")
+	}
+
+	var u *store.User
+	err := ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		t, err := ctr.store.UseOneTimeToken(ctx, store.PurposeEmailVerification, auth.HashOneTimeToken(req.Token))
+		if err != nil {
+			return err
+		}
+		before, err := ctr.store.GetUserByID(ctx, t.UserID)
+		if err != nil {
+			return err
+		}
+		// deleted users are not found.
+		paths := []string{"email_verified"}
+		after, err := ctr.store.UpdateUser(ctx, &store.User{ID: t.UserID, EmailVerified: true}, paths, store.AnyVersion)
+		if err != nil {
+			return err
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_UPDATE, before, after, paths)
+		if err := ctr.events.Publish(ctx, events.UpdateUserEvent, e); err != nil {
+			return err
+		}
+		u = after
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid email verification token")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return userToPb(u), nil
+}
+
+// newEmailVerification creates an email verification token of a user, replacing the previous one,
+// and returns a notification with it to send once the transaction is committed.
+func (ctr *Ctr) newEmailVerification(ctx context.Context, u *store.User) (*notifier.Notification, error) {
+	return ctr.newOneTimeToken(ctx, u, store.PurposeEmailVerification, notifier.KindEmailVerification, ctr.verificationTTL)
+}
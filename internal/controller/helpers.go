@@ -1,5 +1,18 @@
 package controller
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
 func contains(slice []string, s string) bool {
 	for _, a := range slice {
 		if a == s {
@@ -8,3 +21,51 @@ func contains(slice []string, s string) bool {
 	}
 	return false
 }
+
+// paginate validates page and size request params and sets their default values.
+func paginate(page, size *int32) error {
+	if *page < 0 {
+		return status.Error(codes.InvalidArgument, "req.page should be a positive integer")
+	}
+	if *size < 0 {
+		return status.Error(codes.InvalidArgument, "req.size should be a positive integer")
+	}
+	if *page == 0 {
+		*page = 1 // default page.
+	}
+	if *size == 0 {
+		*size = 15 // default size.
+	}
+	return nil
+}
+
+// notDeleted is a filter of users which aren't deleted, they are listed unless show_deleted is set.
+var notDeleted = &filtering.Not{Expr: &filtering.Restriction{Field: "delete_time", Op: filtering.Has}}
+
+// userPageToken is a content of ListUsers page tokens,
+// Key and ID are the sort key and id of the last user of the page.
+type userPageToken struct {
+	// Query is a hash of filter and order of the request the token was returned by.
+	Query string    `json:"q"`
+	Key   []*string `json:"k,omitempty"`
+	ID    string    `json:"id"`
+}
+
+// queryHash identifies filter and order of a list request,
+// so page tokens are not reused with other ones.
+func queryHash(filter filtering.Expr, order store.Order) string {
+	q := order.String()
+	if filter != nil {
+		q = filter.String() + "\n" + q
+	}
+	sum := sha256.Sum256([]byte(q))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// actor returns who makes the request, the authenticated caller or an empty string.
+func actor(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return p.String()
+	}
+	return ""
+}
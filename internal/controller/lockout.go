@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// LockoutOptions limit failed password checks of Authenticate and UpdatePassword of a user and from a client IP address.
+// After FreeFailures failures every check has to wait for Delay, which doubles with every further failure up to MaxDelay,
+// and after MaxFailures failures of a user, or MaxClientFailures from an address, it's locked for Duration.
+// Failures are forgotten after Window without a failure, failures of a user also when its password matches.
+type LockoutOptions struct {
+	// MaxFailures is 10 by default.
+	MaxFailures int
+	// MaxClientFailures is 100 by default, clients can check passwords of many users.
+	MaxClientFailures int
+	// FreeFailures is 3 by default.
+	FreeFailures int
+	// Delay is a second and MaxDelay is a minute by default.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Duration is 15 minutes by default.
+	Duration time.Duration
+	// Window is an hour by default.
+	Window time.Duration
+}
+
+func (o *LockoutOptions) setDefaults() {
+	if o.MaxFailures <= 0 {
+		o.MaxFailures = 10
+	}
+	if o.MaxClientFailures <= 0 {
+		o.MaxClientFailures = 100
+	}
+	if o.FreeFailures <= 0 {
+		o.FreeFailures = 3
+	}
+	if o.Delay <= 0 {
+		o.Delay = time.Second
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = time.Minute
+	}
+	if o.Duration <= 0 {
+		o.Duration = 15 * time.Minute
+	}
+	if o.Window <= 0 {
+		o.Window = time.Hour
+	}
+}
+
+// retryAt returns when the next password check of a lockout can be made, it's in the past when it can be made now.
+func (o *LockoutOptions) retryAt(l *store.Lockout) time.Time {
+	var t time.Time
+	if l.LockedUntil != nil {
+		t = *l.LockedUntil
+	}
+	if l.LastFailureAt != nil && l.Failures > o.FreeFailures {
+		delay := o.Delay
+		for i := o.FreeFailures + 1; i < l.Failures && delay < o.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > o.MaxDelay {
+			delay = o.MaxDelay
+		}
+		if delayed := l.LastFailureAt.Add(delay); delayed.After(t) {
+			t = delayed
+		}
+	}
+	return t
+}
+
+func (ctr *Ctr) LockUser(ctx context.Context, req *usersvcv1.LockUserRequest) (*usersvcv1.Lockout, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	duration := ctr.lockout.Duration
+	if req.Duration != nil {
+		if duration = req.Duration.AsDuration(); duration <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "duration should be positive")
+		}
+	}
+
+	var l *store.Lockout
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		u, err := ctr.store.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u.DeletedAt != nil {
+			return store.ErrNotFound
+		}
+		l, err = ctr.lockUser(ctx, u, time.Now().Add(duration))
+		return err
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return lockoutToPb(id, l), nil
+}
+
+func (ctr *Ctr) UnlockUser(ctx context.Context, req *usersvcv1.UnlockUserRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		u, err := ctr.store.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		l, err := ctr.store.DeleteLockout(ctx, store.UserLockoutKey(id))
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// forgetting failures of a user which isn't locked doesn't publish an event.
+		if !l.IsLocked(time.Now()) {
+			return nil
+		}
+		e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_UNLOCK, nil, u, nil)
+		return ctr.events.Publish(ctx, events.UnlockUserEvent, e)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (ctr *Ctr) GetUserLockout(ctx context.Context, req *usersvcv1.GetUserLockoutRequest) (*usersvcv1.Lockout, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	_, err = ctr.store.GetUserByID(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	l, err := ctr.store.GetLockout(ctx, store.UserLockoutKey(id))
+	if errors.Is(err, store.ErrNotFound) {
+		l, err = &store.Lockout{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return lockoutToPb(id, l), nil
+}
+
+// checkLockouts returns RESOURCE_EXHAUSTED error with RetryInfo detail when a lockout with one of the keys
+// is locked or its next password check should be delayed.
+func (ctr *Ctr) checkLockouts(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		l, err := ctr.store.GetLockout(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		retryAt := ctr.lockout.retryAt(l)
+		if !now.Before(retryAt) {
+			continue
+		}
+		msg := "too many failed password checks, retry later"
+		if l.IsLocked(now) {
+			msg = "password checks are locked, retry later"
+		}
+		st, err := status.New(codes.ResourceExhausted, msg).
+			WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAt.Sub(now))})
+		if err != nil {
+			return status.Error(codes.ResourceExhausted, msg)
+		}
+		return st.Err()
+	}
+	return nil
+}
+
+// recordFailure counts a failed password check of a user, unless it's nil, and from a client, unless it's empty,
+// and locks them out when they have too many failures.
+func (ctr *Ctr) recordFailure(ctx context.Context, u *store.User, client string) error {
+	now := time.Now()
+	expiresAt := now.Add(ctr.lockout.Window)
+	return ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if u != nil {
+			l, err := ctr.store.AddLockoutFailure(ctx, store.UserLockoutKey(u.ID), expiresAt)
+			if err != nil {
+				return err
+			}
+			if l.Failures >= ctr.lockout.MaxFailures {
+				if _, err := ctr.lockUser(ctx, u, now.Add(ctr.lockout.Duration)); err != nil {
+					return err
+				}
+			}
+		}
+		if client != "" {
+			l, err := ctr.store.AddLockoutFailure(ctx, store.ClientLockoutKey(client), expiresAt)
+			if err != nil {
+				return err
+			}
+			if l.Failures >= ctr.lockout.MaxClientFailures {
+				if _, err := ctr.store.LockOut(ctx, store.ClientLockoutKey(client), now.Add(ctr.lockout.Duration)); err != nil {
+					return err
+				}
+				ctr.logger.Warn("client locked out after too many failed password checks", zap.String("ip", client))
+			}
+		}
+		return nil
+	})
+}
+
+// resetFailures forgets failed password checks of a user once its password matches.
+func (ctr *Ctr) resetFailures(ctx context.Context, u *store.User) error {
+	_, err := ctr.store.DeleteLockout(ctx, store.UserLockoutKey(u.ID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// lockUser locks a user out until the time and publishes an event about it.
+func (ctr *Ctr) lockUser(ctx context.Context, u *store.User, until time.Time) (*store.Lockout, error) {
+	l, err := ctr.store.LockOut(ctx, store.UserLockoutKey(u.ID), until)
+	if err != nil {
+		return nil, err
+	}
+	e := userEvent(ctx, usersvcv1.EventType_EVENT_TYPE_LOCK, nil, u, nil)
+	e.LockExpireTime = timestamppb.New(until)
+	return l, ctr.events.Publish(ctx, events.LockUserEvent, e)
+}
+
+// clientIP returns an IP address of the caller, or an empty string when it's unknown.
+// Behind a proxy it's the address of the proxy.
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -4,22 +4,28 @@ package controller_test
 
 import (
 	"context"
+	"crypto/rsa"
 	"log"
 	"os"
 	"testing"
 
 	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
 	"github.com/mlukasik-dev/usersvc/internal/appconfig"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
 	"github.com/mlukasik-dev/usersvc/internal/controller"
 	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
 	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/store/backend"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
 var (
-	s        *store.Store
+	s        store.Repository
 	ctr      usersvcv1.ServiceServer
 	l        *zap.Logger
+	tokens   *auth.Issuer
 	testData = struct {
 		users []*store.User
 	}{
@@ -31,9 +37,17 @@ var (
 	}
 )
 
+// Tests run against the in-memory store by default,
+// set STORAGE_DRIVER env. variable to run them against another backend.
 var testConfig = `
+storage:
+  driver: ${STORAGE_DRIVER:-memory}
 mongodb:
-  uri: ${MONGODB_URI:?uri was not provided}
+  uri: ${MONGODB_URI}
+postgres:
+  dsn: ${POSTGRES_DSN}
+sqlite:
+  path: ${SQLITE_PATH}
 `
 
 func TestMain(m *testing.M) {
@@ -48,28 +62,36 @@ func TestMain(m *testing.M) {
 	}
 	defer l.Sync()
 
-	client, err := store.Connect(appconfig.AppConfig.Mongodb.URI)
+	var closeStore func() error
+	s, closeStore, err = backend.Open(context.Background(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(context.Background())
+	defer closeStore()
 
-	s = store.New(client)
-	if err := s.CreateIndexes(context.Background()); err != nil {
+	if err := s.Ping(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := s.Ping(context.Background()); err != nil {
+	if err := seedDB(); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := seedDB(); err != nil {
+	key, err := auth.GenerateKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokens, err = auth.NewIssuer([]*rsa.PrivateKey{key}, auth.Options{})
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	e := events.New()
+	e := events.New(s)
 
-	ctr = controller.New(s, l, e)
+	ctr, err = controller.New(s, l, e, controller.Options{PageTokenKey: []byte("test"), Tokens: tokens, Notifier: discard{}})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	code := m.Run()
 
@@ -78,6 +100,24 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// discard is a notifier of tests which don't check notifications.
+type discard struct{}
+
+func (discard) Notify(context.Context, *notifier.Notification) error {
+	return nil
+}
+
+// newCtr creates a controller with the test store and logger,
+// notifications are discarded unless opts have a notifier.
+func newCtr(t *testing.T, e events.Client, opts controller.Options) usersvcv1.ServiceServer {
+	if opts.Notifier == nil {
+		opts.Notifier = discard{}
+	}
+	ctr, err := controller.New(s, l, e, opts)
+	require.NoError(t, err)
+	return ctr
+}
+
 func seedDB() error {
 	var users []*store.User
 	for _, u := range testData.users {
@@ -93,7 +133,7 @@ func seedDB() error {
 
 func unseedDB() error {
 	for _, u := range testData.users {
-		err := s.DeleteUser(context.Background(), u.ID)
+		_, err := s.DeleteUser(context.Background(), u.ID, store.AnyVersion)
 		if err != nil {
 			return err
 		}
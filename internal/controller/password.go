@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkPassword returns INVALID_ARGUMENT error with a BadRequest detail
+// when a new password of a user passed in the field doesn't satisfy the password policy.
+func (ctr *Ctr) checkPassword(field, pass string, u *store.User) error {
+	err := ctr.passwords.Check(pass, password.User{Email: u.Email, Nickname: deref.String(u.Nickname)})
+	var perr *password.Error
+	if errors.As(err, &perr) {
+		return passwordError(field, perr)
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// passwordError converts a password policy error to INVALID_ARGUMENT error,
+// each violation is reported as a field violation of the BadRequest detail.
+func passwordError(field string, err *password.Error) error {
+	br := &errdetails.BadRequest{}
+	for _, v := range err.Violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: "password " + v,
+		})
+	}
+	st, derr := status.New(codes.InvalidArgument, err.Error()).WithDetails(br)
+	if derr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
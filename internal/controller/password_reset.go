@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gookit/validate"
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (ctr *Ctr) RequestPasswordReset(ctx context.Context, req *usersvcv1.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if !validate.IsEmail(req.Email) {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+
+	u, err := ctr.store.GetUserByEmail(ctx, req.Email)
+	// whether the user exists is not revealed.
+	if errors.Is(err, store.ErrNotFound) {
+		return &emptypb.Empty{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	n, err := ctr.newOneTimeToken(ctx, u, store.PurposePasswordReset, notifier.KindPasswordReset, ctr.resetTTL)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// failures are only logged, so they don't reveal the user exists either.
+	ctr.notify(ctx, n)
+	return &emptypb.Empty{}, nil
+}
+
+func (ctr *Ctr) ConfirmPasswordReset(ctx context.Context, req *usersvcv1.ConfirmPasswordResetRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		return nil, status.Error(codes.InvalidArgument, "token and new_password should not be empty")
+	}
+
+	err := ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		t, err := ctr.store.UseOneTimeToken(ctx, store.PurposePasswordReset, auth.HashOneTimeToken(req.Token))
+		if err != nil {
+			return err
+		}
+		u, err := ctr.store.GetUserByID(ctx, t.UserID)
+		if err != nil {
+			return err
+		}
+		// the token is used only when the password is changed, so the user can try another one.
+		if err := ctr.passwords.Check(req.NewPassword, password.User{Email: u.Email, Nickname: deref.String(u.Nickname)}); err != nil {
+			return err
+		}
+		if err := ctr.store.SetPassword(ctx, t.UserID, req.NewPassword); err != nil {
+			return err
+		}
+		// sessions are revoked, so a reset recovers the account from whoever stole it.
+		return ctr.store.RevokeUserRefreshTokens(ctx, t.UserID)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid password reset token")
+	}
+	var perr *password.Error
+	if errors.As(err, &perr) {
+		return nil, passwordError("new_password", perr)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// newOneTimeToken creates a one-time token of a user with the purpose and returns a notification with it,
+// which should be sent once the transaction creating the token is committed.
+func (ctr *Ctr) newOneTimeToken(ctx context.Context, u *store.User, purpose, kind string, ttl time.Duration) (*notifier.Notification, error) {
+	token, hash, err := auth.NewOneTimeToken()
+	if err != nil {
+		return nil, err
+	}
+	t, err := ctr.store.CreateOneTimeToken(ctx, &store.OneTimeToken{
+		Hash:      hash,
+		UserID:    u.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &notifier.Notification{
+		Kind:      kind,
+		UserID:    u.ID.Hex(),
+		Email:     u.Email,
+		Token:     token,
+		ExpiresAt: t.ExpiresAt,
+	}, nil
+}
+
+// notify sends a notification, unless it's nil. Failures are only logged, the call already made its changes
+// and the user can ask for a new token.
+func (ctr *Ctr) notify(ctx context.Context, n *notifier.Notification) {
+	if n == nil {
+		return
+	}
+	if err := ctr.notifier.Notify(ctx, n); err != nil {
+		ctr.logger.Error("failed to send notification", zap.String("kind", n.Kind), zap.String("userId", n.UserID), zap.Error(err))
+	}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"context"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+)
+
+// Policy returns authorization rules of RPCs of the service by their full names.
+// Users may read and update only themselves, service accounts may call RPCs of their scopes.
+// Users are created with CreateUser and change or reset passwords without tokens.
+// API keys are managed only by admins, so their RPCs have no rules.
+func Policy() map[string]auth.Rule {
+	prefix := "/" + usersvcv1.Service_ServiceDesc.ServiceName + "/"
+	public := auth.Rule{Public: true}
+	return map[string]auth.Rule{
+		prefix + "ListUsers":   {Scope: auth.ScopeUsersRead},
+		prefix + "SearchUsers": {Scope: auth.ScopeUsersRead},
+		prefix + "GetUser": {Scope: auth.ScopeUsersRead, Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.GetUserRequest)
+			return r.GetId()
+		}},
+		prefix + "CreateUser":     public,
+		prefix + "UpdatePassword": public,
+		prefix + "UpdateUser": {Scope: auth.ScopeUsersWrite, Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.UpdateUserRequest)
+			return r.GetUser().GetId()
+		}},
+		prefix + "DeleteUser":           {Scope: auth.ScopeUsersWrite},
+		prefix + "UndeleteUser":         {Scope: auth.ScopeUsersWrite},
+		prefix + "Authenticate":         public,
+		prefix + "RefreshToken":         public,
+		prefix + "RevokeToken":          public,
+		prefix + "RequestPasswordReset": public,
+		prefix + "ConfirmPasswordReset": public,
+		prefix + "VerifyEmail":          public,
+		prefix + "EnrollTwoFactor": {Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.EnrollTwoFactorRequest)
+			return r.GetId()
+		}},
+		prefix + "ConfirmTwoFactor": {Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.ConfirmTwoFactorRequest)
+			return r.GetId()
+		}},
+		prefix + "GenerateRecoveryCodes": {Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.GenerateRecoveryCodesRequest)
+			return r.GetId()
+		}},
+		prefix + "DisableTwoFactor": {Self: func(req interface{}) string {
+			r, _ := req.(*usersvcv1.DisableTwoFactorRequest)
+			return r.GetId()
+		}},
+		prefix + "WatchUsers":            {Scope: auth.ScopeUsersRead},
+		prefix + "CreateWebhook":         {Scope: auth.ScopeWebhooksWrite},
+		prefix + "ListWebhooks":          {Scope: auth.ScopeWebhooksRead},
+		prefix + "DeleteWebhook":         {Scope: auth.ScopeWebhooksWrite},
+		prefix + "ListWebhookDeliveries": {Scope: auth.ScopeWebhooksRead},
+		prefix + "HealthCheck":           public,
+	}
+}
+
+// canSetRoles reports whether the caller may set roles of users, only admins can,
+// so neither users nor service accounts can make admins.
+func canSetRoles(ctx context.Context) bool {
+	return auth.FromContext(ctx).IsAdmin()
+}
@@ -4,19 +4,38 @@ package controller_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
 	"github.com/mlukasik-dev/usersvc/internal/controller"
 	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/totp"
 	"github.com/mlukasik-dev/usersvc/pkg/deref"
 	"github.com/mlukasik-dev/usersvc/pkg/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServiceServer_HealthCheck(t *testing.T) {
@@ -49,6 +68,111 @@ func TestServiceServer_ListUsers(t *testing.T) {
 		assert.Equal(t, res.Total, int64(3))
 	})
 
+	t.Run("page token", func(t *testing.T) {
+		var ids []string
+		req := &usersvcv1.ListUsersRequest{Size: 2}
+		for {
+			res, err := ctr.ListUsers(context.Background(), req)
+			require.NoError(t, err)
+			for _, u := range res.Users {
+				ids = append(ids, u.Id)
+			}
+			if res.NextPageToken == "" {
+				break
+			}
+			req.PageToken = res.NextPageToken
+		}
+		require.Len(t, ids, 3)
+		assert.NotEqual(t, ids[0], ids[1])
+		assert.NotEqual(t, ids[1], ids[2])
+	})
+
+	t.Run("page token is not affected by deleted users", func(t *testing.T) {
+		res, err := ctr.ListUsers(context.Background(), &usersvcv1.ListUsersRequest{Size: 1})
+		require.NoError(t, err)
+		require.NotEmpty(t, res.NextPageToken)
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := s.DeleteUser(ctx, testData.users[0].ID, store.AnyVersion)
+			require.NoError(t, err)
+			req := &usersvcv1.ListUsersRequest{Size: 1, PageToken: res.NextPageToken}
+			next, err := ctr.ListUsers(ctx, req)
+			require.NoError(t, err)
+			require.Len(t, next.Users, 1)
+			// with page numbers the second user would be skipped.
+			assert.Equal(t, testData.users[1].ID.Hex(), next.Users[0].Id)
+		})
+	})
+
+	t.Run("invalid page token", func(t *testing.T) {
+		res, err := ctr.ListUsers(context.Background(), &usersvcv1.ListUsersRequest{Size: 1})
+		require.NoError(t, err)
+		reqs := []*usersvcv1.ListUsersRequest{
+			{PageToken: "invalid"},
+			{PageToken: res.NextPageToken[1:]},
+			// token of a request with other filters.
+			{PageToken: res.NextPageToken, Filters: &usersvcv1.User{LastName: "Doe"}},
+		}
+		for _, req := range reqs {
+			_, err := ctr.ListUsers(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		}
+	})
+
+	t.Run("order", func(t *testing.T) {
+		firstNames := func(users []*usersvcv1.User) []string {
+			var names []string
+			for _, u := range users {
+				names = append(names, u.FirstName)
+			}
+			return names
+		}
+
+		req := &usersvcv1.ListUsersRequest{OrderBy: "country, first_name desc"}
+		res, err := ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Jan", "John", "Jane"}, firstNames(res.Users))
+
+		req = &usersvcv1.ListUsersRequest{OrderBy: "country, first_name desc", Page: 2, Size: 1}
+		res, err = ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"John"}, firstNames(res.Users))
+
+		var users []*usersvcv1.User
+		req = &usersvcv1.ListUsersRequest{OrderBy: "country, first_name desc", Size: 1}
+		for {
+			res, err := ctr.ListUsers(context.Background(), req)
+			require.NoError(t, err)
+			users = append(users, res.Users...)
+			if res.NextPageToken == "" {
+				break
+			}
+			req.PageToken = res.NextPageToken
+		}
+		assert.Equal(t, []string{"Jan", "John", "Jane"}, firstNames(users))
+
+		req = &usersvcv1.ListUsersRequest{OrderBy: "first_name", Filters: &usersvcv1.User{LastName: "Doe"}}
+		res, err = ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Jane", "John"}, firstNames(res.Users))
+	})
+
+	t.Run("invalid order", func(t *testing.T) {
+		res, err := ctr.ListUsers(context.Background(), &usersvcv1.ListUsersRequest{Size: 1, OrderBy: "last_name"})
+		require.NoError(t, err)
+		reqs := []*usersvcv1.ListUsersRequest{
+			{OrderBy: "password"},
+			{OrderBy: "last_name up"},
+			// token of a request with other order.
+			{OrderBy: "last_name desc", PageToken: res.NextPageToken},
+		}
+		for _, req := range reqs {
+			_, err := ctr.ListUsers(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		}
+	})
+
 	t.Run("filter", func(t *testing.T) {
 		// Query John Doe and Jane Doe by their surname.
 		req := &usersvcv1.ListUsersRequest{Filters: &usersvcv1.User{LastName: "Doe"}}
@@ -59,6 +183,50 @@ func TestServiceServer_ListUsers(t *testing.T) {
 		assert.Equal(t, res.Users[1].LastName, "Doe")
 	})
 
+	t.Run("filter expression", func(t *testing.T) {
+		req := &usersvcv1.ListUsersRequest{Filter: "(country = PL OR country = DE) OR NOT first_name:Ja", OrderBy: "email"}
+		res, err := ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Users, 2)
+		assert.Equal(t, "jan.kowalski@gmail.com", res.Users[0].Email)
+		assert.Equal(t, "john.doe@gmail.com", res.Users[1].Email)
+		assert.Equal(t, int64(2), res.Total)
+
+		// filters and filter are both applied.
+		req = &usersvcv1.ListUsersRequest{Filter: "first_name:Ja", Filters: &usersvcv1.User{LastName: "Doe"}, Size: 1}
+		res, err = ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Users, 1)
+		assert.Equal(t, "jane.doe@gmail.com", res.Users[0].Email)
+		assert.Empty(t, res.NextPageToken)
+	})
+
+	t.Run("filter and order by create time", func(t *testing.T) {
+		first := testData.users[0]
+		req := &usersvcv1.ListUsersRequest{
+			Filter:  "create_time > " + strconv.Quote(first.CreatedAt.Format(time.RFC3339Nano)) + " AND version = 1",
+			OrderBy: "create_time desc",
+		}
+		res, err := ctr.ListUsers(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Users, 2)
+		assert.Equal(t, testData.users[2].ID.Hex(), res.Users[0].Id)
+		assert.Equal(t, testData.users[1].ID.Hex(), res.Users[1].Id)
+	})
+
+	t.Run("invalid filter expression", func(t *testing.T) {
+		reqs := map[string]string{
+			"country = PL AND (last_name = Doe": "invalid filter: unexpected end of filter at position 34",
+			"country = PL AND password = 1":     `invalid filter: unknown field "password" at position 18`,
+		}
+		for filter, msg := range reqs {
+			_, err := ctr.ListUsers(context.Background(), &usersvcv1.ListUsersRequest{Filter: filter})
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+			assert.Equal(t, msg, status.Convert(err).Message())
+		}
+	})
+
 	t.Run("filter by unique field", func(t *testing.T) {
 		req := &usersvcv1.ListUsersRequest{Filters: &usersvcv1.User{Email: "jan.kowalski@gmail.com"}}
 		res, err := ctr.ListUsers(context.Background(), req)
@@ -81,6 +249,49 @@ func TestServiceServer_ListUsers(t *testing.T) {
 	})
 }
 
+func TestServiceServer_SearchUsers(t *testing.T) {
+	search := func(t *testing.T, req *usersvcv1.SearchUsersRequest) []string {
+		res, err := ctr.SearchUsers(context.Background(), req)
+		require.NoError(t, err)
+		var emails []string
+		for _, u := range res.Users {
+			emails = append(emails, u.Email)
+		}
+		return emails
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		// Equally relevant users are in order of creation.
+		assert.Equal(t, []string{"jane.doe@gmail.com", "jan.kowalski@gmail.com"}, search(t, &usersvcv1.SearchUsersRequest{Query: "JA"}))
+		// Exact match is the most relevant.
+		assert.Equal(t, []string{"jan.kowalski@gmail.com", "jane.doe@gmail.com"}, search(t, &usersvcv1.SearchUsersRequest{Query: "jan"}))
+	})
+
+	t.Run("all terms", func(t *testing.T) {
+		assert.Equal(t, []string{"john.doe@gmail.com"}, search(t, &usersvcv1.SearchUsersRequest{Query: "doe jo"}))
+	})
+
+	t.Run("fuzzy", func(t *testing.T) {
+		assert.Equal(t, []string{"jan.kowalski@gmail.com"}, search(t, &usersvcv1.SearchUsersRequest{Query: "kowalsky"}))
+		assert.Empty(t, search(t, &usersvcv1.SearchUsersRequest{Query: "kawalski"}))
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		req := &usersvcv1.SearchUsersRequest{Query: "doe", Page: 2, Size: 1}
+		res, err := ctr.SearchUsers(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, res.Users, 1)
+		assert.Equal(t, "jane.doe@gmail.com", res.Users[0].Email)
+		assert.Equal(t, int64(2), res.Total)
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		_, err := ctr.SearchUsers(context.Background(), &usersvcv1.SearchUsersRequest{Query: " "})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+}
+
 func TestServiceServer_GetUser(t *testing.T) {
 	t.Run("existing", func(t *testing.T) {
 		user := testData.users[1]
@@ -106,12 +317,14 @@ func TestServiceServer_GetUser(t *testing.T) {
 func TestServiceServer_CreateUser(t *testing.T) {
 	t.Run("basic", func(t *testing.T) {
 		e := &events.Mock{}
-		e.On("Publish", events.CreateUserEvent, "<id>").Return()
-		ctr := controller.New(s, l, e)
+		e.On("Publish", events.CreateUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_CREATE && e.Before == nil && e.After.Email == "mark.brown@gmail.com"
+		})).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			user := &usersvcv1.User{FirstName: "Mark", LastName: "Brown", Nickname: "mb", Email: "mark.brown@gmail.com", Country: "US"}
-			req := &usersvcv1.CreateUserRequest{User: user, Password: ""}
+			req := &usersvcv1.CreateUserRequest{User: user, Password: "correct horse battery"}
 			res, err := ctr.CreateUser(ctx, req)
 			e.AssertExpectations(t)
 			require.NoError(t, err)
@@ -121,16 +334,19 @@ func TestServiceServer_CreateUser(t *testing.T) {
 			assert.Equal(t, res.Nickname, user.Nickname)
 			assert.Equal(t, res.Email, user.Email)
 			assert.Equal(t, res.Country, user.Country)
+			assert.Equal(t, int64(1), res.Version)
+			assert.NotNil(t, res.CreateTime)
+			assert.Equal(t, res.CreateTime.AsTime(), res.UpdateTime.AsTime())
 		})
 	})
 
 	t.Run("validate", func(t *testing.T) {
 		e := &events.Mock{}
-		ctr := controller.New(s, l, e)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			user := &usersvcv1.User{FirstName: "Mark", LastName: "Brown", Nickname: "#-#", Email: "mark.brown@gmail.com", Country: "US"}
-			req := &usersvcv1.CreateUserRequest{User: user, Password: ""}
+			req := &usersvcv1.CreateUserRequest{User: user, Password: "correct horse battery"}
 			_, err := ctr.CreateUser(ctx, req)
 			e.AssertNotCalled(t, "Publish")
 			require.Error(t, err)
@@ -140,59 +356,147 @@ func TestServiceServer_CreateUser(t *testing.T) {
 
 	t.Run("already exists", func(t *testing.T) {
 		e := &events.Mock{}
-		ctr := controller.New(s, l, e)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			user := &usersvcv1.User{FirstName: "John", LastName: "Doe", Email: "john.doe@gmail.com", Country: "UK"}
-			req := &usersvcv1.CreateUserRequest{User: user, Password: ""}
+			req := &usersvcv1.CreateUserRequest{User: user, Password: "correct horse battery"}
 			_, err := ctr.CreateUser(ctx, req)
 			e.AssertNotCalled(t, "Publish")
 			require.Error(t, err)
 			assert.Equal(t, status.Convert(err).Code(), codes.AlreadyExists)
 		})
 	})
+
+	t.Run("weak password", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+
+		for _, password := range []string{"", "short", "password123", "mark.brown@gmail.com!", "i-am-markb"} {
+			user := &usersvcv1.User{FirstName: "Mark", LastName: "Brown", Nickname: "markb", Email: "mark.brown@gmail.com", Country: "US"}
+			_, err := ctr.CreateUser(context.Background(), &usersvcv1.CreateUserRequest{User: user, Password: password})
+			require.Error(t, err, password)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code(), password)
+			assert.Equal(t, []string{"password"}, violatedFields(err), password)
+		}
+		e.AssertNotCalled(t, "Publish")
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{PasswordPolicy: &password.Policy{MinLength: 4, RequireDigit: true, RequireSymbol: true}})
+
+		user := &usersvcv1.User{FirstName: "Mark", LastName: "Brown", Email: "mark.brown@gmail.com", Country: "US"}
+		_, err := ctr.CreateUser(context.Background(), &usersvcv1.CreateUserRequest{User: user, Password: "abc"})
+		require.Error(t, err)
+		assert.Equal(t, []string{"password", "password", "password"}, violatedFields(err))
+	})
+}
+
+// violatedFields returns fields of violations of a BadRequest detail of an error.
+func violatedFields(err error) []string {
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	return fields
 }
 
 func TestServiceServer_UpdatePassword(t *testing.T) {
 	t.Run("basic", func(t *testing.T) {
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
-			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "654321"}
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
 			_, err := ctr.UpdatePassword(ctx, req)
 			require.NoError(t, err)
 
-			req = &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "654321"}
+			req = &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
 			_, err = ctr.UpdatePassword(ctx, req)
 			require.Error(t, err)
 			assert.Equal(t, status.Convert(err).Code(), codes.PermissionDenied)
 
-			req = &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "654321", NewPassword: "123456"}
+			req = &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "k33p-it-secret", NewPassword: "an0ther-secret"}
+			_, err = ctr.UpdatePassword(ctx, req)
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("revokes sessions", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			session, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: "jane.doe@gmail.com", Password: "123456"})
+			require.NoError(t, err)
+			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err = ctr.UpdatePassword(ctx, req)
+			require.NoError(t, err)
+			_, err = ctr.RefreshToken(ctx, &usersvcv1.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+			assert.Equal(t, codes.Unauthenticated, status.Code(err), "sessions should be revoked by a password change")
+		})
+	})
+
+	t.Run("changed email", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.UpdateUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			list, err := ctr.ListUsers(ctx, &usersvcv1.ListUsersRequest{Filter: `email = "jane.doe@gmail.com"`})
+			require.NoError(t, err)
+			require.Len(t, list.Users, 1)
+			pbUser := &usersvcv1.User{Id: list.Users[0].Id, Email: "jane@doe.com"}
+			um, err := fieldmaskpb.New(pbUser, "email")
+			require.NoError(t, err)
+			_, err = ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+			require.NoError(t, err)
+
+			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err = ctr.UpdatePassword(ctx, req)
+			require.Error(t, err)
+			assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+			req = &usersvcv1.UpdatePasswordRequest{Email: "jane@doe.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
 			_, err = ctr.UpdatePassword(ctx, req)
 			require.NoError(t, err)
 		})
 	})
 
 	t.Run("invalid creds", func(t *testing.T) {
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
-			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "", NewPassword: "654321"}
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "", NewPassword: "k33p-it-secret"}
 			_, err := ctr.UpdatePassword(ctx, req)
 			require.Error(t, err)
 			assert.Equal(t, status.Convert(err).Code(), codes.PermissionDenied)
 		})
 	})
+
+	t.Run("weak password", func(t *testing.T) {
+		req := &usersvcv1.UpdatePasswordRequest{Email: "jane.doe@gmail.com", OldPassword: "123456", NewPassword: "654321"}
+		_, err := ctr.UpdatePassword(context.Background(), req)
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		assert.Equal(t, []string{"new_password", "new_password"}, violatedFields(err))
+	})
 }
 
 func TestServiceServer_UpdateUser(t *testing.T) {
 	t.Run("basic", func(t *testing.T) {
 		user := testData.users[0]
 		e := &events.Mock{}
-		e.On("Publish", events.UpdateUserEvent, user.ID).Return()
-		ctr := controller.New(s, l, e)
+		e.On("Publish", events.UpdateUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_UPDATE && e.Actor == "users/"+testData.users[1].ID.Hex() &&
+				e.Before.Id == user.ID.Hex() && e.Before.Country == user.Country &&
+				e.After.Id == user.ID.Hex() && e.After.Country == "PL" &&
+				assert.ObjectsAreEqual([]string{"country"}, e.UpdateMask.Paths)
+		})).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			pbUser := &usersvcv1.User{Id: user.ID.Hex(), Country: "PL"}
 			um, err := fieldmaskpb.New(pbUser, "country")
 			require.NoError(t, err)
 			req := &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um}
+			ctx = auth.NewContext(ctx, &auth.Principal{UserID: testData.users[1].ID.Hex(), Role: store.RoleAdmin})
 			res, err := ctr.UpdateUser(ctx, req)
 			e.AssertExpectations(t)
 			require.NoError(t, err)
@@ -202,6 +506,54 @@ func TestServiceServer_UpdateUser(t *testing.T) {
 			assert.Equal(t, res.Nickname, deref.String(user.Nickname))
 			assert.Equal(t, res.Email, user.Email)
 			assert.Equal(t, res.Country, "PL")
+			assert.Equal(t, int64(2), res.Version)
+			assert.True(t, res.UpdateTime.AsTime().After(res.CreateTime.AsTime()))
+		})
+	})
+
+	t.Run("output only fields", func(t *testing.T) {
+		user := testData.users[0]
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+		for _, path := range []string{"id", "create_time", "update_time", "version", "etag", "delete_time"} {
+			pbUser := &usersvcv1.User{Id: user.ID.Hex()}
+			um, err := fieldmaskpb.New(pbUser, path)
+			require.NoError(t, err)
+			_, err = ctr.UpdateUser(context.Background(), &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+			require.Error(t, err, path)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code(), path)
+		}
+		e.AssertNotCalled(t, "Publish")
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		user := testData.users[0]
+		e := &events.Mock{}
+		e.On("Publish", events.UpdateUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			got, err := ctr.GetUser(ctx, &usersvcv1.GetUserRequest{Id: user.ID.Hex()})
+			require.NoError(t, err)
+			assert.Equal(t, `"1"`, got.Etag)
+			pbUser := &usersvcv1.User{Id: user.ID.Hex(), Country: "PL", Etag: got.Etag}
+			um, err := fieldmaskpb.New(pbUser, "country")
+			require.NoError(t, err)
+			res, err := ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+			require.NoError(t, err)
+			assert.Equal(t, `"2"`, res.Etag)
+			e.AssertNumberOfCalls(t, "Publish", 1)
+
+			// The same etag is stale now.
+			_, err = ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+			require.Error(t, err)
+			assert.Equal(t, codes.Aborted, status.Convert(err).Code())
+			e.AssertNumberOfCalls(t, "Publish", 1)
+
+			pbUser.Etag = "abc"
+			_, err = ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
 		})
 	})
 
@@ -209,9 +561,9 @@ func TestServiceServer_UpdateUser(t *testing.T) {
 		// Try to change user's email to email of an existing user.
 		user := testData.users[0]
 		e := &events.Mock{}
-		ctr := controller.New(s, l, e)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			pbUser := &usersvcv1.User{Id: user.ID.Hex(), Email: "jan.kowalski@gmail.com"}
 			um, err := fieldmaskpb.New(pbUser, "email")
 			require.NoError(t, err)
@@ -228,10 +580,12 @@ func TestServiceServer_DeleteUser(t *testing.T) {
 	t.Run("existing", func(t *testing.T) {
 		id := testData.users[1].ID
 		e := &events.Mock{}
-		e.On("Publish", events.DeleteUserEvent, id).Return()
-		ctr := controller.New(s, l, e)
+		e.On("Publish", events.DeleteUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_DELETE && e.Before.Id == id.Hex() && e.After == nil
+		})).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			req := &usersvcv1.DeleteUserRequest{Id: id.Hex()}
 			_, err := ctr.DeleteUser(ctx, req)
 			e.AssertExpectations(t)
@@ -242,14 +596,61 @@ func TestServiceServer_DeleteUser(t *testing.T) {
 				require.Error(t, err)
 				assert.Equal(t, status.Convert(err).Code(), codes.NotFound)
 			}
+			_, err = ctr.DeleteUser(ctx, req)
+			require.Error(t, err)
+			assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+		})
+	})
+
+	t.Run("show deleted", func(t *testing.T) {
+		id := testData.users[1].ID
+		e := &events.Mock{}
+		e.On("Publish", events.DeleteUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: id.Hex()})
+			require.NoError(t, err)
+			res, err := ctr.GetUser(ctx, &usersvcv1.GetUserRequest{Id: id.Hex(), ShowDeleted: true})
+			require.NoError(t, err)
+			assert.NotNil(t, res.DeleteTime)
+
+			list, err := ctr.ListUsers(ctx, &usersvcv1.ListUsersRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(testData.users)-1), list.Total)
+			for _, u := range list.Users {
+				assert.NotEqual(t, id.Hex(), u.Id)
+			}
+			list, err = ctr.ListUsers(ctx, &usersvcv1.ListUsersRequest{ShowDeleted: true, Filter: "delete_time:*"})
+			require.NoError(t, err)
+			require.Len(t, list.Users, 1)
+			assert.Equal(t, id.Hex(), list.Users[0].Id)
+		})
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		id := testData.users[1].ID
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: id.Hex(), Etag: `"2"`})
+			e.AssertNotCalled(t, "Publish")
+			require.Error(t, err)
+			assert.Equal(t, codes.Aborted, status.Convert(err).Code())
+			_, err = ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: id.Hex(), Etag: "2"})
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+			_, err = ctr.GetUser(ctx, &usersvcv1.GetUserRequest{Id: id.Hex()})
+			require.NoError(t, err)
 		})
 	})
 
 	t.Run("not existing", func(t *testing.T) {
 		e := &events.Mock{}
-		ctr := controller.New(s, l, e)
+		ctr := newCtr(t, e, controller.Options{})
 
-		testutils.WithAbortedTransaction(context.Background(), s.Client(), func(ctx context.Context) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
 			req := &usersvcv1.DeleteUserRequest{Id: primitive.NewObjectID().Hex()}
 			_, err := ctr.DeleteUser(ctx, req)
 			e.AssertNotCalled(t, "Publish")
@@ -258,3 +659,1067 @@ func TestServiceServer_DeleteUser(t *testing.T) {
 		})
 	})
 }
+
+func TestServiceServer_UndeleteUser(t *testing.T) {
+	t.Run("deleted", func(t *testing.T) {
+		id := testData.users[1].ID
+		e := &events.Mock{}
+		e.On("Publish", events.DeleteUserEvent, mock.Anything).Return(nil)
+		e.On("Publish", events.UndeleteUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_UNDELETE && e.Before.DeleteTime != nil &&
+				e.After.Id == id.Hex() && e.After.DeleteTime == nil
+		})).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: id.Hex()})
+			require.NoError(t, err)
+			deleted, err := ctr.GetUser(ctx, &usersvcv1.GetUserRequest{Id: id.Hex(), ShowDeleted: true})
+			require.NoError(t, err)
+			_, err = ctr.UndeleteUser(ctx, &usersvcv1.UndeleteUserRequest{Id: id.Hex(), Etag: `"1"`})
+			require.Error(t, err)
+			assert.Equal(t, codes.Aborted, status.Convert(err).Code())
+
+			res, err := ctr.UndeleteUser(ctx, &usersvcv1.UndeleteUserRequest{Id: id.Hex(), Etag: deleted.Etag})
+			require.NoError(t, err)
+			e.AssertExpectations(t)
+			assert.Nil(t, res.DeleteTime)
+			assert.Equal(t, deleted.Version+1, res.Version)
+			_, err = ctr.GetUser(ctx, &usersvcv1.GetUserRequest{Id: id.Hex()})
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("not deleted", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+		_, err := ctr.UndeleteUser(context.Background(), &usersvcv1.UndeleteUserRequest{Id: testData.users[0].ID.Hex()})
+		e.AssertNotCalled(t, "Publish")
+		require.Error(t, err)
+		assert.Equal(t, codes.AlreadyExists, status.Convert(err).Code())
+	})
+
+	t.Run("not existing", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+		_, err := ctr.UndeleteUser(context.Background(), &usersvcv1.UndeleteUserRequest{Id: primitive.NewObjectID().Hex()})
+		e.AssertNotCalled(t, "Publish")
+		require.Error(t, err)
+		assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+	})
+}
+
+func TestServiceServer_Authenticate(t *testing.T) {
+	t.Run("basic", func(t *testing.T) {
+		for _, login := range []string{"john.doe@gmail.com", "jd"} {
+			testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+				nickname := "jd"
+				_, err := s.UpdateUser(ctx, &store.User{ID: testData.users[0].ID, Nickname: &nickname}, []string{"nickname"}, store.AnyVersion)
+				require.NoError(t, err)
+
+				res, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: login, Password: "123456"})
+				require.NoError(t, err, login)
+				assert.Equal(t, "Bearer", res.TokenType)
+				assert.NotEmpty(t, res.RefreshToken)
+				assert.True(t, res.RefreshExpireTime.AsTime().After(res.ExpireTime.AsTime()))
+				claims, err := tokens.Verify(res.AccessToken)
+				require.NoError(t, err)
+				assert.Equal(t, testData.users[0].ID.Hex(), claims.Subject)
+				assert.Equal(t, store.RoleUser, claims.Role)
+			})
+		}
+	})
+
+	t.Run("invalid creds", func(t *testing.T) {
+		for _, req := range []*usersvcv1.AuthenticateRequest{
+			{Login: "john.doe@gmail.com", Password: "654321"},
+			{Login: "nobody@gmail.com", Password: "123456"},
+			{Login: "nobody", Password: "123456"},
+		} {
+			// failures are counted, so they're rolled back.
+			testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+				_, err := ctr.Authenticate(ctx, req)
+				require.Error(t, err, req.Login)
+				assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code(), req.Login)
+			})
+		}
+		_, err := ctr.Authenticate(context.Background(), &usersvcv1.AuthenticateRequest{Login: "john.doe@gmail.com"})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.DeleteUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{Tokens: tokens})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: testData.users[0].ID.Hex()})
+			require.NoError(t, err)
+			_, err = ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: "john.doe@gmail.com", Password: "123456"})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		})
+	})
+}
+
+func TestServiceServer_Lockout(t *testing.T) {
+	// fromIP returns a context of calls from the IP address.
+	fromIP := func(ctx context.Context, ip string) context.Context {
+		return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50051}})
+	}
+	// retryDelay returns the delay of RetryInfo detail of a RESOURCE_EXHAUSTED error.
+	retryDelay := func(t *testing.T, err error) time.Duration {
+		require.Error(t, err)
+		assert.Equal(t, codes.ResourceExhausted, status.Convert(err).Code())
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				return info.RetryDelay.AsDuration()
+			}
+		}
+		t.Fatal("error has no RetryInfo detail")
+		return 0
+	}
+	authenticate := func(ctx context.Context, ctr usersvcv1.ServiceServer, login, password string) error {
+		_, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: login, Password: password})
+		return err
+	}
+	id := testData.users[2].ID.Hex()
+
+	t.Run("user", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.LockUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_LOCK && e.After.Id == id && e.Before == nil &&
+				e.LockExpireTime.AsTime().After(time.Now().Add(14*time.Minute))
+		})).Return(nil).Once()
+		e.On("Publish", events.UnlockUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.Type == usersvcv1.EventType_EVENT_TYPE_UNLOCK && e.After.Id == id && e.Before == nil
+		})).Return(nil).Once()
+		ctr := newCtr(t, e, controller.Options{Tokens: tokens, Lockout: controller.LockoutOptions{MaxFailures: 3, FreeFailures: 5}})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			// failures are forgotten when the password matches.
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "jan.kowalski@gmail.com", "654321")).Code())
+			}
+			lockout, err := ctr.GetUserLockout(ctx, &usersvcv1.GetUserLockoutRequest{Id: id})
+			require.NoError(t, err)
+			assert.Equal(t, int32(2), lockout.FailedAttempts)
+			require.NoError(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+			lockout, err = ctr.GetUserLockout(ctx, &usersvcv1.GetUserLockoutRequest{Id: id})
+			require.NoError(t, err)
+			assert.Equal(t, int32(0), lockout.FailedAttempts)
+
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "jan.kowalski@gmail.com", "654321")).Code())
+			}
+			req := &usersvcv1.UpdatePasswordRequest{Email: "jan.kowalski@gmail.com", OldPassword: "654321", NewPassword: "k33p-it-secret"}
+			_, err = ctr.UpdatePassword(ctx, req)
+			assert.Equal(t, codes.PermissionDenied, status.Convert(err).Code())
+
+			// locked users are rejected even with a matching password.
+			delay := retryDelay(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+			assert.InDelta(t, 15*time.Minute, delay, float64(time.Minute))
+			req = &usersvcv1.UpdatePasswordRequest{Email: "jan.kowalski@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err = ctr.UpdatePassword(ctx, req)
+			retryDelay(t, err)
+			lockout, err = ctr.GetUserLockout(ctx, &usersvcv1.GetUserLockoutRequest{Id: id})
+			require.NoError(t, err)
+			assert.Equal(t, id, lockout.UserId)
+			assert.True(t, lockout.LockExpireTime.AsTime().After(time.Now()))
+
+			_, err = ctr.UnlockUser(ctx, &usersvcv1.UnlockUserRequest{Id: id})
+			require.NoError(t, err)
+			require.NoError(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+			lockout, err = ctr.GetUserLockout(ctx, &usersvcv1.GetUserLockoutRequest{Id: id})
+			require.NoError(t, err)
+			assert.Nil(t, lockout.LockExpireTime)
+		})
+		e.AssertExpectations(t)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		ctr := newCtr(t, &events.Mock{}, controller.Options{Tokens: tokens,
+			Lockout: controller.LockoutOptions{FreeFailures: 1, Delay: time.Minute, MaxDelay: time.Hour}})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "jan.kowalski@gmail.com", "654321")).Code())
+			}
+			delay := retryDelay(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+			assert.InDelta(t, time.Minute, delay, float64(time.Second))
+
+			// unlocking users which aren't locked only forgets their failures and publishes no event.
+			_, err := ctr.UnlockUser(ctx, &usersvcv1.UnlockUserRequest{Id: id})
+			require.NoError(t, err)
+			require.NoError(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+		})
+	})
+
+	t.Run("client", func(t *testing.T) {
+		ctr := newCtr(t, &events.Mock{}, controller.Options{Tokens: tokens,
+			Lockout: controller.LockoutOptions{MaxClientFailures: 3, FreeFailures: 5}})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			client := fromIP(ctx, "192.0.2.1")
+			for _, login := range []string{"nobody@gmail.com", "nobody"} {
+				assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(client, ctr, login, "123456")).Code())
+			}
+			req := &usersvcv1.UpdatePasswordRequest{Email: "nobody@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err := ctr.UpdatePassword(client, req)
+			assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+
+			retryDelay(t, authenticate(client, ctr, "jan.kowalski@gmail.com", "123456"))
+			req = &usersvcv1.UpdatePasswordRequest{Email: "jan.kowalski@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err = ctr.UpdatePassword(client, req)
+			retryDelay(t, err)
+			// other clients and the user aren't locked.
+			require.NoError(t, authenticate(fromIP(ctx, "192.0.2.2"), ctr, "jan.kowalski@gmail.com", "123456"))
+		})
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.LockUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.After.Id == id && e.LockExpireTime.AsTime().After(time.Now().Add(59*time.Minute))
+		})).Return(nil).Once()
+		e.On("Publish", events.UnlockUserEvent, mock.Anything).Return(nil).Once()
+		ctr := newCtr(t, e, controller.Options{Tokens: tokens})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			session, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: "jan.kowalski@gmail.com", Password: "123456"})
+			require.NoError(t, err)
+			lockout, err := ctr.LockUser(ctx, &usersvcv1.LockUserRequest{Id: id, Duration: durationpb.New(time.Hour)})
+			require.NoError(t, err)
+			assert.Equal(t, id, lockout.UserId)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), lockout.LockExpireTime.AsTime(), time.Minute)
+			delay := retryDelay(t, authenticate(ctx, ctr, "jan.kowalski@gmail.com", "123456"))
+			assert.InDelta(t, time.Hour, delay, float64(time.Minute))
+
+			// sessions of locked users can't be refreshed, their tokens can be used once they're unlocked.
+			_, err = ctr.RefreshToken(ctx, &usersvcv1.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+			retryDelay(t, err)
+			_, err = ctr.UnlockUser(ctx, &usersvcv1.UnlockUserRequest{Id: id})
+			require.NoError(t, err)
+			_, err = ctr.RefreshToken(ctx, &usersvcv1.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+			require.NoError(t, err)
+		})
+		e.AssertExpectations(t)
+
+		_, err := ctr.LockUser(context.Background(), &usersvcv1.LockUserRequest{Id: id, Duration: durationpb.New(-time.Hour)})
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		_, err = ctr.LockUser(context.Background(), &usersvcv1.LockUserRequest{Id: "abc"})
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		_, err = ctr.LockUser(context.Background(), &usersvcv1.LockUserRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+		_, err = ctr.UnlockUser(context.Background(), &usersvcv1.UnlockUserRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+		_, err = ctr.GetUserLockout(context.Background(), &usersvcv1.GetUserLockoutRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+	})
+}
+
+func TestServiceServer_TwoFactor(t *testing.T) {
+	id := testData.users[0].ID.Hex()
+	// enroll enrolls the user and confirms its enrollment, returns its secret and recovery codes.
+	enroll := func(t *testing.T, ctx context.Context, ctr usersvcv1.ServiceServer) ([]byte, []string) {
+		enrollment, err := ctr.EnrollTwoFactor(ctx, &usersvcv1.EnrollTwoFactorRequest{Id: id})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(enrollment.Uri, "otpauth://totp/usersvc:john.doe@gmail.com?"), enrollment.Uri)
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+		require.NoError(t, err)
+		res, err := ctr.ConfirmTwoFactor(ctx, &usersvcv1.ConfirmTwoFactorRequest{Id: id, Code: totp.Code(secret, totp.Step(time.Now()))})
+		require.NoError(t, err)
+		assert.Len(t, res.Codes, 10)
+		return secret, res.Codes
+	}
+	// nextCode returns a code of the next time step, codes of the current one were used by enroll.
+	nextCode := func(secret []byte) string {
+		return totp.Code(secret, totp.Step(time.Now())+1)
+	}
+	authenticate := func(ctx context.Context, ctr usersvcv1.ServiceServer, password, code string) error {
+		_, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: "john.doe@gmail.com", Password: password, TwoFactorCode: code})
+		return err
+	}
+
+	t.Run("authenticate", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			secret, recoveryCodes := enroll(t, ctx, ctr)
+			tf, err := s.GetTwoFactor(ctx, testData.users[0].ID)
+			require.NoError(t, err)
+			assert.NotContains(t, string(tf.Secret), string(secret), "secrets should be encrypted")
+
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(authenticate(ctx, ctr, "123456", "")).Code())
+			assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "654321", nextCode(secret))).Code())
+			wrong := totp.Code(secret, totp.Step(time.Now())+5)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "123456", wrong)).Code())
+			require.NoError(t, authenticate(ctx, ctr, "123456", nextCode(secret)))
+			assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "123456", nextCode(secret))).Code(),
+				"codes can be used once")
+
+			require.NoError(t, authenticate(ctx, ctr, "123456", recoveryCodes[0]))
+			assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "123456", recoveryCodes[0])).Code(),
+				"recovery codes can be used once")
+			require.NoError(t, authenticate(ctx, ctr, "123456", strings.ToUpper(strings.ReplaceAll(recoveryCodes[1], "-", ""))))
+		})
+	})
+
+	t.Run("update password", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, recoveryCodes := enroll(t, ctx, ctr)
+			req := &usersvcv1.UpdatePasswordRequest{Email: "john.doe@gmail.com", OldPassword: "123456", NewPassword: "k33p-it-secret"}
+			_, err := ctr.UpdatePassword(ctx, req)
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(err).Code())
+			req.OldPassword, req.TwoFactorCode = "654321", recoveryCodes[0]
+			_, err = ctr.UpdatePassword(ctx, req)
+			assert.Equal(t, codes.PermissionDenied, status.Convert(err).Code())
+			// the code isn't used when the password doesn't match.
+			req.OldPassword = "123456"
+			_, err = ctr.UpdatePassword(ctx, req)
+			require.NoError(t, err)
+			require.NoError(t, authenticate(ctx, ctr, "k33p-it-secret", recoveryCodes[1]))
+		})
+	})
+
+	t.Run("recovery codes", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, recoveryCodes := enroll(t, ctx, ctr)
+			_, err := ctr.GenerateRecoveryCodes(ctx, &usersvcv1.GenerateRecoveryCodesRequest{Id: id, Code: "abcd-efgh"})
+			assert.Equal(t, codes.PermissionDenied, status.Convert(err).Code())
+			_, err = ctr.GenerateRecoveryCodes(ctx, &usersvcv1.GenerateRecoveryCodesRequest{Id: id})
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+			res, err := ctr.GenerateRecoveryCodes(ctx, &usersvcv1.GenerateRecoveryCodesRequest{Id: id, Code: recoveryCodes[0]})
+			require.NoError(t, err)
+			assert.Len(t, res.Codes, 10)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "123456", recoveryCodes[1])).Code(),
+				"previous codes should be replaced")
+			require.NoError(t, authenticate(ctx, ctr, "123456", res.Codes[0]))
+		})
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			secret, _ := enroll(t, ctx, ctr)
+			_, err := ctr.DisableTwoFactor(ctx, &usersvcv1.DisableTwoFactorRequest{Id: id})
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code(), "users should pass a code")
+			_, err = ctr.DisableTwoFactor(ctx, &usersvcv1.DisableTwoFactorRequest{Id: id, Code: totp.Code(secret, totp.Step(time.Now())+5)})
+			assert.Equal(t, codes.PermissionDenied, status.Convert(err).Code())
+			_, err = ctr.DisableTwoFactor(ctx, &usersvcv1.DisableTwoFactorRequest{Id: id, Code: nextCode(secret)})
+			require.NoError(t, err)
+			require.NoError(t, authenticate(ctx, ctr, "123456", ""))
+			_, err = ctr.DisableTwoFactor(ctx, &usersvcv1.DisableTwoFactorRequest{Id: id, Code: nextCode(secret)})
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(err).Code())
+
+			// admins don't need a code.
+			enroll(t, ctx, ctr)
+			admin := auth.NewContext(ctx, &auth.Principal{UserID: testData.users[1].ID.Hex(), Role: store.RoleAdmin})
+			_, err = ctr.DisableTwoFactor(admin, &usersvcv1.DisableTwoFactorRequest{Id: id})
+			require.NoError(t, err)
+			require.NoError(t, authenticate(ctx, ctr, "123456", ""))
+		})
+	})
+
+	t.Run("enrollment", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.ConfirmTwoFactor(ctx, &usersvcv1.ConfirmTwoFactorRequest{Id: id, Code: "123456"})
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(err).Code(), "the user didn't enroll")
+			first, err := ctr.EnrollTwoFactor(ctx, &usersvcv1.EnrollTwoFactorRequest{Id: id})
+			require.NoError(t, err)
+			// codes aren't required until the enrollment is confirmed.
+			require.NoError(t, authenticate(ctx, ctr, "123456", ""))
+			firstSecret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(first.Secret)
+			require.NoError(t, err)
+
+			secret, _ := enroll(t, ctx, ctr)
+			assert.NotEqual(t, firstSecret, secret)
+			_, err = ctr.EnrollTwoFactor(ctx, &usersvcv1.EnrollTwoFactorRequest{Id: id})
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(err).Code())
+			_, err = ctr.ConfirmTwoFactor(ctx, &usersvcv1.ConfirmTwoFactorRequest{Id: id, Code: nextCode(secret)})
+			assert.Equal(t, codes.FailedPrecondition, status.Convert(err).Code())
+		})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			enrollment, err := ctr.EnrollTwoFactor(ctx, &usersvcv1.EnrollTwoFactorRequest{Id: id})
+			require.NoError(t, err)
+			secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+			require.NoError(t, err)
+			_, err = ctr.ConfirmTwoFactor(ctx, &usersvcv1.ConfirmTwoFactorRequest{Id: id, Code: totp.Code(secret, totp.Step(time.Now())+5)})
+			assert.Equal(t, codes.PermissionDenied, status.Convert(err).Code())
+		})
+
+		_, err := ctr.EnrollTwoFactor(context.Background(), &usersvcv1.EnrollTwoFactorRequest{Id: "abc"})
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+		_, err = ctr.EnrollTwoFactor(context.Background(), &usersvcv1.EnrollTwoFactorRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.NotFound, status.Convert(err).Code())
+		_, err = ctr.ConfirmTwoFactor(context.Background(), &usersvcv1.ConfirmTwoFactorRequest{Id: id})
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.LockUserEvent, mock.Anything).Return(nil).Once()
+		ctr := newCtr(t, e, controller.Options{Tokens: tokens, Lockout: controller.LockoutOptions{MaxFailures: 2, FreeFailures: 5}})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			secret, _ := enroll(t, ctx, ctr)
+			wrong := totp.Code(secret, totp.Step(time.Now())+5)
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, codes.Unauthenticated, status.Convert(authenticate(ctx, ctr, "123456", wrong)).Code())
+			}
+			assert.Equal(t, codes.ResourceExhausted, status.Convert(authenticate(ctx, ctr, "123456", nextCode(secret))).Code())
+		})
+		e.AssertExpectations(t)
+	})
+}
+
+func TestServiceServer_RefreshToken(t *testing.T) {
+	authenticate := func(t *testing.T) *usersvcv1.Token {
+		token, err := ctr.Authenticate(context.Background(), &usersvcv1.AuthenticateRequest{Login: "jane.doe@gmail.com", Password: "123456"})
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		first := authenticate(t)
+		second, err := ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: first.RefreshToken})
+		require.NoError(t, err)
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		claims, err := tokens.Verify(second.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, testData.users[1].ID.Hex(), claims.Subject)
+		third, err := ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: second.RefreshToken})
+		require.NoError(t, err)
+
+		// reusing a token revokes the whole family.
+		_, err = ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: first.RefreshToken})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		_, err = ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: third.RefreshToken})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+
+		// other families are still valid.
+		_, err = ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: authenticate(t).RefreshToken})
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: "abc"})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		_, err = ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		token := authenticate(t)
+		e := &events.Mock{}
+		e.On("Publish", events.DeleteUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{Tokens: tokens})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: testData.users[1].ID.Hex()})
+			require.NoError(t, err)
+			_, err = ctr.RefreshToken(ctx, &usersvcv1.RefreshTokenRequest{RefreshToken: token.RefreshToken})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		})
+	})
+}
+
+func TestServiceServer_RevokeToken(t *testing.T) {
+	token, err := ctr.Authenticate(context.Background(), &usersvcv1.AuthenticateRequest{Login: "jan.kowalski@gmail.com", Password: "123456"})
+	require.NoError(t, err)
+	rotated, err := ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	// any token of a family revokes all of them.
+	_, err = ctr.RevokeToken(context.Background(), &usersvcv1.RevokeTokenRequest{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+	_, err = ctr.RefreshToken(context.Background(), &usersvcv1.RefreshTokenRequest{RefreshToken: rotated.RefreshToken})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+
+	_, err = ctr.RevokeToken(context.Background(), &usersvcv1.RevokeTokenRequest{RefreshToken: "abc"})
+	assert.NoError(t, err)
+	_, err = ctr.RevokeToken(context.Background(), &usersvcv1.RevokeTokenRequest{})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+}
+
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	resp chan *usersvcv1.WatchUsersResponse
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(resp *usersvcv1.WatchUsersResponse) error {
+	s.resp <- resp
+	return nil
+}
+
+// watch starts WatchUsers in the background and returns a channel with received changes.
+func watch(t *testing.T, req *usersvcv1.WatchUsersRequest) <-chan *usersvcv1.WatchUsersResponse {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream := &watchStream{ctx: ctx, resp: make(chan *usersvcv1.WatchUsersResponse, 10)}
+	go ctr.WatchUsers(req, stream)
+	return stream.resp
+}
+
+func receive(t *testing.T, c <-chan *usersvcv1.WatchUsersResponse) *usersvcv1.WatchUsersResponse {
+	select {
+	case resp := <-c:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+		return nil
+	}
+}
+
+func TestServiceServer_PasswordReset(t *testing.T) {
+	// requestReset requests a password reset of a user and returns the token sent to them.
+	requestReset := func(t *testing.T, ctx context.Context, email string) string {
+		n := &notifier.Mock{}
+		var token string
+		n.On("Notify", mock.MatchedBy(func(n *notifier.Notification) bool {
+			return n.Kind == notifier.KindPasswordReset && n.Email == email && n.ExpiresAt.After(time.Now())
+		})).Run(func(args mock.Arguments) {
+			token = args.Get(0).(*notifier.Notification).Token
+		}).Return(nil)
+		ctr := newCtr(t, &events.Mock{}, controller.Options{Notifier: n})
+		_, err := ctr.RequestPasswordReset(ctx, &usersvcv1.RequestPasswordResetRequest{Email: email})
+		require.NoError(t, err)
+		n.AssertExpectations(t)
+		return token
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			token := requestReset(t, ctx, "john.doe@gmail.com")
+			_, err := ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "k33p-it-secret"})
+			require.NoError(t, err)
+			_, err = s.Authenticate(ctx, "john.doe@gmail.com", "k33p-it-secret")
+			require.NoError(t, err)
+
+			// tokens can be used once.
+			_, err = ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "an0ther-secret"})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		})
+	})
+
+	t.Run("revokes sessions", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			session, err := ctr.Authenticate(ctx, &usersvcv1.AuthenticateRequest{Login: "john.doe@gmail.com", Password: "123456"})
+			require.NoError(t, err)
+			token := requestReset(t, ctx, "john.doe@gmail.com")
+			_, err = ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "k33p-it-secret"})
+			require.NoError(t, err)
+			_, err = ctr.RefreshToken(ctx, &usersvcv1.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+			assert.Equal(t, codes.Unauthenticated, status.Code(err), "sessions should be revoked by a reset")
+		})
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			first := requestReset(t, ctx, "john.doe@gmail.com")
+			second := requestReset(t, ctx, "john.doe@gmail.com")
+			_, err := ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: first, NewPassword: "k33p-it-secret"})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+			_, err = ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: second, NewPassword: "k33p-it-secret"})
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("weak password", func(t *testing.T) {
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			token := requestReset(t, ctx, "john.doe@gmail.com")
+			_, err := ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "john.doe@gmail.com"})
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+			assert.Equal(t, []string{"new_password"}, violatedFields(err))
+
+			// the token isn't used by a rejected password.
+			_, err = ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "k33p-it-secret"})
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		n := &notifier.Mock{}
+		ctr := newCtr(t, &events.Mock{}, controller.Options{Notifier: n})
+		_, err := ctr.RequestPasswordReset(context.Background(), &usersvcv1.RequestPasswordResetRequest{Email: "nobody@gmail.com"})
+		require.NoError(t, err)
+		n.AssertNotCalled(t, "Notify", mock.Anything)
+
+		_, err = ctr.RequestPasswordReset(context.Background(), &usersvcv1.RequestPasswordResetRequest{Email: "nobody"})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := ctr.ConfirmPasswordReset(context.Background(), &usersvcv1.ConfirmPasswordResetRequest{Token: "abc", NewPassword: "k33p-it-secret"})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		_, err = ctr.ConfirmPasswordReset(context.Background(), &usersvcv1.ConfirmPasswordResetRequest{Token: "abc"})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		e := &events.Mock{}
+		e.On("Publish", events.DeleteUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			token := requestReset(t, ctx, "john.doe@gmail.com")
+			_, err := ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: testData.users[0].ID.Hex()})
+			require.NoError(t, err)
+			_, err = ctr.ConfirmPasswordReset(ctx, &usersvcv1.ConfirmPasswordResetRequest{Token: token, NewPassword: "k33p-it-secret"})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		})
+	})
+}
+
+func TestServiceServer_VerifyEmail(t *testing.T) {
+	// verifications returns a notifier which sends tokens to the channel.
+	verifications := func() (*notifier.Mock, chan *notifier.Notification) {
+		n := &notifier.Mock{}
+		sent := make(chan *notifier.Notification, 1)
+		n.On("Notify", mock.MatchedBy(func(n *notifier.Notification) bool {
+			return n.Kind == notifier.KindEmailVerification
+		})).Run(func(args mock.Arguments) {
+			sent <- args.Get(0).(*notifier.Notification)
+		}).Return(nil)
+		return n, sent
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		n, sent := verifications()
+		e := &events.Mock{}
+		e.On("Publish", events.CreateUserEvent, mock.Anything).Return(nil)
+		e.On("Publish", events.UpdateUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+			return e.GetBefore() != nil && !e.Before.EmailVerified && e.After.EmailVerified &&
+				assert.ObjectsAreEqual([]string{"email_verified"}, e.UpdateMask.Paths)
+		})).Return(nil)
+		ctr := newCtr(t, e, controller.Options{Notifier: n})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			user := &usersvcv1.User{FirstName: "Mark", LastName: "Brown", Email: "mark.brown@gmail.com", Country: "US"}
+			created, err := ctr.CreateUser(ctx, &usersvcv1.CreateUserRequest{User: user, Password: "correct horse battery"})
+			require.NoError(t, err)
+			assert.False(t, created.EmailVerified)
+			verification := <-sent
+			assert.Equal(t, created.Id, verification.UserID)
+			assert.Equal(t, "mark.brown@gmail.com", verification.Email)
+
+			res, err := ctr.VerifyEmail(ctx, &usersvcv1.VerifyEmailRequest{Token: verification.Token})
+			require.NoError(t, err)
+			assert.True(t, res.EmailVerified)
+			assert.Equal(t, created.Version+1, res.Version)
+			e.AssertExpectations(t)
+
+			list, err := ctr.ListUsers(ctx, &usersvcv1.ListUsersRequest{Filter: "email_verified = true"})
+			require.NoError(t, err)
+			require.Len(t, list.Users, 1)
+			assert.Equal(t, created.Id, list.Users[0].Id)
+
+			// tokens can be used once.
+			_, err = ctr.VerifyEmail(ctx, &usersvcv1.VerifyEmailRequest{Token: verification.Token})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		})
+	})
+
+	t.Run("email change", func(t *testing.T) {
+		user := testData.users[0]
+		n, sent := verifications()
+		e := &events.Mock{}
+		e.On("Publish", events.UpdateUserEvent, mock.Anything).Return(nil)
+		ctr := newCtr(t, e, controller.Options{Notifier: n})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			_, err := s.UpdateUser(ctx, &store.User{ID: user.ID, EmailVerified: true}, []string{"email_verified"}, store.AnyVersion)
+			require.NoError(t, err)
+
+			// the same email doesn't reset verification.
+			req := &usersvcv1.UpdateUserRequest{
+				User:       &usersvcv1.User{Id: user.ID.Hex(), Email: user.Email},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+			}
+			res, err := ctr.UpdateUser(ctx, req)
+			require.NoError(t, err)
+			assert.True(t, res.EmailVerified)
+			n.AssertNotCalled(t, "Notify", mock.Anything)
+
+			req.User.Email = "john.doe@outlook.com"
+			res, err = ctr.UpdateUser(ctx, req)
+			require.NoError(t, err)
+			assert.False(t, res.EmailVerified)
+			e.AssertCalled(t, "Publish", events.UpdateUserEvent, mock.MatchedBy(func(e *usersvcv1.UserEvent) bool {
+				return e.Before.EmailVerified && !e.After.EmailVerified &&
+					assert.ObjectsAreEqual([]string{"email", "email_verified"}, e.UpdateMask.Paths)
+			}))
+			verification := <-sent
+			assert.Equal(t, "john.doe@outlook.com", verification.Email)
+
+			res, err = ctr.VerifyEmail(ctx, &usersvcv1.VerifyEmailRequest{Token: verification.Token})
+			require.NoError(t, err)
+			assert.True(t, res.EmailVerified)
+			assert.Equal(t, "john.doe@outlook.com", res.Email)
+		})
+	})
+
+	t.Run("output only", func(t *testing.T) {
+		req := &usersvcv1.UpdateUserRequest{
+			User:       &usersvcv1.User{Id: testData.users[0].ID.Hex(), EmailVerified: true},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email_verified"}},
+		}
+		_, err := ctr.UpdateUser(context.Background(), req)
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := ctr.VerifyEmail(context.Background(), &usersvcv1.VerifyEmailRequest{Token: "abc"})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Convert(err).Code())
+		_, err = ctr.VerifyEmail(context.Background(), &usersvcv1.VerifyEmailRequest{})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Convert(err).Code())
+	})
+}
+
+func TestServiceServer_Policy(t *testing.T) {
+	const accountToken = "matchmaking-token"
+	sum := sha256.Sum256([]byte(accountToken))
+	account := auth.ServiceAccount{Name: "matchmaking", TokenSHA256: hex.EncodeToString(sum[:]), Scopes: []string{auth.ScopeUsersWrite}}
+	a := auth.NewAuthorizer(tokens, []auth.ServiceAccount{account}, s, controller.Policy())
+	token := func(u *store.User, role string) context.Context {
+		token, _, err := tokens.AccessToken(u.ID.Hex(), role)
+		require.NoError(t, err)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	call := func(ctx context.Context, method string, req interface{}, handler grpc.UnaryHandler) error {
+		info := &grpc.UnaryServerInfo{FullMethod: "/" + usersvcv1.Service_ServiceDesc.ServiceName + "/" + method}
+		_, err := a.UnaryServerInterceptor()(ctx, req, info, handler)
+		return err
+	}
+	getUser := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return ctr.GetUser(ctx, req.(*usersvcv1.GetUserRequest))
+	}
+	john, jane := testData.users[0], testData.users[1]
+
+	t.Run("get user", func(t *testing.T) {
+		req := &usersvcv1.GetUserRequest{Id: john.ID.Hex()}
+		assert.NoError(t, call(token(john, store.RoleUser), "GetUser", req, getUser))
+		assert.NoError(t, call(token(jane, store.RoleAdmin), "GetUser", req, getUser))
+		err := call(token(jane, store.RoleUser), "GetUser", req, getUser)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		err = call(context.Background(), "GetUser", req, getUser)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("update own role", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			md, _ := metadata.FromIncomingContext(token(john, store.RoleUser))
+			ctx = metadata.NewIncomingContext(ctx, md)
+			req := &usersvcv1.UpdateUserRequest{
+				User:       &usersvcv1.User{Id: john.ID.Hex(), Role: usersvcv1.Role_ROLE_ADMIN},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"role"}},
+			}
+			err := call(ctx, "UpdateUser", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ctr.UpdateUser(ctx, req.(*usersvcv1.UpdateUserRequest))
+			})
+			e.AssertNotCalled(t, "Publish")
+			require.Error(t, err)
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		})
+	})
+
+	t.Run("service account role", func(t *testing.T) {
+		e := &events.Mock{}
+		ctr := newCtr(t, e, controller.Options{})
+
+		testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+accountToken))
+			update := &usersvcv1.UpdateUserRequest{
+				User:       &usersvcv1.User{Id: john.ID.Hex(), Role: usersvcv1.Role_ROLE_ADMIN},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"role"}},
+			}
+			err := call(ctx, "UpdateUser", update, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ctr.UpdateUser(ctx, req.(*usersvcv1.UpdateUserRequest))
+			})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			create := &usersvcv1.CreateUserRequest{
+				User: &usersvcv1.User{FirstName: "Ad", LastName: "Min", Email: "ad.min@gmail.com", Country: "PL",
+					Role: usersvcv1.Role_ROLE_ADMIN},
+				Password: "correct horse battery",
+			}
+			err = call(ctx, "CreateUser", create, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ctr.CreateUser(ctx, req.(*usersvcv1.CreateUserRequest))
+			})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			e.AssertNotCalled(t, "Publish")
+		})
+	})
+
+	t.Run("public", func(t *testing.T) {
+		err := call(context.Background(), "HealthCheck", &usersvcv1.HealthCheckRequest{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return ctr.HealthCheck(ctx, req.(*usersvcv1.HealthCheckRequest))
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestServiceServer_ApiKeys(t *testing.T) {
+	admin := auth.NewContext(context.Background(), &auth.Principal{UserID: testData.users[1].ID.Hex(), Role: store.RoleAdmin})
+	a := auth.NewAuthorizer(tokens, nil, s, controller.Policy())
+	getUser := func(key string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(auth.APIKeyMetadata, key))
+		info := &grpc.UnaryServerInfo{FullMethod: "/" + usersvcv1.Service_ServiceDesc.ServiceName + "/GetUser"}
+		_, err := a.UnaryServerInterceptor()(ctx, &usersvcv1.GetUserRequest{Id: testData.users[0].ID.Hex()},
+			info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				assert.True(t, strings.HasPrefix(auth.FromContext(ctx).String(), "apiKeys/"))
+				return ctr.GetUser(ctx, req.(*usersvcv1.GetUserRequest))
+			})
+		return err
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		testutils.WithAbortedTransaction(admin, s, func(ctx context.Context) {
+			count, err := s.CountAPIKeys(ctx)
+			require.NoError(t, err)
+			expireTime := timestamppb.New(time.Now().Add(time.Hour))
+			created, err := ctr.CreateApiKey(ctx, &usersvcv1.CreateApiKeyRequest{ApiKey: &usersvcv1.ApiKey{
+				Name: "mailer", Scopes: []string{auth.ScopeUsersRead}, ExpireTime: expireTime,
+			}})
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(created.Key, auth.APIKeyPrefix), created.Key)
+			assert.Equal(t, "users/"+testData.users[1].ID.Hex(), created.Owner, "the creator should own the key")
+			assert.Equal(t, expireTime.AsTime().Truncate(time.Millisecond), created.ExpireTime.AsTime().Truncate(time.Millisecond))
+			assert.Nil(t, created.RevokeTime)
+			stored, err := s.GetAPIKeyByHash(ctx, auth.HashAPIKey(created.Key))
+			require.NoError(t, err)
+			assert.Equal(t, created.Id, stored.ID.Hex())
+			assert.NotContains(t, stored.Hash, created.Key, "keys should be stored hashed")
+
+			other, err := ctr.CreateApiKey(ctx, &usersvcv1.CreateApiKeyRequest{ApiKey: &usersvcv1.ApiKey{
+				Name: "reports", Owner: "team-reports", Scopes: []string{auth.ScopeWebhooksRead},
+			}})
+			require.NoError(t, err)
+			assert.Equal(t, "team-reports", other.Owner)
+
+			res, err := ctr.ListApiKeys(ctx, &usersvcv1.ListApiKeysRequest{Size: int32(count) + 2})
+			require.NoError(t, err)
+			assert.Equal(t, count+2, res.Total)
+			require.Len(t, res.ApiKeys, int(count)+2)
+			assert.Equal(t, created.Id, res.ApiKeys[count].Id)
+			assert.Empty(t, res.ApiKeys[count].Key, "keys should be shown once")
+		})
+	})
+
+	t.Run("authorization", func(t *testing.T) {
+		// keys are created without a transaction, the authorizer finds them with its own context.
+		created, err := ctr.CreateApiKey(admin, &usersvcv1.CreateApiKeyRequest{ApiKey: &usersvcv1.ApiKey{
+			Name: "mailer", Scopes: []string{auth.ScopeUsersRead},
+		}})
+		require.NoError(t, err)
+		other, err := ctr.CreateApiKey(admin, &usersvcv1.CreateApiKeyRequest{ApiKey: &usersvcv1.ApiKey{
+			Name: "reports", Scopes: []string{auth.ScopeWebhooksRead},
+		}})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			for _, id := range []string{created.Id, other.Id} {
+				_, err := ctr.RevokeApiKey(admin, &usersvcv1.RevokeApiKeyRequest{Id: id})
+				assert.NoError(t, err)
+			}
+		})
+
+		require.NoError(t, getUser(created.Key))
+		assert.Equal(t, codes.PermissionDenied, status.Code(getUser(other.Key)), "the key has no users.read scope")
+		assert.Equal(t, codes.Unauthenticated, status.Code(getUser(auth.APIKeyPrefix+"unknown")))
+
+		revoked, err := ctr.RevokeApiKey(admin, &usersvcv1.RevokeApiKeyRequest{Id: created.Id})
+		require.NoError(t, err)
+		require.NotNil(t, revoked.RevokeTime)
+		assert.Equal(t, codes.Unauthenticated, status.Code(getUser(created.Key)))
+		again, err := ctr.RevokeApiKey(admin, &usersvcv1.RevokeApiKeyRequest{Id: created.Id})
+		require.NoError(t, err)
+		assert.True(t, revoked.RevokeTime.AsTime().Equal(again.RevokeTime.AsTime()), "keys are revoked once")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		past := timestamppb.New(time.Now().Add(-time.Minute))
+		for name, k := range map[string]*usersvcv1.ApiKey{
+			"no name":       {Scopes: []string{auth.ScopeUsersRead}},
+			"no scopes":     {Name: "mailer"},
+			"unknown scope": {Name: "mailer", Scopes: []string{"users.delete"}},
+			"expired":       {Name: "mailer", Scopes: []string{auth.ScopeUsersRead}, ExpireTime: past},
+		} {
+			_, err := ctr.CreateApiKey(admin, &usersvcv1.CreateApiKeyRequest{ApiKey: k})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		}
+		_, err := ctr.CreateApiKey(context.Background(), &usersvcv1.CreateApiKeyRequest{ApiKey: &usersvcv1.ApiKey{
+			Name: "mailer", Scopes: []string{auth.ScopeUsersRead},
+		}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "owner is required without a caller")
+		_, err = ctr.CreateApiKey(admin, &usersvcv1.CreateApiKeyRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = ctr.RevokeApiKey(admin, &usersvcv1.RevokeApiKeyRequest{Id: "abc"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = ctr.RevokeApiKey(admin, &usersvcv1.RevokeApiKeyRequest{Id: primitive.NewObjectID().Hex()})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("admin only", func(t *testing.T) {
+		token, _, err := tokens.AccessToken(testData.users[0].ID.Hex(), store.RoleUser)
+		require.NoError(t, err)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		info := &grpc.UnaryServerInfo{FullMethod: "/" + usersvcv1.Service_ServiceDesc.ServiceName + "/CreateApiKey"}
+		_, err = a.UnaryServerInterceptor()(ctx, &usersvcv1.CreateApiKeyRequest{}, info,
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func TestServiceServer_WatchUsers(t *testing.T) {
+	ctx := context.Background()
+	other := testData.users[2]
+	updateOther := func() {
+		pbUser := &usersvcv1.User{Id: other.ID.Hex(), Country: other.Country}
+		um, err := fieldmaskpb.New(pbUser, "country")
+		require.NoError(t, err)
+		_, err = ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+		require.NoError(t, err)
+	}
+	// Watchers without a token stream changes committed after they start,
+	// so other watchers start after a change streamed by a started one.
+	all := watch(t, &usersvcv1.WatchUsersRequest{})
+	var token string
+	for token == "" {
+		updateOther()
+		select {
+		case resp := <-all:
+			token = resp.ResumeToken
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	filters := &usersvcv1.User{Email: "watch.me@gmail.com"}
+	changes := watch(t, &usersvcv1.WatchUsersRequest{Filters: filters, ResumeToken: token})
+	inDE := watch(t, &usersvcv1.WatchUsersRequest{
+		Filters:     &usersvcv1.User{Email: "watch.me@gmail.com", Country: "DE"},
+		ResumeToken: token,
+	})
+
+	// Changes of other users are filtered out.
+	updateOther()
+
+	user, err := ctr.CreateUser(ctx, &usersvcv1.CreateUserRequest{
+		User:     &usersvcv1.User{FirstName: "Watch", LastName: "Me", Email: "watch.me@gmail.com", Country: "DE"},
+		Password: "correct horse battery",
+	})
+	require.NoError(t, err)
+	pbUser := &usersvcv1.User{Id: user.Id, Country: "PL"}
+	um, err := fieldmaskpb.New(pbUser, "country")
+	require.NoError(t, err)
+	_, err = ctr.UpdateUser(ctx, &usersvcv1.UpdateUserRequest{User: pbUser, UpdateMask: um})
+	require.NoError(t, err)
+	_, err = ctr.DeleteUser(ctx, &usersvcv1.DeleteUserRequest{Id: user.Id})
+	require.NoError(t, err)
+
+	created := receive(t, changes)
+	assert.Equal(t, usersvcv1.EventType_EVENT_TYPE_CREATE, created.Type)
+	assert.Equal(t, user.Id, created.User.Id)
+	assert.Equal(t, "DE", created.User.Country)
+	updated := receive(t, changes)
+	assert.Equal(t, usersvcv1.EventType_EVENT_TYPE_UPDATE, updated.Type)
+	assert.Equal(t, "PL", updated.User.Country)
+	deleted := receive(t, changes)
+	assert.Equal(t, usersvcv1.EventType_EVENT_TYPE_DELETE, deleted.Type)
+	assert.Equal(t, user.Id, deleted.User.Id)
+
+	t.Run("left filters", func(t *testing.T) {
+		assert.Equal(t, created.ResumeToken, receive(t, inDE).ResumeToken)
+		left := receive(t, inDE)
+		assert.Equal(t, updated.ResumeToken, left.ResumeToken)
+		assert.True(t, left.LeftFilters)
+		assert.Equal(t, "PL", left.User.Country)
+		assert.False(t, updated.LeftFilters)
+		select {
+		case resp := <-inDE:
+			t.Fatalf("unexpected change: %v", resp)
+		case <-time.After(time.Second):
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		changes := watch(t, &usersvcv1.WatchUsersRequest{Filters: filters, ResumeToken: created.ResumeToken})
+		assert.Equal(t, updated.ResumeToken, receive(t, changes).ResumeToken)
+		assert.Equal(t, deleted.ResumeToken, receive(t, changes).ResumeToken)
+	})
+
+	t.Run("invalid resume token", func(t *testing.T) {
+		stream := &watchStream{ctx: ctx}
+		err := ctr.WatchUsers(&usersvcv1.WatchUsersRequest{ResumeToken: "-"}, stream)
+		require.Error(t, err)
+		assert.Equal(t, status.Convert(err).Code(), codes.InvalidArgument)
+	})
+}
+
+func TestServiceServer_Webhooks(t *testing.T) {
+	testutils.WithAbortedTransaction(context.Background(), s, func(ctx context.Context) {
+		created, err := ctr.CreateWebhook(ctx, &usersvcv1.CreateWebhookRequest{Webhook: &usersvcv1.Webhook{
+			Url:        "https://example.com/hook",
+			EventTypes: []string{events.CreateUserEvent, events.DeleteUserEvent},
+		}})
+		require.NoError(t, err)
+		assert.NotEmpty(t, created.Id)
+		assert.Equal(t, usersvcv1.Webhook_CONTENT_MODE_STRUCTURED, created.ContentMode)
+		// Secret is generated and returned only once.
+		assert.NotEmpty(t, created.Secret)
+
+		list, err := ctr.ListWebhooks(ctx, &usersvcv1.ListWebhooksRequest{})
+		require.NoError(t, err)
+		require.Len(t, list.Webhooks, 1)
+		assert.Equal(t, int64(1), list.Total)
+		assert.Equal(t, created.Id, list.Webhooks[0].Id)
+		assert.Equal(t, created.EventTypes, list.Webhooks[0].EventTypes)
+		assert.Empty(t, list.Webhooks[0].Secret)
+
+		deliveries, err := ctr.ListWebhookDeliveries(ctx, &usersvcv1.ListWebhookDeliveriesRequest{WebhookId: created.Id})
+		require.NoError(t, err)
+		assert.Empty(t, deliveries.Deliveries)
+
+		_, err = ctr.DeleteWebhook(ctx, &usersvcv1.DeleteWebhookRequest{Id: created.Id})
+		require.NoError(t, err)
+		_, err = ctr.DeleteWebhook(ctx, &usersvcv1.DeleteWebhookRequest{Id: created.Id})
+		require.Error(t, err)
+		assert.Equal(t, status.Convert(err).Code(), codes.NotFound)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		reqs := []*usersvcv1.CreateWebhookRequest{
+			{},
+			{Webhook: &usersvcv1.Webhook{Url: "example.com/hook"}},
+			{Webhook: &usersvcv1.Webhook{Url: "ftp://example.com/hook"}},
+			{Webhook: &usersvcv1.Webhook{Url: "https://example.com/hook", EventTypes: []string{"users.create"}}},
+		}
+		for _, req := range reqs {
+			_, err := ctr.CreateWebhook(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, status.Convert(err).Code(), codes.InvalidArgument)
+		}
+	})
+}
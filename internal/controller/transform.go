@@ -1,21 +1,68 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
 	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
 	"github.com/mlukasik-dev/usersvc/internal/store"
 	"github.com/mlukasik-dev/usersvc/pkg/deref"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func userToPb(u *store.User) *usersvcv1.User {
-	return &usersvcv1.User{
-		Id:        u.ID.Hex(),
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Nickname:  deref.String(u.Nickname),
-		Email:     u.Email,
-		Country:   u.Country,
+	pb := &usersvcv1.User{
+		Id:            u.ID.Hex(),
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Nickname:      deref.String(u.Nickname),
+		Email:         u.Email,
+		Country:       u.Country,
+		Version:       u.Version,
+		Etag:          userEtag(u),
+		Role:          roleToPb(userRole(u)),
+		EmailVerified: u.EmailVerified,
+	}
+	// users created before timestamps were introduced don't have them.
+	if !u.CreatedAt.IsZero() {
+		pb.CreateTime = timestamppb.New(u.CreatedAt)
+	}
+	if !u.UpdatedAt.IsZero() {
+		pb.UpdateTime = timestamppb.New(u.UpdatedAt)
+	}
+	if u.DeletedAt != nil {
+		pb.DeleteTime = timestamppb.New(*u.DeletedAt)
 	}
+	return pb
+}
+
+// userOutputOnlyFields are fields of User which can't be updated.
+var userOutputOnlyFields = []string{"id", "create_time", "update_time", "version", "etag", "delete_time", "email_verified"}
+
+// userEtag returns an etag of the user's version.
+func userEtag(u *store.User) string {
+	return strconv.Quote(strconv.FormatInt(u.Version, 10))
+}
+
+// parseEtag returns a version of the etag, store.AnyVersion when it's empty.
+func parseEtag(etag string) (int64, error) {
+	if etag == "" {
+		return store.AnyVersion, nil
+	}
+	s, err := strconv.Unquote(etag)
+	if err != nil || !strings.HasPrefix(etag, `"`) {
+		return 0, errors.New("invalid etag")
+	}
+	version, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || version < 0 {
+		return 0, errors.New("invalid etag")
+	}
+	return version, nil
 }
 
 func pbToUser(pb *usersvcv1.User) *store.User {
@@ -27,5 +74,135 @@ func pbToUser(pb *usersvcv1.User) *store.User {
 		Nickname:  &pb.Nickname,
 		Email:     pb.Email,
 		Country:   pb.Country,
+		Role:      roleFromPb(pb.Role),
+	}
+}
+
+// userRole returns a role of a user, users created before roles were introduced are regular users.
+func userRole(u *store.User) string {
+	if u.Role == "" {
+		return store.RoleUser
+	}
+	return u.Role
+}
+
+func roleToPb(role string) usersvcv1.Role {
+	switch role {
+	case store.RoleUser:
+		return usersvcv1.Role_ROLE_USER
+	case store.RoleAdmin:
+		return usersvcv1.Role_ROLE_ADMIN
+	}
+	return usersvcv1.Role_ROLE_UNSPECIFIED
+}
+
+// roleFromPb returns a role of the store, an empty one when it's not specified.
+// Unknown roles are returned as they are, so they fail validation.
+func roleFromPb(role usersvcv1.Role) string {
+	switch role {
+	case usersvcv1.Role_ROLE_UNSPECIFIED:
+		return ""
+	case usersvcv1.Role_ROLE_USER:
+		return store.RoleUser
+	case usersvcv1.Role_ROLE_ADMIN:
+		return store.RoleAdmin
+	}
+	return role.String()
+}
+
+// userEvent creates an event describing a change of a user,
+// before is nil for created users and after is nil for deleted ones.
+func userEvent(ctx context.Context, t usersvcv1.EventType, before, after *store.User, paths []string) *usersvcv1.UserEvent {
+	e := &usersvcv1.UserEvent{Type: t, Actor: actor(ctx)}
+	if before != nil {
+		e.Before = userToPb(before)
+	}
+	if after != nil {
+		e.After = userToPb(after)
+	}
+	if paths != nil {
+		e.UpdateMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+	return e
+}
+
+func lockoutToPb(id primitive.ObjectID, l *store.Lockout) *usersvcv1.Lockout {
+	pb := &usersvcv1.Lockout{UserId: id.Hex(), FailedAttempts: int32(l.Failures)}
+	if l.IsLocked(time.Now()) {
+		pb.LockExpireTime = timestamppb.New(*l.LockedUntil)
+	}
+	return pb
+}
+
+// webhookToPb transforms webhook omitting its secret.
+func webhookToPb(w *store.Webhook) *usersvcv1.Webhook {
+	return &usersvcv1.Webhook{
+		Id:          w.ID.Hex(),
+		Url:         w.URL,
+		EventTypes:  w.EventTypes,
+		ContentMode: webhookContentModes[w.ContentMode],
+		CreateTime:  timestamppb.New(w.CreatedAt),
+	}
+}
+
+func apiKeyToPb(k *store.APIKey) *usersvcv1.ApiKey {
+	pb := &usersvcv1.ApiKey{
+		Id:         k.ID.Hex(),
+		Name:       k.Name,
+		Owner:      k.Owner,
+		Scopes:     k.Scopes,
+		CreateTime: timestamppb.New(k.CreatedAt),
+	}
+	if k.ExpiresAt != nil {
+		pb.ExpireTime = timestamppb.New(*k.ExpiresAt)
+	}
+	if k.RevokedAt != nil {
+		pb.RevokeTime = timestamppb.New(*k.RevokedAt)
+	}
+	return pb
+}
+
+var webhookContentModes = map[string]usersvcv1.Webhook_ContentMode{
+	store.WebhookContentModeStructured: usersvcv1.Webhook_CONTENT_MODE_STRUCTURED,
+	store.WebhookContentModeBinary:     usersvcv1.Webhook_CONTENT_MODE_BINARY,
+}
+
+func pbToWebhook(pb *usersvcv1.Webhook) *store.Webhook {
+	w := &store.Webhook{
+		URL:         pb.Url,
+		EventTypes:  pb.EventTypes,
+		Secret:      pb.Secret,
+		ContentMode: store.WebhookContentModeStructured,
+	}
+	if pb.ContentMode == usersvcv1.Webhook_CONTENT_MODE_BINARY {
+		w.ContentMode = store.WebhookContentModeBinary
+	}
+	return w
+}
+
+var webhookDeliveryStatuses = map[string]usersvcv1.WebhookDelivery_Status{
+	store.WebhookDeliveryPending:   usersvcv1.WebhookDelivery_STATUS_PENDING,
+	store.WebhookDeliverySucceeded: usersvcv1.WebhookDelivery_STATUS_SUCCEEDED,
+	store.WebhookDeliveryFailed:    usersvcv1.WebhookDelivery_STATUS_FAILED,
+}
+
+func webhookDeliveryToPb(d *store.WebhookDelivery) *usersvcv1.WebhookDelivery {
+	pb := &usersvcv1.WebhookDelivery{
+		Id:           d.ID.Hex(),
+		WebhookId:    d.WebhookID.Hex(),
+		EventId:      d.EventID,
+		EventType:    d.EventName,
+		Status:       webhookDeliveryStatuses[d.Status],
+		Attempts:     int32(d.Attempts),
+		ResponseCode: int32(d.ResponseCode),
+		Error:        d.LastError,
+		CreateTime:   timestamppb.New(d.CreatedAt),
+	}
+	if d.LastAttemptAt != nil {
+		pb.LastAttemptTime = timestamppb.New(*d.LastAttemptAt)
+	}
+	if d.Status == store.WebhookDeliveryPending {
+		pb.NextAttemptTime = timestamppb.New(d.NextAttemptAt)
 	}
+	return pb
 }
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// recoveryCodesCount is the number of recovery codes users get.
+const recoveryCodesCount = 10
+
+var (
+	// errTwoFactorRequired is returned when a user with two-factor authentication didn't pass a code.
+	errTwoFactorRequired = errors.New("two_factor_code is required")
+	errTwoFactorEnabled  = errors.New("two-factor authentication is already enabled")
+	errTwoFactorDisabled = errors.New("two-factor authentication is not enabled")
+)
+
+func (ctr *Ctr) EnrollTwoFactor(ctx context.Context, req *usersvcv1.EnrollTwoFactorRequest) (*usersvcv1.TwoFactorEnrollment, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	sealed, err := ctr.secrets.Seal(secret)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var u *store.User
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		u, err = ctr.activeUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		tf, err := ctr.store.GetTwoFactor(ctx, id)
+		if err == nil && tf.EnabledAt != nil {
+			return errTwoFactorEnabled
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		// a secret which wasn't confirmed is replaced.
+		return ctr.store.SetTwoFactor(ctx, id, &store.TwoFactor{Secret: sealed})
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, errTwoFactorEnabled) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &usersvcv1.TwoFactorEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		Uri:    totp.URI(secret, ctr.twoFactorIssuer, u.Email),
+	}, nil
+}
+
+func (ctr *Ctr) ConfirmTwoFactor(ctx context.Context, req *usersvcv1.ConfirmTwoFactorRequest) (*usersvcv1.RecoveryCodes, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "code should not be empty")
+	}
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := ctr.activeUser(ctx, id); err != nil {
+			return err
+		}
+		tf, err := ctr.store.GetTwoFactor(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return errTwoFactorDisabled
+		}
+		if err != nil {
+			return err
+		}
+		if tf.EnabledAt != nil {
+			return errTwoFactorEnabled
+		}
+		secret, err := ctr.secrets.Open(tf.Secret)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		step, ok := totp.Validate(secret, req.Code, now)
+		if !ok {
+			return store.ErrInvalidCreds
+		}
+		tf.EnabledAt = &now
+		tf.LastStep = step
+		tf.RecoveryCodes = hashes
+		return ctr.store.SetTwoFactor(ctx, id, tf)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, errTwoFactorEnabled) || errors.Is(err, errTwoFactorDisabled) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, store.ErrInvalidCreds) {
+		return nil, status.Error(codes.PermissionDenied, "invalid code")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &usersvcv1.RecoveryCodes{Codes: recoveryCodes}, nil
+}
+
+func (ctr *Ctr) GenerateRecoveryCodes(ctx context.Context, req *usersvcv1.GenerateRecoveryCodesRequest) (*usersvcv1.RecoveryCodes, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "code should not be empty")
+	}
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = ctr.useTwoFactorCode(ctx, id, req.Code, func(ctx context.Context) error {
+		// the two-factor authentication is read again, the code could have been a recovery code.
+		tf, err := ctr.store.GetTwoFactor(ctx, id)
+		if err != nil {
+			return err
+		}
+		tf.RecoveryCodes = hashes
+		return ctr.store.SetTwoFactor(ctx, id, tf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &usersvcv1.RecoveryCodes{Codes: recoveryCodes}, nil
+}
+
+func (ctr *Ctr) DisableTwoFactor(ctx context.Context, req *usersvcv1.DisableTwoFactorRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Code != "" || !auth.FromContext(ctx).IsAdmin() {
+		if req.Code == "" {
+			return nil, status.Error(codes.InvalidArgument, "code should not be empty")
+		}
+		err := ctr.useTwoFactorCode(ctx, id, req.Code, func(ctx context.Context) error {
+			return ctr.store.DeleteTwoFactor(ctx, id)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &emptypb.Empty{}, nil
+	}
+
+	// admins disable two-factor authentication without a code, also of users who didn't confirm their enrollment.
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := ctr.activeUser(ctx, id); err != nil {
+			return err
+		}
+		err := ctr.store.DeleteTwoFactor(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return errTwoFactorDisabled
+		}
+		return err
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, errTwoFactorDisabled) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// useTwoFactorCode verifies a code of a user with enabled two-factor authentication and runs fn in the same transaction,
+// so the code is used only when fn succeeds, and returns status errors. Invalid codes count as failed password checks.
+func (ctr *Ctr) useTwoFactorCode(ctx context.Context, id primitive.ObjectID, code string, fn func(ctx context.Context) error) error {
+	u, err := ctr.activeUser(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	client := clientIP(ctx)
+	keys := []string{store.UserLockoutKey(id)}
+	if client != "" {
+		keys = append(keys, store.ClientLockoutKey(client))
+	}
+	if err := ctr.checkLockouts(ctx, keys...); err != nil {
+		return err
+	}
+	tf, err := ctr.twoFactor(ctx, id)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if tf == nil {
+		return status.Error(codes.FailedPrecondition, errTwoFactorDisabled.Error())
+	}
+
+	err = ctr.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := ctr.verifyTwoFactor(ctx, id, tf, code); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+	if errors.Is(err, store.ErrInvalidCreds) {
+		if err := ctr.recordFailure(ctx, u, client); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return status.Error(codes.PermissionDenied, "invalid code")
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return status.Error(codes.FailedPrecondition, errTwoFactorDisabled.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// twoFactor returns two-factor authentication of a user when it's enabled, otherwise nil.
+func (ctr *Ctr) twoFactor(ctx context.Context, id primitive.ObjectID) (*store.TwoFactor, error) {
+	tf, err := ctr.store.GetTwoFactor(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tf.EnabledAt == nil {
+		return nil, nil
+	}
+	return tf, nil
+}
+
+// verifyTwoFactor uses a TOTP code or a recovery code of a user with two-factor authentication tf, unless it's nil.
+// Returns errTwoFactorRequired when the code is empty and store.ErrInvalidCreds when it's invalid or was used.
+func (ctr *Ctr) verifyTwoFactor(ctx context.Context, id primitive.ObjectID, tf *store.TwoFactor, code string) error {
+	if tf == nil {
+		return nil
+	}
+	if code == "" {
+		return errTwoFactorRequired
+	}
+	if !totp.IsCode(code) {
+		return ctr.store.UseRecoveryCode(ctx, id, hashRecoveryCode(code))
+	}
+	secret, err := ctr.secrets.Open(tf.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return store.ErrInvalidCreds
+	}
+	return ctr.store.UseTwoFactorStep(ctx, id, step)
+}
+
+// activeUser returns a user which isn't deleted, store.ErrNotFound otherwise.
+func (ctr *Ctr) activeUser(ctx context.Context, id primitive.ObjectID) (*store.User, error) {
+	u, err := ctr.store.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.DeletedAt != nil {
+		return nil, store.ErrNotFound
+	}
+	return u, nil
+}
+
+// recoveryCodesEncoding encodes random bytes of recovery codes, base32 has no digits which look like letters.
+var recoveryCodesEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns new random recovery codes, e.g. abcd-efgh-ijkl-mnop, and their hashes.
+func newRecoveryCodes() (recoveryCodes, hashes []string, err error) {
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := recoveryCodesEncoding.EncodeToString(b)
+		code := s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return recoveryCodes, hashes, nil
+}
+
+// hashRecoveryCode returns a hash of a recovery code under which it's stored, codes are random,
+// so they don't need a slow password hash. Case, dashes and spaces are ignored.
+func hashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (ctr *Ctr) WatchUsers(req *usersvcv1.WatchUsersRequest, stream usersvcv1.Service_WatchUsersServer) error {
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.Filters == nil {
+		req.Filters = &usersvcv1.User{}
+	}
+	filter := pbToUser(req.Filters)
+	if err := filter.Validate(store.FilterValidationKind); err != nil {
+		return status.Error(codes.InvalidArgument, err.One())
+	}
+
+	ctx := stream.Context()
+	evts, err := ctr.store.WatchEvents(ctx, req.ResumeToken)
+	if err != nil {
+		return watchError(err)
+	}
+	defer evts.Close(context.Background())
+	for {
+		e, token, err := evts.Next(ctx)
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if err != nil {
+			return watchError(err)
+		}
+		ue, err := events.DecodeUserEvent(e)
+		if err != nil {
+			ctr.logger.Error("failed to decode event", zap.String("id", e.ID), zap.Error(err))
+			continue
+		}
+		resp := watchResponse(ue, token)
+		if !filter.Matches(pbToUser(resp.User)) {
+			// users which are changed so they no longer match filters leave them.
+			if ue.Before == nil || ue.After == nil || !filter.Matches(pbToUser(ue.Before)) {
+				continue
+			}
+			resp.LeftFilters = true
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func watchError(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return status.Error(codes.InvalidArgument, "invalid resume_token")
+	case errors.Is(err, store.ErrExpired):
+		return status.Error(codes.OutOfRange, "resume_token expired, changes after it were removed")
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// watchResponse returns a response with the user after the change, or before it when it was deleted.
+func watchResponse(ue *usersvcv1.UserEvent, resumeToken string) *usersvcv1.WatchUsersResponse {
+	u := ue.After
+	if ue.Type == usersvcv1.EventType_EVENT_TYPE_DELETE {
+		u = ue.Before
+	}
+	return &usersvcv1.WatchUsersResponse{
+		Type:        ue.Type,
+		User:        u,
+		Time:        ue.Time,
+		ResumeToken: resumeToken,
+	}
+}
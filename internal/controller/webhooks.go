@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (ctr *Ctr) CreateWebhook(ctx context.Context, req *usersvcv1.CreateWebhookRequest) (*usersvcv1.Webhook, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if req.Webhook == nil {
+		return nil, status.Error(codes.InvalidArgument, "req.webhook should not be <nil>")
+	}
+	u, err := url.Parse(req.Webhook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid url")
+	}
+	for _, t := range req.Webhook.EventTypes {
+		if !contains(events.Names, t) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid event type: %q", t)
+		}
+	}
+	w := pbToWebhook(req.Webhook)
+	if w.Secret == "" {
+		if w.Secret, err = webhooks.NewSecret(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	w.CreatedAt = time.Now()
+
+	w, err = ctr.store.CreateWebhook(ctx, w)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	pb := webhookToPb(w)
+	pb.Secret = w.Secret
+	return pb, nil
+}
+
+func (ctr *Ctr) ListWebhooks(ctx context.Context, req *usersvcv1.ListWebhooksRequest) (*usersvcv1.ListWebhooksResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	if err := paginate(&req.Page, &req.Size); err != nil {
+		return nil, err
+	}
+
+	count, err := ctr.store.CountWebhooks(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	ws, err := ctr.store.ListWebhooks(ctx, &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &usersvcv1.ListWebhooksResponse{
+		Page:  req.Page,
+		Size:  req.Size,
+		Total: count,
+	}
+	for _, w := range ws {
+		resp.Webhooks = append(resp.Webhooks, webhookToPb(w))
+	}
+	return resp, nil
+}
+
+func (ctr *Ctr) DeleteWebhook(ctx context.Context, req *usersvcv1.DeleteWebhookRequest) (*emptypb.Empty, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = ctr.store.DeleteWebhook(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (ctr *Ctr) ListWebhookDeliveries(ctx context.Context, req *usersvcv1.ListWebhookDeliveriesRequest) (*usersvcv1.ListWebhookDeliveriesResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "req should not be <nil>")
+	}
+	id, err := primitive.ObjectIDFromHex(req.WebhookId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := paginate(&req.Page, &req.Size); err != nil {
+		return nil, err
+	}
+
+	_, err = ctr.store.GetWebhook(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	count, err := ctr.store.CountWebhookDeliveries(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	ds, err := ctr.store.ListWebhookDeliveries(ctx, id, &store.Pagination{Page: uint(req.Page), Size: uint(req.Size)})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &usersvcv1.ListWebhookDeliveriesResponse{
+		Page:  req.Page,
+		Size:  req.Size,
+		Total: count,
+	}
+	for _, d := range ds {
+		resp.Deliveries = append(resp.Deliveries, webhookDeliveryToPb(d))
+	}
+	return resp, nil
+}
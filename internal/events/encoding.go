@@ -0,0 +1,69 @@
+package events
+
+import (
+	"fmt"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Formats events can be delivered in.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// DecodeUserEvent decodes data of an outbox event, filling in its id and time.
+func DecodeUserEvent(e *Event) (*usersvcv1.UserEvent, error) {
+	if e.ContentType != ContentTypeProtobuf {
+		return nil, fmt.Errorf("unsupported content type: %q", e.ContentType)
+	}
+	var ue usersvcv1.UserEvent
+	if err := proto.Unmarshal(e.Data, &ue); err != nil {
+		return nil, err
+	}
+	ue.Id = e.ID
+	ue.Time = timestamppb.New(e.CreatedAt)
+	return &ue, nil
+}
+
+// CloudEvent converts an outbox event into a CloudEvents event,
+// with UserEvent data encoded in a given format.
+func CloudEvent(e *Event, format, source string) (*cloudevents.Event, error) {
+	ue, err := DecodeUserEvent(e)
+	if err != nil {
+		return nil, err
+	}
+	ce := &cloudevents.Event{
+		ID:      e.ID,
+		Source:  source,
+		Type:    e.Name,
+		Subject: ue.GetAfter().GetId(),
+		Time:    e.CreatedAt,
+	}
+	if ue.Type == usersvcv1.EventType_EVENT_TYPE_DELETE {
+		ce.Subject = ue.GetBefore().GetId()
+	}
+	switch format {
+	case FormatJSON:
+		ce.Data, err = protojson.Marshal(ue)
+		ce.DataContentType = ContentTypeJSON
+	case FormatProtobuf:
+		ce.Data, err = proto.Marshal(ue)
+		ce.DataContentType = ContentTypeProtobuf
+	default:
+		err = fmt.Errorf("unknown events format: %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ce, nil
+}
@@ -1,31 +1,72 @@
 package events
 
+import (
+	"context"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"google.golang.org/protobuf/proto"
+)
+
 const (
-	CreateUserEvent = "faceit.usersvc.v1.users.create"
-	UpdateUserEvent = "faceit.usersvc.v1.users.update"
-	DeleteUserEvent = "faceit.usersvc.v1.users.delete"
+	CreateUserEvent   = "faceit.usersvc.v1.users.create"
+	UpdateUserEvent   = "faceit.usersvc.v1.users.update"
+	DeleteUserEvent   = "faceit.usersvc.v1.users.delete"
+	UndeleteUserEvent = "faceit.usersvc.v1.users.undelete"
+	LockUserEvent     = "faceit.usersvc.v1.users.lock"
+	UnlockUserEvent   = "faceit.usersvc.v1.users.unlock"
 )
 
+// DefaultSource is a CloudEvents source attribute used when none is configured.
+const DefaultSource = "/faceit/usersvc"
+
+// Names contains names of all published events, they are used as CloudEvents type attribute.
+var Names = []string{CreateUserEvent, UpdateUserEvent, DeleteUserEvent, UndeleteUserEvent, LockUserEvent, UnlockUserEvent}
+
+// Event is a single entry of the transactional outbox.
+type Event struct {
+	ID   string
+	Name string
+	// Data is encoded as protobuf in the outbox,
+	// sinks receive it encoded in the format configured for the Relay.
+	Data        []byte
+	ContentType string
+	CreatedAt   time.Time
+	// Attempts is a number of failed delivery attempts.
+	Attempts int
+}
+
 type Client interface {
-	Publish(eventName string, data interface{})
+	// Publish should be called in the same transaction as the change the event describes,
+	// so both of them are either committed or rolled back.
+	// Id and time of the event are set when it's delivered.
+	Publish(ctx context.Context, eventName string, e *usersvcv1.UserEvent) error
+}
+
+// Outbox stores events until they are delivered by the Relay.
+type Outbox interface {
+	// AddEvent persists an event, using a transaction found in ctx if there is one.
+	AddEvent(ctx context.Context, e *Event) error
+	// ClaimEvents returns up to limit undelivered events which are due,
+	// and hides them from other relays for the lease duration.
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*Event, error)
+	MarkEventPublished(ctx context.Context, id string) error
+	MarkEventFailed(ctx context.Context, id string, retryAt time.Time) error
 }
 
-// Here come future dependencies.
 type client struct {
+	outbox Outbox
 }
 
-func New() *client {
-	return &client{}
+func New(outbox Outbox) *client {
+	return &client{outbox}
 }
 
-func (c *client) Publish(eventName string, data interface{}) {
-	// TODO: do some stuff here.
-	//
-	// Possible solutions:
-	// 1. Publish an event into some pubsub system, Cloud PubSub for instance.
-	// 2. Use "webhooks" mechanism: allow services have changes pushed to them via http,
-	//    developer puts array of urls in configs/config.yaml, then they are written to db,
-	//    and on it event Publish iterates over them and send them data.
-	// 3. Use gRPC server streaming, but not with this package.
-	//
+// Publish writes an event into the outbox, from there it is delivered by the Relay.
+func (c *client) Publish(ctx context.Context, eventName string, e *usersvcv1.UserEvent) error {
+	b, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.outbox.AddEvent(ctx, &Event{Name: eventName, Data: b, ContentType: ContentTypeProtobuf, CreatedAt: time.Now()})
 }
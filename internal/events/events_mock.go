@@ -1,6 +1,11 @@
 package events
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/stretchr/testify/mock"
+)
 
 type Mock struct {
 	mock.Mock
@@ -8,10 +13,6 @@ type Mock struct {
 
 var _ Client = (*Mock)(nil)
 
-func (m *Mock) Publish(eventName string, data interface{}) {
-	if eventName == CreateUserEvent {
-		m.Called(eventName, "<id>")
-		return
-	}
-	m.Called(eventName, data)
+func (m *Mock) Publish(_ context.Context, eventName string, e *usersvcv1.UserEvent) error {
+	return m.Called(eventName, e).Error(0)
 }
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/pkg/backoff"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"go.uber.org/zap"
+)
+
+// Sink delivers events to their consumers.
+type Sink interface {
+	Send(ctx context.Context, e *cloudevents.Event) error
+}
+
+type RelayOptions struct {
+	// Format events are encoded in before they're sent to the sink.
+	Format string
+	// Source is a CloudEvents source attribute of all events.
+	Source string
+	// Interval between outbox polls.
+	Interval time.Duration
+	// BatchSize is a maximum number of events claimed at once.
+	BatchSize int
+	// Lease is for how long claimed events are hidden from other relays,
+	// it should be longer than delivery of the whole batch takes.
+	Lease time.Duration
+	// MinBackoff and MaxBackoff bound exponential delay between delivery attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o *RelayOptions) setDefaults() {
+	if o.Format == "" {
+		o.Format = FormatJSON
+	}
+	if o.Source == "" {
+		o.Source = DefaultSource
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.Lease <= 0 {
+		o.Lease = 30 * time.Second
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = 5 * time.Minute
+	}
+}
+
+// Relay drains the outbox into a sink. Event is marked as published
+// only after the sink accepted it, so every event is delivered at least once,
+// but it can be delivered more than once and order of deliveries isn't guaranteed.
+type Relay struct {
+	outbox Outbox
+	sink   Sink
+	logger *zap.Logger
+	opts   RelayOptions
+}
+
+func NewRelay(outbox Outbox, sink Sink, l *zap.Logger, opts RelayOptions) *Relay {
+	opts.setDefaults()
+	return &Relay{outbox, sink, l, opts}
+}
+
+// Run delivers events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		r.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain delivers due events until there are no more of them.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		evts, err := r.outbox.ClaimEvents(ctx, r.opts.BatchSize, r.opts.Lease)
+		if err != nil {
+			r.logger.Error("failed to claim outbox events", zap.Error(err))
+			return
+		}
+		for _, e := range evts {
+			r.deliver(ctx, e)
+		}
+		if len(evts) < r.opts.BatchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) deliver(ctx context.Context, e *Event) {
+	ce, err := CloudEvent(e, r.opts.Format, r.opts.Source)
+	if err == nil {
+		err = r.sink.Send(ctx, ce)
+	}
+	if err != nil {
+		retryAt := time.Now().Add(backoff.Exponential(e.Attempts, r.opts.MinBackoff, r.opts.MaxBackoff))
+		r.logger.Warn("event delivery failed",
+			zap.String("id", e.ID), zap.String("name", e.Name), zap.Int("attempts", e.Attempts+1),
+			zap.Time("retryAt", retryAt), zap.Error(err))
+		if err := r.outbox.MarkEventFailed(ctx, e.ID, retryAt); err != nil {
+			r.logger.Error("failed to mark event as failed", zap.String("id", e.ID), zap.Error(err))
+		}
+		return
+	}
+	if err := r.outbox.MarkEventPublished(ctx, e.ID); err != nil {
+		// The event will be delivered again once its lease expires.
+		r.logger.Error("failed to mark event as published", zap.String("id", e.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,166 @@
+// +build unit
+
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeOutbox struct {
+	mu        sync.Mutex
+	events    []*Event
+	retryAt   map[string]time.Time
+	published map[string]bool
+}
+
+func newFakeOutbox() *fakeOutbox {
+	return &fakeOutbox{retryAt: map[string]time.Time{}, published: map[string]bool{}}
+}
+
+func (o *fakeOutbox) AddEvent(_ context.Context, e *Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.ID = strconv.Itoa(len(o.events))
+	o.events = append(o.events, e)
+	return nil
+}
+
+func (o *fakeOutbox) ClaimEvents(_ context.Context, limit int, lease time.Duration) ([]*Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var evts []*Event
+	for _, e := range o.events {
+		if len(evts) == limit {
+			break
+		}
+		if o.published[e.ID] || o.retryAt[e.ID].After(time.Now()) {
+			continue
+		}
+		o.retryAt[e.ID] = time.Now().Add(lease)
+		c := *e
+		evts = append(evts, &c)
+	}
+	return evts, nil
+}
+
+func (o *fakeOutbox) MarkEventPublished(_ context.Context, id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.published[id] = true
+	return nil
+}
+
+func (o *fakeOutbox) MarkEventFailed(_ context.Context, id string, retryAt time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i, _ := strconv.Atoi(id)
+	o.events[i].Attempts++
+	o.retryAt[id] = retryAt
+	return nil
+}
+
+// flakySink fails first n deliveries.
+type flakySink struct {
+	mu        sync.Mutex
+	failures  int
+	delivered []string
+}
+
+func (s *flakySink) Send(_ context.Context, e *cloudevents.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.delivered = append(s.delivered, e.ID)
+	return nil
+}
+
+func TestClient_Publish(t *testing.T) {
+	outbox := newFakeOutbox()
+	ue := &usersvcv1.UserEvent{Type: usersvcv1.EventType_EVENT_TYPE_CREATE, After: &usersvcv1.User{Id: "1"}}
+	err := New(outbox).Publish(context.Background(), CreateUserEvent, ue)
+	require.NoError(t, err)
+	require.Len(t, outbox.events, 1)
+	e := outbox.events[0]
+	assert.Equal(t, CreateUserEvent, e.Name)
+	assert.Equal(t, ContentTypeProtobuf, e.ContentType)
+
+	decoded, err := DecodeUserEvent(e)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, decoded.Id)
+	assert.Equal(t, e.CreatedAt.UnixNano(), decoded.Time.AsTime().UnixNano())
+	assert.Equal(t, "1", decoded.After.Id)
+}
+
+func TestCloudEvent(t *testing.T) {
+	outbox := newFakeOutbox()
+	ue := &usersvcv1.UserEvent{Type: usersvcv1.EventType_EVENT_TYPE_DELETE, Before: &usersvcv1.User{Id: "1"}}
+	require.NoError(t, New(outbox).Publish(context.Background(), DeleteUserEvent, ue))
+	e := outbox.events[0]
+
+	ce, err := CloudEvent(e, FormatJSON, DefaultSource)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, ce.ID)
+	assert.Equal(t, DefaultSource, ce.Source)
+	assert.Equal(t, DeleteUserEvent, ce.Type)
+	assert.Equal(t, "1", ce.Subject)
+	assert.Equal(t, e.CreatedAt, ce.Time)
+	assert.Equal(t, ContentTypeJSON, ce.DataContentType)
+	var fromJSON usersvcv1.UserEvent
+	require.NoError(t, protojson.Unmarshal(ce.Data, &fromJSON))
+	assert.Equal(t, e.ID, fromJSON.Id)
+	assert.Equal(t, usersvcv1.EventType_EVENT_TYPE_DELETE, fromJSON.Type)
+
+	ce, err = CloudEvent(e, FormatProtobuf, DefaultSource)
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeProtobuf, ce.DataContentType)
+	var fromProto usersvcv1.UserEvent
+	require.NoError(t, proto.Unmarshal(ce.Data, &fromProto))
+	assert.True(t, proto.Equal(&fromJSON, &fromProto))
+
+	_, err = CloudEvent(e, "xml", DefaultSource)
+	assert.Error(t, err)
+}
+
+func TestRelay(t *testing.T) {
+	outbox := newFakeOutbox()
+	c := New(outbox)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Publish(context.Background(), UpdateUserEvent, &usersvcv1.UserEvent{}))
+	}
+
+	sink := &flakySink{failures: 2}
+	relay := NewRelay(outbox, sink, zap.NewNop(), RelayOptions{
+		Interval:   5 * time.Millisecond,
+		BatchSize:  2,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		outbox.mu.Lock()
+		defer outbox.mu.Unlock()
+		return len(outbox.published) == 3
+	}, time.Second, 5*time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.ElementsMatch(t, []string{"0", "1", "2"}, sink.delivered)
+}
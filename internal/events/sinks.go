@@ -0,0 +1,40 @@
+package events
+
+import (
+	"context"
+
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// LogSink writes events to the log, it's meant for local development.
+type LogSink struct {
+	logger *zap.Logger
+}
+
+func NewLogSink(l *zap.Logger) *LogSink {
+	return &LogSink{l}
+}
+
+func (s *LogSink) Send(_ context.Context, e *cloudevents.Event) error {
+	data := zap.Binary("data", e.Data)
+	if e.DataContentType == ContentTypeJSON {
+		data = zap.ByteString("data", e.Data)
+	}
+	s.logger.Info("event published",
+		zap.String("id", e.ID), zap.String("type", e.Type), zap.String("subject", e.Subject), data)
+	return nil
+}
+
+// MultiSink sends events to all of its sinks, an event is delivered
+// only when all of them accepted it, otherwise it's sent again to every sink.
+type MultiSink []Sink
+
+func (m MultiSink) Send(ctx context.Context, e *cloudevents.Event) error {
+	var err error
+	for _, s := range m {
+		err = multierr.Append(err, s.Send(ctx, e))
+	}
+	return err
+}
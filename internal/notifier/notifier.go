@@ -0,0 +1,75 @@
+// Package notifier delivers notifications with secret tokens, e.g. of password resets, to users.
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of notifications.
+const (
+	KindPasswordReset     = "passwordReset"
+	KindEmailVerification = "emailVerification"
+)
+
+// Notification asks a user to confirm an action with a token.
+type Notification struct {
+	Kind      string    `json:"kind"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Notifier delivers notifications, e.g. by email.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Log writes notifications with their tokens to the log, it's meant for local development.
+type Log struct {
+	logger *zap.Logger
+}
+
+func NewLog(l *zap.Logger) *Log {
+	return &Log{l}
+}
+
+func (l *Log) Notify(_ context.Context, n *Notification) error {
+	l.logger.Info("notification sent", zap.String("kind", n.Kind), zap.String("userId", n.UserID),
+		zap.String("email", n.Email), zap.String("token", n.Token), zap.Time("expiresAt", n.ExpiresAt))
+	return nil
+}
+
+// File appends notifications to a file as JSON lines, it's meant for local development and tests.
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Notify(_ context.Context, n *Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,17 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type Mock struct {
+	mock.Mock
+}
+
+var _ Notifier = (*Mock)(nil)
+
+func (m *Mock) Notify(_ context.Context, n *Notification) error {
+	return m.Called(n).Error(0)
+}
@@ -0,0 +1,41 @@
+// +build unit
+
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	f := NewFile(path)
+	sent := []*Notification{
+		{Kind: KindPasswordReset, UserID: "1", Email: "john@doe.com", Token: "a", ExpiresAt: time.Now().UTC().Round(0)},
+		{Kind: KindPasswordReset, UserID: "2", Email: "jane@doe.com", Token: "b", ExpiresAt: time.Now().UTC().Round(0)},
+	}
+	for _, n := range sent {
+		require.NoError(t, f.Notify(context.Background(), n))
+	}
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var received []*Notification
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var n Notification
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &n))
+		received = append(received, &n)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, sent, received)
+}
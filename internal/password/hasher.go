@@ -0,0 +1,171 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher hashes passwords into encoded hashes in the PHC string format, e.g. $argon2id$v=19$m=65536,t=1,p=4$salt$hash,
+// which identify their algorithm and parameters, so hashes of different algorithms can be stored together.
+type Hasher interface {
+	// Hash returns an encoded hash of a password with a random salt.
+	Hash(password string) ([]byte, error)
+	// NeedsRehash reports whether an encoded hash wasn't created by the hasher with its current parameters,
+	// so it should be replaced with a new hash once the password is known.
+	NeedsRehash(hash []byte) bool
+}
+
+// DefaultHasher is used when no hasher is configured.
+var DefaultHasher Hasher = Argon2id{}
+
+// ErrMalformedHash is returned when an encoded hash can't be parsed.
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Verify reports whether a password matches an encoded hash of any supported algorithm, argon2id or bcrypt.
+func Verify(password string, hash []byte) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+		}
+		return true, nil
+	}
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.Time, h.Memory, h.Threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+// Argon2id hashes passwords with argon2id, zero parameters take default values recommended
+// by golang.org/x/crypto/argon2: a single pass over 64 MiB of memory with 4 threads, 32 bytes keys and 16 bytes salts.
+type Argon2id struct {
+	// Time is a number of passes over the memory.
+	Time uint32
+	// Memory is a size of the memory in KiB.
+	Memory    uint32
+	Threads   uint8
+	KeyLength uint32
+	// SaltLength is in bytes.
+	SaltLength uint32
+}
+
+// withDefaults returns the parameters with zero ones set to default values.
+func (a Argon2id) withDefaults() Argon2id {
+	if a.Time == 0 {
+		a.Time = 1
+	}
+	if a.Memory == 0 {
+		a.Memory = 64 * 1024
+	}
+	if a.Threads == 0 {
+		a.Threads = 4
+	}
+	if a.KeyLength == 0 {
+		a.KeyLength = 32
+	}
+	if a.SaltLength == 0 {
+		a.SaltLength = 16
+	}
+	return a
+}
+
+func (a Argon2id) Hash(password string) ([]byte, error) {
+	a = a.withDefaults()
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Time, a.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+}
+
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	a = a.withDefaults()
+	return h.Time != a.Time || h.Memory != a.Memory || h.Threads != a.Threads ||
+		uint32(len(h.key)) != a.KeyLength || uint32(len(h.salt)) != a.SaltLength
+}
+
+// b64 encodes salts and keys of PHC strings, they're base64 encoded without padding.
+var b64 = base64.RawStdEncoding
+
+// argon2idHash is a parsed argon2id hash.
+type argon2idHash struct {
+	Argon2id
+	salt, key []byte
+}
+
+func parseArgon2id(hash []byte) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=65536,t=1,p=4", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrMalformedHash
+	}
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.Memory, &h.Time, &h.Threads); err != nil {
+		return nil, ErrMalformedHash
+	}
+	var err error
+	if h.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if h.key, err = b64.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+	if h.Time == 0 || h.Threads == 0 {
+		return nil, ErrMalformedHash
+	}
+	return &h, nil
+}
+
+// Bcrypt hashes passwords with bcrypt, which only uses the first MaxBcryptLength bytes of passwords.
+// Hashes were created with bcrypt.DefaultCost before argon2id was introduced.
+type Bcrypt struct {
+	// Cost is bcrypt.DefaultCost when it's not set.
+	Cost int
+}
+
+func (b Bcrypt) cost() int {
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+func (b Bcrypt) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), b.cost())
+}
+
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+	if !isBcrypt(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.cost()
+}
+
+// isBcrypt reports whether an encoded hash is a bcrypt hash, they are in the modular crypt format: $2a$10$...
+func isBcrypt(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2"))
+}
@@ -0,0 +1,66 @@
+// +build unit
+
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2id(t *testing.T) {
+	a := Argon2id{Time: 1, Memory: 1024, Threads: 1}
+	hash, err := a.Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$"), string(hash))
+	other, err := a.Hash("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salts should be random")
+
+	matches, err := Verify("secret", hash)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = Verify("other", hash)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	assert.False(t, a.NeedsRehash(hash))
+	assert.True(t, Argon2id{Time: 2, Memory: 1024, Threads: 1}.NeedsRehash(hash))
+	assert.True(t, Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLength: 16}.NeedsRehash(hash))
+	assert.True(t, Bcrypt{}.NeedsRehash(hash))
+}
+
+func TestBcrypt(t *testing.T) {
+	b := Bcrypt{Cost: bcrypt.MinCost}
+	hash, err := b.Hash("secret")
+	require.NoError(t, err)
+	matches, err := Verify("secret", hash)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = Verify("other", hash)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	assert.False(t, b.NeedsRehash(hash))
+	assert.True(t, Bcrypt{}.NeedsRehash(hash))
+	assert.True(t, Argon2id{}.NeedsRehash(hash))
+}
+
+func TestVerify_Malformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"secret",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=1024,t=1,p=1$!$a2V5a2V5",
+		"$2a$10$short",
+	} {
+		_, err := Verify("secret", []byte(hash))
+		assert.ErrorIs(t, err, ErrMalformedHash, hash)
+	}
+}
@@ -0,0 +1,138 @@
+// Package password checks passwords of users against a configurable policy.
+package password
+
+import (
+	_ "embed"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBcryptLength is a number of bytes of a password bcrypt uses, following bytes are ignored.
+const MaxBcryptLength = 72
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is a set of lower case common passwords.
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+func parseCommonPasswords(file string) map[string]bool {
+	m := map[string]bool{}
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			m[strings.ToLower(line)] = true
+		}
+	}
+	return m
+}
+
+// Policy is a set of rules passwords of users should satisfy.
+type Policy struct {
+	// MinLength is a minimum number of characters of a password.
+	MinLength int
+	// MaxLength is a maximum number of bytes of a password, MaxBcryptLength when it's not set.
+	MaxLength int
+	// Require* require at least one character of a class.
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	// RequireSymbol requires a character which isn't a letter or a digit.
+	RequireSymbol bool
+	// DisallowUserInfo rejects passwords containing the email, the part of the email before @
+	// or the nickname of their user, ignoring case.
+	DisallowUserInfo bool
+	// DisallowCommon rejects passwords from the bundled list of common passwords, ignoring case.
+	DisallowCommon bool
+}
+
+// DefaultPolicy is used when no policy is configured.
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: MaxBcryptLength, DisallowUserInfo: true, DisallowCommon: true}
+
+// User is a user whose password is checked.
+type User struct {
+	Email    string
+	Nickname string
+}
+
+// Error is returned when a password doesn't satisfy a policy.
+type Error struct {
+	// Violations describe the broken rules, e.g. "should contain a digit".
+	Violations []string
+}
+
+func (e *Error) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Check returns *Error when a password of a user doesn't satisfy the policy.
+func (p *Policy) Check(password string, u User) error {
+	var violations []string
+	add := func(v string) {
+		violations = append(violations, v)
+	}
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		add("should be at least " + strconv.Itoa(p.MinLength) + " characters long")
+	}
+	maxLength := p.MaxLength
+	if maxLength <= 0 {
+		maxLength = MaxBcryptLength
+	}
+	if len(password) > maxLength {
+		add("should be at most " + strconv.Itoa(maxLength) + " bytes long")
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if p.RequireLowercase && !lower {
+		add("should contain a lowercase letter")
+	}
+	if p.RequireUppercase && !upper {
+		add("should contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add("should contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add("should contain a symbol")
+	}
+	lowered := strings.ToLower(password)
+	if p.DisallowUserInfo && containsUserInfo(lowered, u) {
+		add("should not contain the email or the nickname")
+	}
+	if p.DisallowCommon && commonPasswords[lowered] {
+		add("is too common")
+	}
+	if len(violations) > 0 {
+		return &Error{violations}
+	}
+	return nil
+}
+
+// containsUserInfo reports whether a lower case password contains the email or the nickname of a user,
+// parts shorter than 3 characters are ignored, they'd reject too many passwords.
+func containsUserInfo(password string, u User) bool {
+	email := strings.ToLower(u.Email)
+	local := email
+	if i := strings.LastIndexByte(email, '@'); i >= 0 {
+		local = email[:i]
+	}
+	for _, s := range []string{email, local, strings.ToLower(u.Nickname)} {
+		if utf8.RuneCountInString(s) >= 3 && strings.Contains(password, s) {
+			return true
+		}
+	}
+	return false
+}
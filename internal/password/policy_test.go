@@ -0,0 +1,56 @@
+// +build unit
+
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	strict := Policy{MinLength: 10, MaxLength: 20, RequireLowercase: true, RequireUppercase: true,
+		RequireDigit: true, RequireSymbol: true, DisallowUserInfo: true, DisallowCommon: true}
+	john := User{Email: "john.doe@gmail.com", Nickname: "jd"}
+	for _, tc := range []struct {
+		policy     Policy
+		password   string
+		violations []string
+	}{
+		{DefaultPolicy, "correct horse battery", nil},
+		{DefaultPolicy, "short", []string{"should be at least 8 characters long"}},
+		{DefaultPolicy, "zażółćgę", nil},
+		{DefaultPolicy, strings.Repeat("ą", 37), []string{"should be at most 72 bytes long"}},
+		{DefaultPolicy, "Password1", []string{"is too common"}},
+		{DefaultPolicy, "my-John.Doe-secret", []string{"should not contain the email or the nickname"}},
+		// nicknames shorter than 3 characters are ignored.
+		{DefaultPolicy, "jd-secret-word", nil},
+		{Policy{}, "", nil},
+		{strict, "Tr0ub4dor&3x", nil},
+		{strict, "troubadour", []string{
+			"should contain an uppercase letter", "should contain a digit", "should contain a symbol",
+		}},
+		{strict, "TROUBADOUR-0123456789", []string{"should be at most 20 bytes long", "should contain a lowercase letter"}},
+	} {
+		err := tc.policy.Check(tc.password, john)
+		if tc.violations == nil {
+			assert.NoError(t, err, tc.password)
+			continue
+		}
+		var perr *Error
+		require.ErrorAs(t, err, &perr, tc.password)
+		assert.Equal(t, tc.violations, perr.Violations, tc.password)
+	}
+}
+
+func TestCommonPasswords(t *testing.T) {
+	assert.True(t, commonPasswords["123456"])
+	assert.True(t, commonPasswords["p@ssw0rd"])
+	assert.False(t, commonPasswords[""])
+	for p := range commonPasswords {
+		assert.Equal(t, strings.ToLower(p), p)
+		assert.False(t, strings.HasPrefix(p, "#"))
+	}
+}
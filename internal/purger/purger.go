@@ -0,0 +1,80 @@
+// Package purger permanently removes deleted users after the retention period.
+package purger
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Store removes deleted users, it's implemented by store.Repository.
+type Store interface {
+	PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+}
+
+type Options struct {
+	// Interval between purges.
+	Interval time.Duration
+	// Retention is for how long deleted users can be undeleted before they are purged.
+	Retention time.Duration
+	// BatchSize is a maximum number of users removed at once.
+	BatchSize int
+}
+
+func (o *Options) setDefaults() {
+	if o.Interval <= 0 {
+		o.Interval = time.Hour
+	}
+	if o.Retention <= 0 {
+		o.Retention = 30 * 24 * time.Hour
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+}
+
+// Purger removes users deleted earlier than the retention period ago.
+// Purged users are not reported with events, their deletion already was.
+type Purger struct {
+	store  Store
+	logger *zap.Logger
+	opts   Options
+}
+
+func New(s Store, l *zap.Logger, opts Options) *Purger {
+	opts.setDefaults()
+	return &Purger{s, l, opts}
+}
+
+// Run purges users until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge removes users in batches until there are no more of them to remove.
+func (p *Purger) purge(ctx context.Context) {
+	deletedBefore := time.Now().Add(-p.opts.Retention)
+	for ctx.Err() == nil {
+		n, err := p.store.PurgeUsers(ctx, deletedBefore, p.opts.BatchSize)
+		if err != nil {
+			p.logger.Error("failed to purge deleted users", zap.Error(err))
+			return
+		}
+		if n > 0 {
+			p.logger.Info("purged deleted users", zap.Int("count", n))
+		}
+		if n < p.opts.BatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// +build unit
+
+package purger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/store/memstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPurger(t *testing.T) {
+	ctx := context.Background()
+	s := memstore.New(nil)
+	var deleted []*store.User
+	for _, email := range []string{"john@doe.com", "jane@doe.com", "jan@kowalski.com"} {
+		u, err := s.CreateUser(ctx, &store.User{Email: email}, "123456")
+		require.NoError(t, err)
+		if email != "jan@kowalski.com" {
+			u, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+			require.NoError(t, err)
+			deleted = append(deleted, u)
+		}
+	}
+
+	// users deleted within the retention period are kept.
+	New(s, zap.NewNop(), Options{Retention: time.Hour, BatchSize: 1}).purge(ctx)
+	_, err := s.GetUserByID(ctx, deleted[0].ID)
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+	New(s, zap.NewNop(), Options{Retention: time.Millisecond, BatchSize: 1}).purge(ctx)
+	for _, u := range deleted {
+		_, err := s.GetUserByID(ctx, u.ID)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	}
+	count, err := s.CountUsers(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// emails of purged users can be taken again.
+	_, err = s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKey authenticates a service calling the service, only a hash of the key is stored.
+// Revoked keys are kept, so callers recorded in logs can still be looked up.
+type APIKey struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Hash string             `bson:"hash"`
+	Name string             `bson:"name"`
+	// Owner is who is responsible for the key, e.g. a team or the user who created it.
+	Owner     string    `bson:"owner"`
+	Scopes    []string  `bson:"scopes"`
+	CreatedAt time.Time `bson:"createdAt"`
+	// ExpiresAt is not set for keys which don't expire.
+	ExpiresAt *time.Time `bson:"expiresAt"`
+	RevokedAt *time.Time `bson:"revokedAt"`
+}
+
+// IsValid reports whether the key is neither revoked nor expired at the time.
+func (k *APIKey) IsValid(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+func (s *Store) createAPIKeyIndexes(ctx context.Context) error {
+	apiKeysUniqueHash := mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.apiKeys.Indexes().CreateOne(ctx, apiKeysUniqueHash)
+	return err
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, k *APIKey) (*APIKey, error) {
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	result, err := s.apiKeys.InsertOne(ctx, k)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAPIKey(ctx, result.InsertedID.(primitive.ObjectID))
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, id primitive.ObjectID) (*APIKey, error) {
+	return s.findAPIKey(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	return s.findAPIKey(ctx, bson.D{{Key: "hash", Value: hash}})
+}
+
+func (s *Store) findAPIKey(ctx context.Context, filter bson.D) (*APIKey, error) {
+	var k APIKey
+	err := s.apiKeys.FindOne(ctx, filter).Decode(&k)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *Store) CountAPIKeys(ctx context.Context) (int64, error) {
+	return s.apiKeys.CountDocuments(ctx, bson.D{})
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context, p *Pagination) ([]*APIKey, error) {
+	var keys []*APIKey
+	cur, err := s.apiKeys.Find(ctx, bson.D{}, p.findOpts().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) (*APIKey, error) {
+	_, err := s.apiKeys.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "revokedAt", Value: nil}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: at}}}})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAPIKey(ctx, id)
+}
@@ -0,0 +1,69 @@
+// Package backend opens the storage backend selected in the config.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mlukasik-dev/usersvc/internal/appconfig"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/store/memstore"
+	"github.com/mlukasik-dev/usersvc/internal/store/sqlstore"
+)
+
+// Storage drivers.
+const (
+	MongoDB  = "mongodb"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+	Memory   = "memory"
+)
+
+// Open opens a repository of the configured driver, creating its indexes or migrating its schema,
+// returned function releases its resources. Passwords are hashed with the hasher, password.DefaultHasher when it's nil.
+func Open(ctx context.Context, hasher password.Hasher) (store.Repository, func() error, error) {
+	switch driver := appconfig.AppConfig.Storage.Driver; driver {
+	case MongoDB:
+		uri := appconfig.AppConfig.Mongodb.URI
+		if uri == "" {
+			return nil, nil, errors.New("mongodb uri was not provided")
+		}
+		client, err := store.Connect(uri)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeFn := func() error { return client.Disconnect(context.Background()) }
+		s := store.New(client, hasher)
+		if err := s.Migrate(ctx); err != nil {
+			closeFn()
+			return nil, nil, err
+		}
+		if err := s.CreateIndexes(ctx); err != nil {
+			closeFn()
+			return nil, nil, err
+		}
+		return s, closeFn, nil
+	case Postgres:
+		dsn := appconfig.AppConfig.Postgres.DSN
+		if dsn == "" {
+			return nil, nil, errors.New("postgres dsn was not provided")
+		}
+		s, err := sqlstore.Open(ctx, sqlstore.Postgres, dsn, hasher)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case SQLite:
+		s, err := sqlstore.Open(ctx, sqlstore.SQLite, appconfig.AppConfig.SQLite.Path, hasher)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case Memory:
+		return memstore.New(hasher), func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage driver: %q", driver)
+	}
+}
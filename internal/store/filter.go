@@ -0,0 +1,175 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ParseUserFilter parses a filter expression of users, see filtering package for its syntax.
+// Restrictions can be applied to id, first_name, last_name, nickname, email and country fields,
+// which are compared as strings and : operator tests whether a field starts with a value,
+// and to create_time, update_time and delete_time, compared with RFC 3339 timestamps, and version, compared as a number.
+// delete_time:* matches deleted users. email_verified can be only tested for equality with true or false. Values of timestamps and versions are normalized. Returns *filtering.Error when the filter is invalid.
+func ParseUserFilter(filter string) (filtering.Expr, error) {
+	e, err := filtering.Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	return e, validateUserFilter(e)
+}
+
+func validateUserFilter(e filtering.Expr) error {
+	switch e := e.(type) {
+	case *filtering.And:
+		if err := validateUserFilter(e.Left); err != nil {
+			return err
+		}
+		return validateUserFilter(e.Right)
+	case *filtering.Or:
+		if err := validateUserFilter(e.Left); err != nil {
+			return err
+		}
+		return validateUserFilter(e.Right)
+	case *filtering.Not:
+		return validateUserFilter(e.Expr)
+	case *filtering.Restriction:
+		if _, ok := userFields[e.Field]; !ok {
+			return &filtering.Error{Pos: e.Pos, Msg: fmt.Sprintf("unknown field %q", e.Field)}
+		}
+		if e.Field == "delete_time" && e.Op == filtering.Has && e.Value == "" {
+			return nil
+		}
+		switch e.Field {
+		case "id", "create_time", "update_time", "delete_time", "version", "email_verified":
+			if e.Op == filtering.Has {
+				return &filtering.Error{Pos: e.Pos, Msg: fmt.Sprintf(`operator ":" is not supported by %s`, e.Field)}
+			}
+		}
+		switch e.Field {
+		case "id":
+			if _, err := primitive.ObjectIDFromHex(e.Value); err != nil {
+				return &filtering.Error{Pos: e.ValuePos, Msg: fmt.Sprintf("invalid id %q", e.Value)}
+			}
+		case "create_time", "update_time", "delete_time":
+			t, err := time.Parse(time.RFC3339Nano, e.Value)
+			if err != nil {
+				return &filtering.Error{Pos: e.ValuePos, Msg: fmt.Sprintf("invalid timestamp %q, expected RFC 3339 format", e.Value)}
+			}
+			e.Value = t.UTC().Format(timeFormat)
+		case "version":
+			v, err := strconv.ParseInt(e.Value, 10, 64)
+			if err != nil {
+				return &filtering.Error{Pos: e.ValuePos, Msg: fmt.Sprintf("invalid version %q", e.Value)}
+			}
+			e.Value = strconv.FormatInt(v, 10)
+		case "email_verified":
+			v, err := strconv.ParseBool(e.Value)
+			if err != nil {
+				return &filtering.Error{Pos: e.ValuePos, Msg: fmt.Sprintf("invalid boolean %q", e.Value)}
+			}
+			if e.Op != filtering.Equal && e.Op != filtering.NotEqual {
+				return &filtering.Error{Pos: e.Pos, Msg: fmt.Sprintf(`operator "%s" is not supported by %s`, e.Op, e.Field)}
+			}
+			// users stored before verification was introduced don't have the field in mongodb,
+			// so restrictions are normalized to equality, which treats them as not verified.
+			if e.Op == filtering.NotEqual {
+				v = !v
+				e.Op = filtering.Equal
+			}
+			e.Value = strconv.FormatBool(v)
+		}
+	}
+	return nil
+}
+
+// mongoFilter creates a mongodb filter document of a filter expression, nil expression matches all users.
+func mongoFilter(e filtering.Expr) bson.D {
+	switch e := e.(type) {
+	case *filtering.And:
+		return bson.D{{Key: "$and", Value: bson.A{mongoFilter(e.Left), mongoFilter(e.Right)}}}
+	case *filtering.Or:
+		return bson.D{{Key: "$or", Value: bson.A{mongoFilter(e.Left), mongoFilter(e.Right)}}}
+	case *filtering.Not:
+		return bson.D{{Key: "$nor", Value: bson.A{mongoFilter(e.Expr)}}}
+	case *filtering.Restriction:
+		key := userFields[e.Field]
+		value := mongoValue(e.Field, e.Value)
+		if e.Field == "email_verified" && value == false {
+			return bson.D{{Key: key, Value: bson.D{{Key: "$ne", Value: true}}}}
+		}
+		switch e.Op {
+		case filtering.Equal:
+			return bson.D{{Key: key, Value: value}}
+		case filtering.NotEqual:
+			return bson.D{{Key: key, Value: bson.D{{Key: "$ne", Value: value}}}}
+		case filtering.Less:
+			return bson.D{{Key: key, Value: bson.D{{Key: "$lt", Value: value}}}}
+		case filtering.LessOrEqual:
+			return bson.D{{Key: key, Value: bson.D{{Key: "$lte", Value: value}}}}
+		case filtering.Greater:
+			return bson.D{{Key: key, Value: bson.D{{Key: "$gt", Value: value}}}}
+		case filtering.GreaterOrEqual:
+			return bson.D{{Key: key, Value: bson.D{{Key: "$gte", Value: value}}}}
+		case filtering.Has:
+			if e.Value == "" && e.Field == "delete_time" {
+				return bson.D{{Key: key, Value: bson.D{{Key: "$type", Value: "date"}}}}
+			}
+			if e.Value == "" {
+				return bson.D{{Key: key, Value: bson.D{{Key: "$type", Value: "string"}}}}
+			}
+			// anchored regular expressions use indexes.
+			return bson.D{{Key: key, Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(e.Value)}}}
+		}
+	}
+	return bson.D{}
+}
+
+// MatchesFilter reports whether u matches a filter expression, nil expression matches all users.
+// It's an in-memory counterpart of mongodb filters, so users without nickname
+// match only != restrictions of nickname, the same applies to zero timestamps and versions
+// and to delete time of users which aren't deleted.
+func MatchesFilter(e filtering.Expr, u *User) bool {
+	switch e := e.(type) {
+	case *filtering.And:
+		return MatchesFilter(e.Left, u) && MatchesFilter(e.Right, u)
+	case *filtering.Or:
+		return MatchesFilter(e.Left, u) || MatchesFilter(e.Right, u)
+	case *filtering.Not:
+		return !MatchesFilter(e.Expr, u)
+	case *filtering.Restriction:
+		value := userField(u, e.Field)
+		if e.Field == "id" {
+			hex := u.ID.Hex()
+			value = &hex
+		}
+		if value == nil {
+			return e.Op == filtering.NotEqual
+		}
+		d := compareValues(e.Field, value, &e.Value)
+		switch e.Op {
+		case filtering.Equal:
+			return d == 0
+		case filtering.NotEqual:
+			return d != 0
+		case filtering.Less:
+			return d < 0
+		case filtering.LessOrEqual:
+			return d <= 0
+		case filtering.Greater:
+			return d > 0
+		case filtering.GreaterOrEqual:
+			return d >= 0
+		case filtering.Has:
+			return strings.HasPrefix(*value, e.Value)
+		}
+		return false
+	}
+	return true
+}
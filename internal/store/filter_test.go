@@ -0,0 +1,125 @@
+// +build unit
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseUserFilter(t *testing.T) {
+	invalid := map[string]*filtering.Error{
+		"country = PL AND password = x": {Pos: 18, Msg: `unknown field "password"`},
+		"id:6":                          {Pos: 1, Msg: `operator ":" is not supported by id`},
+		"NOT (id = 12)":                 {Pos: 11, Msg: `invalid id "12"`},
+		"country = (PL)":                {Pos: 11, Msg: `unexpected "("`},
+		"version:1":                     {Pos: 1, Msg: `operator ":" is not supported by version`},
+		"version > 1.5":                 {Pos: 11, Msg: `invalid version "1.5"`},
+		"create_time > 2021-01-01":      {Pos: 15, Msg: `invalid timestamp "2021-01-01", expected RFC 3339 format`},
+		"delete_time:2021":              {Pos: 1, Msg: `operator ":" is not supported by delete_time`},
+		"email_verified:true":           {Pos: 1, Msg: `operator ":" is not supported by email_verified`},
+		"email_verified = yes":          {Pos: 18, Msg: `invalid boolean "yes"`},
+		"email_verified > false":        {Pos: 1, Msg: `operator ">" is not supported by email_verified`},
+	}
+	for filter, want := range invalid {
+		_, err := ParseUserFilter(filter)
+		assert.Equal(t, want, err, filter)
+	}
+
+	e, err := ParseUserFilter("")
+	require.NoError(t, err)
+	assert.Nil(t, e)
+
+	// timestamps and versions are normalized.
+	e, err = ParseUserFilter(`create_time >= "2021-05-01T14:30:00.5+02:00" version != 007`)
+	require.NoError(t, err)
+	assert.Equal(t, `(create_time >= "2021-05-01T12:30:00.500000000Z" AND version != "7")`, e.String())
+
+	// restrictions of email_verified are normalized to equality.
+	e, err = ParseUserFilter(`email_verified != TRUE`)
+	require.NoError(t, err)
+	assert.Equal(t, `email_verified = "false"`, e.String())
+}
+
+func TestMatchesFilter(t *testing.T) {
+	john := &User{ID: primitive.NewObjectID(), FirstName: "John", LastName: "Doe", Nickname: deref.StringAddr("pro1"), Country: "UK",
+		CreatedAt: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC), Version: 10, EmailVerified: true}
+	deletedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	jane := &User{ID: primitive.NewObjectID(), FirstName: "Jane", LastName: "Doe", Country: "PL", DeletedAt: &deletedAt}
+	tests := map[string][]*User{
+		"":                                   {john, jane},
+		"country = PL OR country = DE":       {jane},
+		"last_name = Doe AND NOT country=UK": {jane},
+		"nickname:pro":                       {john},
+		"nickname:*":                         {john},
+		"-nickname:*":                        {jane},
+		"nickname != pro1":                   {jane},
+		"NOT nickname < z":                   {jane},
+		"first_name >= Jo":                   {john},
+		"id > " + john.ID.Hex():              {jane},
+		"version > 9":                        {john},
+		`create_time < "2021-05-01T14:00:00.1+02:00"`: {john},
+		// users without timestamps and versions, created before they were introduced, are like ones without nickname.
+		"version != 10":                        {jane},
+		"delete_time:*":                        {jane},
+		"-delete_time:*":                       {john},
+		`delete_time > "2021-05-01T00:00:00Z"`: {jane},
+		"email_verified = true":                {john},
+		"email_verified != true":               {jane},
+	}
+	for filter, want := range tests {
+		e, err := ParseUserFilter(filter)
+		require.NoError(t, err, filter)
+		var got []*User
+		for _, u := range []*User{john, jane} {
+			if MatchesFilter(e, u) {
+				got = append(got, u)
+			}
+		}
+		assert.Equal(t, want, got, filter)
+	}
+}
+
+func TestMongoFilter(t *testing.T) {
+	assert.Equal(t, bson.D{}, mongoFilter(nil))
+
+	e, err := ParseUserFilter(`NOT email:"a.b" OR nickname:*`)
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "email", Value: primitive.Regex{Pattern: `^a\.b`}}}}}},
+		bson.D{{Key: "nickname", Value: bson.D{{Key: "$type", Value: "string"}}}},
+	}}}, mongoFilter(e))
+
+	id := primitive.NewObjectID()
+	e, err = ParseUserFilter("id != " + id.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: id}}}}, mongoFilter(e))
+
+	e, err = ParseUserFilter(`update_time > "2021-05-01T12:00:00Z" AND version <= 2`)
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "updatedAt", Value: bson.D{{Key: "$gt", Value: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)}}}},
+		bson.D{{Key: "version", Value: bson.D{{Key: "$lte", Value: int64(2)}}}},
+	}}}, mongoFilter(e))
+
+	e, err = ParseUserFilter("NOT delete_time:*")
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "$nor", Value: bson.A{
+		bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$type", Value: "date"}}}},
+	}}}, mongoFilter(e))
+
+	// users without emailVerified, stored before it was introduced, aren't verified.
+	e, err = ParseUserFilter("email_verified = false")
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "emailVerified", Value: bson.D{{Key: "$ne", Value: true}}}}, mongoFilter(e))
+	e, err = ParseUserFilter("email_verified != false")
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "emailVerified", Value: true}}, mongoFilter(e))
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lockout counts recent failed password checks of an account or of a client,
+// which is locked out for a while when there are too many of them.
+type Lockout struct {
+	// Key is UserLockoutKey of an account or ClientLockoutKey of a client.
+	Key      string `bson:"_id"`
+	Failures int    `bson:"failures"`
+	// LastFailureAt is not set when there were no failures since the lockout was locked.
+	LastFailureAt *time.Time `bson:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil"`
+	// ExpiresAt is when failures are forgotten and the lockout is removed, it's never before LockedUntil.
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// IsLocked reports whether the lockout is locked at the time.
+func (l *Lockout) IsLocked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+// UserLockoutKey returns a key of a lockout of a user.
+func UserLockoutKey(id primitive.ObjectID) string {
+	return "users/" + id.Hex()
+}
+
+// ClientLockoutKey returns a key of a lockout of a client IP address.
+func ClientLockoutKey(ip string) string {
+	return "clients/" + ip
+}
+
+func (s *Store) createLockoutIndexes(ctx context.Context) error {
+	// Lockouts are removed once they expire.
+	lockoutsTTL := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := s.lockouts.Indexes().CreateOne(ctx, lockoutsTTL)
+	return err
+}
+
+func (s *Store) GetLockout(ctx context.Context, key string) (*Lockout, error) {
+	var l Lockout
+	// the TTL monitor removes expired lockouts only once a minute.
+	err := s.lockouts.FindOne(ctx, bson.D{{Key: "_id", Value: key}, {Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}}}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// AddLockoutFailure increments failures of a lockout atomically, so concurrent failures are all counted.
+func (s *Store) AddLockoutFailure(ctx context.Context, key string, expiresAt time.Time) (*Lockout, error) {
+	now := time.Now()
+	result, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		_, err := s.lockouts.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: key}, {Key: "expiresAt", Value: bson.D{{Key: "$lte", Value: now}}}})
+		if err != nil {
+			return nil, err
+		}
+		update := bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+			{Key: "$set", Value: bson.D{{Key: "lastFailureAt", Value: now}}},
+			{Key: "$max", Value: bson.D{{Key: "expiresAt", Value: expiresAt}}},
+		}
+		var l Lockout
+		err = s.lockouts.FindOneAndUpdate(sessCtx, bson.D{{Key: "_id", Value: key}}, update,
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&l)
+		return &l, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Lockout), nil
+}
+
+func (s *Store) LockOut(ctx context.Context, key string, until time.Time) (*Lockout, error) {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "failures", Value: 0},
+		{Key: "lockedUntil", Value: until},
+		{Key: "expiresAt", Value: until},
+	}}}
+	var l Lockout
+	err := s.lockouts.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: key}}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&l)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *Store) DeleteLockout(ctx context.Context, key string) (*Lockout, error) {
+	var l Lockout
+	err := s.lockouts.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) || err == nil && !l.ExpiresAt.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
@@ -0,0 +1,93 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneAPIKey(k *store.APIKey) *store.APIKey {
+	c := *k
+	c.Scopes = append([]string{}, k.Scopes...)
+	if k.ExpiresAt != nil {
+		expiresAt := *k.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if k.RevokedAt != nil {
+		revokedAt := *k.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
+
+// apiKeyIndex returns an index of the first key matching fn, or -1.
+func (s *Store) apiKeyIndex(fn func(k *store.APIKey) bool) int {
+	for i, k := range s.data.apiKeys {
+		if fn(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, k *store.APIKey) (*store.APIKey, error) {
+	defer s.lock(ctx)()
+	c := cloneAPIKey(k)
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if s.apiKeyIndex(func(k *store.APIKey) bool { return k.ID == c.ID || k.Hash == c.Hash }) >= 0 {
+		return nil, store.ErrAlreadyExists
+	}
+	s.data.apiKeys = append(s.data.apiKeys, c)
+	return cloneAPIKey(c), nil
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, id primitive.ObjectID) (*store.APIKey, error) {
+	defer s.lock(ctx)()
+	i := s.apiKeyIndex(func(k *store.APIKey) bool { return k.ID == id })
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	return cloneAPIKey(s.data.apiKeys[i]), nil
+}
+
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (*store.APIKey, error) {
+	defer s.lock(ctx)()
+	i := s.apiKeyIndex(func(k *store.APIKey) bool { return k.Hash == hash })
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	return cloneAPIKey(s.data.apiKeys[i]), nil
+}
+
+func (s *Store) CountAPIKeys(ctx context.Context) (int64, error) {
+	defer s.lock(ctx)()
+	return int64(len(s.data.apiKeys)), nil
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context, p *store.Pagination) ([]*store.APIKey, error) {
+	defer s.lock(ctx)()
+	lo, hi := page(len(s.data.apiKeys), p)
+	var keys []*store.APIKey
+	for _, k := range s.data.apiKeys[lo:hi] {
+		keys = append(keys, cloneAPIKey(k))
+	}
+	return keys, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) (*store.APIKey, error) {
+	defer s.lock(ctx)()
+	i := s.apiKeyIndex(func(k *store.APIKey) bool { return k.ID == id })
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	k := cloneAPIKey(s.data.apiKeys[i])
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		s.data.apiKeys[i] = k
+	}
+	return cloneAPIKey(k), nil
+}
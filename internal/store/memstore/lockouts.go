@@ -0,0 +1,83 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+)
+
+func cloneLockout(l *store.Lockout) *store.Lockout {
+	c := *l
+	if l.LastFailureAt != nil {
+		lastFailureAt := *l.LastFailureAt
+		c.LastFailureAt = &lastFailureAt
+	}
+	if l.LockedUntil != nil {
+		lockedUntil := *l.LockedUntil
+		c.LockedUntil = &lockedUntil
+	}
+	return &c
+}
+
+// lockout returns a lockout with the key which isn't expired, or nil.
+func (s *Store) lockout(key string, now time.Time) *store.Lockout {
+	l, ok := s.data.lockouts[key]
+	if !ok || !l.ExpiresAt.After(now) {
+		return nil
+	}
+	return l
+}
+
+func (s *Store) GetLockout(ctx context.Context, key string) (*store.Lockout, error) {
+	defer s.lock(ctx)()
+	l := s.lockout(key, time.Now())
+	if l == nil {
+		return nil, store.ErrNotFound
+	}
+	return cloneLockout(l), nil
+}
+
+func (s *Store) AddLockoutFailure(ctx context.Context, key string, expiresAt time.Time) (*store.Lockout, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	l := &store.Lockout{Key: key}
+	if old := s.lockout(key, now); old != nil {
+		l = cloneLockout(old)
+	}
+	for k, old := range s.data.lockouts {
+		if !old.ExpiresAt.After(now) {
+			delete(s.data.lockouts, k)
+		}
+	}
+	l.Failures++
+	l.LastFailureAt = &now
+	if expiresAt.After(l.ExpiresAt) {
+		l.ExpiresAt = expiresAt
+	}
+	s.data.lockouts[key] = l
+	return cloneLockout(l), nil
+}
+
+func (s *Store) LockOut(ctx context.Context, key string, until time.Time) (*store.Lockout, error) {
+	defer s.lock(ctx)()
+	l := &store.Lockout{Key: key}
+	if old := s.lockout(key, time.Now()); old != nil {
+		l = cloneLockout(old)
+	}
+	l.Failures = 0
+	l.LockedUntil = &until
+	l.ExpiresAt = until
+	s.data.lockouts[key] = l
+	return cloneLockout(l), nil
+}
+
+func (s *Store) DeleteLockout(ctx context.Context, key string) (*store.Lockout, error) {
+	defer s.lock(ctx)()
+	l := s.lockout(key, time.Now())
+	delete(s.data.lockouts, key)
+	if l == nil {
+		return nil, store.ErrNotFound
+	}
+	return cloneLockout(l), nil
+}
@@ -0,0 +1,143 @@
+// Package memstore implements store.Repository in memory,
+// it's used for tests and to run the service without a database.
+package memstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ store.Repository = (*Store)(nil)
+
+// Store keeps all data in memory, it's safe for concurrent use.
+// Transactions are serialized, they hold the store lock until they finish.
+type Store struct {
+	mu     sync.Mutex
+	data   data
+	hasher password.Hasher
+}
+
+// data is copied on every transaction to roll it back,
+// so stored objects are never modified, they're replaced instead.
+type data struct {
+	users      []*store.User
+	creds      map[primitive.ObjectID][]byte // password hashes by user ids
+	twoFactors map[primitive.ObjectID]*store.TwoFactor
+	outbox     []*outboxEvent
+	eventSeq   int64 // number of the last event
+	tokens     []*store.RefreshToken
+	oneTime    []*store.OneTimeToken
+	lockouts   map[string]*store.Lockout
+	apiKeys    []*store.APIKey
+	webhooks   []*store.Webhook
+	deliveries []*store.WebhookDelivery
+}
+
+func (d *data) clone() data {
+	c := data{
+		users:      append([]*store.User(nil), d.users...),
+		creds:      make(map[primitive.ObjectID][]byte, len(d.creds)),
+		twoFactors: make(map[primitive.ObjectID]*store.TwoFactor, len(d.twoFactors)),
+		outbox:     append([]*outboxEvent(nil), d.outbox...),
+		eventSeq:   d.eventSeq,
+		tokens:     append([]*store.RefreshToken(nil), d.tokens...),
+		oneTime:    append([]*store.OneTimeToken(nil), d.oneTime...),
+		lockouts:   make(map[string]*store.Lockout, len(d.lockouts)),
+		apiKeys:    append([]*store.APIKey(nil), d.apiKeys...),
+		webhooks:   append([]*store.Webhook(nil), d.webhooks...),
+		deliveries: append([]*store.WebhookDelivery(nil), d.deliveries...),
+	}
+	for k, v := range d.creds {
+		c.creds[k] = v
+	}
+	for k, v := range d.twoFactors {
+		c.twoFactors[k] = v
+	}
+	for k, v := range d.lockouts {
+		c.lockouts[k] = v
+	}
+	return c
+}
+
+// New creates an empty store, passwords are hashed with the hasher, password.DefaultHasher when it's nil.
+func New(hasher password.Hasher) *Store {
+	if hasher == nil {
+		hasher = password.DefaultHasher
+	}
+	return &Store{data: data{
+		creds:      map[primitive.ObjectID][]byte{},
+		twoFactors: map[primitive.ObjectID]*store.TwoFactor{},
+		lockouts:   map[string]*store.Lockout{},
+	}, hasher: hasher}
+}
+
+type txKey struct{}
+
+type tx struct {
+	s    *Store
+	done bool
+}
+
+// inTransaction reports whether ctx belongs to a running transaction of the store.
+func (s *Store) inTransaction(ctx context.Context) bool {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	return ok && t.s == s && !t.done
+}
+
+// lock locks the store, unless ctx belongs to a transaction which already holds the lock.
+func (s *Store) lock(ctx context.Context) func() {
+	if s.inTransaction(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// RunInTransaction runs fn holding the store lock, changes made by fn are rolled back when it returns an error.
+// Nested transactions are rolled back on their own, like savepoints.
+func (s *Store) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !s.inTransaction(ctx) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		t := &tx{s: s}
+		defer func() { t.done = true }()
+		ctx = context.WithValue(ctx, txKey{}, t)
+	}
+	snapshot := s.data.clone()
+	if err := fn(ctx); err != nil {
+		s.data = snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// page returns bounds of a page of n items, all of them when p is nil.
+// Items should be already narrowed to those after the cursor when p.After is set.
+func page(n int, p *store.Pagination) (int, int) {
+	if p == nil || p.Size == 0 {
+		return 0, n
+	}
+	if p.After != nil {
+		return 0, min(int(p.Size), n)
+	}
+	if p.Page == 0 {
+		return n, n
+	}
+	lo := min(int((p.Page-1)*p.Size), n)
+	return lo, min(lo+int(p.Size), n)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,515 @@
+// +build unit
+
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	john, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK", Nickname: strPtr("jd")}, "123456")
+	require.NoError(t, err)
+	assert.False(t, john.ID.IsZero())
+	assert.Equal(t, int64(1), john.Version)
+	assert.False(t, john.CreatedAt.IsZero())
+	jane, err := s.CreateUser(ctx, &store.User{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Country: "PL"}, "123456")
+	require.NoError(t, err)
+	_, err = s.CreateUser(ctx, &store.User{FirstName: "Jan", LastName: "Kowalski", Email: "jan@kowalski.com", Country: "PL"}, "123456")
+	require.NoError(t, err)
+
+	t.Run("unique", func(t *testing.T) {
+		_, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+		assert.ErrorIs(t, err, store.ErrAlreadyExists)
+		_, err = s.CreateUser(ctx, &store.User{Email: "other@doe.com", Nickname: strPtr("jd")}, "")
+		assert.ErrorIs(t, err, store.ErrAlreadyExists)
+		_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Nickname: strPtr("jd")}, []string{"nickname"}, store.AnyVersion)
+		assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		count, err := s.CountUsers(ctx, (&store.User{LastName: "Doe"}).Expr())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		users, err := s.ListUsers(ctx, (&store.User{Country: "PL"}).Expr(), nil, &store.Pagination{Page: 2, Size: 1})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "Kowalski", users[0].LastName)
+		users, err = s.ListUsers(ctx, nil, nil, &store.Pagination{Page: 5, Size: 5, After: &store.Cursor{ID: john.ID}})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, jane.ID, users[0].ID)
+		order, err := store.ParseOrder("nickname desc, last_name")
+		require.NoError(t, err)
+		users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Page: 1, Size: 5})
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(users), 2)
+		assert.Equal(t, john.ID, users[0].ID)
+		// users without nickname come last in descending order.
+		users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Size: 5, After: order.Cursor(users[0])})
+		require.NoError(t, err)
+		require.NotEmpty(t, users)
+		assert.Nil(t, users[len(users)-1].Nickname)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		users, total, err := s.SearchUsers(ctx, "JD", &store.Pagination{Page: 1, Size: 5})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		require.Len(t, users, 1)
+		assert.Equal(t, john.ID, users[0].ID)
+		// Returned users are copies.
+		users[0].Nickname = nil
+		users, _, err = s.SearchUsers(ctx, "jd", nil)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, strPtr("jd"), users[0].Nickname)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		u, err := s.UpdateUser(ctx, &store.User{ID: john.ID, Country: "DE", Email: "ignored@doe.com"}, []string{"country"}, store.AnyVersion)
+		require.NoError(t, err)
+		assert.Equal(t, "DE", u.Country)
+		assert.Equal(t, "john@doe.com", u.Email)
+		assert.Equal(t, int64(2), u.Version)
+		assert.Equal(t, john.CreatedAt, u.CreatedAt)
+		assert.True(t, u.UpdatedAt.After(john.UpdatedAt))
+		_, err = s.UpdateUser(ctx, &store.User{ID: john.ID, Country: "FR"}, []string{"country"}, 1)
+		assert.ErrorIs(t, err, store.ErrVersionMismatch)
+		_, err = s.DeleteUser(ctx, john.ID, 1)
+		assert.ErrorIs(t, err, store.ErrVersionMismatch)
+		assert.Equal(t, store.RoleUser, u.Role)
+		u, err = s.UpdateUser(ctx, &store.User{ID: john.ID, Role: store.RoleAdmin, EmailVerified: true},
+			[]string{"role", "email_verified"}, store.AnyVersion)
+		require.NoError(t, err)
+		assert.Equal(t, store.RoleAdmin, u.Role)
+		assert.True(t, u.EmailVerified)
+		// Returned users are copies.
+		u.Country = "FR"
+		u, err = s.GetUserByID(ctx, john.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "DE", u.Country)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "", "654321"), store.ErrInvalidCreds)
+		assert.ErrorIs(t, s.UpdatePassword(ctx, "nobody@doe.com", "", "654321"), store.ErrNotFound)
+		assert.NoError(t, s.UpdatePassword(ctx, "jane@doe.com", "123456", "654321"))
+		// credentials follow email changes.
+		_, err := s.UpdateUser(ctx, &store.User{ID: jane.ID, Email: "jane@kowalski.com"}, []string{"email"}, store.AnyVersion)
+		require.NoError(t, err)
+		assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "654321", "123456"), store.ErrNotFound)
+		assert.NoError(t, s.UpdatePassword(ctx, "jane@kowalski.com", "654321", "123456"))
+		_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Email: "jane@doe.com"}, []string{"email"}, store.AnyVersion)
+		require.NoError(t, err)
+		assert.NoError(t, s.UpdatePassword(ctx, "jane@doe.com", "123456", "654321"))
+	})
+
+	t.Run("authenticate", func(t *testing.T) {
+		u, err := s.Authenticate(ctx, "john@doe.com", "123456")
+		require.NoError(t, err)
+		assert.Equal(t, john.ID, u.ID)
+		u, err = s.Authenticate(ctx, "jd", "123456")
+		require.NoError(t, err)
+		assert.Equal(t, john.ID, u.ID)
+		_, err = s.Authenticate(ctx, "jd", "654321")
+		assert.ErrorIs(t, err, store.ErrInvalidCreds)
+		_, err = s.Authenticate(ctx, "nobody", "123456")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		u, err := s.DeleteUser(ctx, jane.ID, store.AnyVersion)
+		require.NoError(t, err)
+		assert.Equal(t, jane.ID, u.ID)
+		require.NotNil(t, u.DeletedAt)
+		got, err := s.GetUserByID(ctx, jane.ID)
+		require.NoError(t, err)
+		assert.Equal(t, u, got)
+		_, err = s.DeleteUser(ctx, jane.ID, store.AnyVersion)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Country: "DE"}, []string{"country"}, store.AnyVersion)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "654321", "123456"), store.ErrNotFound)
+		_, err = s.Authenticate(ctx, "jane@doe.com", "654321")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		users, _, err := s.SearchUsers(ctx, "doe", nil)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, john.ID, users[0].ID)
+		// deleted users keep their email.
+		_, err = s.CreateUser(ctx, &store.User{Email: "jane@doe.com"}, "")
+		assert.ErrorIs(t, err, store.ErrAlreadyExists)
+
+		_, err = s.UndeleteUser(ctx, john.ID, store.AnyVersion)
+		assert.ErrorIs(t, err, store.ErrNotDeleted)
+		_, err = s.UndeleteUser(ctx, jane.ID, jane.Version)
+		assert.ErrorIs(t, err, store.ErrVersionMismatch)
+		u, err = s.UndeleteUser(ctx, jane.ID, u.Version)
+		require.NoError(t, err)
+		assert.Nil(t, u.DeletedAt)
+		assert.NoError(t, s.UpdatePassword(ctx, "jane@doe.com", "654321", "123456"))
+	})
+}
+
+func TestRehash(t *testing.T) {
+	ctx := context.Background()
+	s := New(password.Bcrypt{Cost: bcrypt.MinCost})
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	bcryptHash := s.data.creds[u.ID]
+
+	s.hasher = password.Argon2id{Memory: 1024, Threads: 1}
+	_, err = s.Authenticate(ctx, "john@doe.com", "654321")
+	assert.ErrorIs(t, err, store.ErrInvalidCreds)
+	assert.Equal(t, bcryptHash, s.data.creds[u.ID], "hash shouldn't be replaced without the password")
+	_, err = s.Authenticate(ctx, "john@doe.com", "123456")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(s.data.creds[u.ID], []byte("$argon2id$")))
+	argon2idHash := s.data.creds[u.ID]
+	_, err = s.Authenticate(ctx, "john@doe.com", "123456")
+	require.NoError(t, err)
+	assert.Equal(t, argon2idHash, s.data.creds[u.ID])
+}
+
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	family := primitive.NewObjectID()
+	first, err := s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "1", UserID: u.ID, Family: family, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.False(t, first.ID.IsZero())
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "2", UserID: u.ID, Family: family, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "expired", UserID: u.ID, Family: family, ExpiresAt: time.Now()})
+	require.NoError(t, err)
+
+	used, err := s.UseRefreshToken(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, first, used)
+	used, err = s.UseRefreshToken(ctx, "1")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+	_, err = s.UseRefreshToken(ctx, "expired")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.RevokeRefreshTokens(ctx, family))
+	used, err = s.UseRefreshToken(ctx, "2")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+
+	// tokens of other families of the user are revoked with all of its tokens.
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "4", UserID: u.ID, Family: primitive.NewObjectID(), ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeUserRefreshTokens(ctx, u.ID))
+	used, err = s.UseRefreshToken(ctx, "4")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+
+	// tokens are purged with their users.
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.UseRefreshToken(ctx, "2")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestOneTimeTokens(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	found, err := s.GetUserByEmail(ctx, "john@doe.com")
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, found.ID)
+	_, err = s.GetUserByEmail(ctx, "jane@doe.com")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	token := func(hash string, expiresAt time.Time) *store.OneTimeToken {
+		return &store.OneTimeToken{Hash: hash, UserID: u.ID, Purpose: store.PurposePasswordReset, ExpiresAt: expiresAt}
+	}
+	_, err = s.CreateOneTimeToken(ctx, token("replaced", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	first, err := s.CreateOneTimeToken(ctx, token("1", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.False(t, first.ID.IsZero())
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "replaced")
+	assert.ErrorIs(t, err, store.ErrNotFound, "only the latest token can be used")
+	_, err = s.UseOneTimeToken(ctx, "other", "1")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	used, err := s.UseOneTimeToken(ctx, store.PurposePasswordReset, "1")
+	require.NoError(t, err)
+	assert.Equal(t, first, used)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "1")
+	assert.ErrorIs(t, err, store.ErrNotFound, "tokens can be used once")
+	_, err = s.CreateOneTimeToken(ctx, token("expired", time.Now()))
+	require.NoError(t, err)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "expired")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.SetPassword(ctx, u.ID, "654321"))
+	_, err = s.Authenticate(ctx, "john@doe.com", "654321")
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetPassword(ctx, primitive.NewObjectID(), "654321"), store.ErrNotFound)
+
+	// tokens are purged with their users, deleted users can't set passwords.
+	_, err = s.CreateOneTimeToken(ctx, token("2", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetPassword(ctx, u.ID, "123456"), store.ErrNotFound)
+	_, err = s.GetUserByEmail(ctx, "john@doe.com")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "2")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestLockouts(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	key := store.UserLockoutKey(u.ID)
+	_, err = s.GetLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	l, err := s.AddLockoutFailure(ctx, key, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Failures)
+	assert.NotNil(t, l.LastFailureAt)
+	l, err = s.AddLockoutFailure(ctx, key, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Failures)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), l.ExpiresAt, time.Minute, "expiration shouldn't be shortened")
+	found, err := s.GetLockout(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, l, found)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond).UTC()
+	l, err = s.LockOut(ctx, key, until)
+	require.NoError(t, err)
+	assert.Equal(t, 0, l.Failures)
+	assert.True(t, l.IsLocked(time.Now()))
+	assert.True(t, until.Equal(*l.LockedUntil))
+	l, err = s.DeleteLockout(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, l.IsLocked(time.Now()))
+	_, err = s.DeleteLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// failures of expired lockouts are forgotten.
+	client := store.ClientLockoutKey("127.0.0.1")
+	_, err = s.AddLockoutFailure(ctx, client, time.Now())
+	require.NoError(t, err)
+	_, err = s.GetLockout(ctx, client)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	l, err = s.AddLockoutFailure(ctx, client, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Failures)
+
+	// lockouts are purged with their users.
+	_, err = s.AddLockoutFailure(ctx, key, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.GetLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.GetLockout(ctx, client)
+	assert.NoError(t, err)
+}
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.DeleteTwoFactor(ctx, u.ID), store.ErrNotFound)
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 1), store.ErrInvalidCreds)
+	assert.ErrorIs(t, s.SetTwoFactor(ctx, primitive.NewObjectID(), &store.TwoFactor{Secret: []byte("secret")}), store.ErrNotFound)
+
+	enabledAt := time.Now().Truncate(time.Millisecond).UTC()
+	tf := &store.TwoFactor{Secret: []byte("secret"), EnabledAt: &enabledAt, LastStep: 10, RecoveryCodes: []string{"a", "b"}}
+	require.NoError(t, s.SetTwoFactor(ctx, u.ID, tf))
+	found, err := s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, tf.Secret, found.Secret)
+	assert.True(t, enabledAt.Equal(*found.EnabledAt))
+	assert.Equal(t, tf.LastStep, found.LastStep)
+	assert.ElementsMatch(t, tf.RecoveryCodes, found.RecoveryCodes)
+
+	// steps and recovery codes can be used once.
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 10), store.ErrInvalidCreds)
+	require.NoError(t, s.UseTwoFactorStep(ctx, u.ID, 11))
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 11), store.ErrInvalidCreds)
+	require.NoError(t, s.UseRecoveryCode(ctx, u.ID, "a"))
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, u.ID, "a"), store.ErrInvalidCreds)
+	found, err = s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), found.LastStep)
+	assert.Equal(t, []string{"b"}, found.RecoveryCodes)
+
+	// changing the password keeps two-factor authentication.
+	require.NoError(t, s.SetPassword(ctx, u.ID, "654321"))
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteTwoFactor(ctx, u.ID))
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, u.ID, "b"), store.ErrInvalidCreds)
+
+	// two-factor authentication is purged with its user.
+	require.NoError(t, s.SetTwoFactor(ctx, u.ID, tf))
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond).UTC()
+	k, err := s.CreateAPIKey(ctx, &store.APIKey{Hash: "hash", Name: "mailer", Owner: "team-mail",
+		Scopes: []string{"users.read"}, CreatedAt: time.Now(), ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	assert.Equal(t, "mailer", k.Name)
+	assert.Equal(t, []string{"users.read"}, k.Scopes)
+	assert.True(t, expiresAt.Equal(*k.ExpiresAt))
+	assert.True(t, k.IsValid(time.Now()))
+	assert.False(t, k.IsValid(expiresAt))
+	_, err = s.CreateAPIKey(ctx, &store.APIKey{Hash: "hash", Name: "other", CreatedAt: time.Now()})
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	other, err := s.CreateAPIKey(ctx, &store.APIKey{Hash: "other", Name: "other", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, other.Scopes)
+	assert.Nil(t, other.ExpiresAt)
+
+	found, err := s.GetAPIKeyByHash(ctx, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, k.ID, found.ID)
+	_, err = s.GetAPIKeyByHash(ctx, "unknown")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	count, err := s.CountAPIKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	keys, err := s.ListAPIKeys(ctx, &store.Pagination{Page: 2, Size: 1})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, other.ID, keys[0].ID)
+
+	// revoked keys are kept, revoking them again keeps the time they were revoked.
+	revokedAt := time.Now().Truncate(time.Millisecond).UTC()
+	revoked, err := s.RevokeAPIKey(ctx, k.ID, revokedAt)
+	require.NoError(t, err)
+	assert.True(t, revokedAt.Equal(*revoked.RevokedAt))
+	assert.False(t, revoked.IsValid(time.Now()))
+	revoked, err = s.RevokeAPIKey(ctx, k.ID, revokedAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, revokedAt.Equal(*revoked.RevokedAt))
+	found, err = s.GetAPIKeyByHash(ctx, "hash")
+	require.NoError(t, err)
+	assert.NotNil(t, found.RevokedAt)
+	_, err = s.RevokeAPIKey(ctx, primitive.NewObjectID(), revokedAt)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestRunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	errRollback := errors.New("rollback")
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+		require.NoError(t, err)
+		// Nested transaction is rolled back on its own.
+		err = s.RunInTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.CreateUser(ctx, &store.User{Email: "jane@doe.com"}, "")
+			require.NoError(t, err)
+			return errRollback
+		})
+		assert.ErrorIs(t, err, errRollback)
+		count, err := s.CountUsers(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		return s.AddEvent(ctx, &events.Event{Name: events.CreateUserEvent, CreatedAt: time.Now()})
+	})
+	require.NoError(t, err)
+
+	err = s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.CreateUser(ctx, &store.User{Email: "jan@kowalski.com"}, "")
+		require.NoError(t, err)
+		require.NoError(t, s.AddEvent(ctx, &events.Event{Name: events.CreateUserEvent, CreatedAt: time.Now()}))
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	count, err := s.CountUsers(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	evts, err := s.ListEventsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, evts, 1)
+	seq, err := s.LastEventSeq(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), seq)
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	var last string
+	for i := 0; i < 3; i++ {
+		e := &events.Event{Name: events.UpdateUserEvent, CreatedAt: time.Now()}
+		require.NoError(t, s.AddEvent(ctx, e))
+		last = e.ID
+	}
+	seq, err := s.LastEventSeq(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), seq)
+
+	claimed, err := s.ClaimEvents(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	require.NoError(t, s.MarkEventPublished(ctx, claimed[0].ID))
+	require.NoError(t, s.MarkEventFailed(ctx, claimed[1].ID, time.Now()))
+
+	claimed, err = s.ClaimEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	assert.Equal(t, last, claimed[1].ID)
+
+	evts, err := s.ListEventsAfter(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, evts, 2)
+	assert.Equal(t, int64(2), evts[0].Seq)
+	assert.Equal(t, last, evts[1].ID)
+}
@@ -0,0 +1,135 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type outboxEvent struct {
+	events.Event
+	objectID      primitive.ObjectID
+	seq           int64
+	nextAttemptAt time.Time
+	publishedAt   *time.Time
+}
+
+func (e *outboxEvent) event() *events.Event {
+	c := e.Event
+	return &c
+}
+
+func (s *Store) eventIndex(id string) int {
+	for i, e := range s.data.outbox {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// expireEvents removes events published before the retention period.
+func (s *Store) expireEvents(now time.Time) {
+	evts := s.data.outbox[:0:0]
+	for _, e := range s.data.outbox {
+		if e.publishedAt == nil || now.Sub(*e.publishedAt) < store.OutboxRetention {
+			evts = append(evts, e)
+		}
+	}
+	s.data.outbox = evts
+}
+
+func (s *Store) AddEvent(ctx context.Context, e *events.Event) error {
+	defer s.lock(ctx)()
+	id := primitive.NewObjectID()
+	e.ID = id.Hex()
+	s.data.eventSeq++
+	s.data.outbox = append(s.data.outbox, &outboxEvent{
+		Event:         events.Event{ID: e.ID, Name: e.Name, Data: e.Data, ContentType: e.ContentType, CreatedAt: e.CreatedAt},
+		objectID:      id,
+		seq:           s.data.eventSeq,
+		nextAttemptAt: e.CreatedAt,
+	})
+	return nil
+}
+
+// WatchEvents streams events by their numbers, transactions are serialized so they number events
+// in order of commits.
+func (s *Store) WatchEvents(ctx context.Context, resumeToken string) (store.EventStream, error) {
+	return store.WatchSequence(ctx, s, resumeToken)
+}
+
+func (s *Store) LastEventSeq(ctx context.Context) (int64, error) {
+	defer s.lock(ctx)()
+	return s.data.eventSeq, nil
+}
+
+func (s *Store) ListEventsAfter(ctx context.Context, seq int64, limit int) ([]*store.SequencedEvent, error) {
+	defer s.lock(ctx)()
+	var evts []*store.SequencedEvent
+	for _, e := range s.data.outbox {
+		if len(evts) == limit {
+			break
+		}
+		if e.seq > seq {
+			evts = append(evts, &store.SequencedEvent{Event: e.event(), Seq: e.seq})
+		}
+	}
+	return evts, nil
+}
+
+// sortedEvents returns events sorted by id, like the mongodb store does.
+func (s *Store) sortedEvents() []*outboxEvent {
+	evts := append([]*outboxEvent(nil), s.data.outbox...)
+	sort.SliceStable(evts, func(i, j int) bool {
+		return bytes.Compare(evts[i].objectID[:], evts[j].objectID[:]) < 0
+	})
+	return evts
+}
+
+func (s *Store) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*events.Event, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	var evts []*events.Event
+	for _, e := range s.sortedEvents() {
+		if len(evts) == limit {
+			break
+		}
+		if e.publishedAt != nil || e.nextAttemptAt.After(now) {
+			continue
+		}
+		claimed := *e
+		claimed.nextAttemptAt = now.Add(lease)
+		s.data.outbox[s.eventIndex(e.ID)] = &claimed
+		evts = append(evts, claimed.event())
+	}
+	return evts, nil
+}
+
+func (s *Store) MarkEventPublished(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+	now := time.Now()
+	if i := s.eventIndex(id); i >= 0 {
+		published := *s.data.outbox[i]
+		published.publishedAt = &now
+		s.data.outbox[i] = &published
+	}
+	s.expireEvents(now)
+	return nil
+}
+
+func (s *Store) MarkEventFailed(ctx context.Context, id string, retryAt time.Time) error {
+	defer s.lock(ctx)()
+	if i := s.eventIndex(id); i >= 0 {
+		failed := *s.data.outbox[i]
+		failed.Attempts++
+		failed.nextAttemptAt = retryAt
+		s.data.outbox[i] = &failed
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneToken(t *store.RefreshToken) *store.RefreshToken {
+	c := *t
+	if t.RevokedAt != nil {
+		revokedAt := *t.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
+
+// removeTokens removes tokens for which remove returns true.
+func (s *Store) removeTokens(remove func(t *store.RefreshToken) bool) {
+	kept := s.data.tokens[:0:0]
+	for _, t := range s.data.tokens {
+		if !remove(t) {
+			kept = append(kept, t)
+		}
+	}
+	s.data.tokens = kept
+}
+
+func (s *Store) CreateRefreshToken(ctx context.Context, t *store.RefreshToken) (*store.RefreshToken, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	s.removeTokens(func(t *store.RefreshToken) bool { return !t.ExpiresAt.After(now) })
+	c := cloneToken(t)
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = now
+	s.data.tokens = append(s.data.tokens, c)
+	return cloneToken(c), nil
+}
+
+func (s *Store) UseRefreshToken(ctx context.Context, hash string) (*store.RefreshToken, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	for i, t := range s.data.tokens {
+		if t.Hash != hash || !t.ExpiresAt.After(now) {
+			continue
+		}
+		if t.RevokedAt == nil {
+			used := cloneToken(t)
+			used.RevokedAt = &now
+			s.data.tokens[i] = used
+		}
+		return cloneToken(t), nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) RevokeRefreshTokens(ctx context.Context, family primitive.ObjectID) error {
+	defer s.lock(ctx)()
+	s.revokeTokens(func(t *store.RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	defer s.lock(ctx)()
+	s.revokeTokens(func(t *store.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+// revokeTokens revokes refresh tokens for which revoke returns true, unless they're revoked already.
+func (s *Store) revokeTokens(revoke func(t *store.RefreshToken) bool) {
+	now := time.Now()
+	for i, t := range s.data.tokens {
+		if t.RevokedAt == nil && revoke(t) {
+			revoked := cloneToken(t)
+			revoked.RevokedAt = &now
+			s.data.tokens[i] = revoked
+		}
+	}
+}
+
+// removeOneTimeTokens removes one-time tokens for which remove returns true.
+func (s *Store) removeOneTimeTokens(remove func(t *store.OneTimeToken) bool) {
+	kept := s.data.oneTime[:0:0]
+	for _, t := range s.data.oneTime {
+		if !remove(t) {
+			kept = append(kept, t)
+		}
+	}
+	s.data.oneTime = kept
+}
+
+func (s *Store) CreateOneTimeToken(ctx context.Context, t *store.OneTimeToken) (*store.OneTimeToken, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	s.removeOneTimeTokens(func(o *store.OneTimeToken) bool {
+		return !o.ExpiresAt.After(now) || o.UserID == t.UserID && o.Purpose == t.Purpose
+	})
+	c := *t
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = now
+	s.data.oneTime = append(s.data.oneTime, &c)
+	r := c
+	return &r, nil
+}
+
+func (s *Store) UseOneTimeToken(ctx context.Context, purpose, hash string) (*store.OneTimeToken, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	var used *store.OneTimeToken
+	s.removeOneTimeTokens(func(t *store.OneTimeToken) bool {
+		if t.Hash == hash && t.Purpose == purpose && t.ExpiresAt.After(now) {
+			c := *t
+			used = &c
+			return true
+		}
+		return false
+	})
+	if used == nil {
+		return nil, store.ErrNotFound
+	}
+	return used, nil
+}
@@ -0,0 +1,75 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneTwoFactor(tf *store.TwoFactor) *store.TwoFactor {
+	c := *tf
+	c.Secret = append([]byte(nil), tf.Secret...)
+	if tf.EnabledAt != nil {
+		enabledAt := *tf.EnabledAt
+		c.EnabledAt = &enabledAt
+	}
+	c.RecoveryCodes = append([]string(nil), tf.RecoveryCodes...)
+	return &c
+}
+
+func (s *Store) GetTwoFactor(ctx context.Context, id primitive.ObjectID) (*store.TwoFactor, error) {
+	defer s.lock(ctx)()
+	tf, ok := s.data.twoFactors[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return cloneTwoFactor(tf), nil
+}
+
+func (s *Store) SetTwoFactor(ctx context.Context, id primitive.ObjectID, tf *store.TwoFactor) error {
+	defer s.lock(ctx)()
+	if _, ok := s.data.creds[id]; !ok {
+		return store.ErrNotFound
+	}
+	s.data.twoFactors[id] = cloneTwoFactor(tf)
+	return nil
+}
+
+func (s *Store) DeleteTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+	if _, ok := s.data.twoFactors[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.data.twoFactors, id)
+	return nil
+}
+
+func (s *Store) UseTwoFactorStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	defer s.lock(ctx)()
+	tf, ok := s.data.twoFactors[id]
+	if !ok || tf.LastStep >= step {
+		return store.ErrInvalidCreds
+	}
+	tf = cloneTwoFactor(tf)
+	tf.LastStep = step
+	s.data.twoFactors[id] = tf
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	defer s.lock(ctx)()
+	tf, ok := s.data.twoFactors[id]
+	if !ok {
+		return store.ErrInvalidCreds
+	}
+	for i, h := range tf.RecoveryCodes {
+		if h == hash {
+			tf = cloneTwoFactor(tf)
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			s.data.twoFactors[id] = tf
+			return nil
+		}
+	}
+	return store.ErrInvalidCreds
+}
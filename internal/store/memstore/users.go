@@ -0,0 +1,331 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneUser(u *store.User) *store.User {
+	c := *u
+	if u.Nickname != nil {
+		nickname := *u.Nickname
+		c.Nickname = &nickname
+	}
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+func (s *Store) userIndex(id primitive.ObjectID) int {
+	for i, u := range s.data.users {
+		if u.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// conflict returns an error when u has email or nickname of another user, deleted ones included,
+// like unique indexes do in mongodb, where only users without nickname are not checked.
+func (s *Store) conflict(u *store.User) error {
+	for _, other := range s.data.users {
+		if other.ID == u.ID {
+			continue
+		}
+		if other.Email == u.Email {
+			return fmt.Errorf("user with email '%s' %w", u.Email, store.ErrAlreadyExists)
+		}
+		if u.Nickname != nil && other.Nickname != nil && *other.Nickname == *u.Nickname {
+			return fmt.Errorf("user with nickname '%s' %w", deref.String(u.Nickname), store.ErrAlreadyExists)
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id primitive.ObjectID) (*store.User, error) {
+	defer s.lock(ctx)()
+	i := s.userIndex(id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	return cloneUser(s.data.users[i]), nil
+}
+
+func (s *Store) CountUsers(ctx context.Context, filter filtering.Expr) (int64, error) {
+	defer s.lock(ctx)()
+	var count int64
+	for _, u := range s.data.users {
+		if store.MatchesFilter(filter, u) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context, filter filtering.Expr, order store.Order, p *store.Pagination) ([]*store.User, error) {
+	defer s.lock(ctx)()
+	var matched []*store.User
+	for _, u := range s.data.users {
+		if p != nil && p.After != nil && !order.After(u, p.After) {
+			continue
+		}
+		if store.MatchesFilter(filter, u) {
+			matched = append(matched, u)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return order.Compare(matched[i], matched[j]) < 0
+	})
+	lo, hi := page(len(matched), p)
+	var users []*store.User
+	for _, u := range matched[lo:hi] {
+		users = append(users, cloneUser(u))
+	}
+	return users, nil
+}
+
+func (s *Store) SearchUsers(ctx context.Context, query string, p *store.Pagination) ([]*store.User, int64, error) {
+	defer s.lock(ctx)()
+	var candidates []*store.User
+	for _, u := range s.data.users {
+		if u.DeletedAt == nil {
+			candidates = append(candidates, u)
+		}
+	}
+	found, total := store.RankSearch(candidates, store.SearchTerms(query), p)
+	var users []*store.User
+	for _, u := range found {
+		users = append(users, cloneUser(u))
+	}
+	return users, total, nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *store.User, password string) (*store.User, error) {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	defer s.lock(ctx)()
+	u := cloneUser(user)
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	if u.Role == "" {
+		u.Role = store.RoleUser
+	}
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+	if s.userIndex(u.ID) >= 0 || s.conflict(u) != nil {
+		return nil, store.ErrAlreadyExists
+	}
+	s.data.users = append(s.data.users, u)
+	s.data.creds[u.ID] = hash
+	return cloneUser(u), nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, email, oldPassword, newPassword string) error {
+	hash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	defer s.lock(ctx)()
+	u := s.userByEmail(email)
+	if u == nil {
+		return store.ErrNotFound
+	}
+	old, ok := s.data.creds[u.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	matches, err := password.Verify(oldPassword, old)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return store.ErrInvalidCreds
+	}
+	s.data.creds[u.ID] = hash
+	return nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*store.User, error) {
+	defer s.lock(ctx)()
+	u := s.userByEmail(email)
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	return cloneUser(u), nil
+}
+
+func (s *Store) SetPassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	defer s.lock(ctx)()
+	i := s.userIndex(id)
+	if i < 0 || s.data.users[i].DeletedAt != nil {
+		return store.ErrNotFound
+	}
+	s.data.creds[id] = hash
+	return nil
+}
+
+func (s *Store) Authenticate(ctx context.Context, login, pass string) (*store.User, error) {
+	defer s.lock(ctx)()
+	var u *store.User
+	for _, v := range s.data.users {
+		if v.DeletedAt == nil && (v.Email == login || v.Nickname != nil && *v.Nickname == login) {
+			u = v
+			break
+		}
+	}
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	hash, ok := s.data.creds[u.ID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	matches, err := password.Verify(pass, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return cloneUser(u), store.ErrInvalidCreds
+	}
+	// hashes created by another algorithm or with other parameters than the hasher's are replaced.
+	if s.hasher.NeedsRehash(hash) {
+		newHash, err := s.hasher.Hash(pass)
+		if err != nil {
+			return nil, err
+		}
+		s.data.creds[u.ID] = newHash
+	}
+	return cloneUser(u), nil
+}
+
+// userByEmail returns a user with the email which isn't deleted, or nil.
+func (s *Store) userByEmail(email string) *store.User {
+	for _, u := range s.data.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return u
+		}
+	}
+	return nil
+}
+
+// findUser returns index of a user which is deleted or not, with the version unless it's store.AnyVersion.
+func (s *Store) findUser(id primitive.ObjectID, version int64, deleted bool) (int, error) {
+	i := s.userIndex(id)
+	if i < 0 {
+		return -1, store.ErrNotFound
+	}
+	u := s.data.users[i]
+	switch {
+	case u.DeletedAt != nil && !deleted:
+		return -1, store.ErrNotFound
+	case u.DeletedAt == nil && deleted:
+		return -1, store.ErrNotDeleted
+	case version != store.AnyVersion && version != u.Version:
+		return -1, store.ErrVersionMismatch
+	}
+	return i, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, u *store.User, paths []string, version int64) (*store.User, error) {
+	defer s.lock(ctx)()
+	i, err := s.findUser(u.ID, version, false)
+	if err != nil {
+		return nil, err
+	}
+	updated := cloneUser(s.data.users[i])
+	for _, path := range paths {
+		switch path {
+		case "first_name":
+			updated.FirstName = u.FirstName
+		case "last_name":
+			updated.LastName = u.LastName
+		case "nickname":
+			updated.Nickname = u.Nickname
+		case "email":
+			updated.Email = u.Email
+		case "country":
+			updated.Country = u.Country
+		case "role":
+			updated.Role = u.Role
+		case "email_verified":
+			updated.EmailVerified = u.EmailVerified
+		}
+	}
+	if err := s.conflict(updated); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	s.data.users[i] = updated
+	return cloneUser(updated), nil
+}
+
+// DeleteUser marks a user deleted, returns the deleted user.
+func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*store.User, error) {
+	defer s.lock(ctx)()
+	i, err := s.findUser(id, version, false)
+	if err != nil {
+		return nil, err
+	}
+	u := cloneUser(s.data.users[i])
+	now := time.Now()
+	u.DeletedAt = &now
+	u.UpdatedAt = now
+	u.Version++
+	s.data.users[i] = u
+	return cloneUser(u), nil
+}
+
+// UndeleteUser restores a deleted user.
+func (s *Store) UndeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*store.User, error) {
+	defer s.lock(ctx)()
+	i, err := s.findUser(id, version, true)
+	if err != nil {
+		return nil, err
+	}
+	u := cloneUser(s.data.users[i])
+	u.DeletedAt = nil
+	u.UpdatedAt = time.Now()
+	u.Version++
+	s.data.users[i] = u
+	return cloneUser(u), nil
+}
+
+// PurgeUsers removes users deleted before a given time and their credentials.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	defer s.lock(ctx)()
+	kept := s.data.users[:0:0]
+	purged := 0
+	for _, u := range s.data.users {
+		if purged < limit && u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			delete(s.data.creds, u.ID)
+			delete(s.data.twoFactors, u.ID)
+			s.removeTokens(func(t *store.RefreshToken) bool { return t.UserID == u.ID })
+			s.removeOneTimeTokens(func(t *store.OneTimeToken) bool { return t.UserID == u.ID })
+			delete(s.data.lockouts, store.UserLockoutKey(u.ID))
+			purged++
+			continue
+		}
+		kept = append(kept, u)
+	}
+	s.data.users = kept
+	return purged, nil
+}
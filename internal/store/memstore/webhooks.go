@@ -0,0 +1,215 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneWebhook(w *store.Webhook) *store.Webhook {
+	c := *w
+	c.EventTypes = append([]string{}, w.EventTypes...)
+	return &c
+}
+
+func cloneWebhookDelivery(d *store.WebhookDelivery) *store.WebhookDelivery {
+	c := *d
+	if d.LastAttemptAt != nil {
+		t := *d.LastAttemptAt
+		c.LastAttemptAt = &t
+	}
+	return &c
+}
+
+func (s *Store) webhookIndex(id primitive.ObjectID) int {
+	for i, w := range s.data.webhooks {
+		if w.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// expireWebhookDeliveries removes deliveries created before the retention period.
+func (s *Store) expireWebhookDeliveries(now time.Time) {
+	ds := s.data.deliveries[:0:0]
+	for _, d := range s.data.deliveries {
+		if now.Sub(d.CreatedAt) < store.WebhookDeliveryRetention {
+			ds = append(ds, d)
+		}
+	}
+	s.data.deliveries = ds
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *store.Webhook) (*store.Webhook, error) {
+	defer s.lock(ctx)()
+	c := cloneWebhook(w)
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if s.webhookIndex(c.ID) >= 0 {
+		return nil, store.ErrAlreadyExists
+	}
+	s.data.webhooks = append(s.data.webhooks, c)
+	return cloneWebhook(c), nil
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id primitive.ObjectID) (*store.Webhook, error) {
+	defer s.lock(ctx)()
+	i := s.webhookIndex(id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	return cloneWebhook(s.data.webhooks[i]), nil
+}
+
+func (s *Store) CountWebhooks(ctx context.Context) (int64, error) {
+	defer s.lock(ctx)()
+	return int64(len(s.data.webhooks)), nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, p *store.Pagination) ([]*store.Webhook, error) {
+	defer s.lock(ctx)()
+	lo, hi := page(len(s.data.webhooks), p)
+	var webhooks []*store.Webhook
+	for _, w := range s.data.webhooks[lo:hi] {
+		webhooks = append(webhooks, cloneWebhook(w))
+	}
+	return webhooks, nil
+}
+
+// ListWebhooksByEvent returns all webhooks subscribed to the event.
+func (s *Store) ListWebhooksByEvent(ctx context.Context, eventName string) ([]*store.Webhook, error) {
+	defer s.lock(ctx)()
+	var webhooks []*store.Webhook
+	for _, w := range s.data.webhooks {
+		subscribed := len(w.EventTypes) == 0
+		for _, t := range w.EventTypes {
+			subscribed = subscribed || t == eventName
+		}
+		if subscribed {
+			webhooks = append(webhooks, cloneWebhook(w))
+		}
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes webhook together with its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	defer s.lock(ctx)()
+	i := s.webhookIndex(id)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	s.data.webhooks = append(s.data.webhooks[:i:i], s.data.webhooks[i+1:]...)
+	ds := s.data.deliveries[:0:0]
+	for _, d := range s.data.deliveries {
+		if d.WebhookID != id {
+			ds = append(ds, d)
+		}
+	}
+	s.data.deliveries = ds
+	return nil
+}
+
+// AddWebhookDeliveries schedules deliveries, ignoring those which already exist for the same webhook and event.
+func (s *Store) AddWebhookDeliveries(ctx context.Context, ds []*store.WebhookDelivery) error {
+	defer s.lock(ctx)()
+	s.expireWebhookDeliveries(time.Now())
+	for _, d := range ds {
+		if s.hasWebhookDelivery(d.WebhookID, d.EventID) {
+			continue
+		}
+		c := cloneWebhookDelivery(d)
+		if c.ID.IsZero() {
+			c.ID = primitive.NewObjectID()
+		}
+		s.data.deliveries = append(s.data.deliveries, c)
+	}
+	return nil
+}
+
+func (s *Store) hasWebhookDelivery(webhookID primitive.ObjectID, eventID string) bool {
+	for _, d := range s.data.deliveries {
+		if d.WebhookID == webhookID && d.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries which are due,
+// and hides them from other dispatchers for the lease duration.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*store.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+	now := time.Now()
+	var due []int
+	for i, d := range s.data.deliveries {
+		if d.Status == store.WebhookDeliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return s.data.deliveries[due[i]].NextAttemptAt.Before(s.data.deliveries[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	var ds []*store.WebhookDelivery
+	for _, i := range due {
+		claimed := cloneWebhookDelivery(s.data.deliveries[i])
+		claimed.NextAttemptAt = now.Add(lease)
+		s.data.deliveries[i] = claimed
+		ds = append(ds, cloneWebhookDelivery(claimed))
+	}
+	return ds, nil
+}
+
+// SaveWebhookDeliveryAttempt stores an outcome of the last delivery attempt.
+func (s *Store) SaveWebhookDeliveryAttempt(ctx context.Context, d *store.WebhookDelivery) error {
+	defer s.lock(ctx)()
+	for i, stored := range s.data.deliveries {
+		if stored.ID == d.ID {
+			saved := *stored
+			saved.Status = d.Status
+			saved.Attempts = d.Attempts
+			saved.ResponseCode = d.ResponseCode
+			saved.LastError = d.LastError
+			saved.LastAttemptAt = d.LastAttemptAt
+			saved.NextAttemptAt = d.NextAttemptAt
+			s.data.deliveries[i] = cloneWebhookDelivery(&saved)
+		}
+	}
+	return nil
+}
+
+func (s *Store) CountWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID) (int64, error) {
+	defer s.lock(ctx)()
+	var count int64
+	for _, d := range s.data.deliveries {
+		if d.WebhookID == webhookID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListWebhookDeliveries returns delivery log of a webhook, most recent deliveries first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, p *store.Pagination) ([]*store.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+	var matched []*store.WebhookDelivery
+	for i := len(s.data.deliveries) - 1; i >= 0; i-- {
+		if d := s.data.deliveries[i]; d.WebhookID == webhookID {
+			matched = append(matched, d)
+		}
+	}
+	lo, hi := page(len(matched), p)
+	var ds []*store.WebhookDelivery
+	for _, d := range matched[lo:hi] {
+		ds = append(ds, cloneWebhookDelivery(d))
+	}
+	return ds, nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrate updates data stored by previous versions of the service, it should be called before CreateIndexes.
+// It's safe to call it on every start, also by many instances at once.
+func (s *Store) Migrate(ctx context.Context) error {
+	return s.migrateCreds(ctx)
+}
+
+// legacyCreds are credentials stored under email of their user, as they were before they were linked to its id.
+type legacyCreds struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Email    string             `bson:"email"`
+	Password []byte             `bson:"password"`
+}
+
+// migrateCreds moves credentials stored under emails to ids of their users,
+// each in a transaction. Ones without a user can't be used, so they are removed.
+func (s *Store) migrateCreds(ctx context.Context) error {
+	// unique index of emails allows only one document without email.
+	if _, err := s.creds.Indexes().DropOne(ctx, "email_1"); err != nil && !isNotFound(err) {
+		return err
+	}
+	cur, err := s.creds.Find(ctx, bson.D{{Key: "email", Value: bson.D{{Key: "$exists", Value: true}}}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var c legacyCreds
+		if err := cur.Decode(&c); err != nil {
+			return err
+		}
+		_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			var u User
+			err := s.users.FindOne(sessCtx, bson.D{{Key: "email", Value: c.Email}}).Decode(&u)
+			if err == nil {
+				// password set since the migration started is kept.
+				_, err = s.creds.UpdateOne(sessCtx, bson.D{{Key: "_id", Value: u.ID}},
+					bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "password", Value: c.Password}}}},
+					options.Update().SetUpsert(true))
+			}
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, err
+			}
+			_, err = s.creds.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: c.ID}})
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// isNotFound reports whether a command failed because an index or a collection doesn't exist.
+func isNotFound(err error) bool {
+	var e mongo.CommandError
+	return errors.As(err, &e) && (e.Name == "IndexNotFound" || e.Name == "NamespaceNotFound")
+}
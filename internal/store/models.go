@@ -1,6 +1,10 @@
 package store
 
 import (
+	"strings"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -14,8 +18,27 @@ type User struct {
 	Nickname  *string            `bson:"nickname" validate:"alphaNum"`
 	Email     string             `bson:"email" validate:"email|required_if:validationKind,create"`
 	Country   string             `bson:"country" validate:"required_if:validationKind,create"`
+	// Role is RoleUser or RoleAdmin, users created before roles were introduced have an empty role,
+	// which is the same as RoleUser.
+	Role string `bson:"role" validate:"in:user,admin"`
+	// EmailVerified is set once a user confirms the email with a token sent to it,
+	// it's reset when the email changes.
+	EmailVerified bool `bson:"emailVerified" validate:"-"`
+	// CreatedAt, UpdatedAt and Version are managed by stores, they are zero
+	// for users created before they were introduced. Version is incremented on each update.
+	CreatedAt time.Time `bson:"createdAt" validate:"-"`
+	UpdatedAt time.Time `bson:"updatedAt" validate:"-"`
+	Version   int64     `bson:"version" validate:"-"`
+	// DeletedAt is set when a user is deleted, deleted users are purged after the retention period.
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" validate:"-"`
 }
 
+// Roles of users, admins can manage all users.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // SetID parses hex id and sets it on user object.
 func (u *User) SetID(hex string) (*User, error) {
 	id, err := primitive.ObjectIDFromHex(hex)
@@ -26,52 +49,89 @@ func (u *User) SetID(hex string) (*User, error) {
 	return u, nil
 }
 
-// filter creates mongodb a document containing query operators.
-// Ignores ID field.
-func (filter *User) filter() bson.D {
-	d := bson.D{}
-	if filter != nil {
-		if filter.FirstName != "" {
-			d = append(d, bson.E{Key: "firstName", Value: filter.FirstName})
-		}
-		if filter.LastName != "" {
-			d = append(d, bson.E{Key: "lastName", Value: filter.LastName})
-		}
-		if filter.Nickname != nil && *filter.Nickname != "" {
-			d = append(d, bson.E{Key: "nickname", Value: *filter.Nickname})
-		}
-		if filter.Email != "" {
-			d = append(d, bson.E{Key: "email", Value: filter.Email})
-		}
-		if filter.Country != "" {
-			d = append(d, bson.E{Key: "country", Value: filter.Country})
+// IsEmailLogin reports whether a login is an email, otherwise it's a nickname,
+// nicknames consist of letters and digits only.
+func IsEmailLogin(login string) bool {
+	return strings.Contains(login, "@")
+}
+
+// Expr creates a filter expression matching users with the same non-empty fields,
+// an empty nickname is ignored too. Ignores ID field.
+func (filter *User) Expr() filtering.Expr {
+	if filter == nil {
+		return nil
+	}
+	var e filtering.Expr
+	add := func(field, value string) {
+		if value != "" {
+			e = filtering.AndAll(e, &filtering.Restriction{Field: field, Op: filtering.Equal, Value: value})
 		}
 	}
-	return d
+	add("first_name", filter.FirstName)
+	add("last_name", filter.LastName)
+	if filter.Nickname != nil {
+		add("nickname", *filter.Nickname)
+	}
+	add("email", filter.Email)
+	add("country", filter.Country)
+	return e
+}
+
+// Matches reports whether u matches the filter,
+// it's an in-memory counterpart of the Expr method.
+func (filter *User) Matches(u *User) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.FirstName != "" && filter.FirstName != u.FirstName {
+		return false
+	}
+	if filter.LastName != "" && filter.LastName != u.LastName {
+		return false
+	}
+	if filter.Nickname != nil && *filter.Nickname != "" && (u.Nickname == nil || *filter.Nickname != *u.Nickname) {
+		return false
+	}
+	if filter.Email != "" && filter.Email != u.Email {
+		return false
+	}
+	if filter.Country != "" && filter.Country != u.Country {
+		return false
+	}
+	return true
 }
 
-// filter creates a mongodb document containing update operators.
-// Ignores ID field.
-func (u *User) update(paths []string) bson.D {
-	var d bson.D
+// update creates a mongodb document containing update operators,
+// it sets update time and increments version. Ignores ID field.
+func (u *User) update(paths []string, now time.Time) bson.D {
+	set := bson.D{{Key: "updatedAt", Value: now}}
 	for _, path := range paths {
 		switch path {
 		case "first_name":
-			d = append(d, bson.E{Key: "$set", Value: bson.D{{Key: "firstName", Value: u.FirstName}}})
+			set = append(set, bson.E{Key: "firstName", Value: u.FirstName})
 		case "last_name":
-			d = append(d, bson.E{Key: "$set", Value: bson.D{{Key: "lastName", Value: u.LastName}}})
+			set = append(set, bson.E{Key: "lastName", Value: u.LastName})
 		case "nickname":
-			d = append(d, bson.E{Key: "$set", Value: bson.D{{Key: "nickname", Value: u.Nickname}}})
+			set = append(set, bson.E{Key: "nickname", Value: u.Nickname})
 		case "email":
-			d = append(d, bson.E{Key: "$set", Value: bson.D{{Key: "email", Value: u.Email}}})
+			set = append(set, bson.E{Key: "email", Value: u.Email})
 		case "country":
-			d = append(d, bson.E{Key: "$set", Value: bson.D{{Key: "country", Value: u.Country}}})
+			set = append(set, bson.E{Key: "country", Value: u.Country})
+		case "role":
+			set = append(set, bson.E{Key: "role", Value: u.Role})
+		case "email_verified":
+			set = append(set, bson.E{Key: "emailVerified", Value: u.EmailVerified})
 		}
 	}
-	return d
+	return bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
 }
 
+// creds are credentials of a user with the same id.
 type creds struct {
-	Email    string `bson:"email"`
-	Password []byte `bson:"password"`
+	UserID    primitive.ObjectID `bson:"_id"`
+	Password  []byte             `bson:"password"`
+	TwoFactor *TwoFactor         `bson:"twoFactor,omitempty"`
 }
@@ -8,6 +8,7 @@ var fieldAliases = validate.MS{
 	"User.Nickname":  "nickname",
 	"User.Email":     "email",
 	"User.Country":   "country",
+	"User.Role":      "role",
 }
 
 type ValidationErrors struct {
@@ -47,6 +48,7 @@ func (v userValidation) Messages() map[string]string {
 		"alpha":       "The field '{field}' should contain only apha characters.",
 		"alphaNum":    "The field '{field}' should contain only apha-numeric characters.",
 		"email":       "The field '{field}' is not a valid email.",
+		"in":          "The field '{field}' is not a valid value.",
 		"required_if": "The field '{field}' is required.",
 	}
 }
@@ -68,6 +68,14 @@ func TestUser_Validate(t *testing.T) {
 				map[string]string{"nickname": "The field 'nickname' should contain only apha-numeric characters."},
 			}, errs)
 		})
+		t.Run("role", func(t *testing.T) {
+			assert.Nil(t, (&User{Role: RoleAdmin}).Validate(UpdateValidationKind))
+			errs := (&User{Role: "ROLE_OWNER"}).Validate(UpdateValidationKind)
+			require.NotNil(t, errs)
+			assert.EqualValues(t, &ValidationErrors{
+				map[string]string{"role": "The field 'role' is not a valid value."},
+			}, errs)
+		})
 
 	})
 
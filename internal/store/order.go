@@ -0,0 +1,311 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userFields maps names of fields users can be filtered and ordered by to their mongodb keys.
+var userFields = map[string]string{
+	"id":          "_id",
+	"first_name":  "firstName",
+	"last_name":   "lastName",
+	"nickname":    "nickname",
+	"email":       "email",
+	"country":     "country",
+	"create_time": "createdAt",
+	"update_time": "updatedAt",
+	"version":     "version",
+	"delete_time": "deletedAt",
+	// email_verified can be filtered by, but not ordered by.
+	"email_verified": "emailVerified",
+}
+
+// timeFormat is a format of timestamps in filters and cursors, it's RFC 3339 in UTC
+// with fixed number of fractional digits, so timestamps are ordered like strings.
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// FieldValue converts a value of a field from a string, as in filters and cursors,
+// to the field's type: time.Time for timestamps, int64 for version, bool for email_verified
+// and string for other fields. Values are expected to be valid.
+func FieldValue(field, value string) interface{} {
+	switch field {
+	case "create_time", "update_time", "delete_time":
+		t, _ := time.Parse(time.RFC3339Nano, value)
+		return t.UTC()
+	case "version":
+		v, _ := strconv.ParseInt(value, 10, 64)
+		return v
+	case "email_verified":
+		v, _ := strconv.ParseBool(value)
+		return v
+	}
+	return value
+}
+
+// mongoValue is FieldValue with ids converted to ObjectIDs.
+func mongoValue(field, value string) interface{} {
+	if field == "id" {
+		id, _ := primitive.ObjectIDFromHex(value)
+		return id
+	}
+	return FieldValue(field, value)
+}
+
+// OrderField is a field of User, named as in the API, e.g. last_name.
+type OrderField struct {
+	Field string
+	Desc  bool
+}
+
+// Order is a sort order of users, it always ends with id field, which breaks ties.
+// Users without nickname, ones created before timestamps and versions were introduced
+// and ones which aren't deleted come first in ascending order, like in mongodb.
+type Order []OrderField
+
+// ParseOrder parses comma separated list of fields followed by optional asc or desc,
+// e.g. "last_name asc, country desc". Empty string orders users by id.
+func ParseOrder(s string) (Order, error) {
+	var o Order
+	if strings.TrimSpace(s) != "" {
+		for _, part := range strings.Split(s, ",") {
+			words := strings.Fields(part)
+			if len(words) == 0 || len(words) > 2 {
+				return nil, fmt.Errorf("invalid order %q", strings.TrimSpace(part))
+			}
+			f := OrderField{Field: words[0]}
+			if _, ok := userFields[f.Field]; !ok || f.Field == "email_verified" {
+				return nil, fmt.Errorf("users can't be ordered by %q", f.Field)
+			}
+			for _, prev := range o {
+				if prev.Field == f.Field {
+					return nil, fmt.Errorf("field %q is repeated in order", f.Field)
+				}
+			}
+			if len(words) == 2 {
+				switch strings.ToLower(words[1]) {
+				case "asc":
+				case "desc":
+					f.Desc = true
+				default:
+					return nil, fmt.Errorf("invalid order direction %q", words[1])
+				}
+			}
+			o = append(o, f)
+			if f.Field == "id" {
+				// ids are unique, following fields don't change the order.
+				return o, nil
+			}
+		}
+	}
+	return append(o, DefaultOrder...), nil
+}
+
+// String returns order in the same format as accepted by ParseOrder.
+func (o Order) String() string {
+	parts := make([]string, len(o))
+	for i, f := range o {
+		parts[i] = f.Field + " asc"
+		if f.Desc {
+			parts[i] = f.Field + " desc"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DefaultOrder orders users by id, that is in order of creation.
+var DefaultOrder = Order{{Field: "id"}}
+
+// orDefault returns DefaultOrder when o is empty.
+func (o Order) orDefault() Order {
+	if len(o) == 0 {
+		return DefaultOrder
+	}
+	return o
+}
+
+// Cursor returns a cursor pointing at u in a list sorted by o.
+func (o Order) Cursor(u *User) *Cursor {
+	c := &Cursor{ID: u.ID}
+	for _, f := range o.orDefault() {
+		if f.Field != "id" {
+			c.Key = append(c.Key, userField(u, f.Field))
+		}
+	}
+	return c
+}
+
+// Compare returns a negative number when a comes before b in order o,
+// a positive one when it comes after b and zero when they are the same user.
+func (o Order) Compare(a, b *User) int {
+	return o.compare(a, o.Cursor(b))
+}
+
+// After reports whether u comes after the cursor in order o,
+// it's an in-memory counterpart of the after method.
+func (o Order) After(u *User, c *Cursor) bool {
+	return o.compare(u, c) > 0
+}
+
+func (o Order) compare(u *User, c *Cursor) int {
+	i := 0
+	for _, f := range o.orDefault() {
+		var d int
+		if f.Field == "id" {
+			d = strings.Compare(u.ID.Hex(), c.ID.Hex())
+		} else {
+			d = compareValues(f.Field, userField(u, f.Field), c.key(i))
+			i++
+		}
+		if f.Desc {
+			d = -d
+		}
+		if d != 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// compareValues compares nullable values of a field, nulls come first.
+func compareValues(field string, a, b *string) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case field == "version":
+		x, y := FieldValue(field, *a).(int64), FieldValue(field, *b).(int64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(*a, *b)
+}
+
+// userField returns value of a field by its name as a string, in timeFormat for timestamps.
+// Returns nil for users without nickname, for zero timestamps and versions and for users which aren't deleted.
+func userField(u *User, field string) *string {
+	switch field {
+	case "first_name":
+		return &u.FirstName
+	case "last_name":
+		return &u.LastName
+	case "nickname":
+		return u.Nickname
+	case "email":
+		return &u.Email
+	case "country":
+		return &u.Country
+	case "create_time":
+		return formatTime(u.CreatedAt)
+	case "update_time":
+		return formatTime(u.UpdatedAt)
+	case "delete_time":
+		if u.DeletedAt == nil {
+			return nil
+		}
+		return formatTime(*u.DeletedAt)
+	case "version":
+		if u.Version == 0 {
+			return nil
+		}
+		v := strconv.FormatInt(u.Version, 10)
+		return &v
+	case "email_verified":
+		v := strconv.FormatBool(u.EmailVerified)
+		return &v
+	}
+	return nil
+}
+
+func formatTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	s := t.UTC().Format(timeFormat)
+	return &s
+}
+
+// sort creates mongodb's sort document.
+func (o Order) sort() bson.D {
+	d := bson.D{}
+	for _, f := range o.orDefault() {
+		dir := 1
+		if f.Desc {
+			dir = -1
+		}
+		d = append(d, bson.E{Key: userFields[f.Field], Value: dir})
+	}
+	return d
+}
+
+// after creates mongodb's filter selecting users following the cursor in order o:
+// users with greater first field, or with the same first field and greater second one and so on.
+func (o Order) after(c *Cursor) bson.D {
+	var or bson.A
+	var equal bson.D
+	i := 0
+	for _, f := range o.orDefault() {
+		key := userFields[f.Field]
+		if f.Field == "id" {
+			op := "$gt"
+			if f.Desc {
+				op = "$lt"
+			}
+			or = append(or, with(equal, bson.E{Key: key, Value: bson.D{{Key: op, Value: c.ID}}}))
+			break
+		}
+		v := c.key(i)
+		i++
+		switch {
+		case v == nil && f.Desc:
+			// nothing comes after nulls in descending order.
+		case v == nil:
+			or = append(or, with(equal, bson.E{Key: key, Value: bson.D{{Key: "$ne", Value: nil}}}))
+		case f.Desc:
+			or = append(or, with(equal, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: key, Value: bson.D{{Key: "$lt", Value: mongoValue(f.Field, *v)}}}},
+				bson.D{{Key: key, Value: nil}},
+			}}))
+		default:
+			or = append(or, with(equal, bson.E{Key: key, Value: bson.D{{Key: "$gt", Value: mongoValue(f.Field, *v)}}}))
+		}
+		if v == nil {
+			equal = with(equal, bson.E{Key: key, Value: nil})
+		} else {
+			equal = with(equal, bson.E{Key: key, Value: mongoValue(f.Field, *v)})
+		}
+	}
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+// with returns a copy of d with e appended.
+func with(d bson.D, e bson.E) bson.D {
+	return append(d[:len(d):len(d)], e)
+}
+
+// Cursor is a position in a list of users, it points at the last user of the previous page.
+// Key holds values of the user's fields the list is ordered by, except for id.
+type Cursor struct {
+	Key []*string
+	ID  primitive.ObjectID
+}
+
+func (c *Cursor) key(i int) *string {
+	if i < len(c.Key) {
+		return c.Key[i]
+	}
+	return nil
+}
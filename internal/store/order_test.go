@@ -0,0 +1,104 @@
+// +build unit
+
+package store
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseOrder(t *testing.T) {
+	valid := map[string]string{
+		"":                              "id asc",
+		"last_name":                     "last_name asc, id asc",
+		" last_name asc , country DESC": "last_name asc, country desc, id asc",
+		"nickname desc, id desc, email": "nickname desc, id desc",
+	}
+	for s, want := range valid {
+		o, err := ParseOrder(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, o.String())
+	}
+
+	invalid := []string{"password", "last_name up", "last_name asc desc", "country,", "country, country desc", "email_verified"}
+	for _, s := range invalid {
+		_, err := ParseOrder(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestOrder_After(t *testing.T) {
+	users := []*User{
+		{ID: primitive.NewObjectID(), LastName: "Doe", Country: "UK", Nickname: deref.StringAddr("jd"), Version: 10},
+		{ID: primitive.NewObjectID(), LastName: "Doe", Country: "PL", Version: 9, CreatedAt: time.Unix(100, 0)},
+		{ID: primitive.NewObjectID(), LastName: "Kowalski", Country: "PL", Nickname: deref.StringAddr(""), Version: 9, CreatedAt: time.Unix(99, 5)},
+		{ID: primitive.NewObjectID(), LastName: "Doe", Country: "UK"},
+	}
+	for _, s := range []string{"", "last_name, country desc", "nickname", "nickname desc, id desc", "version desc, create_time"} {
+		o, err := ParseOrder(s)
+		require.NoError(t, err)
+		sorted := append([]*User(nil), users...)
+		sort.Slice(sorted, func(i, j int) bool { return o.Compare(sorted[i], sorted[j]) < 0 })
+		// users following a cursor are exactly the ones after its user.
+		for i, u := range sorted {
+			after := []*User{}
+			for _, other := range sorted {
+				if o.After(other, o.Cursor(u)) {
+					after = append(after, other)
+				}
+			}
+			assert.Equal(t, sorted[i+1:], after, s)
+		}
+	}
+
+	t.Run("nulls", func(t *testing.T) {
+		o, err := ParseOrder("nickname")
+		require.NoError(t, err)
+		assert.Negative(t, o.Compare(users[1], users[2]))
+		assert.Negative(t, o.Compare(users[2], users[0]))
+	})
+
+	t.Run("types", func(t *testing.T) {
+		// versions are compared as numbers and timestamps chronologically.
+		o, err := ParseOrder("version")
+		require.NoError(t, err)
+		assert.Negative(t, o.Compare(users[1], users[0]))
+		o, err = ParseOrder("create_time")
+		require.NoError(t, err)
+		assert.Negative(t, o.Compare(users[2], users[1]))
+		assert.Negative(t, o.Compare(users[0], users[2]))
+	})
+}
+
+func TestOrder_after(t *testing.T) {
+	o, err := ParseOrder("nickname desc")
+	require.NoError(t, err)
+	id := primitive.NewObjectID()
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "nickname", Value: bson.D{{Key: "$lt", Value: "jd"}}}},
+			bson.D{{Key: "nickname", Value: nil}},
+		}}},
+		bson.D{{Key: "nickname", Value: "jd"}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}},
+	}}}, o.after(&Cursor{Key: []*string{deref.StringAddr("jd")}, ID: id}))
+
+	// nothing comes after nulls in descending order, but users with null and greater id.
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "nickname", Value: nil}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}},
+	}}}, o.after(&Cursor{Key: []*string{nil}, ID: id}))
+
+	// cursor keys are converted to types of fields.
+	o, err = ParseOrder("version")
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "version", Value: bson.D{{Key: "$gt", Value: int64(2)}}}},
+		bson.D{{Key: "version", Value: int64(2)}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}},
+	}}}, o.after(o.Cursor(&User{ID: id, Version: 2})))
+}
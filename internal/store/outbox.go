@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OutboxRetention is for how long published events are kept in the outbox.
+const OutboxRetention = 7 * 24 * time.Hour
+
+var _ events.Outbox = (*Store)(nil)
+
+type outboxEvent struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Name          string             `bson:"name"`
+	Data          []byte             `bson:"data"`
+	ContentType   string             `bson:"contentType"`
+	CreatedAt     time.Time          `bson:"createdAt"`
+	Attempts      int                `bson:"attempts"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt"`
+	PublishedAt   *time.Time         `bson:"publishedAt"`
+}
+
+func (e *outboxEvent) event() *events.Event {
+	return &events.Event{
+		ID:          e.ID.Hex(),
+		Name:        e.Name,
+		Data:        e.Data,
+		ContentType: e.ContentType,
+		CreatedAt:   e.CreatedAt,
+		Attempts:    e.Attempts,
+	}
+}
+
+func (s *Store) AddEvent(ctx context.Context, e *events.Event) error {
+	doc := outboxEvent{
+		Name:          e.Name,
+		Data:          e.Data,
+		ContentType:   e.ContentType,
+		CreatedAt:     e.CreatedAt,
+		NextAttemptAt: e.CreatedAt,
+	}
+	result, err := s.outbox.InsertOne(ctx, doc)
+	if err != nil {
+		return err
+	}
+	e.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return nil
+}
+
+// Codes of errors returned when a change stream can't be resumed.
+const (
+	invalidResumeTokenCode      = 260
+	changeStreamFatalErrorCode  = 280
+	changeStreamHistoryLostCode = 286
+)
+
+// WatchEvents streams events with a change stream of the outbox, which is in order of commits,
+// resume tokens are tokens of the change stream, they expire when the oplog no longer has their changes.
+func (s *Store) WatchEvents(ctx context.Context, resumeToken string) (EventStream, error) {
+	opts := options.ChangeStream()
+	if resumeToken != "" {
+		opts.SetStartAfter(bson.D{{Key: "_data", Value: resumeToken}})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}}}
+	cs, err := s.outbox.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, changeStreamError(err)
+	}
+	return &changeStream{cs}, nil
+}
+
+func changeStreamError(err error) error {
+	var se mongo.ServerError
+	switch {
+	case !errors.As(err, &se):
+		return err
+	case se.HasErrorCode(invalidResumeTokenCode):
+		return ErrNotFound
+	case se.HasErrorCode(changeStreamFatalErrorCode), se.HasErrorCode(changeStreamHistoryLostCode):
+		return ErrExpired
+	}
+	return err
+}
+
+type changeStream struct {
+	cs *mongo.ChangeStream
+}
+
+func (c *changeStream) Next(ctx context.Context) (*events.Event, string, error) {
+	if !c.cs.Next(ctx) {
+		if err := c.cs.Err(); err != nil {
+			return nil, "", changeStreamError(err)
+		}
+		return nil, "", ctx.Err()
+	}
+	var change struct {
+		FullDocument outboxEvent `bson:"fullDocument"`
+	}
+	if err := c.cs.Decode(&change); err != nil {
+		return nil, "", err
+	}
+	token, ok := c.cs.ResumeToken().Lookup("_data").StringValueOK()
+	if !ok {
+		return nil, "", errors.New("change stream resume token has no _data")
+	}
+	return change.FullDocument.event(), token, nil
+}
+
+func (c *changeStream) Close(ctx context.Context) error {
+	return c.cs.Close(ctx)
+}
+
+func (s *Store) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*events.Event, error) {
+	var evts []*events.Event
+	// Events are claimed one by one, because mongodb can't atomically find and update many documents.
+	for len(evts) < limit {
+		now := time.Now()
+		filter := bson.D{
+			{Key: "publishedAt", Value: nil},
+			{Key: "nextAttemptAt", Value: bson.D{{Key: "$lte", Value: now}}},
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "nextAttemptAt", Value: now.Add(lease)}}}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}})
+		var e outboxEvent
+		err := s.outbox.FindOneAndUpdate(ctx, filter, update, opts).Decode(&e)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		evts = append(evts, e.event())
+	}
+	return evts, nil
+}
+
+func (s *Store) MarkEventPublished(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "publishedAt", Value: time.Now()}}}}
+	_, err = s.outbox.UpdateOne(ctx, bson.D{{Key: "_id", Value: oid}}, update)
+	return err
+}
+
+func (s *Store) MarkEventFailed(ctx context.Context, id string, retryAt time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "nextAttemptAt", Value: retryAt}}},
+	}
+	_, err = s.outbox.UpdateOne(ctx, bson.D{{Key: "_id", Value: oid}}, update)
+	return err
+}
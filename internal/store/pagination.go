@@ -2,9 +2,12 @@ package store
 
 import "go.mongodb.org/mongo-driver/mongo/options"
 
+// Pagination selects a page of a list either by its number or, when After is set,
+// as Size items following the cursor, in which case Page is ignored.
 type Pagination struct {
-	Page uint
-	Size uint
+	Page  uint
+	Size  uint
+	After *Cursor
 }
 
 // findOpts creates an options for mongodb's Find method.
@@ -12,7 +15,9 @@ func (p *Pagination) findOpts() *options.FindOptions {
 	opts := options.Find()
 	if p != nil {
 		opts.SetLimit(int64(p.Size))
-		opts.SetSkip(int64((p.Page - 1) * p.Size))
+		if p.After == nil {
+			opts.SetSkip(int64((p.Page - 1) * p.Size))
+		}
 	}
 	return opts
 }
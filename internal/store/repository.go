@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores users and their credentials.
+type UserRepository interface {
+	// GetUserByID returns a user, even a deleted one.
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	CountUsers(ctx context.Context, filter filtering.Expr) (int64, error)
+	ListUsers(ctx context.Context, filter filtering.Expr, order Order, p *Pagination) ([]*User, error)
+	// SearchUsers returns a page of users which aren't deleted matching a query, as described by SearchScore,
+	// the most relevant first, and a total number of matching users.
+	SearchUsers(ctx context.Context, query string, p *Pagination) ([]*User, int64, error)
+	CreateUser(ctx context.Context, user *User, password string) (*User, error)
+	// UpdateUser, DeleteUser and UndeleteUser change a user only when its version is equal to version,
+	// unless it's AnyVersion, and return ErrVersionMismatch otherwise.
+	// Users created before versions were introduced have version 0.
+	// Deleted users are not found by UpdateUser, DeleteUser, UpdatePassword and SetPassword.
+	UpdateUser(ctx context.Context, u *User, paths []string, version int64) (*User, error)
+	UpdatePassword(ctx context.Context, email, oldPassword, newPassword string) error
+	// GetUserByEmail returns a user which isn't deleted with the email.
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// SetPassword sets a password of a user without checking the old one, e.g. when it's reset.
+	SetPassword(ctx context.Context, id primitive.ObjectID, password string) error
+	// Authenticate returns a user which isn't deleted with the login, an email or a nickname, and the password.
+	// Returns ErrNotFound when there is no such user and ErrInvalidCreds when the password doesn't match,
+	// in which case the user is returned too, so failed checks can be counted.
+	Authenticate(ctx context.Context, login, password string) (*User, error)
+	// DeleteUser marks a user deleted and returns it, deleted users keep their email and nickname
+	// until they are purged, so they can always be undeleted.
+	DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*User, error)
+	// UndeleteUser restores a deleted user, returns ErrNotDeleted when it isn't deleted.
+	UndeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*User, error)
+	// PurgeUsers permanently removes up to limit users deleted before a given time, with their credentials, tokens and lockouts,
+	// and returns the number of removed users.
+	PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	Ping(ctx context.Context) error
+}
+
+// EventRepository is the outbox of events, also read by watchers.
+type EventRepository interface {
+	events.Outbox
+	// WatchEvents streams events in order of their commits, starting after the event of resumeToken,
+	// or with events committed after the call when it's empty.
+	// Returns ErrNotFound when resumeToken is invalid and ErrExpired when events after it could have been removed,
+	// the stream returns ErrExpired too when it falls that far behind.
+	WatchEvents(ctx context.Context, resumeToken string) (EventStream, error)
+}
+
+// TokenRepository stores refresh and one-time tokens, expired tokens are removed by stores.
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, t *RefreshToken) (*RefreshToken, error)
+	// UseRefreshToken revokes a token with the hash which isn't expired and returns it as it was before,
+	// so RevokedAt is set when the token was already used or revoked. Returns ErrNotFound when there is no such token.
+	UseRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	// RevokeRefreshTokens revokes all tokens of a family.
+	RevokeRefreshTokens(ctx context.Context, family primitive.ObjectID) error
+	// RevokeUserRefreshTokens revokes all tokens of a user, e.g. when its password changes.
+	RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error
+	// CreateOneTimeToken replaces other tokens of the user with the same purpose.
+	CreateOneTimeToken(ctx context.Context, t *OneTimeToken) (*OneTimeToken, error)
+	// UseOneTimeToken removes a token with the purpose and the hash which isn't expired and returns it,
+	// so a token can be used once. Returns ErrNotFound when there is no such token.
+	UseOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error)
+}
+
+// LockoutRepository stores lockouts of accounts and clients, expired lockouts are removed by stores.
+type LockoutRepository interface {
+	// GetLockout returns a lockout with the key which isn't expired. Returns ErrNotFound when there is no such lockout.
+	GetLockout(ctx context.Context, key string) (*Lockout, error)
+	// AddLockoutFailure counts a failed password check of a lockout, creating it when there is none,
+	// and returns the lockout. Failures of an expired lockout are forgotten first.
+	// ExpiresAt of the lockout is moved to expiresAt, unless it's later already.
+	AddLockoutFailure(ctx context.Context, key string, expiresAt time.Time) (*Lockout, error)
+	// LockOut locks a lockout until the time, forgetting its failures, and returns it.
+	LockOut(ctx context.Context, key string, until time.Time) (*Lockout, error)
+	// DeleteLockout removes a lockout, so its failures are forgotten and its lock is lifted, and returns it.
+	// Returns ErrNotFound when there is no such lockout which isn't expired.
+	DeleteLockout(ctx context.Context, key string) (*Lockout, error)
+}
+
+// TwoFactorRepository stores TOTP two-factor authentication of users with their credentials,
+// it's removed with them when they are purged.
+type TwoFactorRepository interface {
+	// GetTwoFactor returns two-factor authentication of a user, ErrNotFound when the user has none.
+	GetTwoFactor(ctx context.Context, id primitive.ObjectID) (*TwoFactor, error)
+	// SetTwoFactor replaces two-factor authentication of a user, ErrNotFound when the user has no credentials.
+	SetTwoFactor(ctx context.Context, id primitive.ObjectID, tf *TwoFactor) error
+	// DeleteTwoFactor removes two-factor authentication of a user, ErrNotFound when the user has none.
+	DeleteTwoFactor(ctx context.Context, id primitive.ObjectID) error
+	// UseTwoFactorStep sets LastStep of two-factor authentication of a user when the step is later,
+	// so each code can be used once, and returns ErrInvalidCreds otherwise or when the user has none.
+	UseTwoFactorStep(ctx context.Context, id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes a recovery code with the hash of a user, so it can be used once,
+	// and returns ErrInvalidCreds when the user has no such code.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error
+}
+
+// APIKeyRepository stores API keys of services calling the service.
+type APIKeyRepository interface {
+	// CreateAPIKey returns ErrAlreadyExists when a key with the same hash exists.
+	CreateAPIKey(ctx context.Context, k *APIKey) (*APIKey, error)
+	GetAPIKey(ctx context.Context, id primitive.ObjectID) (*APIKey, error)
+	// GetAPIKeyByHash returns a key with the hash, also when it's revoked or expired,
+	// ErrNotFound when there is no such key.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	CountAPIKeys(ctx context.Context) (int64, error)
+	ListAPIKeys(ctx context.Context, p *Pagination) ([]*APIKey, error)
+	// RevokeAPIKey revokes a key at the time, unless it's revoked already, and returns it.
+	// Returns ErrNotFound when there is no such key.
+	RevokeAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) (*APIKey, error)
+}
+
+// WebhookRepository stores webhooks and their delivery log.
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, w *Webhook) (*Webhook, error)
+	GetWebhook(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+	CountWebhooks(ctx context.Context) (int64, error)
+	ListWebhooks(ctx context.Context, p *Pagination) ([]*Webhook, error)
+	ListWebhooksByEvent(ctx context.Context, eventName string) ([]*Webhook, error)
+	DeleteWebhook(ctx context.Context, id primitive.ObjectID) error
+	AddWebhookDeliveries(ctx context.Context, ds []*WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	SaveWebhookDeliveryAttempt(ctx context.Context, d *WebhookDelivery) error
+	CountWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID) (int64, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, p *Pagination) ([]*WebhookDelivery, error)
+}
+
+// Repository is the whole storage of the service, implemented by the mongodb Store
+// and by other backends.
+type Repository interface {
+	UserRepository
+	EventRepository
+	TokenRepository
+	LockoutRepository
+	TwoFactorRepository
+	APIKeyRepository
+	WebhookRepository
+	// RunInTransaction runs fn in a transaction, changes made by fn are rolled back when it returns an error.
+	// Repository methods called with ctx passed to fn are part of the transaction.
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+var _ Repository = (*Store)(nil)
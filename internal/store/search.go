@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SearchCandidates is how many users backends fetch at most to rank them,
+	// users beyond that are not found.
+	SearchCandidates = 1000
+	// searchPrefixLen is a length of a prefix that should match for typos to be tolerated.
+	searchPrefixLen = 2
+)
+
+// Scores of a match of a search term and a field.
+const (
+	scoreExact  = 8
+	scorePrefix = 4
+	scoreWord   = 2
+	scoreFuzzy  = 1
+)
+
+// searchFields are fields users are searched by with weights of their matches.
+var searchFields = []struct {
+	name   string
+	weight int
+}{
+	{"nickname", 3},
+	{"first_name", 2},
+	{"last_name", 2},
+	{"email", 1},
+}
+
+// searchCollation makes comparisons case-insensitive.
+var searchCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// SearchTerms splits a search query into lowercase terms.
+func SearchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// SearchPrefix returns a prefix of a term fields should start with to match it,
+// apart from matches of whole words.
+func SearchPrefix(term string) string {
+	if utf8.RuneCountInString(term) <= searchPrefixLen {
+		return term
+	}
+	return string([]rune(term)[:searchPrefixLen])
+}
+
+// SearchScore returns relevance of a user for search terms, zero when any of terms doesn't match.
+// A term matches a field when the field, case-insensitively, is equal to it, starts with it,
+// has a word equal to it, or when it starts with the same two characters
+// and differs from the term or its prefix by one or two typos.
+func SearchScore(u *User, terms []string) int {
+	total := 0
+	for _, term := range terms {
+		best := 0
+		for _, f := range searchFields {
+			value := userField(u, f.name)
+			if value == nil {
+				continue
+			}
+			if score := matchScore(strings.ToLower(*value), term) * f.weight; score > best {
+				best = score
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+func matchScore(value, term string) int {
+	switch {
+	case value == "":
+		return 0
+	case value == term:
+		return scoreExact
+	case strings.HasPrefix(value, term):
+		return scorePrefix
+	}
+	for _, word := range strings.FieldsFunc(value, notAlnum) {
+		if word == term {
+			return scoreWord
+		}
+	}
+	t, v := []rune(term), []rune(value)
+	maxEdits := 1
+	if len(t) > 5 {
+		maxEdits = 2
+	}
+	if len(t) <= searchPrefixLen || !strings.HasPrefix(value, SearchPrefix(term)) {
+		return 0
+	}
+	if levenshtein(t, v) <= maxEdits || (len(v) > len(t) && levenshtein(t, v[:len(t)]) <= maxEdits) {
+		return scoreFuzzy
+	}
+	return 0
+}
+
+// notAlnum reports whether r separates words.
+func notAlnum(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// levenshtein returns the edit distance of a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// RankSearch returns a page of candidates matching search terms, the most relevant first,
+// and a total number of matching ones. Users with the same relevance are ordered by id.
+func RankSearch(candidates []*User, terms []string, p *Pagination) ([]*User, int64) {
+	type result struct {
+		user  *User
+		score int
+	}
+	var results []result
+	for _, u := range candidates {
+		if score := SearchScore(u, terms); score > 0 {
+			results = append(results, result{u, score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return DefaultOrder.Compare(results[i].user, results[j].user) < 0
+	})
+	lo, hi := 0, len(results)
+	if p != nil && p.Size > 0 {
+		lo = min(int((p.Page-1)*p.Size), len(results))
+		hi = min(lo+int(p.Size), len(results))
+	}
+	users := make([]*User, 0, hi-lo)
+	for _, r := range results[lo:hi] {
+		users = append(users, r.user)
+	}
+	return users, int64(len(results))
+}
+
+// SearchUsers finds candidates with case-insensitive ranges of prefixes of terms, served by collation indexes,
+// and with a text search of whole words, then ranks them.
+func (s *Store) SearchUsers(ctx context.Context, query string, p *Pagination) ([]*User, int64, error) {
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+	var prefixes bson.A
+	for _, term := range terms {
+		prefix := SearchPrefix(term)
+		for _, f := range searchFields {
+			prefixes = append(prefixes, bson.D{{Key: userFields[f.name], Value: bson.D{
+				{Key: "$gte", Value: prefix},
+				// U+FFFF sorts after all characters.
+				{Key: "$lt", Value: prefix + "\uffff"},
+			}}})
+		}
+	}
+	opts := options.Find().SetCollation(searchCollation).SetLimit(SearchCandidates)
+	candidates, err := s.findUsers(ctx, bson.D{{Key: "$or", Value: prefixes}, notDeleted}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	// words are passed without punctuation, which has a special meaning in text search.
+	search := strings.Join(strings.FieldsFunc(strings.Join(terms, " "), notAlnum), " ")
+	words, err := s.findUsers(ctx, bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: search}}}, notDeleted},
+		options.Find().SetLimit(SearchCandidates))
+	if err != nil {
+		return nil, 0, err
+	}
+	found := make(map[primitive.ObjectID]bool, len(candidates))
+	for _, u := range candidates {
+		found[u.ID] = true
+	}
+	for _, u := range words {
+		if !found[u.ID] {
+			candidates = append(candidates, u)
+		}
+	}
+	users, total := RankSearch(candidates, terms, p)
+	return users, total, nil
+}
+
+func (s *Store) findUsers(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]*User, error) {
+	cur, err := s.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var users []*User
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	return users, cur.Err()
+}
+
+func (s *Store) createSearchIndexes(ctx context.Context) error {
+	// Text index of whole words, without stemming of names.
+	text := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "nickname", Value: "text"},
+			{Key: "firstName", Value: "text"},
+			{Key: "lastName", Value: "text"},
+			{Key: "email", Value: "text"},
+		},
+		Options: options.Index().SetName("search_text").SetDefaultLanguage("none"),
+	}
+	indexes := []mongo.IndexModel{text}
+
+	// Case-insensitive indexes used to find users by prefixes,
+	// named explicitly because other indexes have the same keys.
+	for _, f := range searchFields {
+		key := userFields[f.name]
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: key, Value: 1}},
+			Options: options.Index().SetName("search_" + key).SetCollation(searchCollation),
+		})
+	}
+	_, err := s.users.Indexes().CreateMany(ctx, indexes)
+	return err
+}
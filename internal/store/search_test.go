@@ -0,0 +1,66 @@
+// +build unit
+
+package store
+
+import (
+	"testing"
+
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSearchScore(t *testing.T) {
+	u := &User{FirstName: "Jan", LastName: "Kowalski", Nickname: deref.StringAddr("JanK"), Email: "jan.kowalski@gmail.com"}
+	scores := map[string]int{
+		"jank":            scoreExact * 3,
+		"JA":              scorePrefix * 3,
+		"kowalski":        scoreExact * 2,
+		"gmail":           scoreWord,
+		"kowalsky":        scoreFuzzy * 2,
+		"kowlaski":        scoreFuzzy * 2,
+		"kowa jan":        scorePrefix*2 + scoreExact*2,
+		"jan smith":       0,
+		"kawalski":        0,
+		"kowalskiewicz":   0,
+		"jan.kowalski@gm": scorePrefix,
+	}
+	for query, want := range scores {
+		assert.Equal(t, want, SearchScore(u, SearchTerms(query)), query)
+	}
+	// users without nickname are matched by other fields.
+	assert.Equal(t, scoreExact*2, SearchScore(&User{FirstName: "Jan"}, SearchTerms("jan")))
+}
+
+func TestRankSearch(t *testing.T) {
+	users := []*User{
+		{ID: primitive.NewObjectID(), FirstName: "Jane", LastName: "Doe"},
+		{ID: primitive.NewObjectID(), FirstName: "John", LastName: "Doe"},
+		{ID: primitive.NewObjectID(), FirstName: "Jan", LastName: "Doe"},
+		{ID: primitive.NewObjectID(), FirstName: "Jane", LastName: "Doe"},
+	}
+	ranked, total := RankSearch(users, SearchTerms("jan"), nil)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []*User{users[2], users[0], users[3]}, ranked)
+
+	ranked, total = RankSearch(users, SearchTerms("jan"), &Pagination{Page: 2, Size: 2})
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []*User{users[3]}, ranked)
+
+	ranked, total = RankSearch(users, SearchTerms("jan"), &Pagination{Page: 3, Size: 2})
+	assert.Equal(t, int64(3), total)
+	assert.Empty(t, ranked)
+}
+
+func TestLevenshtein(t *testing.T) {
+	distances := map[[2]string]int{
+		{"", ""}:                 0,
+		{"", "abc"}:              3,
+		{"kitten", "sitting"}:    3,
+		{"kowalski", "kowlaski"}: 2,
+		{"żółw", "zółw"}:         1,
+	}
+	for s, want := range distances {
+		assert.Equal(t, want, levenshtein([]rune(s[0]), []rune(s[1])), s)
+	}
+}
@@ -0,0 +1,106 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const apiKeyColumns = "id, hash, name, owner, scopes, create_time, expire_time, revoke_time"
+
+func scanAPIKey(row scanner) (*store.APIKey, error) {
+	var k store.APIKey
+	var scopes string
+	err := row.Scan((*objectID)(&k.ID), &k.Hash, &k.Name, &k.Owner, &scopes, &k.CreatedAt,
+		nullTime{&k.ExpiresAt}, nullTime{&k.RevokedAt})
+	if err != nil {
+		return nil, err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, k *store.APIKey) (*store.APIKey, error) {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	b, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, err
+	}
+	id := k.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id.Hex(), k.Hash, k.Name, k.Owner, string(b), k.CreatedAt.UTC(), utcPtr(k.ExpiresAt), utcPtr(k.RevokedAt))
+	if _, ok := s.dialect.uniqueViolation(err); ok {
+		return nil, store.ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAPIKey(ctx, id)
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, id primitive.ObjectID) (*store.APIKey, error) {
+	return s.getAPIKey(ctx, "id", id.Hex())
+}
+
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (*store.APIKey, error) {
+	return s.getAPIKey(ctx, "hash", hash)
+}
+
+// getAPIKey returns a key which column has the value, column is never user input.
+func (s *Store) getAPIKey(ctx context.Context, column, value string) (*store.APIKey, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+column+` = $1`, value)
+	k, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *Store) CountAPIKeys(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM api_keys`).Scan(&count)
+	return count, err
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context, p *store.Pagination) ([]*store.APIKey, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`+limit(p))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []*store.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) (*store.APIKey, error) {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE api_keys SET revoke_time = $1 WHERE id = $2 AND revoke_time IS NULL`,
+		at.UTC(), id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAPIKey(ctx, id)
+}
@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+)
+
+const lockoutColumns = "id, failures, last_failure_time, lock_expire_time, expire_time"
+
+func scanLockout(row scanner) (*store.Lockout, error) {
+	var l store.Lockout
+	err := row.Scan(&l.Key, &l.Failures, nullTime{&l.LastFailureAt}, nullTime{&l.LockedUntil}, &l.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	l.ExpiresAt = l.ExpiresAt.UTC()
+	return &l, nil
+}
+
+// getLockout returns a lockout with the key, even when it's expired.
+func (s *Store) getLockout(ctx context.Context, key string) (*store.Lockout, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+lockoutColumns+` FROM lockouts WHERE id = $1`, key)
+	l, err := scanLockout(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *Store) GetLockout(ctx context.Context, key string) (*store.Lockout, error) {
+	l, err := s.getLockout(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !l.ExpiresAt.After(time.Now()) {
+		return nil, store.ErrNotFound
+	}
+	return l, nil
+}
+
+func (s *Store) AddLockoutFailure(ctx context.Context, key string, expiresAt time.Time) (*store.Lockout, error) {
+	var l *store.Lockout
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM lockouts WHERE expire_time <= $1`, now)
+		if err != nil {
+			return err
+		}
+		// the failure is counted by a single statement, so concurrent failures are all counted.
+		_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO lockouts (id, failures, last_failure_time, expire_time)
+			VALUES ($1, 1, $2, $3) ON CONFLICT (id) DO UPDATE SET
+			failures = lockouts.failures + 1,
+			last_failure_time = excluded.last_failure_time,
+			expire_time = CASE WHEN lockouts.expire_time > excluded.expire_time THEN lockouts.expire_time ELSE excluded.expire_time END`,
+			key, now, expiresAt.UTC())
+		if err != nil {
+			return err
+		}
+		l, err = s.getLockout(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *Store) LockOut(ctx context.Context, key string, until time.Time) (*store.Lockout, error) {
+	var l *store.Lockout
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO lockouts (id, failures, lock_expire_time, expire_time)
+			VALUES ($1, 0, $2, $2) ON CONFLICT (id) DO UPDATE SET
+			failures = 0, lock_expire_time = excluded.lock_expire_time, expire_time = excluded.expire_time`,
+			key, until.UTC())
+		if err != nil {
+			return err
+		}
+		l, err = s.getLockout(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *Store) DeleteLockout(ctx context.Context, key string) (*store.Lockout, error) {
+	var l *store.Lockout
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if l, err = s.getLockout(ctx, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `DELETE FROM lockouts WHERE id = $1`, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || !l.ExpiresAt.After(time.Now()) {
+		return nil, store.ErrNotFound
+	}
+	return l, nil
+}
@@ -0,0 +1,55 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrate applies migrations of the dialect which were not applied yet, each of them in a transaction.
+func (s *Store) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	names, err := fs.Glob(s.dialect.migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("invalid migration name %q: %w", name, err)
+		}
+		migration, err := fs.ReadFile(s.dialect.migrations, name)
+		if err != nil {
+			return err
+		}
+		err = s.RunInTransaction(ctx, func(ctx context.Context) error {
+			tx := s.tx(ctx)
+			if s.dialect.lockMigrations != "" {
+				if _, err := tx.ExecContext(ctx, s.dialect.lockMigrations); err != nil {
+					return err
+				}
+			}
+			var applied int
+			err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&applied)
+			if err != nil || applied > 0 {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, string(migration)); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %q failed: %w", name, err)
+		}
+	}
+	return nil
+}
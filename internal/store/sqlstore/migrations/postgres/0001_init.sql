@@ -0,0 +1,74 @@
+CREATE TABLE users (
+    id         CHAR(24) PRIMARY KEY,
+    first_name TEXT NOT NULL,
+    last_name  TEXT NOT NULL,
+    -- nulls are allowed, like in the partial unique index of mongodb.
+    nickname   TEXT UNIQUE,
+    email      TEXT NOT NULL UNIQUE,
+    country    TEXT NOT NULL
+);
+
+CREATE TABLE creds (
+    email    TEXT PRIMARY KEY,
+    password BYTEA NOT NULL
+);
+
+CREATE TABLE outbox (
+    id              CHAR(24) PRIMARY KEY,
+    -- seq numbers events in order of commits, it's taken from outbox_seq.
+    seq             BIGINT NOT NULL UNIQUE,
+    name            TEXT NOT NULL,
+    data            BYTEA,
+    content_type    TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    published_at    TIMESTAMPTZ
+);
+
+-- Index used by the relay to find undelivered events.
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at) WHERE published_at IS NULL;
+-- Index used to remove events after the retention period.
+CREATE INDEX outbox_published_at_idx ON outbox (published_at);
+
+-- outbox_seq has a single row with the number of the last event, transactions adding events
+-- wait for each other to update it, so events are numbered in order of commits.
+CREATE TABLE outbox_seq (
+    value BIGINT NOT NULL
+);
+INSERT INTO outbox_seq (value) VALUES (0);
+
+CREATE TABLE webhooks (
+    id           CHAR(24) PRIMARY KEY,
+    url          TEXT NOT NULL,
+    -- JSON array of event names, all events are sent when empty.
+    event_types  TEXT NOT NULL,
+    secret       TEXT NOT NULL,
+    content_mode TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id              CHAR(24) PRIMARY KEY,
+    webhook_id      CHAR(24) NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id        TEXT NOT NULL,
+    event_name      TEXT NOT NULL,
+    payload         BYTEA,
+    content_type    TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    response_code   INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL,
+    last_attempt_at TIMESTAMPTZ,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    -- Each event is delivered to a webhook once, even if it was relayed more than once.
+    UNIQUE (webhook_id, event_id)
+);
+
+-- Index used by the dispatcher to find pending deliveries.
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (status, next_attempt_at);
+-- Index used to list delivery log of a webhook.
+CREATE INDEX webhook_deliveries_log_idx ON webhook_deliveries (webhook_id, id DESC);
+-- Index used to remove deliveries after the retention period.
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries (created_at);
@@ -0,0 +1,17 @@
+-- Existing users get timestamps of their ids, which are ObjectIDs
+-- starting with 8 hex digits of seconds since the epoch.
+ALTER TABLE users
+    ADD COLUMN create_time TIMESTAMPTZ,
+    ADD COLUMN update_time TIMESTAMPTZ,
+    ADD COLUMN version     BIGINT NOT NULL DEFAULT 1;
+
+UPDATE users SET create_time = to_timestamp(('x' || substr(id, 1, 8))::bit(32)::bigint);
+UPDATE users SET update_time = create_time;
+
+ALTER TABLE users
+    ALTER COLUMN create_time SET NOT NULL,
+    ALTER COLUMN update_time SET NOT NULL;
+
+-- Indexes used to sort users, ties are broken by id.
+CREATE INDEX users_create_time_id_idx ON users (create_time, id);
+CREATE INDEX users_update_time_id_idx ON users (update_time, id);
@@ -0,0 +1,12 @@
+-- Credentials are linked to ids of users instead of their emails, which can change.
+-- Ones without a user can't be used, so they are not kept.
+CREATE TABLE user_creds (
+    user_id  CHAR(24) PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    password BYTEA NOT NULL
+);
+
+INSERT INTO user_creds (user_id, password)
+SELECT users.id, creds.password FROM creds JOIN users ON users.email = creds.email;
+
+DROP TABLE creds;
+ALTER TABLE user_creds RENAME TO creds;
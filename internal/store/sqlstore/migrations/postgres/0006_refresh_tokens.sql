@@ -0,0 +1,17 @@
+-- Refresh tokens are stored as hashes, tokens rotated from one authentication share a family.
+CREATE TABLE refresh_tokens (
+    id          CHAR(24) PRIMARY KEY,
+    hash        TEXT NOT NULL UNIQUE,
+    user_id     CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family      CHAR(24) NOT NULL,
+    create_time TIMESTAMPTZ NOT NULL,
+    expire_time TIMESTAMPTZ NOT NULL,
+    revoke_time TIMESTAMPTZ
+);
+
+-- Index used to revoke a family of tokens.
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
+-- Index used to remove tokens of purged users.
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+-- Index used to remove expired tokens.
+CREATE INDEX refresh_tokens_expire_time_idx ON refresh_tokens (expire_time);
@@ -0,0 +1,2 @@
+-- Existing users are regular users, admins are promoted with UpdateUser.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,15 @@
+-- One-time tokens confirm actions of users, e.g. password resets, they are stored as hashes
+-- and removed once they are used.
+CREATE TABLE one_time_tokens (
+    id          CHAR(24) PRIMARY KEY,
+    hash        TEXT NOT NULL UNIQUE,
+    user_id     CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose     TEXT NOT NULL,
+    create_time TIMESTAMPTZ NOT NULL,
+    expire_time TIMESTAMPTZ NOT NULL
+);
+
+-- Index used to replace tokens of a user, and to remove tokens of purged users.
+CREATE INDEX one_time_tokens_user_id_purpose_idx ON one_time_tokens (user_id, purpose);
+-- Index used to remove expired tokens.
+CREATE INDEX one_time_tokens_expire_time_idx ON one_time_tokens (expire_time);
@@ -0,0 +1,2 @@
+-- Emails of existing users were never verified.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
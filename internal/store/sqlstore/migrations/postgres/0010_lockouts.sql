@@ -0,0 +1,12 @@
+-- Lockouts count failed password checks of accounts, users/<id>, and of client IP addresses, clients/<ip>,
+-- which are locked for a while when there are too many of them.
+CREATE TABLE lockouts (
+    id                TEXT PRIMARY KEY,
+    failures          INTEGER NOT NULL,
+    last_failure_time TIMESTAMPTZ,
+    lock_expire_time  TIMESTAMPTZ,
+    expire_time       TIMESTAMPTZ NOT NULL
+);
+
+-- Index used to remove expired lockouts.
+CREATE INDEX lockouts_expire_time_idx ON lockouts (expire_time);
@@ -0,0 +1,12 @@
+-- API keys authenticate services calling the service, only hashes of keys are stored.
+CREATE TABLE api_keys (
+    id          CHAR(24) PRIMARY KEY,
+    hash        TEXT NOT NULL UNIQUE,
+    name        TEXT NOT NULL,
+    owner       TEXT NOT NULL,
+    -- JSON array of scopes.
+    scopes      TEXT NOT NULL,
+    create_time TIMESTAMPTZ NOT NULL,
+    expire_time TIMESTAMPTZ,
+    revoke_time TIMESTAMPTZ
+);
@@ -0,0 +1,7 @@
+-- Indexes used to sort users, ties are broken by id.
+-- Emails are unique, so unique index is enough to sort by email.
+-- Nulls come first in sqlite indexes, so users without nickname come first, like in mongodb.
+CREATE INDEX users_first_name_id_idx ON users (first_name, id);
+CREATE INDEX users_last_name_id_idx ON users (last_name, id);
+CREATE INDEX users_nickname_id_idx ON users (nickname, id);
+CREATE INDEX users_country_id_idx ON users (country, id);
@@ -0,0 +1,22 @@
+-- Existing users get timestamps of their ids, which are ObjectIDs
+-- starting with 8 hex digits of seconds since the epoch.
+-- Timestamps are stored in the format of the driver, so they are compared as strings.
+ALTER TABLE users ADD COLUMN create_time DATETIME NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN update_time DATETIME NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN version     INTEGER NOT NULL DEFAULT 1;
+
+UPDATE users SET create_time = datetime(
+    (((((((instr('0123456789abcdef', substr(id, 1, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 2, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 3, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 4, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 5, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 6, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 7, 1)) - 1) * 16
+    + instr('0123456789abcdef', substr(id, 8, 1)) - 1,
+    'unixepoch') || ' +0000 UTC';
+UPDATE users SET update_time = create_time;
+
+-- Indexes used to sort users, ties are broken by id.
+CREATE INDEX users_create_time_id_idx ON users (create_time, id);
+CREATE INDEX users_update_time_id_idx ON users (update_time, id);
@@ -0,0 +1,5 @@
+-- Deleted users are kept until they are purged.
+ALTER TABLE users ADD COLUMN delete_time DATETIME;
+
+-- Index used to sort users, ties are broken by id, and to find users to purge.
+CREATE INDEX users_delete_time_id_idx ON users (delete_time, id);
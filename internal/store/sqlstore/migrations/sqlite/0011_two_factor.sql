@@ -0,0 +1,12 @@
+-- TOTP two-factor authentication is kept with credentials, secrets are encrypted by the service.
+-- Users without a secret don't have two-factor authentication.
+ALTER TABLE creds ADD COLUMN totp_secret BLOB;
+ALTER TABLE creds ADD COLUMN totp_enable_time DATETIME;
+ALTER TABLE creds ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+-- Recovery codes are stored as hashes, each of them can be used once.
+CREATE TABLE recovery_codes (
+    user_id CHAR(24) NOT NULL REFERENCES creds (user_id) ON DELETE CASCADE,
+    hash    TEXT NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,121 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const eventColumns = "id, name, data, content_type, created_at, attempts"
+
+func scanEvents(rows *sql.Rows) ([]*events.Event, error) {
+	defer rows.Close()
+	var evts []*events.Event
+	for rows.Next() {
+		var e events.Event
+		if err := rows.Scan(&e.ID, &e.Name, &e.Data, &e.ContentType, &e.CreatedAt, &e.Attempts); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		evts = append(evts, &e)
+	}
+	return evts, rows.Err()
+}
+
+func (s *Store) AddEvent(ctx context.Context, e *events.Event) error {
+	id := primitive.NewObjectID().Hex()
+	// The transaction holds a lock of the outbox_seq row until it's committed.
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.conn(ctx).ExecContext(ctx, `UPDATE outbox_seq SET value = value + 1`); err != nil {
+			return err
+		}
+		var seq int64
+		if err := s.conn(ctx).QueryRowContext(ctx, `SELECT value FROM outbox_seq`).Scan(&seq); err != nil {
+			return err
+		}
+		_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO outbox (id, seq, name, data, content_type, created_at, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)`, id, seq, e.Name, e.Data, e.ContentType, e.CreatedAt.UTC())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// WatchEvents streams events by their numbers, see outbox_seq table.
+func (s *Store) WatchEvents(ctx context.Context, resumeToken string) (store.EventStream, error) {
+	return store.WatchSequence(ctx, s, resumeToken)
+}
+
+func (s *Store) LastEventSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT value FROM outbox_seq`).Scan(&seq)
+	return seq, err
+}
+
+func (s *Store) ListEventsAfter(ctx context.Context, seq int64, limit int) ([]*store.SequencedEvent, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT seq, `+eventColumns+` FROM outbox WHERE seq > $1 ORDER BY seq LIMIT $2`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var evts []*store.SequencedEvent
+	for rows.Next() {
+		e := store.SequencedEvent{Event: &events.Event{}}
+		if err := rows.Scan(&e.Seq, &e.ID, &e.Name, &e.Data, &e.ContentType, &e.CreatedAt, &e.Attempts); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		evts = append(evts, &e)
+	}
+	return evts, rows.Err()
+}
+
+func (s *Store) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*events.Event, error) {
+	var evts []*events.Event
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		// Published events are removed after the retention period.
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM outbox WHERE published_at < $1`, now.Add(-store.OutboxRetention))
+		if err != nil {
+			return err
+		}
+		rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+eventColumns+` FROM outbox
+			WHERE published_at IS NULL AND next_attempt_at <= $1 ORDER BY id LIMIT $2`+s.dialect.forUpdate, now, limit)
+		if err != nil {
+			return err
+		}
+		if evts, err = scanEvents(rows); err != nil || len(evts) == 0 {
+			return err
+		}
+		ids := make([]string, len(evts))
+		for i, e := range evts {
+			ids[i] = e.ID
+		}
+		placeholders, args := in(ids, now.Add(lease))
+		_, err = s.conn(ctx).ExecContext(ctx, `UPDATE outbox SET next_attempt_at = $1 WHERE id IN (`+placeholders+`)`, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return evts, nil
+}
+
+func (s *Store) MarkEventPublished(ctx context.Context, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE outbox SET published_at = $1 WHERE id = $2`, time.Now().UTC(), id)
+	return err
+}
+
+func (s *Store) MarkEventFailed(ctx context.Context, id string, retryAt time.Time) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $1 WHERE id = $2`, retryAt.UTC(), id)
+	return err
+}
@@ -0,0 +1,37 @@
+package sqlstore
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Postgres is a PostgreSQL dialect, dsn is in a format accepted by github.com/lib/pq.
+var Postgres = Dialect{
+	driver:         "postgres",
+	migrations:     subFS(postgresMigrations, "migrations/postgres"),
+	lockMigrations: "SELECT pg_advisory_xact_lock(4242)",
+	forUpdate:      " FOR UPDATE SKIP LOCKED",
+	uniqueViolation: func(err error) (string, bool) {
+		var e *pq.Error
+		if !errors.As(err, &e) || e.Code.Name() != "unique_violation" {
+			return "", false
+		}
+		// Unique constraints have default names: <table>_<column>_key.
+		return strings.TrimSuffix(strings.TrimPrefix(e.Constraint, e.Table+"_"), "_key"), true
+	},
+}
+
+func subFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,43 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// SQLite is an embedded SQLite dialect, dsn is a path of the database file.
+// Connections are limited to one, so transactions are serialized
+// and other queries wait until a running transaction finishes.
+var SQLite = Dialect{
+	driver:     "sqlite",
+	migrations: subFS(sqliteMigrations, "migrations/sqlite"),
+	init: func(db *sql.DB) error {
+		db.SetMaxOpenConns(1)
+		// LIKE is made case sensitive, like prefix filters of other backends.
+		_, err := db.Exec(`PRAGMA foreign_keys = ON; PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL; PRAGMA case_sensitive_like = ON`)
+		return err
+	},
+	uniqueViolation: func(err error) (string, bool) {
+		var e *sqlite.Error
+		if !errors.As(err, &e) || e.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return "", false
+		}
+		// Message is in the format: UNIQUE constraint failed: <table>.<column> (2067).
+		const prefix = "UNIQUE constraint failed: "
+		msg := e.Error()
+		i := strings.Index(msg, prefix)
+		if i < 0 {
+			return "", false
+		}
+		column := strings.Fields(msg[i+len(prefix):])[0]
+		return column[strings.Index(column, ".")+1:], true
+	},
+}
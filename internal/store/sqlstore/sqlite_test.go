@@ -0,0 +1,799 @@
+// +build unit
+
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func openSQLite(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "usersvc.db")
+	s, err := Open(context.Background(), SQLite, path, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestSQLite_Users(t *testing.T) {
+	ctx := context.Background()
+	s, path := openSQLite(t)
+	nickname := "jd"
+	john, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK", Nickname: &nickname}, "123456")
+	require.NoError(t, err)
+	jane, err := s.CreateUser(ctx, &store.User{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Country: "PL"}, "123456")
+	require.NoError(t, err)
+	assert.Nil(t, jane.Nickname)
+
+	_, err = s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Nickname: &nickname}, []string{"nickname"}, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	assert.Contains(t, err.Error(), "nickname 'jd'")
+	_, err = s.UpdateUser(ctx, &store.User{ID: primitive.NewObjectID(), Country: "DE"}, []string{"country"}, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	u, err := s.UpdateUser(ctx, &store.User{ID: john.ID, Country: "DE"}, []string{"country"}, store.AnyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, "DE", u.Country)
+	assert.Equal(t, "jd", *u.Nickname)
+
+	users, err := s.ListUsers(ctx, (&store.User{LastName: "Doe"}).Expr(), nil, &store.Pagination{Page: 2, Size: 1})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, jane.ID, users[0].ID)
+	users, err = s.ListUsers(ctx, (&store.User{LastName: "Doe"}).Expr(), nil, &store.Pagination{Page: 5, Size: 5, After: &store.Cursor{ID: john.ID}})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, jane.ID, users[0].ID)
+	order, err := store.ParseOrder("nickname desc, last_name")
+	require.NoError(t, err)
+	users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Page: 1, Size: 5})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(users), 2)
+	assert.Equal(t, john.ID, users[0].ID)
+	// users without nickname come last in descending order.
+	users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Size: 5, After: order.Cursor(users[0])})
+	require.NoError(t, err)
+	require.NotEmpty(t, users)
+	assert.Nil(t, users[len(users)-1].Nickname)
+
+	assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "", "654321"), store.ErrInvalidCreds)
+	assert.NoError(t, s.UpdatePassword(ctx, "jane@doe.com", "123456", "654321"))
+	// credentials follow email changes.
+	_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Email: "jane@kowalski.com"}, []string{"email"}, store.AnyVersion)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "654321", "123456"), store.ErrNotFound)
+	assert.NoError(t, s.UpdatePassword(ctx, "jane@kowalski.com", "654321", "123456"))
+	_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Email: "jane@doe.com"}, []string{"email"}, store.AnyVersion)
+	require.NoError(t, err)
+	assert.NoError(t, s.UpdatePassword(ctx, "jane@doe.com", "123456", "654321"))
+	_, err = s.DeleteUser(ctx, jane.ID, store.AnyVersion)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.UpdatePassword(ctx, "jane@doe.com", "654321", "123456"), store.ErrNotFound)
+
+	// Data is kept and migrations are not applied again after reopening.
+	require.NoError(t, s.Close())
+	s, err = Open(ctx, SQLite, path, nil)
+	require.NoError(t, err)
+	defer s.Close()
+	filter, err := store.ParseUserFilter("-delete_time:*")
+	require.NoError(t, err)
+	count, err := s.CountUsers(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSQLite_DeleteUsers(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	nickname := "jd"
+	john, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK", Nickname: &nickname}, "123456")
+	require.NoError(t, err)
+	jane, err := s.CreateUser(ctx, &store.User{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Country: "PL"}, "123456")
+	require.NoError(t, err)
+
+	deleted, err := s.DeleteUser(ctx, john.ID, john.Version)
+	require.NoError(t, err)
+	require.NotNil(t, deleted.DeletedAt)
+	assert.Equal(t, john.Version+1, deleted.Version)
+	_, err = s.DeleteUser(ctx, john.ID, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.UpdateUser(ctx, &store.User{ID: john.ID, Country: "DE"}, []string{"country"}, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.UpdatePassword(ctx, "john@doe.com", "123456", "654321"), store.ErrNotFound)
+	_, err = s.Authenticate(ctx, "jd", "123456")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	got, err := s.GetUserByID(ctx, john.ID)
+	require.NoError(t, err)
+	assert.Equal(t, deleted, got)
+
+	// deleted users keep their email and nickname.
+	_, err = s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	_, err = s.UpdateUser(ctx, &store.User{ID: jane.ID, Nickname: &nickname}, []string{"nickname"}, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+
+	users, _, err := s.SearchUsers(ctx, "doe", nil)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, jane.ID, users[0].ID)
+	for filter, want := range map[string][]primitive.ObjectID{
+		"delete_time:*":  {john.ID},
+		"-delete_time:*": {jane.ID},
+		"delete_time < " + strconv.Quote(time.Now().Add(time.Minute).Format(time.RFC3339)): {john.ID},
+		"delete_time != " + strconv.Quote(time.Now().Format(time.RFC3339)):                 {john.ID, jane.ID},
+	} {
+		e, err := store.ParseUserFilter(filter)
+		require.NoError(t, err, filter)
+		users, err := s.ListUsers(ctx, e, nil, nil)
+		require.NoError(t, err, filter)
+		var got []primitive.ObjectID
+		for _, u := range users {
+			got = append(got, u.ID)
+		}
+		assert.Equal(t, want, got, filter)
+	}
+	// users which aren't deleted come first.
+	order, err := store.ParseOrder("delete_time")
+	require.NoError(t, err)
+	users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Size: 1})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, jane.ID, users[0].ID)
+	users, err = s.ListUsers(ctx, nil, order, &store.Pagination{Size: 1, After: order.Cursor(users[0])})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, john.ID, users[0].ID)
+
+	_, err = s.UndeleteUser(ctx, jane.ID, store.AnyVersion)
+	assert.ErrorIs(t, err, store.ErrNotDeleted)
+	_, err = s.UndeleteUser(ctx, john.ID, john.Version)
+	assert.ErrorIs(t, err, store.ErrVersionMismatch)
+	restored, err := s.UndeleteUser(ctx, john.ID, deleted.Version)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, deleted.Version+1, restored.Version)
+	assert.NoError(t, s.UpdatePassword(ctx, "john@doe.com", "123456", "654321"))
+
+	_, err = s.DeleteUser(ctx, john.ID, store.AnyVersion)
+	require.NoError(t, err)
+	n, err := s.PurgeUsers(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = s.GetUserByID(ctx, john.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	// email of a purged user can be taken again.
+	_, err = s.CreateUser(ctx, &store.User{Email: "john@doe.com", Nickname: &nickname}, "")
+	assert.NoError(t, err)
+}
+
+func TestSQLite_UserVersions(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	u, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+	assert.Equal(t, u.CreatedAt, u.UpdatedAt)
+	assert.WithinDuration(t, time.Now(), u.CreatedAt, time.Minute)
+	assert.Equal(t, store.RoleUser, u.Role)
+
+	updated, err := s.UpdateUser(ctx, &store.User{ID: u.ID, Country: "PL", Role: store.RoleAdmin}, []string{"country", "role"}, store.AnyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+	assert.Equal(t, store.RoleAdmin, updated.Role)
+	assert.Equal(t, u.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.UpdatedAt.After(u.UpdatedAt))
+
+	// stale versions are rejected without writing.
+	_, err = s.UpdateUser(ctx, &store.User{ID: u.ID, Country: "DE"}, []string{"country"}, u.Version)
+	assert.ErrorIs(t, err, store.ErrVersionMismatch)
+	_, err = s.DeleteUser(ctx, u.ID, u.Version)
+	assert.ErrorIs(t, err, store.ErrVersionMismatch)
+	_, err = s.UpdateUser(ctx, &store.User{ID: primitive.NewObjectID()}, nil, 1)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	got, err := s.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, updated, got)
+
+	for filter, want := range map[string]int64{
+		"version > 1": 1,
+		`version = 2 AND create_time < "2100-01-01T00:00:00+02:00"`:             1,
+		"update_time <= " + strconv.Quote(u.UpdatedAt.Format(time.RFC3339Nano)): 0,
+	} {
+		e, err := store.ParseUserFilter(filter)
+		require.NoError(t, err, filter)
+		count, err := s.CountUsers(ctx, e)
+		require.NoError(t, err, filter)
+		assert.Equal(t, want, count, filter)
+	}
+}
+
+func TestSQLite_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	nickname := "jd"
+	john, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK", Nickname: &nickname}, "123456")
+	require.NoError(t, err)
+
+	for _, login := range []string{"john@doe.com", "jd"} {
+		u, err := s.Authenticate(ctx, login, "123456")
+		require.NoError(t, err, login)
+		assert.Equal(t, john, u, login)
+	}
+	_, err = s.Authenticate(ctx, "john@doe.com", "654321")
+	assert.ErrorIs(t, err, store.ErrInvalidCreds)
+	_, err = s.Authenticate(ctx, "jane@doe.com", "123456")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestSQLite_Rehash(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	s.hasher = password.Bcrypt{Cost: bcrypt.MinCost}
+	john, err := s.CreateUser(ctx, &store.User{FirstName: "John", LastName: "Doe", Email: "john@doe.com", Country: "UK"}, "123456")
+	require.NoError(t, err)
+	hash := func() []byte {
+		var hash []byte
+		require.NoError(t, s.db.QueryRowContext(ctx, `SELECT password FROM creds WHERE user_id = $1`, john.ID.Hex()).Scan(&hash))
+		return hash
+	}
+	bcryptHash := hash()
+
+	s.hasher = password.Argon2id{Memory: 1024, Threads: 1}
+	_, err = s.Authenticate(ctx, "john@doe.com", "654321")
+	assert.ErrorIs(t, err, store.ErrInvalidCreds)
+	assert.Equal(t, bcryptHash, hash(), "hash shouldn't be replaced without the password")
+	_, err = s.Authenticate(ctx, "john@doe.com", "123456")
+	require.NoError(t, err)
+	argon2idHash := hash()
+	assert.True(t, bytes.HasPrefix(argon2idHash, []byte("$argon2id$v=19$m=1024,t=1,p=1$")), string(argon2idHash))
+
+	// hashes with outdated parameters are replaced too.
+	s.hasher = password.Argon2id{Memory: 2048, Threads: 1}
+	_, err = s.Authenticate(ctx, "john@doe.com", "123456")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(hash(), []byte("$argon2id$v=19$m=2048,t=1,p=1$")))
+}
+
+func TestSQLite_RefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	family := primitive.NewObjectID()
+	first, err := s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "1", UserID: u.ID, Family: family, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "2", UserID: u.ID, Family: family, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "expired", UserID: u.ID, Family: family, ExpiresAt: time.Now()})
+	require.NoError(t, err)
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "3", UserID: primitive.NewObjectID(), ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Error(t, err, "tokens belong to existing users")
+
+	used, err := s.UseRefreshToken(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, first, used)
+	used, err = s.UseRefreshToken(ctx, "1")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+	_, err = s.UseRefreshToken(ctx, "expired")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.RevokeRefreshTokens(ctx, family))
+	used, err = s.UseRefreshToken(ctx, "2")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+
+	// tokens of other families of the user are revoked with all of its tokens.
+	_, err = s.CreateRefreshToken(ctx, &store.RefreshToken{Hash: "4", UserID: u.ID, Family: primitive.NewObjectID(), ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeUserRefreshTokens(ctx, u.ID))
+	used, err = s.UseRefreshToken(ctx, "4")
+	require.NoError(t, err)
+	assert.NotNil(t, used.RevokedAt)
+
+	// tokens are purged with their users.
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.UseRefreshToken(ctx, "2")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestSQLite_OneTimeTokens(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	found, err := s.GetUserByEmail(ctx, "john@doe.com")
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, found.ID)
+	_, err = s.GetUserByEmail(ctx, "jane@doe.com")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	token := func(hash string, expiresAt time.Time) *store.OneTimeToken {
+		return &store.OneTimeToken{Hash: hash, UserID: u.ID, Purpose: store.PurposePasswordReset, ExpiresAt: expiresAt}
+	}
+	_, err = s.CreateOneTimeToken(ctx, token("replaced", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	first, err := s.CreateOneTimeToken(ctx, token("1", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.False(t, first.ID.IsZero())
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "replaced")
+	assert.ErrorIs(t, err, store.ErrNotFound, "only the latest token can be used")
+	_, err = s.UseOneTimeToken(ctx, "other", "1")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	used, err := s.UseOneTimeToken(ctx, store.PurposePasswordReset, "1")
+	require.NoError(t, err)
+	assert.Equal(t, first, used)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "1")
+	assert.ErrorIs(t, err, store.ErrNotFound, "tokens can be used once")
+	_, err = s.CreateOneTimeToken(ctx, token("expired", time.Now()))
+	require.NoError(t, err)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "expired")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.SetPassword(ctx, u.ID, "654321"))
+	_, err = s.Authenticate(ctx, "john@doe.com", "654321")
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetPassword(ctx, primitive.NewObjectID(), "654321"), store.ErrNotFound)
+
+	// tokens are purged with their users, deleted users can't set passwords.
+	_, err = s.CreateOneTimeToken(ctx, token("2", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetPassword(ctx, u.ID, "123456"), store.ErrNotFound)
+	_, err = s.GetUserByEmail(ctx, "john@doe.com")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.UseOneTimeToken(ctx, store.PurposePasswordReset, "2")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestSQLite_Lockouts(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	key := store.UserLockoutKey(u.ID)
+	_, err = s.GetLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	l, err := s.AddLockoutFailure(ctx, key, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Failures)
+	assert.NotNil(t, l.LastFailureAt)
+	l, err = s.AddLockoutFailure(ctx, key, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Failures)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), l.ExpiresAt, time.Minute, "expiration shouldn't be shortened")
+	found, err := s.GetLockout(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, l, found)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond).UTC()
+	l, err = s.LockOut(ctx, key, until)
+	require.NoError(t, err)
+	assert.Equal(t, 0, l.Failures)
+	assert.True(t, l.IsLocked(time.Now()))
+	assert.True(t, until.Equal(*l.LockedUntil))
+	l, err = s.DeleteLockout(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, l.IsLocked(time.Now()))
+	_, err = s.DeleteLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// failures of expired lockouts are forgotten.
+	client := store.ClientLockoutKey("127.0.0.1")
+	_, err = s.AddLockoutFailure(ctx, client, time.Now())
+	require.NoError(t, err)
+	_, err = s.GetLockout(ctx, client)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	l, err = s.AddLockoutFailure(ctx, client, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Failures)
+
+	// lockouts are purged with their users.
+	_, err = s.AddLockoutFailure(ctx, key, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.GetLockout(ctx, key)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.GetLockout(ctx, client)
+	assert.NoError(t, err)
+}
+
+func TestSQLite_TwoFactor(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	u, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "123456")
+	require.NoError(t, err)
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.DeleteTwoFactor(ctx, u.ID), store.ErrNotFound)
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 1), store.ErrInvalidCreds)
+	assert.ErrorIs(t, s.SetTwoFactor(ctx, primitive.NewObjectID(), &store.TwoFactor{Secret: []byte("secret")}), store.ErrNotFound)
+
+	enabledAt := time.Now().Truncate(time.Millisecond).UTC()
+	tf := &store.TwoFactor{Secret: []byte("secret"), EnabledAt: &enabledAt, LastStep: 10, RecoveryCodes: []string{"a", "b"}}
+	require.NoError(t, s.SetTwoFactor(ctx, u.ID, tf))
+	found, err := s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, tf.Secret, found.Secret)
+	assert.True(t, enabledAt.Equal(*found.EnabledAt))
+	assert.Equal(t, tf.LastStep, found.LastStep)
+	assert.ElementsMatch(t, tf.RecoveryCodes, found.RecoveryCodes)
+
+	// steps and recovery codes can be used once.
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 10), store.ErrInvalidCreds)
+	require.NoError(t, s.UseTwoFactorStep(ctx, u.ID, 11))
+	assert.ErrorIs(t, s.UseTwoFactorStep(ctx, u.ID, 11), store.ErrInvalidCreds)
+	require.NoError(t, s.UseRecoveryCode(ctx, u.ID, "a"))
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, u.ID, "a"), store.ErrInvalidCreds)
+	found, err = s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), found.LastStep)
+	assert.Equal(t, []string{"b"}, found.RecoveryCodes)
+
+	// changing the password keeps two-factor authentication.
+	require.NoError(t, s.SetPassword(ctx, u.ID, "654321"))
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteTwoFactor(ctx, u.ID))
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, u.ID, "b"), store.ErrInvalidCreds)
+
+	// two-factor authentication is purged with its user.
+	require.NoError(t, s.SetTwoFactor(ctx, u.ID, tf))
+	_, err = s.DeleteUser(ctx, u.ID, store.AnyVersion)
+	require.NoError(t, err)
+	_, err = s.PurgeUsers(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	_, err = s.GetTwoFactor(ctx, u.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestSQLite_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond).UTC()
+	k, err := s.CreateAPIKey(ctx, &store.APIKey{Hash: "hash", Name: "mailer", Owner: "team-mail",
+		Scopes: []string{"users.read"}, CreatedAt: time.Now(), ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	assert.Equal(t, "mailer", k.Name)
+	assert.Equal(t, []string{"users.read"}, k.Scopes)
+	assert.True(t, expiresAt.Equal(*k.ExpiresAt))
+	assert.True(t, k.IsValid(time.Now()))
+	assert.False(t, k.IsValid(expiresAt))
+	_, err = s.CreateAPIKey(ctx, &store.APIKey{Hash: "hash", Name: "other", CreatedAt: time.Now()})
+	assert.ErrorIs(t, err, store.ErrAlreadyExists)
+	other, err := s.CreateAPIKey(ctx, &store.APIKey{Hash: "other", Name: "other", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, other.Scopes)
+	assert.Nil(t, other.ExpiresAt)
+
+	found, err := s.GetAPIKeyByHash(ctx, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, k.ID, found.ID)
+	_, err = s.GetAPIKeyByHash(ctx, "unknown")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	count, err := s.CountAPIKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	keys, err := s.ListAPIKeys(ctx, &store.Pagination{Page: 2, Size: 1})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, other.ID, keys[0].ID)
+
+	// revoked keys are kept, revoking them again keeps the time they were revoked.
+	revokedAt := time.Now().Truncate(time.Millisecond).UTC()
+	revoked, err := s.RevokeAPIKey(ctx, k.ID, revokedAt)
+	require.NoError(t, err)
+	assert.True(t, revokedAt.Equal(*revoked.RevokedAt))
+	assert.False(t, revoked.IsValid(time.Now()))
+	revoked, err = s.RevokeAPIKey(ctx, k.ID, revokedAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, revokedAt.Equal(*revoked.RevokedAt))
+	found, err = s.GetAPIKeyByHash(ctx, "hash")
+	require.NoError(t, err)
+	assert.NotNil(t, found.RevokedAt)
+	_, err = s.RevokeAPIKey(ctx, primitive.NewObjectID(), revokedAt)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+// Users existing before timestamps were introduced get timestamps of their ids.
+// legacySQLite returns SQLite dialect with only the named migrations, as in previous versions of the service.
+func legacySQLite(t *testing.T, names ...string) Dialect {
+	legacy := SQLite
+	migrations := fstest.MapFS{}
+	for _, name := range names {
+		data, err := fs.ReadFile(SQLite.migrations, name)
+		require.NoError(t, err)
+		migrations[name] = &fstest.MapFile{Data: data}
+	}
+	legacy.migrations = migrations
+	return legacy
+}
+
+func TestSQLite_UserVersionsMigration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "usersvc.db")
+	s, err := Open(ctx, legacySQLite(t, "0001_init.sql", "0002_users_order.sql"), path, nil)
+	require.NoError(t, err)
+	id := primitive.NewObjectIDFromTimestamp(time.Date(2021, 5, 1, 12, 30, 0, 0, time.UTC))
+	_, err = s.db.ExecContext(ctx, `INSERT INTO users (id, first_name, last_name, email, country) VALUES ($1, 'John', 'Doe', 'john@doe.com', 'UK')`, id.Hex())
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = Open(ctx, SQLite, path, nil)
+	require.NoError(t, err)
+	defer s.Close()
+	u, err := s.GetUserByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, id.Timestamp().Equal(u.CreatedAt), u.CreatedAt)
+	assert.True(t, id.Timestamp().Equal(u.UpdatedAt), u.UpdatedAt)
+	assert.Equal(t, int64(1), u.Version)
+}
+
+func TestSQLite_CredsMigration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "usersvc.db")
+	s, err := Open(ctx, legacySQLite(t, "0001_init.sql", "0002_users_order.sql", "0003_users_versions.sql", "0004_users_delete_time.sql"), path, nil)
+	require.NoError(t, err)
+	id := primitive.NewObjectID()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO users (id, first_name, last_name, email, country) VALUES ($1, 'John', 'Doe', 'john@doe.com', 'UK')`, id.Hex())
+	require.NoError(t, err)
+	hash, err := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	require.NoError(t, err)
+	// credentials of a user and ones left without a user.
+	_, err = s.db.ExecContext(ctx, `INSERT INTO creds (email, password) VALUES ('john@doe.com', $1), ('jane@doe.com', $1)`, hash)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = Open(ctx, SQLite, path, nil)
+	require.NoError(t, err)
+	defer s.Close()
+	var count int
+	require.NoError(t, s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM creds`).Scan(&count))
+	assert.Equal(t, 1, count)
+	assert.ErrorIs(t, s.UpdatePassword(ctx, "john@doe.com", "654321", "123456"), store.ErrInvalidCreds)
+	assert.NoError(t, s.UpdatePassword(ctx, "john@doe.com", "123456", "654321"))
+}
+
+// Filters match the same users as in memory, which is a counterpart of mongodb filters.
+func TestSQLite_ListUsersFilter(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	nicknames := []*string{nil, deref.StringAddr("pro_1"), deref.StringAddr("Pro2"), deref.StringAddr("")}
+	var users []*store.User
+	for i, nickname := range nicknames {
+		u, err := s.CreateUser(ctx, &store.User{
+			FirstName: []string{"John", "Jane", "Jan", "Mary Ann"}[i],
+			LastName:  []string{"Doe", "Doe", "Kowalski", "Smith"}[i],
+			Nickname:  nickname,
+			Email:     fmt.Sprintf("user%d@doe.com", i),
+			Country:   []string{"UK", "PL", "DE", "PL"}[i],
+		}, "")
+		require.NoError(t, err)
+		users = append(users, u)
+	}
+	verified, err := s.UpdateUser(ctx, &store.User{ID: users[2].ID, EmailVerified: true}, []string{"email_verified"}, store.AnyVersion)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	users[2] = verified
+
+	filters := []string{
+		"country = PL OR country = DE",
+		"(country = PL OR country = DE) AND NOT nickname:pro",
+		"nickname:pro_ OR nickname:Pro",
+		"nickname:*",
+		"-nickname:*",
+		`nickname != "" last_name < Kz`,
+		"NOT nickname = pro_1",
+		"NOT nickname >= a",
+		`first_name = "Mary Ann"`,
+		"email:user OR email:USER",
+		"id > " + users[1].ID.Hex() + " OR id = " + users[0].ID.Hex(),
+		"version = 1 AND create_time > " + strconv.Quote(users[1].CreatedAt.Format(time.RFC3339Nano)),
+		"update_time <= " + strconv.Quote(users[1].UpdatedAt.In(time.FixedZone("", 3600)).Format(time.RFC3339Nano)),
+		"version >= 10",
+		"email_verified = true",
+		"email_verified != true AND country = PL",
+	}
+	for _, filter := range filters {
+		e, err := store.ParseUserFilter(filter)
+		require.NoError(t, err, filter)
+		var want []primitive.ObjectID
+		for _, u := range users {
+			if store.MatchesFilter(e, u) {
+				want = append(want, u.ID)
+			}
+		}
+		count, err := s.CountUsers(ctx, e)
+		require.NoError(t, err, filter)
+		assert.Equal(t, int64(len(want)), count, filter)
+		listed, err := s.ListUsers(ctx, e, nil, nil)
+		require.NoError(t, err, filter)
+		var got []primitive.ObjectID
+		for _, u := range listed {
+			got = append(got, u.ID)
+		}
+		assert.Equal(t, want, got, filter)
+	}
+}
+
+func TestSQLite_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	nicknames := []*string{nil, deref.StringAddr("Pro_1"), deref.StringAddr("jd"), deref.StringAddr("")}
+	var users []*store.User
+	for i, nickname := range nicknames {
+		u, err := s.CreateUser(ctx, &store.User{
+			FirstName: []string{"John", "Jane", "Jan", "Mary Ann"}[i],
+			LastName:  []string{"Doe", "Doe", "Kowalski", "Smith"}[i],
+			Nickname:  nickname,
+			Email:     fmt.Sprintf("user%d@doe.com", i),
+			Country:   "PL",
+		}, "")
+		require.NoError(t, err)
+		users = append(users, u)
+	}
+
+	// candidates found by sql are the same as all users matching the query.
+	queries := []string{"ja", "JAN", "doe", "kowalsky", "ann", "pro_", "100%", "user2@doe.com", "mary smyth"}
+	for _, query := range queries {
+		want, total := store.RankSearch(users, store.SearchTerms(query), &store.Pagination{Page: 1, Size: 2})
+		got, count, err := s.SearchUsers(ctx, query, &store.Pagination{Page: 1, Size: 2})
+		require.NoError(t, err, query)
+		assert.Equal(t, total, count, query)
+		assert.Equal(t, want, got, query)
+	}
+}
+
+func TestSQLite_RunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	errRollback := errors.New("rollback")
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+		require.NoError(t, err)
+		// Failed create is rolled back to a savepoint, so the transaction can go on.
+		_, err = s.CreateUser(ctx, &store.User{Email: "john@doe.com"}, "")
+		assert.ErrorIs(t, err, store.ErrAlreadyExists)
+		err = s.RunInTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.CreateUser(ctx, &store.User{Email: "jane@doe.com"}, "")
+			require.NoError(t, err)
+			return errRollback
+		})
+		assert.ErrorIs(t, err, errRollback)
+		return s.AddEvent(ctx, &events.Event{Name: events.CreateUserEvent, CreatedAt: time.Now()})
+	})
+	require.NoError(t, err)
+
+	err = s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.CreateUser(ctx, &store.User{Email: "jan@kowalski.com"}, "")
+		require.NoError(t, err)
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	count, err := s.CountUsers(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	evts, err := s.ListEventsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, evts, 1)
+	seq, err := s.LastEventSeq(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), seq)
+}
+
+func TestSQLite_Outbox(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	var last string
+	for i := 0; i < 3; i++ {
+		e := &events.Event{Name: events.UpdateUserEvent, Data: []byte{byte(i)}, CreatedAt: time.Now()}
+		require.NoError(t, s.AddEvent(ctx, e))
+		last = e.ID
+	}
+	seq, err := s.LastEventSeq(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), seq)
+
+	claimed, err := s.ClaimEvents(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, []byte{0}, claimed[0].Data)
+	require.NoError(t, s.MarkEventPublished(ctx, claimed[0].ID))
+	require.NoError(t, s.MarkEventFailed(ctx, claimed[1].ID, time.Now()))
+
+	claimed, err = s.ClaimEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	assert.Equal(t, last, claimed[1].ID)
+	claimed, err = s.ClaimEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	evts, err := s.ListEventsAfter(ctx, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, evts, 2)
+	assert.Equal(t, []byte{0}, evts[0].Data)
+	evts, err = s.ListEventsAfter(ctx, evts[1].Seq, 10)
+	require.NoError(t, err)
+	require.Len(t, evts, 1)
+	assert.Equal(t, int64(3), evts[0].Seq)
+	assert.Equal(t, last, evts[0].ID)
+}
+
+func TestSQLite_Webhooks(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openSQLite(t)
+	all, err := s.CreateWebhook(ctx, &store.Webhook{URL: "https://example.com/all", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, all.EventTypes)
+	deletes, err := s.CreateWebhook(ctx, &store.Webhook{URL: "https://example.com/deletes", EventTypes: []string{events.DeleteUserEvent}, CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	webhooks, err := s.ListWebhooksByEvent(ctx, events.CreateUserEvent)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, all.ID, webhooks[0].ID)
+
+	now := time.Now()
+	d := &store.WebhookDelivery{WebhookID: deletes.ID, EventID: "1", Status: store.WebhookDeliveryPending, CreatedAt: now, NextAttemptAt: now}
+	// Deliveries of the same event are added once.
+	require.NoError(t, s.AddWebhookDeliveries(ctx, []*store.WebhookDelivery{d}))
+	require.NoError(t, s.AddWebhookDeliveries(ctx, []*store.WebhookDelivery{d}))
+	count, err := s.CountWebhookDeliveries(ctx, deletes.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	ds, err := s.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	ds[0].Status, ds[0].Attempts, ds[0].LastAttemptAt = store.WebhookDeliverySucceeded, 1, &now
+	require.NoError(t, s.SaveWebhookDeliveryAttempt(ctx, ds[0]))
+	ds, err = s.ListWebhookDeliveries(ctx, deletes.ID, nil)
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	assert.Equal(t, store.WebhookDeliverySucceeded, ds[0].Status)
+	assert.Equal(t, now.UnixNano(), ds[0].LastAttemptAt.UnixNano())
+
+	require.NoError(t, s.DeleteWebhook(ctx, deletes.ID))
+	assert.ErrorIs(t, s.DeleteWebhook(ctx, deletes.ID), store.ErrNotFound)
+	count, err = s.CountWebhookDeliveries(ctx, deletes.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
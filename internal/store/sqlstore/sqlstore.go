@@ -0,0 +1,212 @@
+// Package sqlstore implements store.Repository on top of SQL databases,
+// differences between them are described by a Dialect.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/multierr"
+)
+
+var _ store.Repository = (*Store)(nil)
+
+// Dialect describes a database supported by the store.
+type Dialect struct {
+	// driver is a name of database/sql driver.
+	driver string
+	// migrations contains numbered sql files, e.g. 0001_init.sql.
+	migrations fs.FS
+	// lockMigrations is a statement which serializes migrations of concurrently started instances.
+	lockMigrations string
+	// forUpdate is appended to queries selecting rows which are then updated in the same transaction.
+	forUpdate string
+	// init configures a new connection pool, optional.
+	init func(db *sql.DB) error
+	// uniqueViolation returns name of a column which unique constraint was violated.
+	uniqueViolation func(err error) (column string, ok bool)
+}
+
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	hasher  password.Hasher
+}
+
+// Open connects to a database and migrates its schema,
+// passwords are hashed with the hasher, password.DefaultHasher when it's nil.
+func Open(ctx context.Context, d Dialect, dsn string, hasher password.Hasher) (*Store, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if hasher == nil {
+		hasher = password.DefaultHasher
+	}
+	s := &Store{db, d, hasher}
+	if d.init != nil {
+		if err := d.init(db); err != nil {
+			return nil, multierr.Append(err, db.Close())
+		}
+	}
+	if err := s.migrate(ctx); err != nil {
+		return nil, multierr.Append(err, db.Close())
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping pings db with 3 seconds timeout.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+type tx struct {
+	*sql.Tx
+	s          *Store
+	savepoints int
+}
+
+func (s *Store) tx(ctx context.Context) *tx {
+	if t, ok := ctx.Value(txKey{}).(*tx); ok && t.s == s {
+		return t
+	}
+	return nil
+}
+
+// conn returns transaction found in ctx, or the database when there is none.
+func (s *Store) conn(ctx context.Context) querier {
+	if t := s.tx(ctx); t != nil {
+		return t
+	}
+	return s.db
+}
+
+// RunInTransaction runs fn in a transaction, changes made by fn are rolled back when it returns an error.
+// Nested transactions are rolled back on their own, using savepoints.
+func (s *Store) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t := s.tx(ctx); t != nil {
+		return t.savepoint(ctx, fn)
+	}
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(context.WithValue(ctx, txKey{}, &tx{Tx: sqlTx, s: s})); err != nil {
+		return multierr.Append(err, sqlTx.Rollback())
+	}
+	return sqlTx.Commit()
+}
+
+func (t *tx) savepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	t.savepoints++
+	name := fmt.Sprintf("sp%d", t.savepoints)
+	if _, err := t.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	if err := fn(ctx); err != nil {
+		_, rollbackErr := t.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		return multierr.Append(err, rollbackErr)
+	}
+	_, err := t.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// limit returns LIMIT and OFFSET clause of a page, empty when p is nil.
+func limit(p *store.Pagination) string {
+	if p == nil || p.Size == 0 {
+		return ""
+	}
+	offset := uint(0)
+	if p.After == nil && p.Page > 0 {
+		offset = (p.Page - 1) * p.Size
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", p.Size, offset)
+}
+
+// objectID scans hex encoded ids.
+type objectID primitive.ObjectID
+
+func (id *objectID) Scan(src interface{}) error {
+	var hex string
+	switch v := src.(type) {
+	case string:
+		hex = v
+	case []byte:
+		hex = string(v)
+	default:
+		return fmt.Errorf("unsupported id type: %T", src)
+	}
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return err
+	}
+	*id = objectID(oid)
+	return nil
+}
+
+// nullTime scans nullable timestamps into pointers.
+type nullTime struct {
+	t **time.Time
+}
+
+func (n nullTime) Scan(src interface{}) error {
+	var t sql.NullTime
+	if err := t.Scan(src); err != nil {
+		return err
+	}
+	*n.t = nil
+	if t.Valid {
+		utc := t.Time.UTC()
+		*n.t = &utc
+	}
+	return nil
+}
+
+// nullString scans nullable strings into pointers.
+type nullString struct {
+	s **string
+}
+
+func (n nullString) Scan(src interface{}) error {
+	var s sql.NullString
+	if err := s.Scan(src); err != nil {
+		return err
+	}
+	*n.s = nil
+	if s.Valid {
+		*n.s = &s.String
+	}
+	return nil
+}
+
+// in returns placeholders of ids which follow leading args, for use in an IN clause.
+func in(ids []string, leading ...interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := leading
+	for i, id := range ids {
+		args = append(args, id)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	return strings.Join(placeholders, ", "), args
+}
@@ -0,0 +1,79 @@
+// +build unit
+
+package sqlstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserWhere(t *testing.T) {
+	where, args := userWhere(nil)
+	assert.Empty(t, where)
+	assert.Empty(t, args)
+
+	empty := ""
+	where, args = userWhere((&store.User{LastName: "Doe", Nickname: &empty, Country: "UK"}).Expr())
+	assert.Equal(t, " WHERE (last_name = $1 AND country = $2)", where)
+	assert.Equal(t, []interface{}{"Doe", "UK"}, args)
+
+	filter, err := store.ParseUserFilter(`NOT (nickname != jd OR nickname:"1_%") country:* AND email > a`)
+	require.NoError(t, err)
+	where, args = userWhere(filter)
+	assert.Equal(t, ` WHERE ((NOT ((nickname IS NULL OR nickname <> $1) OR (nickname IS NOT NULL AND nickname LIKE $2 ESCAPE '\')) AND country IS NOT NULL) AND email > $3)`, where)
+	assert.Equal(t, []interface{}{"jd", `1\_\%%`, "a"}, args)
+}
+
+func TestLimit(t *testing.T) {
+	assert.Empty(t, limit(nil))
+	assert.Empty(t, limit(&store.Pagination{Page: 1}))
+	assert.Equal(t, " LIMIT 15 OFFSET 30", limit(&store.Pagination{Page: 3, Size: 15}))
+	assert.Equal(t, " LIMIT 15 OFFSET 0", limit(&store.Pagination{Page: 3, Size: 15, After: &store.Cursor{}}))
+}
+
+func TestIn(t *testing.T) {
+	placeholders, args := in([]string{"a", "b"}, 1)
+	assert.Equal(t, "$2, $3", placeholders)
+	assert.Equal(t, []interface{}{1, "a", "b"}, args)
+}
+
+func TestPostgresUniqueViolation(t *testing.T) {
+	err := fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Table: "users", Constraint: "users_nickname_key"})
+	column, ok := Postgres.uniqueViolation(err)
+	assert.True(t, ok)
+	assert.Equal(t, "nickname", column)
+
+	_, ok = Postgres.uniqueViolation(&pq.Error{Code: "23503", Table: "users"})
+	assert.False(t, ok)
+	_, ok = Postgres.uniqueViolation(errors.New("unique"))
+	assert.False(t, ok)
+	_, ok = Postgres.uniqueViolation(nil)
+	assert.False(t, ok)
+}
+
+func TestOrderBy(t *testing.T) {
+	o, err := store.ParseOrder("nickname desc, country")
+	require.NoError(t, err)
+	assert.Equal(t, " ORDER BY nickname DESC NULLS LAST, country ASC, id ASC", orderBy(o))
+}
+
+func TestAfter(t *testing.T) {
+	o, err := store.ParseOrder("nickname desc, country")
+	require.NoError(t, err)
+	nickname, country := "jd", "UK"
+	c := &store.Cursor{Key: []*string{&nickname, &country}, ID: primitive.NewObjectID()}
+	cond, args := after(o, c, []interface{}{"Doe"})
+	assert.Equal(t, "((nickname < $2 OR nickname IS NULL) OR nickname = $3 AND country > $4 OR nickname = $3 AND country = $5 AND id > $6)", cond)
+	assert.Equal(t, []interface{}{"Doe", "jd", "jd", "UK", "UK", c.ID.Hex()}, args)
+
+	c.Key[0] = nil
+	cond, _ = after(o, c, nil)
+	assert.Equal(t, "(nickname IS NULL AND country > $1 OR nickname IS NULL AND country = $2 AND id > $3)", cond)
+}
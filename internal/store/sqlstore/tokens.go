@@ -0,0 +1,139 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Store) CreateRefreshToken(ctx context.Context, t *store.RefreshToken) (*store.RefreshToken, error) {
+	c := *t
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = time.Now().UTC()
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Expired tokens can't be used, so they are removed.
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expire_time <= $1`, c.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO refresh_tokens
+			(id, hash, user_id, family, create_time, expire_time, revoke_time) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			c.ID.Hex(), c.Hash, c.UserID.Hex(), c.Family.Hex(), c.CreatedAt, c.ExpiresAt, utcPtr(c.RevokedAt))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Store) UseRefreshToken(ctx context.Context, hash string) (*store.RefreshToken, error) {
+	var t store.RefreshToken
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		result, err := s.conn(ctx).ExecContext(ctx, `UPDATE refresh_tokens SET revoke_time = $1
+			WHERE hash = $2 AND expire_time > $1 AND revoke_time IS NULL`, now, hash)
+		if err != nil {
+			return err
+		}
+		used, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		err = s.conn(ctx).QueryRowContext(ctx, `SELECT id, hash, user_id, family, create_time, expire_time, revoke_time
+			FROM refresh_tokens WHERE hash = $1 AND expire_time > $2`, hash, now).
+			Scan((*objectID)(&t.ID), &t.Hash, (*objectID)(&t.UserID), (*objectID)(&t.Family),
+				&t.CreatedAt, &t.ExpiresAt, nullTime{&t.RevokedAt})
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if used == 1 {
+			// the token is returned as it was before it was used.
+			t.RevokedAt = nil
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		t.ExpiresAt = t.ExpiresAt.UTC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Store) RevokeRefreshTokens(ctx context.Context, family primitive.ObjectID) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE refresh_tokens SET revoke_time = $1
+		WHERE family = $2 AND revoke_time IS NULL`, time.Now().UTC(), family.Hex())
+	return err
+}
+
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE refresh_tokens SET revoke_time = $1
+		WHERE user_id = $2 AND revoke_time IS NULL`, time.Now().UTC(), userID.Hex())
+	return err
+}
+
+func (s *Store) CreateOneTimeToken(ctx context.Context, t *store.OneTimeToken) (*store.OneTimeToken, error) {
+	c := *t
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = time.Now().UTC()
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Expired tokens and older tokens of the user are removed, so only the latest one can be used.
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM one_time_tokens
+			WHERE expire_time <= $1 OR user_id = $2 AND purpose = $3`, c.CreatedAt, c.UserID.Hex(), c.Purpose)
+		if err != nil {
+			return err
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO one_time_tokens
+			(id, hash, user_id, purpose, create_time, expire_time) VALUES ($1, $2, $3, $4, $5, $6)`,
+			c.ID.Hex(), c.Hash, c.UserID.Hex(), c.Purpose, c.CreatedAt, c.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Store) UseOneTimeToken(ctx context.Context, purpose, hash string) (*store.OneTimeToken, error) {
+	var t store.OneTimeToken
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		err := s.conn(ctx).QueryRowContext(ctx, `SELECT id, hash, user_id, purpose, create_time, expire_time
+			FROM one_time_tokens WHERE hash = $1 AND purpose = $2 AND expire_time > $3`, hash, purpose, now).
+			Scan((*objectID)(&t.ID), &t.Hash, (*objectID)(&t.UserID), &t.Purpose, &t.CreatedAt, &t.ExpiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		result, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM one_time_tokens WHERE id = $1`, t.ID.Hex())
+		if err != nil {
+			return err
+		}
+		// the token could be used by a concurrent transaction since it was selected.
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = store.ErrNotFound
+			}
+			return err
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		t.ExpiresAt = t.ExpiresAt.UTC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
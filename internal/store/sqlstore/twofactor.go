@@ -0,0 +1,112 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Store) GetTwoFactor(ctx context.Context, id primitive.ObjectID) (*store.TwoFactor, error) {
+	var tf store.TwoFactor
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT totp_secret, totp_enable_time, totp_last_step FROM creds
+		WHERE user_id = $1 AND totp_secret IS NOT NULL`, id.Hex()).Scan(&tf.Secret, nullTime{&tf.EnabledAt}, &tf.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT hash FROM recovery_codes WHERE user_id = $1 ORDER BY hash`, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		tf.RecoveryCodes = append(tf.RecoveryCodes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+func (s *Store) SetTwoFactor(ctx context.Context, id primitive.ObjectID, tf *store.TwoFactor) error {
+	return s.RunInTransaction(ctx, func(ctx context.Context) error {
+		result, err := s.conn(ctx).ExecContext(ctx, `UPDATE creds SET totp_secret = $1, totp_enable_time = $2, totp_last_step = $3
+			WHERE user_id = $4`, tf.Secret, utcPtr(tf.EnabledAt), tf.LastStep, id.Hex())
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return store.ErrNotFound
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, id.Hex())
+		if err != nil {
+			return err
+		}
+		for _, hash := range tf.RecoveryCodes {
+			_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, id.Hex(), hash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) DeleteTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	return s.RunInTransaction(ctx, func(ctx context.Context) error {
+		result, err := s.conn(ctx).ExecContext(ctx, `UPDATE creds SET totp_secret = NULL, totp_enable_time = NULL, totp_last_step = 0
+			WHERE user_id = $1 AND totp_secret IS NOT NULL`, id.Hex())
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return store.ErrNotFound
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, id.Hex())
+		return err
+	})
+}
+
+func (s *Store) UseTwoFactorStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	// the step is compared and set by a single statement, so concurrent uses of a code can't both succeed.
+	result, err := s.conn(ctx).ExecContext(ctx, `UPDATE creds SET totp_last_step = $1
+		WHERE user_id = $2 AND totp_secret IS NOT NULL AND totp_last_step < $1`, step, id.Hex())
+	return affectedOrInvalidCreds(result, err)
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	result, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2`, id.Hex(), hash)
+	return affectedOrInvalidCreds(result, err)
+}
+
+// affectedOrInvalidCreds returns store.ErrInvalidCreds when a statement which uses a code changed no rows.
+func affectedOrInvalidCreds(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrInvalidCreds
+	}
+	return nil
+}
@@ -0,0 +1,561 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const userColumns = "id, first_name, last_name, nickname, email, country, role, email_verified, create_time, update_time, version, delete_time"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*store.User, error) {
+	var u store.User
+	err := row.Scan((*objectID)(&u.ID), &u.FirstName, &u.LastName, nullString{&u.Nickname}, &u.Email, &u.Country, &u.Role,
+		&u.EmailVerified, &u.CreatedAt, &u.UpdatedAt, &u.Version, nullTime{&u.DeletedAt})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// userWhere creates a WHERE clause of the filter expression, like store.ParseUserFilter describes.
+// Columns have the same names as fields of the filter.
+func userWhere(filter filtering.Expr) (string, []interface{}) {
+	if filter == nil {
+		return "", nil
+	}
+	cond, args := userCond(filter, nil)
+	return " WHERE " + cond, args
+}
+
+// userCond creates a condition of the filter expression. Conditions of nullable nickname and delete_time
+// are never NULL, so negations match users without them like in mongodb.
+func userCond(e filtering.Expr, args []interface{}) (string, []interface{}) {
+	var left, right string
+	switch e := e.(type) {
+	case *filtering.And:
+		left, args = userCond(e.Left, args)
+		right, args = userCond(e.Right, args)
+		return "(" + left + " AND " + right + ")", args
+	case *filtering.Or:
+		left, args = userCond(e.Left, args)
+		right, args = userCond(e.Right, args)
+		return "(" + left + " OR " + right + ")", args
+	case *filtering.Not:
+		left, args = userCond(e.Expr, args)
+		return "NOT " + left, args
+	case *filtering.Restriction:
+		column := e.Field
+		value := store.FieldValue(e.Field, e.Value)
+		op := string(e.Op)
+		switch e.Op {
+		case filtering.NotEqual:
+			op = "<>"
+		case filtering.Has:
+			if e.Value == "" {
+				return column + " IS NOT NULL", args
+			}
+			op = "LIKE"
+			value = likeEscaper.Replace(e.Value) + "%"
+		}
+		args = append(args, value)
+		cond := fmt.Sprintf("%s %s $%d", column, op, len(args))
+		if e.Op == filtering.Has {
+			cond += ` ESCAPE '\'`
+		}
+		if nullable(column) {
+			if e.Op == filtering.NotEqual {
+				return "(" + column + " IS NULL OR " + cond + ")", args
+			}
+			return "(" + column + " IS NOT NULL AND " + cond + ")", args
+		}
+		return cond, args
+	}
+	return "TRUE", args
+}
+
+// nullable reports whether a column of a field can be NULL.
+func nullable(column string) bool {
+	return column == "nickname" || column == "delete_time"
+}
+
+// likeEscaper escapes wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// orderBy creates ORDER BY clause, users without nickname or delete time come first
+// in ascending order like in other backends.
+func orderBy(o store.Order) string {
+	var parts []string
+	for _, f := range o {
+		part := f.Field + " ASC"
+		if f.Desc {
+			part = f.Field + " DESC"
+		}
+		if nullable(f.Field) {
+			if f.Desc {
+				part += " NULLS LAST"
+			} else {
+				part += " NULLS FIRST"
+			}
+		}
+		parts = append(parts, part)
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+// after creates a condition selecting users following the cursor in order o:
+// users with greater first column, or with the same first column and greater second one and so on.
+// Columns are ones of fields accepted by store.ParseOrder, they have the same names.
+func after(o store.Order, c *store.Cursor, args []interface{}) (string, []interface{}) {
+	var or, equal []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	for i, f := range o {
+		if f.Field == "id" {
+			op := " > "
+			if f.Desc {
+				op = " < "
+			}
+			or = append(or, strings.Join(append(equal, "id"+op+arg(c.ID.Hex())), " AND "))
+			break
+		}
+		var v *string
+		if i < len(c.Key) {
+			v = c.Key[i]
+		}
+		value := func(v string) string {
+			return arg(store.FieldValue(f.Field, v))
+		}
+		switch {
+		case v == nil && f.Desc:
+			// nothing comes after nulls in descending order.
+		case v == nil:
+			or = append(or, strings.Join(append(equal, f.Field+" IS NOT NULL"), " AND "))
+		case f.Desc:
+			or = append(or, strings.Join(append(equal, "("+f.Field+" < "+value(*v)+" OR "+f.Field+" IS NULL)"), " AND "))
+		default:
+			or = append(or, strings.Join(append(equal, f.Field+" > "+value(*v)), " AND "))
+		}
+		if v == nil {
+			equal = append(equal, f.Field+" IS NULL")
+		} else {
+			equal = append(equal, f.Field+" = "+value(*v))
+		}
+	}
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+// and adds a condition to the where clause.
+func and(where, cond string) string {
+	if where == "" {
+		return " WHERE " + cond
+	}
+	return where + " AND " + cond
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id primitive.ObjectID) (*store.User, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id.Hex())
+	u, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Store) CountUsers(ctx context.Context, filter filtering.Expr) (int64, error) {
+	where, args := userWhere(filter)
+	var count int64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context, filter filtering.Expr, order store.Order, p *store.Pagination) ([]*store.User, error) {
+	if len(order) == 0 {
+		order = store.DefaultOrder
+	}
+	where, args := userWhere(filter)
+	if p != nil && p.After != nil {
+		var cond string
+		cond, args = after(order, p.After, args)
+		where = and(where, cond)
+	}
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users`+where+orderBy(order)+limit(p), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*store.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// searchColumns are columns users are searched by.
+var searchColumns = []string{"nickname", "first_name", "last_name", "email"}
+
+// SearchUsers finds candidates which have a column starting with a prefix of a term
+// or containing a term, then ranks them.
+func (s *Store) SearchUsers(ctx context.Context, query string, p *store.Pagination) ([]*store.User, int64, error) {
+	terms := store.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+	var conds []string
+	var args []interface{}
+	for _, term := range terms {
+		args = append(args, likeEscaper.Replace(store.SearchPrefix(term))+"%", "%"+likeEscaper.Replace(term)+"%")
+		for _, column := range searchColumns {
+			conds = append(conds, fmt.Sprintf(`LOWER(%[1]s) LIKE $%[2]d ESCAPE '\' OR LOWER(%[1]s) LIKE $%[3]d ESCAPE '\'`,
+				column, len(args)-1, len(args)))
+		}
+	}
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE delete_time IS NULL AND (`+
+		strings.Join(conds, " OR ")+fmt.Sprintf(`) ORDER BY id LIMIT %d`, store.SearchCandidates), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var candidates []*store.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		candidates = append(candidates, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	users, total := store.RankSearch(candidates, terms, p)
+	return users, total, nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *store.User, password string) (*store.User, error) {
+	var u *store.User
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		id := user.ID
+		if id.IsZero() {
+			id = primitive.NewObjectID()
+		}
+		role := user.Role
+		if role == "" {
+			role = store.RoleUser
+		}
+		now := time.Now().UTC()
+		_, err := s.conn(ctx).ExecContext(ctx,
+			`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 1, NULL)`,
+			id.Hex(), user.FirstName, user.LastName, user.Nickname, user.Email, user.Country, role, user.EmailVerified, now, now)
+		if _, ok := s.dialect.uniqueViolation(err); ok {
+			return store.ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.registerUser(ctx, id, password); err != nil {
+			return err
+		}
+		u, err = s.GetUserByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// registerUser sets password of a user, credentials are kept under the user's id,
+// so they don't change with its email.
+func (s *Store) registerUser(ctx context.Context, id primitive.ObjectID, password string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO creds (user_id, password) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET password = excluded.password`, id.Hex(), hash)
+	return err
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*store.User, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1 AND delete_time IS NULL`, email)
+	u, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Store) SetPassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	return s.RunInTransaction(ctx, func(ctx context.Context) error {
+		var exists bool
+		err := s.conn(ctx).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND delete_time IS NULL)`,
+			id.Hex()).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return store.ErrNotFound
+		}
+		return s.registerUser(ctx, id, password)
+	})
+}
+
+func (s *Store) Authenticate(ctx context.Context, login, password string) (*store.User, error) {
+	column := "nickname"
+	if store.IsEmailLogin(login) {
+		column = "email"
+	}
+	var id primitive.ObjectID
+	var hash []byte
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT users.id, creds.password FROM users
+		JOIN creds ON creds.user_id = users.id WHERE users.`+column+` = $1 AND users.delete_time IS NULL`, login).
+		Scan((*objectID)(&id), &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.matchesPassword(ctx, id, hash, password)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return u, store.ErrInvalidCreds
+	}
+	return u, nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, email, oldPassword, newPassword string) error {
+	var id primitive.ObjectID
+	var hash []byte
+	// credentials of deleted users are kept until they are purged.
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT users.id, creds.password FROM users
+		JOIN creds ON creds.user_id = users.id WHERE users.email = $1 AND users.delete_time IS NULL`, email).
+		Scan((*objectID)(&id), &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	matches, err := s.matchesPassword(ctx, id, hash, oldPassword)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return store.ErrInvalidCreds
+	}
+	return s.registerUser(ctx, id, newPassword)
+}
+
+// matchesPassword reports whether a password matches the hash of a user's password. When it does, but the hash
+// was created by another algorithm or with other parameters than the hasher's, it's replaced with a new hash.
+func (s *Store) matchesPassword(ctx context.Context, id primitive.ObjectID, hash []byte, pass string) (bool, error) {
+	matches, err := password.Verify(pass, hash)
+	if err != nil || !matches {
+		return false, err
+	}
+	if s.hasher.NeedsRehash(hash) {
+		newHash, err := s.hasher.Hash(pass)
+		if err != nil {
+			return false, err
+		}
+		// the hash is replaced only if the password wasn't changed meanwhile.
+		_, err = s.conn(ctx).ExecContext(ctx, `UPDATE creds SET password = $1 WHERE user_id = $2 AND password = $3`,
+			newHash, id.Hex(), hash)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// versionCond creates a condition of the version, unless it's store.AnyVersion.
+func versionCond(version int64, args []interface{}) (string, []interface{}) {
+	if version == store.AnyVersion {
+		return "", args
+	}
+	args = append(args, version)
+	return fmt.Sprintf(" AND version = $%d", len(args)), args
+}
+
+// deletedCond creates a condition of a deleted or not deleted user.
+func deletedCond(deleted bool) string {
+	if deleted {
+		return " AND delete_time IS NOT NULL"
+	}
+	return " AND delete_time IS NULL"
+}
+
+// missingUser returns store.ErrNotFound when a user doesn't exist or is deleted, store.ErrNotDeleted
+// when it isn't deleted and deleted one was expected, and store.ErrVersionMismatch otherwise.
+// It's called when a statement with deletedCond and versionCond didn't affect any user.
+func (s *Store) missingUser(ctx context.Context, id primitive.ObjectID, deleted bool) error {
+	u, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.DeletedAt != nil && !deleted:
+		return store.ErrNotFound
+	case u.DeletedAt == nil && deleted:
+		return store.ErrNotDeleted
+	}
+	return store.ErrVersionMismatch
+}
+
+// UpdateUser updates a user, the version is checked in the same statement, so it's atomic.
+func (s *Store) UpdateUser(ctx context.Context, u *store.User, paths []string, version int64) (*store.User, error) {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	for _, path := range paths {
+		switch path {
+		case "first_name":
+			set("first_name", u.FirstName)
+		case "last_name":
+			set("last_name", u.LastName)
+		case "nickname":
+			set("nickname", u.Nickname)
+		case "email":
+			set("email", u.Email)
+		case "country":
+			set("country", u.Country)
+		case "role":
+			set("role", u.Role)
+		case "email_verified":
+			set("email_verified", u.EmailVerified)
+		}
+	}
+	set("update_time", time.Now().UTC())
+	sets = append(sets, "version = version + 1")
+	args = append(args, u.ID.Hex())
+	query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args)) + deletedCond(false)
+	var cond string
+	cond, args = versionCond(version, args)
+	query += cond
+	result, err := s.conn(ctx).ExecContext(ctx, query, args...)
+	if column, ok := s.dialect.uniqueViolation(err); ok {
+		switch column {
+		case "email":
+			return nil, fmt.Errorf("user with email '%s' %w", u.Email, store.ErrAlreadyExists)
+		case "nickname":
+			return nil, fmt.Errorf("user with nickname '%s' %w", deref.String(u.Nickname), store.ErrAlreadyExists)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, s.missingUser(ctx, u.ID, false)
+	}
+	return s.GetUserByID(ctx, u.ID)
+}
+
+// DeleteUser marks a user deleted, returns the deleted user.
+func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*store.User, error) {
+	now := time.Now().UTC()
+	return s.setDeleted(ctx, id, version, false, &now)
+}
+
+// UndeleteUser restores a deleted user.
+func (s *Store) UndeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*store.User, error) {
+	return s.setDeleted(ctx, id, version, true, nil)
+}
+
+// setDeleted sets delete time of a user which is deleted or not, and returns the updated user.
+// The version is checked in the same statement, so it's atomic.
+func (s *Store) setDeleted(ctx context.Context, id primitive.ObjectID, version int64, deleted bool, deleteTime *time.Time) (*store.User, error) {
+	cond, args := versionCond(version, []interface{}{deleteTime, time.Now().UTC(), id.Hex()})
+	result, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET delete_time = $1, update_time = $2, version = version + 1
+		WHERE id = $3`+deletedCond(deleted)+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, s.missingUser(ctx, id, deleted)
+	}
+	return s.GetUserByID(ctx, id)
+}
+
+// PurgeUsers removes users deleted before a given time in a transaction, their credentials and tokens are removed
+// by cascade and their lockouts explicitly.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	purged := 0
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		rows, err := s.conn(ctx).QueryContext(ctx, `SELECT id FROM users WHERE delete_time < $1
+			ORDER BY delete_time, id LIMIT $2`, deletedBefore.UTC(), limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+		placeholders, args := in(ids)
+		result, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM users WHERE id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		purged = int(n)
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = "users/" + id // store.UserLockoutKey of the id
+		}
+		placeholders, args = in(keys)
+		_, err = s.conn(ctx).ExecContext(ctx, `DELETE FROM lockouts WHERE id IN (`+placeholders+`)`, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
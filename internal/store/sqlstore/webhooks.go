@@ -0,0 +1,238 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	webhookColumns         = "id, url, event_types, secret, content_mode, created_at"
+	webhookDeliveryColumns = "id, webhook_id, event_id, event_name, payload, content_type, status, attempts, " +
+		"response_code, last_error, created_at, last_attempt_at, next_attempt_at"
+)
+
+func scanWebhook(row scanner) (*store.Webhook, error) {
+	var w store.Webhook
+	var eventTypes string
+	err := row.Scan((*objectID)(&w.ID), &w.URL, &eventTypes, &w.Secret, &w.ContentMode, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	w.CreatedAt = w.CreatedAt.UTC()
+	if err := json.Unmarshal([]byte(eventTypes), &w.EventTypes); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func scanWebhooks(rows *sql.Rows) ([]*store.Webhook, error) {
+	defer rows.Close()
+	var webhooks []*store.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]*store.WebhookDelivery, error) {
+	defer rows.Close()
+	var ds []*store.WebhookDelivery
+	for rows.Next() {
+		var d store.WebhookDelivery
+		err := rows.Scan((*objectID)(&d.ID), (*objectID)(&d.WebhookID), &d.EventID, &d.EventName, &d.Payload,
+			&d.ContentType, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.CreatedAt,
+			nullTime{&d.LastAttemptAt}, &d.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		d.CreatedAt, d.NextAttemptAt = d.CreatedAt.UTC(), d.NextAttemptAt.UTC()
+		ds = append(ds, &d)
+	}
+	return ds, rows.Err()
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *store.Webhook) (*store.Webhook, error) {
+	eventTypes := w.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	b, err := json.Marshal(eventTypes)
+	if err != nil {
+		return nil, err
+	}
+	id := w.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO webhooks (`+webhookColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		id.Hex(), w.URL, string(b), w.Secret, w.ContentMode, w.CreatedAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return s.GetWebhook(ctx, id)
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id primitive.ObjectID) (*store.Webhook, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id.Hex())
+	w, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *Store) CountWebhooks(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM webhooks`).Scan(&count)
+	return count, err
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, p *store.Pagination) ([]*store.Webhook, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`+limit(p))
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+// ListWebhooksByEvent returns all webhooks subscribed to the event.
+func (s *Store) ListWebhooksByEvent(ctx context.Context, eventName string) ([]*store.Webhook, error) {
+	// There are few webhooks, so they're filtered here instead of querying event types in a dialect specific way.
+	all, err := s.ListWebhooks(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []*store.Webhook
+	for _, w := range all {
+		subscribed := len(w.EventTypes) == 0
+		for _, t := range w.EventTypes {
+			subscribed = subscribed || t == eventName
+		}
+		if subscribed {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes webhook together with its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	return s.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id.Hex())
+		if err != nil {
+			return err
+		}
+		result, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id.Hex())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return store.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// AddWebhookDeliveries schedules deliveries, ignoring those which already exist for the same webhook and event.
+func (s *Store) AddWebhookDeliveries(ctx context.Context, ds []*store.WebhookDelivery) error {
+	return s.RunInTransaction(ctx, func(ctx context.Context) error {
+		for _, d := range ds {
+			id := d.ID
+			if id.IsZero() {
+				id = primitive.NewObjectID()
+			}
+			_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO webhook_deliveries (`+webhookDeliveryColumns+`)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+				ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+				id.Hex(), d.WebhookID.Hex(), d.EventID, d.EventName, d.Payload, d.ContentType, d.Status, d.Attempts,
+				d.ResponseCode, d.LastError, d.CreatedAt.UTC(), utcPtr(d.LastAttemptAt), d.NextAttemptAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries which are due,
+// and hides them from other dispatchers for the lease duration.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*store.WebhookDelivery, error) {
+	var ds []*store.WebhookDelivery
+	err := s.RunInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		// Delivery log is removed after the retention period.
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1`,
+			now.Add(-store.WebhookDeliveryRetention))
+		if err != nil {
+			return err
+		}
+		rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= $2 ORDER BY next_attempt_at LIMIT $3`+s.dialect.forUpdate,
+			store.WebhookDeliveryPending, now, limit)
+		if err != nil {
+			return err
+		}
+		if ds, err = scanWebhookDeliveries(rows); err != nil || len(ds) == 0 {
+			return err
+		}
+		ids := make([]string, len(ds))
+		for i, d := range ds {
+			d.NextAttemptAt = now.Add(lease)
+			ids[i] = d.ID.Hex()
+		}
+		placeholders, args := in(ids, now.Add(lease))
+		_, err = s.conn(ctx).ExecContext(ctx,
+			`UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id IN (`+placeholders+`)`, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// SaveWebhookDeliveryAttempt stores an outcome of the last delivery attempt.
+func (s *Store) SaveWebhookDeliveryAttempt(ctx context.Context, d *store.WebhookDelivery) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE webhook_deliveries SET status = $1, attempts = $2,
+		response_code = $3, last_error = $4, last_attempt_at = $5, next_attempt_at = $6 WHERE id = $7`,
+		d.Status, d.Attempts, d.ResponseCode, d.LastError, utcPtr(d.LastAttemptAt), d.NextAttemptAt.UTC(), d.ID.Hex())
+	return err
+}
+
+func (s *Store) CountWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID) (int64, error) {
+	var count int64
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = $1`, webhookID.Hex()).Scan(&count)
+	return count, err
+}
+
+// ListWebhookDeliveries returns delivery log of a webhook, most recent deliveries first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, p *store.Pagination) ([]*store.WebhookDelivery, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = $1 ORDER BY id DESC`+limit(p), webhookID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// utcPtr converts an optional time to UTC, so it's stored in the same format by every driver.
+func utcPtr(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
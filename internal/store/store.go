@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/mlukasik-dev/usersvc/internal/password"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -14,19 +15,50 @@ var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
 	ErrInvalidCreds  = errors.New("invalid credentials")
+	// ErrVersionMismatch is returned when a user was changed since the version was read.
+	ErrVersionMismatch = errors.New("version doesn't match")
+	// ErrNotDeleted is returned when a user which isn't deleted is undeleted.
+	ErrNotDeleted = errors.New("not deleted")
+	// ErrExpired is returned when events after a resume token could have been removed from the outbox.
+	ErrExpired = errors.New("expired")
 )
 
+// AnyVersion is passed to UpdateUser, DeleteUser and UndeleteUser to change a user regardless of its version.
+const AnyVersion int64 = -1
+
 type Store struct {
 	client *mongo.Client
 	users  *mongo.Collection
 	creds  *mongo.Collection
+	outbox *mongo.Collection
+
+	refreshTokens *mongo.Collection
+	oneTimeTokens *mongo.Collection
+	lockouts      *mongo.Collection
+	apiKeys       *mongo.Collection
+
+	webhooks          *mongo.Collection
+	webhookDeliveries *mongo.Collection
+
+	hasher password.Hasher
 }
 
-func New(client *mongo.Client) *Store {
+// New creates a store of the client, passwords are hashed with the hasher, password.DefaultHasher when it's nil.
+func New(client *mongo.Client, hasher password.Hasher) *Store {
 	db := client.Database("usersvcdb")
 	users := db.Collection("users")
 	creds := db.Collection("creds")
-	return &Store{client, users, creds}
+	outbox := db.Collection("outbox")
+	refreshTokens := db.Collection("refresh_tokens")
+	oneTimeTokens := db.Collection("one_time_tokens")
+	lockouts := db.Collection("lockouts")
+	apiKeys := db.Collection("api_keys")
+	webhooks := db.Collection("webhooks")
+	webhookDeliveries := db.Collection("webhook_deliveries")
+	if hasher == nil {
+		hasher = password.DefaultHasher
+	}
+	return &Store{client, users, creds, outbox, refreshTokens, oneTimeTokens, lockouts, apiKeys, webhooks, webhookDeliveries, hasher}
 }
 
 func (s *Store) Client() *mongo.Client {
@@ -47,6 +79,14 @@ func (s *Store) WithTransaction(ctx context.Context, fn func(sessCtx mongo.Sessi
 	return session.WithTransaction(ctx, fn)
 }
 
+// RunInTransaction runs fn in a transaction, changes made by fn are rolled back when it returns an error.
+func (s *Store) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
 func (s *Store) CreateIndexes(ctx context.Context) error {
 	// Unique index for email field on users collection.
 	usersUniqueEmail := mongo.IndexModel{
@@ -62,19 +102,49 @@ func (s *Store) CreateIndexes(ctx context.Context) error {
 			SetPartialFilterExpression(bson.D{{Key: "nickname", Value: bson.D{{Key: "$type", Value: "string"}}}}),
 	}
 
-	_, err := s.users.Indexes().CreateMany(ctx, []mongo.IndexModel{usersUniqueEmail, usersUniqueNickname})
+	usersIndexes := []mongo.IndexModel{usersUniqueEmail, usersUniqueNickname}
+
+	// Indexes used to sort users, ties are broken by _id.
+	// Emails are unique, so unique index is enough to sort by email.
+	// Index of deletedAt is also used to find users to purge.
+	for _, key := range []string{"firstName", "lastName", "nickname", "country", "createdAt", "updatedAt", "deletedAt"} {
+		usersIndexes = append(usersIndexes, mongo.IndexModel{Keys: bson.D{{Key: key, Value: 1}, {Key: "_id", Value: 1}}})
+	}
+
+	_, err := s.users.Indexes().CreateMany(ctx, usersIndexes)
 	if err != nil {
 		return err
 	}
 
-	// Unique index for email field on creds collection.
-	credsUniqueEmail := mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
+	// Index used by the relay to find undelivered events.
+	outboxPending := mongo.IndexModel{
+		Keys: bson.D{{Key: "publishedAt", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
 	}
 
-	_, err = s.creds.Indexes().CreateMany(ctx, []mongo.IndexModel{credsUniqueEmail})
-	return err
+	// Published events are removed after the retention period.
+	outboxTTL := mongo.IndexModel{
+		Keys:    bson.D{{Key: "publishedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(OutboxRetention.Seconds())),
+	}
+
+	_, err = s.outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{outboxPending, outboxTTL})
+	if err != nil {
+		return err
+	}
+
+	if err := s.createSearchIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.createTokenIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.createLockoutIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.createAPIKeyIndexes(ctx); err != nil {
+		return err
+	}
+	return s.createWebhookIndexes(ctx)
 }
 
 // Ping pings db with 3 seconds timeout.
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshToken is a refresh token issued to a user, only a hash of the token is stored.
+// A refresh token is used once, it's rotated to a new one of the same family,
+// so a family is a chain of tokens issued from one authentication.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	Family    primitive.ObjectID `bson:"family"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	// RevokedAt is set when a token is used or revoked.
+	RevokedAt *time.Time `bson:"revokedAt"`
+}
+
+// Purposes of one-time tokens.
+const (
+	PurposePasswordReset     = "passwordReset"
+	PurposeEmailVerification = "emailVerification"
+)
+
+// OneTimeToken is a token sent to a user to confirm an action, e.g. a password reset or an email,
+// only a hash of the token is stored and the token is removed once it's used.
+type OneTimeToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	Purpose   string             `bson:"purpose"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+func (s *Store) createTokenIndexes(ctx context.Context) error {
+	tokensUniqueHash := mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// Index used to revoke a family of tokens.
+	tokensFamily := mongo.IndexModel{
+		Keys: bson.D{{Key: "family", Value: 1}},
+	}
+
+	// Index used to remove tokens of purged users.
+	tokensUser := mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	}
+
+	// Tokens are removed once they expire.
+	tokensTTL := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err := s.refreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		tokensUniqueHash, tokensFamily, tokensUser, tokensTTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Index used to replace tokens of a user, and to remove tokens of purged users.
+	oneTimeTokensUser := mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}},
+	}
+
+	_, err = s.oneTimeTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		tokensUniqueHash, oneTimeTokensUser, tokensTTL,
+	})
+	return err
+}
+
+func (s *Store) CreateRefreshToken(ctx context.Context, t *RefreshToken) (*RefreshToken, error) {
+	c := *t
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = time.Now()
+	if _, err := s.refreshTokens.InsertOne(ctx, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// UseRefreshToken revokes a token which isn't expired and returns it as it was before,
+// so RevokedAt of a token which was already used or revoked is set.
+func (s *Store) UseRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	now := time.Now()
+	filter := bson.D{{Key: "hash", Value: hash}, {Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: now}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: now}}}}
+	var t RefreshToken
+	err := s.refreshTokens.FindOneAndUpdate(ctx, append(filter, bson.E{Key: "revokedAt", Value: nil}), update).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the token was already revoked, or it doesn't exist.
+		err = s.refreshTokens.FindOne(ctx, filter).Decode(&t)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RevokeRefreshTokens revokes all tokens of a family which aren't revoked yet.
+func (s *Store) RevokeRefreshTokens(ctx context.Context, family primitive.ObjectID) error {
+	filter := bson.D{{Key: "family", Value: family}, {Key: "revokedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}}}
+	_, err := s.refreshTokens.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes all tokens of a user which aren't revoked yet.
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.D{{Key: "userId", Value: userID}, {Key: "revokedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}}}
+	_, err := s.refreshTokens.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// CreateOneTimeToken removes other tokens of the user with the same purpose, so only the latest one can be used.
+func (s *Store) CreateOneTimeToken(ctx context.Context, t *OneTimeToken) (*OneTimeToken, error) {
+	c := *t
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = time.Now()
+	_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		_, err := s.oneTimeTokens.DeleteMany(sessCtx, bson.D{{Key: "userId", Value: c.UserID}, {Key: "purpose", Value: c.Purpose}})
+		if err != nil {
+			return nil, err
+		}
+		return s.oneTimeTokens.InsertOne(sessCtx, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// UseOneTimeToken removes a token with the purpose which isn't expired and returns it.
+func (s *Store) UseOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error) {
+	filter := bson.D{
+		{Key: "hash", Value: hash},
+		{Key: "purpose", Value: purpose},
+		// the TTL monitor removes expired tokens only once a minute.
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+	var t OneTimeToken
+	err := s.oneTimeTokens.FindOneAndDelete(ctx, filter).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TwoFactor is TOTP two-factor authentication of a user, kept with its credentials.
+type TwoFactor struct {
+	// Secret is the TOTP secret encrypted by the service, stores never see it in plain text.
+	Secret []byte `bson:"secret"`
+	// EnabledAt is set once the enrollment is confirmed with a code, until then codes aren't required.
+	EnabledAt *time.Time `bson:"enabledAt"`
+	// LastStep is the time step of the last used code, so each code can be used once.
+	LastStep int64 `bson:"lastStep"`
+	// RecoveryCodes are hashes of unused recovery codes.
+	RecoveryCodes []string `bson:"recoveryCodes"`
+}
+
+func (s *Store) GetTwoFactor(ctx context.Context, id primitive.ObjectID) (*TwoFactor, error) {
+	var c creds
+	err := s.creds.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.TwoFactor == nil {
+		return nil, ErrNotFound
+	}
+	return c.TwoFactor, nil
+}
+
+func (s *Store) SetTwoFactor(ctx context.Context, id primitive.ObjectID, tf *TwoFactor) error {
+	result, err := s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: bson.D{{Key: "twoFactor", Value: tf}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "twoFactor", Value: bson.D{{Key: "$ne", Value: nil}}}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "twoFactor", Value: ""}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) UseTwoFactorStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	// the step is compared and set by a single update, so concurrent uses of a code can't both succeed.
+	result, err := s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "twoFactor.lastStep", Value: bson.D{{Key: "$lt", Value: step}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "twoFactor.lastStep", Value: step}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidCreds
+	}
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	result, err := s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "twoFactor.recoveryCodes", Value: hash}},
+		bson.D{{Key: "$pull", Value: bson.D{{Key: "twoFactor.recoveryCodes", Value: hash}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidCreds
+	}
+	return nil
+}
@@ -5,13 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/mlukasik-dev/usersvc/internal/password"
 	"github.com/mlukasik-dev/usersvc/pkg/deref"
+	"github.com/mlukasik-dev/usersvc/pkg/filtering"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (s *Store) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
@@ -26,51 +28,43 @@ func (s *Store) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User,
 	return &user, nil
 }
 
-func (s *Store) CountUsers(ctx context.Context, filter *User) (int64, error) {
-	count, err := s.users.CountDocuments(ctx, filter.filter())
+func (s *Store) CountUsers(ctx context.Context, filter filtering.Expr) (int64, error) {
+	count, err := s.users.CountDocuments(ctx, mongoFilter(filter))
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (s *Store) ListUsers(ctx context.Context, filter *User, p *Pagination) ([]*User, error) {
-	var users []*User
-	cur, err := s.users.Find(ctx, filter.filter(), p.findOpts())
-	if err != nil {
-		return nil, err
-	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var u User
-		err := cur.Decode(&u)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, &u)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, err
+func (s *Store) ListUsers(ctx context.Context, filter filtering.Expr, order Order, p *Pagination) ([]*User, error) {
+	f := mongoFilter(filter)
+	if p != nil && p.After != nil {
+		f = bson.D{{Key: "$and", Value: bson.A{f, order.after(p.After)}}}
 	}
-	return users, err
+	return s.findUsers(ctx, f, p.findOpts().SetSort(order.sort()))
 }
 
 func (s *Store) CreateUser(ctx context.Context, user *User, password string) (*User, error) {
+	u := *user
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
 	result, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		if err := s.registerUser(sessCtx, user.Email, password); err != nil {
-			if mongo.IsDuplicateKeyError(err) {
-				return nil, ErrAlreadyExists
-			}
-			return nil, err
-		}
-		result, err := s.users.InsertOne(sessCtx, user)
+		result, err := s.users.InsertOne(sessCtx, &u)
 		if mongo.IsDuplicateKeyError(err) {
 			return nil, ErrAlreadyExists
 		}
 		if err != nil {
 			return nil, err
 		}
-		return s.GetUserByID(sessCtx, result.InsertedID.(primitive.ObjectID))
+		id := result.InsertedID.(primitive.ObjectID)
+		if err := s.registerUser(sessCtx, id, password); err != nil {
+			return nil, err
+		}
+		return s.GetUserByID(sessCtx, id)
 	})
 	if err != nil {
 		return nil, err
@@ -78,18 +72,29 @@ func (s *Store) CreateUser(ctx context.Context, user *User, password string) (*U
 	return result.(*User), err
 }
 
-func (s *Store) registerUser(ctx context.Context, email, password string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// registerUser sets password of a user, credentials are kept under the user's id,
+// so they don't change with its email. Its two-factor authentication is kept.
+func (s *Store) registerUser(ctx context.Context, id primitive.ObjectID, password string) error {
+	hash, err := s.hasher.Hash(password)
 	if err != nil {
 		return err
 	}
-	c := creds{Email: email, Password: hash}
-	_, err = s.creds.ReplaceOne(ctx, bson.D{{Key: "email", Value: email}}, c, options.Replace().SetUpsert(true))
+	_, err = s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hash}}}},
+		options.Update().SetUpsert(true))
 	return err
 }
 
 func (s *Store) UpdatePassword(ctx context.Context, email, oldPassword, newPassword string) error {
-	matches, err := s.matchesPassword(ctx, email, oldPassword)
+	// credentials of deleted users are kept until they are purged.
+	var u User
+	err := s.users.FindOne(ctx, bson.D{{Key: "email", Value: email}, notDeleted}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	matches, err := s.matchesPassword(ctx, u.ID, oldPassword)
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return ErrNotFound
 	}
@@ -99,23 +104,130 @@ func (s *Store) UpdatePassword(ctx context.Context, email, oldPassword, newPassw
 	if !matches {
 		return ErrInvalidCreds
 	}
-	return s.registerUser(ctx, email, newPassword)
+	return s.registerUser(ctx, u.ID, newPassword)
 }
 
-func (s *Store) matchesPassword(ctx context.Context, email, password string) (bool, error) {
+// GetUserByEmail returns a user which isn't deleted with the email.
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var u User
+	err := s.users.FindOne(ctx, bson.D{{Key: "email", Value: email}, notDeleted}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *Store) SetPassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// deleted users are not found, like by UpdatePassword.
+		err := s.users.FindOne(sessCtx, bson.D{{Key: "_id", Value: id}, notDeleted}).Err()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.registerUser(sessCtx, id, password)
+	})
+	return err
+}
+
+// Authenticate returns a user which isn't deleted with the login and password.
+func (s *Store) Authenticate(ctx context.Context, login, password string) (*User, error) {
+	field := "nickname"
+	if IsEmailLogin(login) {
+		field = "email"
+	}
+	var u User
+	err := s.users.FindOne(ctx, bson.D{{Key: field, Value: login}, notDeleted}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.matchesPassword(ctx, u.ID, password)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return &u, ErrInvalidCreds
+	}
+	return &u, nil
+}
+
+// matchesPassword reports whether a password matches the password of a user. When it does, but its hash was created
+// by another algorithm or with other parameters than the hasher's, it's replaced with a new hash.
+func (s *Store) matchesPassword(ctx context.Context, id primitive.ObjectID, pass string) (bool, error) {
 	var c creds
-	err := s.creds.FindOne(ctx, bson.D{{Key: "email", Value: email}}).Decode(&c)
+	err := s.creds.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&c)
 	if err != nil {
 		return false, err
 	}
-	if err = bcrypt.CompareHashAndPassword(c.Password, []byte(password)); err != nil {
-		return false, nil
+	matches, err := password.Verify(pass, c.Password)
+	if err != nil || !matches {
+		return false, err
+	}
+	if s.hasher.NeedsRehash(c.Password) {
+		hash, err := s.hasher.Hash(pass)
+		if err != nil {
+			return false, err
+		}
+		// the hash is replaced only if the password wasn't changed meanwhile.
+		_, err = s.creds.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "password", Value: c.Password}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hash}}}})
+		if err != nil {
+			return false, err
+		}
 	}
 	return true, nil
 }
 
-func (s *Store) UpdateUser(ctx context.Context, u *User, paths []string) (*User, error) {
-	_, err := s.users.UpdateOne(ctx, bson.D{{Key: "_id", Value: u.ID}}, u.update(paths))
+// notDeleted is a mongodb filter of users which aren't deleted.
+var notDeleted = bson.E{Key: "deletedAt", Value: nil}
+
+// userFilter creates a mongodb filter of a deleted or not deleted user with the version, unless it's AnyVersion.
+func userFilter(id primitive.ObjectID, version int64, deleted bool) bson.D {
+	filter := bson.D{{Key: "_id", Value: id}, notDeleted}
+	if deleted {
+		filter[1] = bson.E{Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}
+	}
+	switch version {
+	case AnyVersion:
+	case 0:
+		// users created before versions were introduced don't have them.
+		filter = append(filter, bson.E{Key: "version", Value: nil})
+	default:
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	return filter
+}
+
+// missingUser returns ErrNotFound when a user doesn't exist or is deleted, ErrNotDeleted when it isn't deleted
+// and deleted one was expected, and ErrVersionMismatch otherwise. It's called when a filter of userFilter didn't match.
+func (s *Store) missingUser(ctx context.Context, id primitive.ObjectID, deleted bool) error {
+	u, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.DeletedAt != nil && !deleted:
+		return ErrNotFound
+	case u.DeletedAt == nil && deleted:
+		return ErrNotDeleted
+	}
+	return ErrVersionMismatch
+}
+
+// UpdateUser updates a user, the version is checked by the update's filter, so it's atomic.
+func (s *Store) UpdateUser(ctx context.Context, u *User, paths []string, version int64) (*User, error) {
+	result, err := s.users.UpdateOne(ctx, userFilter(u.ID, version, false), u.update(paths, time.Now()))
 	if mongo.IsDuplicateKeyError(err) {
 		var e mongo.WriteException
 		if errors.As(err, &e) {
@@ -128,27 +240,81 @@ func (s *Store) UpdateUser(ctx context.Context, u *User, paths []string) (*User,
 			}
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, s.missingUser(ctx, u.ID, false)
+	}
+	return s.GetUserByID(ctx, u.ID)
+}
+
+// DeleteUser marks a user deleted, returns the deleted user.
+func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*User, error) {
+	now := time.Now()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: now}, {Key: "updatedAt", Value: now}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	return s.setDeleted(ctx, id, version, false, update)
+}
+
+// UndeleteUser restores a deleted user.
+func (s *Store) UndeleteUser(ctx context.Context, id primitive.ObjectID, version int64) (*User, error) {
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	return s.setDeleted(ctx, id, version, true, update)
+}
+
+// setDeleted applies update to a user which is deleted or not, and returns the updated user.
+func (s *Store) setDeleted(ctx context.Context, id primitive.ObjectID, version int64, deleted bool, update bson.D) (*User, error) {
+	var u User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.users.FindOneAndUpdate(ctx, userFilter(id, version, deleted), update, opts).Decode(&u)
 	if errors.Is(err, mongo.ErrNoDocuments) {
-		return nil, ErrNotFound
+		return nil, s.missingUser(ctx, id, deleted)
 	}
 	if err != nil {
 		return nil, err
 	}
-	return s.GetUserByID(ctx, u.ID)
+	return &u, nil
 }
 
-func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
-	_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		var u User
-		err := s.users.FindOneAndDelete(sessCtx, bson.D{{Key: "_id", Value: id}}).Decode(&u)
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, ErrNotFound
-		}
+// PurgeUsers removes users deleted before a given time, each in its own transaction with its credentials.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	deleted := bson.E{Key: "deletedAt", Value: bson.D{{Key: "$lt", Value: deletedBefore}}}
+	users, err := s.findUsers(ctx, bson.D{deleted},
+		options.Find().SetSort(bson.D{{Key: "deletedAt", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, u := range users {
+		result, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			// the user could be undeleted since it was found.
+			result, err := s.users.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: u.ID}, deleted})
+			if err != nil || result.DeletedCount == 0 {
+				return 0, err
+			}
+			if _, err := s.creds.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: u.ID}}); err != nil {
+				return 0, err
+			}
+			if _, err := s.refreshTokens.DeleteMany(sessCtx, bson.D{{Key: "userId", Value: u.ID}}); err != nil {
+				return 0, err
+			}
+			if _, err := s.oneTimeTokens.DeleteMany(sessCtx, bson.D{{Key: "userId", Value: u.ID}}); err != nil {
+				return 0, err
+			}
+			_, err = s.lockouts.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: UserLockoutKey(u.ID)}})
+			return 1, err
+		})
 		if err != nil {
-			return nil, err
+			return purged, err
 		}
-		_, err = s.creds.DeleteOne(sessCtx, bson.D{{Key: "email", Value: u.Email}})
-		return nil, err
-	})
-	return err
+		purged += result.(int)
+	}
+	return purged, nil
 }
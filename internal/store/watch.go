@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+)
+
+// EventStream streams events of the outbox in order of their commits.
+type EventStream interface {
+	// Next waits for the next event, it returns the event with a token which resumes the stream after it.
+	Next(ctx context.Context) (e *events.Event, resumeToken string, err error)
+	Close(ctx context.Context) error
+}
+
+// SequencedEvent is an event with its number in order of commits.
+type SequencedEvent struct {
+	*events.Event
+	Seq int64
+}
+
+// EventSequence is implemented by stores which number events in order of their commits,
+// so their events can be streamed by WatchSequence.
+type EventSequence interface {
+	// LastEventSeq returns the number of the last committed event, 0 when there were no events.
+	LastEventSeq(ctx context.Context) (int64, error)
+	// ListEventsAfter returns up to limit events numbered after seq, in order of their numbers.
+	ListEventsAfter(ctx context.Context, seq int64, limit int) ([]*SequencedEvent, error)
+}
+
+const (
+	// sequencePollInterval is how often sequence streams poll for new events.
+	sequencePollInterval = 500 * time.Millisecond
+	sequenceBatchSize    = 100
+)
+
+// WatchSequence returns a stream polling events of the sequence, see EventRepository.WatchEvents.
+// Resume tokens are numbers of events, they expire when their events are removed from the outbox.
+func WatchSequence(ctx context.Context, s EventSequence, resumeToken string) (EventStream, error) {
+	last, err := s.LastEventSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resumeToken == "" {
+		return &sequenceStream{s: s, after: last}, nil
+	}
+	seq, err := strconv.ParseInt(resumeToken, 10, 64)
+	if err != nil || seq <= 0 || seq > last {
+		return nil, ErrNotFound
+	}
+	evts, err := s.ListEventsAfter(ctx, seq-1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(evts) == 0 || evts[0].Seq != seq {
+		return nil, ErrExpired
+	}
+	return &sequenceStream{s: s, after: seq}, nil
+}
+
+type sequenceStream struct {
+	s     EventSequence
+	after int64
+	evts  []*SequencedEvent
+}
+
+func (st *sequenceStream) Next(ctx context.Context) (*events.Event, string, error) {
+	for len(st.evts) == 0 {
+		evts, err := st.s.ListEventsAfter(ctx, st.after, sequenceBatchSize)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(evts) > 0 {
+			st.evts = evts
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(sequencePollInterval):
+		}
+	}
+	e := st.evts[0]
+	st.evts = st.evts[1:]
+	st.after = e.Seq
+	return e.Event, strconv.FormatInt(e.Seq, 10), nil
+}
+
+func (st *sequenceStream) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,85 @@
+// +build unit
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequence is an EventSequence of events numbered from first.
+type sequence struct {
+	first int64
+	evts  []string
+}
+
+func (s *sequence) LastEventSeq(ctx context.Context) (int64, error) {
+	return s.first + int64(len(s.evts)) - 1, nil
+}
+
+func (s *sequence) ListEventsAfter(ctx context.Context, seq int64, limit int) ([]*SequencedEvent, error) {
+	var evts []*SequencedEvent
+	for i, id := range s.evts {
+		if n := s.first + int64(i); n > seq && len(evts) < limit {
+			evts = append(evts, &SequencedEvent{Event: &events.Event{ID: id}, Seq: n})
+		}
+	}
+	return evts, nil
+}
+
+func TestWatchSequence(t *testing.T) {
+	ctx := context.Background()
+	s := &sequence{first: 3, evts: []string{"a", "b", "c"}}
+
+	next := func(t *testing.T, st EventStream) (string, string) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		e, token, err := st.Next(ctx)
+		require.NoError(t, err)
+		return e.ID, token
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		st, err := WatchSequence(ctx, s, "3")
+		require.NoError(t, err)
+		id, token := next(t, st)
+		assert.Equal(t, "b", id)
+		assert.Equal(t, "4", token)
+		id, token = next(t, st)
+		assert.Equal(t, "c", id)
+		assert.Equal(t, "5", token)
+	})
+
+	t.Run("new events", func(t *testing.T) {
+		st, err := WatchSequence(ctx, s, "")
+		require.NoError(t, err)
+		short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, _, err = st.Next(short)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "only events after the call are streamed")
+
+		s := &sequence{first: s.first, evts: append(s.evts, "d")}
+		st, err = WatchSequence(ctx, s, "5")
+		require.NoError(t, err)
+		id, token := next(t, st)
+		assert.Equal(t, "d", id)
+		assert.Equal(t, "6", token)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, token := range []string{"-", "0", "6", "5f1b0c8e2a4f7c0001a1b2c3"} {
+			_, err := WatchSequence(ctx, s, token)
+			assert.ErrorIs(t, err, ErrNotFound, token)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, err := WatchSequence(ctx, s, "2")
+		assert.ErrorIs(t, err, ErrExpired)
+	})
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookDeliveryRetention is for how long delivery log is kept.
+const WebhookDeliveryRetention = 30 * 24 * time.Hour
+
+const duplicateKeyCode = 11000
+
+// Webhook is an endpoint subscribed to events.
+type Webhook struct {
+	ID  primitive.ObjectID `bson:"_id,omitempty"`
+	URL string             `bson:"url"`
+	// EventTypes are names of events sent to the webhook, all events when empty.
+	EventTypes []string `bson:"eventTypes"`
+	Secret     string   `bson:"secret"`
+	// ContentMode is a CloudEvents HTTP content mode, structured when empty.
+	ContentMode string    `bson:"contentMode"`
+	CreatedAt   time.Time `bson:"createdAt"`
+}
+
+// CloudEvents HTTP content modes of webhooks.
+const (
+	WebhookContentModeStructured = "structured"
+	WebhookContentModeBinary     = "binary"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a single event sent to a webhook, it's also an entry of the delivery log.
+type WebhookDelivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	WebhookID     primitive.ObjectID `bson:"webhookId"`
+	EventID       string             `bson:"eventId"`
+	EventName     string             `bson:"eventName"`
+	Payload       []byte             `bson:"payload"`
+	ContentType   string             `bson:"contentType"`
+	Status        string             `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	ResponseCode  int                `bson:"responseCode"`
+	LastError     string             `bson:"lastError"`
+	CreatedAt     time.Time          `bson:"createdAt"`
+	LastAttemptAt *time.Time         `bson:"lastAttemptAt"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt"`
+}
+
+func (s *Store) createWebhookIndexes(ctx context.Context) error {
+	// Each event is delivered to a webhook once, even if it was relayed more than once.
+	deliveriesUniqueEvent := mongo.IndexModel{
+		Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "eventId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	// Index used by the dispatcher to find pending deliveries.
+	deliveriesPending := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+	}
+
+	// Index used to list delivery log of a webhook.
+	deliveriesLog := mongo.IndexModel{
+		Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "_id", Value: -1}},
+	}
+
+	deliveriesTTL := mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(WebhookDeliveryRetention.Seconds())),
+	}
+
+	_, err := s.webhookDeliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		deliveriesUniqueEvent, deliveriesPending, deliveriesLog, deliveriesTTL,
+	})
+	return err
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *Webhook) (*Webhook, error) {
+	if w.EventTypes == nil {
+		// Stored as an empty array, so the webhook can be found by ListWebhooksByEvent.
+		w.EventTypes = []string{}
+	}
+	result, err := s.webhooks.InsertOne(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetWebhook(ctx, result.InsertedID.(primitive.ObjectID))
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+	var w Webhook
+	err := s.webhooks.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *Store) CountWebhooks(ctx context.Context) (int64, error) {
+	return s.webhooks.CountDocuments(ctx, bson.D{})
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, p *Pagination) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	cur, err := s.webhooks.Find(ctx, bson.D{}, p.findOpts().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// ListWebhooksByEvent returns all webhooks subscribed to the event.
+func (s *Store) ListWebhooksByEvent(ctx context.Context, eventName string) ([]*Webhook, error) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "eventTypes", Value: eventName}},
+		bson.D{{Key: "eventTypes", Value: bson.D{{Key: "$size", Value: 0}}}},
+	}}}
+	var webhooks []*Webhook
+	cur, err := s.webhooks.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes webhook together with its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		result, err := s.webhooks.DeleteOne(sessCtx, bson.D{{Key: "_id", Value: id}})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, ErrNotFound
+		}
+		_, err = s.webhookDeliveries.DeleteMany(sessCtx, bson.D{{Key: "webhookId", Value: id}})
+		return nil, err
+	})
+	return err
+}
+
+// AddWebhookDeliveries schedules deliveries, ignoring those which already exist for the same webhook and event.
+func (s *Store) AddWebhookDeliveries(ctx context.Context, ds []*WebhookDelivery) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(ds))
+	for i, d := range ds {
+		docs[i] = d
+	}
+	_, err := s.webhookDeliveries.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var e mongo.BulkWriteException
+	if errors.As(err, &e) && e.WriteConcernError == nil {
+		for _, we := range e.WriteErrors {
+			if we.Code != duplicateKeyCode {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries which are due,
+// and hides them from other dispatchers for the lease duration.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	var ds []*WebhookDelivery
+	for len(ds) < limit {
+		now := time.Now()
+		filter := bson.D{
+			{Key: "status", Value: WebhookDeliveryPending},
+			{Key: "nextAttemptAt", Value: bson.D{{Key: "$lte", Value: now}}},
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "nextAttemptAt", Value: now.Add(lease)}}}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}})
+		var d WebhookDelivery
+		err := s.webhookDeliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, &d)
+	}
+	return ds, nil
+}
+
+// SaveWebhookDeliveryAttempt stores an outcome of the last delivery attempt.
+func (s *Store) SaveWebhookDeliveryAttempt(ctx context.Context, d *WebhookDelivery) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: d.Status},
+		{Key: "attempts", Value: d.Attempts},
+		{Key: "responseCode", Value: d.ResponseCode},
+		{Key: "lastError", Value: d.LastError},
+		{Key: "lastAttemptAt", Value: d.LastAttemptAt},
+		{Key: "nextAttemptAt", Value: d.NextAttemptAt},
+	}}}
+	_, err := s.webhookDeliveries.UpdateOne(ctx, bson.D{{Key: "_id", Value: d.ID}}, update)
+	return err
+}
+
+func (s *Store) CountWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID) (int64, error) {
+	return s.webhookDeliveries.CountDocuments(ctx, bson.D{{Key: "webhookId", Value: webhookID}})
+}
+
+// ListWebhookDeliveries returns delivery log of a webhook, most recent deliveries first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, p *Pagination) ([]*WebhookDelivery, error) {
+	var ds []*WebhookDelivery
+	opts := p.findOpts().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cur, err := s.webhookDeliveries.Find(ctx, bson.D{{Key: "webhookId", Value: webhookID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
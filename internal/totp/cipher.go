@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrDecrypt is returned when a sealed secret can't be decrypted, e.g. it was sealed with another key.
+var ErrDecrypt = errors.New("can't decrypt secret")
+
+// Cipher encrypts secrets before they're stored with AES-256-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher with an AES-256 key derived from the key with SHA-256,
+// so the key can have any length, but it should be random.
+func NewCipher(key []byte) *Cipher {
+	sum := sha256.Sum256(key)
+	// neither fails with a 32 bytes key.
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &Cipher{aead}
+}
+
+// Seal returns an encrypted secret prefixed with its random nonce.
+func (c *Cipher) Seal(secret []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Open returns a secret encrypted by Seal.
+func (c *Cipher) Open(sealed []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, ErrDecrypt
+	}
+	secret, err := c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return secret, nil
+}
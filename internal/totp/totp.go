@@ -0,0 +1,104 @@
+// Package totp implements time-based one-time passwords of RFC 6238 as authenticator apps use them:
+// codes of 6 digits computed with HMAC-SHA1 for time steps of 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// Digits is the number of digits of codes.
+	Digits = 6
+	// Period is the duration of a time step.
+	Period = 30 * time.Second
+	// Skew is the number of time steps before and after the current one which codes are accepted too,
+	// so codes typed in at the end of their step or by devices with a drifted clock are valid.
+	Skew = 1
+	// SecretSize is the size of new secrets in bytes, as recommended for HMAC-SHA1.
+	SecretSize = 20
+)
+
+// b32 encodes secrets, authenticator apps expect base32 without padding.
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a new random secret.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns a secret encoded as authenticator apps accept it when it's entered manually.
+func EncodeSecret(secret []byte) string {
+	return b32.EncodeToString(secret)
+}
+
+// URI returns an otpauth URI of a secret of an account, e.g. an email, at the issuer,
+// authenticator apps add it when it's scanned as a QR code.
+func URI(secret []byte, issuer, account string) string {
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(Digits))
+	params.Set("period", strconv.Itoa(int(Period/time.Second)))
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + account, RawQuery: params.Encode()}
+	return u.String()
+}
+
+// Step returns the time step of a time.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of a secret for a time step.
+func Code(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation of RFC 4226.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// IsCode reports whether s has the format of codes, so it's not e.g. a recovery code.
+func IsCode(s string) bool {
+	if len(s) != Digits {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns the time step within Skew steps of the time which code of the secret is equal to code,
+// and false when there is no such step. Callers should accept each step once, so codes can't be replayed.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if !IsCode(code) {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
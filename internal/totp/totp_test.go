@@ -0,0 +1,82 @@
+// +build unit
+
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 secret of test vectors of RFC 6238, codes are the last 6 of their 8 digits.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		assert.Equal(t, tc.code, Code(rfcSecret, Step(time.Unix(tc.unix, 0))), tc.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := Validate(rfcSecret, "050471", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+	// codes of adjacent steps are valid too.
+	step, ok = Validate(rfcSecret, Code(rfcSecret, Step(now)-1), now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now)-1, step)
+	_, ok = Validate(rfcSecret, Code(rfcSecret, Step(now)+2), now)
+	assert.False(t, ok)
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		_, ok = Validate(rfcSecret, code, now)
+		assert.False(t, ok, code)
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, SecretSize)
+	u, err := url.Parse(URI(secret, "usersvc", "john@doe.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/usersvc:john@doe.com", u.Path)
+	assert.Equal(t, EncodeSecret(secret), u.Query().Get("secret"))
+	assert.Equal(t, "usersvc", u.Query().Get("issuer"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
+
+func TestCipher(t *testing.T) {
+	c := NewCipher([]byte("key"))
+	sealed, err := c.Seal(rfcSecret)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), string(rfcSecret))
+	other, err := c.Seal(rfcSecret)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, other, "nonces should be random")
+
+	secret, err := c.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, rfcSecret, secret)
+	_, err = NewCipher([]byte("other key")).Open(sealed)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	sealed[len(sealed)-1] ^= 1
+	_, err = c.Open(sealed)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = c.Open([]byte("short"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
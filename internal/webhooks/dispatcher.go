@@ -0,0 +1,166 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/backoff"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"go.uber.org/zap"
+)
+
+type DispatcherOptions struct {
+	// Interval between polls for pending deliveries.
+	Interval time.Duration
+	// BatchSize is a maximum number of deliveries claimed at once.
+	BatchSize int
+	// Lease is for how long claimed deliveries are hidden from other dispatchers.
+	Lease time.Duration
+	// Timeout of a single delivery request.
+	Timeout time.Duration
+	// MaxAttempts after which delivery is marked as failed.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound exponential delay between delivery attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o *DispatcherOptions) setDefaults() {
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Lease <= 0 {
+		o.Lease = time.Minute
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 10 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = time.Hour
+	}
+}
+
+// Dispatcher sends scheduled deliveries to webhooks as signed HTTP POST requests,
+// retrying failed ones with exponential backoff.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	logger *zap.Logger
+	opts   DispatcherOptions
+}
+
+func NewDispatcher(s Store, l *zap.Logger, opts DispatcherOptions) *Dispatcher {
+	opts.setDefaults()
+	return &Dispatcher{s, &http.Client{Timeout: opts.Timeout}, l, opts}
+}
+
+// Run sends deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		d.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		ds, err := d.store.ClaimWebhookDeliveries(ctx, d.opts.BatchSize, d.opts.Lease)
+		if err != nil {
+			d.logger.Error("failed to claim webhook deliveries", zap.Error(err))
+			return
+		}
+		for _, delivery := range ds {
+			d.deliver(ctx, delivery)
+		}
+		if len(ds) < d.opts.BatchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *store.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	w, err := d.store.GetWebhook(ctx, delivery.WebhookID)
+	if errors.Is(err, store.ErrNotFound) {
+		delivery.Status = store.WebhookDeliveryFailed
+		delivery.LastError = "webhook was deleted"
+	} else if err != nil {
+		d.logger.Error("failed to get webhook", zap.String("id", delivery.WebhookID.Hex()), zap.Error(err))
+		return
+	} else {
+		delivery.ResponseCode, err = d.post(ctx, w, delivery)
+		switch {
+		case err == nil:
+			delivery.Status = store.WebhookDeliverySucceeded
+			delivery.LastError = ""
+		case delivery.Attempts >= d.opts.MaxAttempts:
+			delivery.Status = store.WebhookDeliveryFailed
+			delivery.LastError = err.Error()
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextAttemptAt = now.Add(backoff.Exponential(delivery.Attempts-1, d.opts.MinBackoff, d.opts.MaxBackoff))
+		}
+	}
+
+	if err := d.store.SaveWebhookDeliveryAttempt(ctx, delivery); err != nil {
+		d.logger.Error("failed to save webhook delivery", zap.String("id", delivery.ID.Hex()), zap.Error(err))
+	}
+}
+
+// post sends a delivery in webhook's content mode, returns response status code if any.
+func (d *Dispatcher) post(ctx context.Context, w *store.Webhook, delivery *store.WebhookDelivery) (int, error) {
+	body := delivery.Payload
+	header := http.Header{"Content-Type": {delivery.ContentType}}
+	if w.ContentMode == store.WebhookContentModeBinary {
+		var e cloudevents.Event
+		if err := json.Unmarshal(delivery.Payload, &e); err != nil {
+			return 0, err
+		}
+		body, header = e.Data, e.Header()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = header
+	req.Header.Set(EventHeader, delivery.EventName)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, Sign(w.Secret, time.Now(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the body, so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,51 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+)
+
+var _ events.Sink = (*Sink)(nil)
+
+// Sink schedules deliveries of an event to all webhooks subscribed to it,
+// they are then sent by the Dispatcher.
+type Sink struct {
+	store Store
+}
+
+func NewSink(s Store) *Sink {
+	return &Sink{s}
+}
+
+// Send stores the event in structured mode, the Dispatcher converts it
+// to binary mode for webhooks that require it.
+func (s *Sink) Send(ctx context.Context, e *cloudevents.Event) error {
+	webhooks, err := s.store.ListWebhooksByEvent(ctx, e.Type)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	ds := make([]*store.WebhookDelivery, len(webhooks))
+	for i, w := range webhooks {
+		ds[i] = &store.WebhookDelivery{
+			WebhookID:     w.ID,
+			EventID:       e.ID,
+			EventName:     e.Type,
+			Payload:       payload,
+			ContentType:   cloudevents.ContentTypeJSON,
+			Status:        store.WebhookDeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		}
+	}
+	return s.store.AddWebhookDeliveries(ctx, ds)
+}
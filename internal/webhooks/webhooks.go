@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// SignatureHeader contains a timestamp and HMAC-SHA256 signature of the request body
+	// in the following format: t=<unix timestamp>,v1=<hex encoded signature>,
+	// where the signature is computed over "<unix timestamp>.<request body>".
+	SignatureHeader = "X-Usersvc-Signature"
+	// EventHeader contains name of the delivered event.
+	EventHeader = "X-Usersvc-Event"
+	// DeliveryHeader contains delivery id, which is the same for all retries of the delivery.
+	DeliveryHeader = "X-Usersvc-Delivery"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Store persists webhooks and their deliveries.
+type Store interface {
+	GetWebhook(ctx context.Context, id primitive.ObjectID) (*store.Webhook, error)
+	ListWebhooksByEvent(ctx context.Context, eventName string) ([]*store.Webhook, error)
+	AddWebhookDeliveries(ctx context.Context, ds []*store.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*store.WebhookDelivery, error)
+	SaveWebhookDeliveryAttempt(ctx context.Context, d *store.WebhookDelivery) error
+}
+
+// NewSecret generates a random secret for signing deliveries.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns value of the SignatureHeader for a payload sent at t.
+func Sign(secret string, t time.Time, payload []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac(secret, t.Unix(), payload)))
+}
+
+// Verify checks value of the SignatureHeader, signatures older than tolerance are rejected.
+// It's meant to be used by webhook consumers written in Go.
+func Verify(secret, header string, payload []byte, tolerance time.Duration) error {
+	var ts int64
+	var sig []byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		var err error
+		switch kv[0] {
+		case "t":
+			ts, err = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			sig, err = hex.DecodeString(kv[1])
+		}
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	}
+	if ts == 0 || sig == nil || time.Since(time.Unix(ts, 0)) > tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(sig, mac(secret, ts, payload)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret string, ts int64, payload []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", ts)
+	h.Write(payload)
+	return h.Sum(nil)
+}
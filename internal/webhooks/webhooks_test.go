@@ -0,0 +1,176 @@
+// +build unit
+
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/pkg/cloudevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestSignVerify(t *testing.T) {
+	payload := []byte(`{"id":"1"}`)
+	header := Sign("secret", time.Now(), payload)
+
+	assert.NoError(t, Verify("secret", header, payload, time.Minute))
+	assert.ErrorIs(t, Verify("other secret", header, payload, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", header, []byte(`{"id":"2"}`), time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", "v1=00", payload, time.Minute), ErrInvalidSignature)
+
+	old := Sign("secret", time.Now().Add(-time.Hour), payload)
+	assert.ErrorIs(t, Verify("secret", old, payload, time.Minute), ErrInvalidSignature)
+}
+
+type fakeStore struct {
+	mu         sync.Mutex
+	webhooks   []*store.Webhook
+	deliveries []*store.WebhookDelivery
+}
+
+func (s *fakeStore) GetWebhook(_ context.Context, id primitive.ObjectID) (*store.Webhook, error) {
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeStore) ListWebhooksByEvent(_ context.Context, eventName string) ([]*store.Webhook, error) {
+	var ws []*store.Webhook
+	for _, w := range s.webhooks {
+		if len(w.EventTypes) == 0 || w.EventTypes[0] == eventName {
+			ws = append(ws, w)
+		}
+	}
+	return ws, nil
+}
+
+func (s *fakeStore) AddWebhookDeliveries(_ context.Context, ds []*store.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range ds {
+		d.ID = primitive.NewObjectID()
+		s.deliveries = append(s.deliveries, d)
+	}
+	return nil
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(_ context.Context, limit int, lease time.Duration) ([]*store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ds []*store.WebhookDelivery
+	for _, d := range s.deliveries {
+		if len(ds) < limit && d.Status == store.WebhookDeliveryPending && !d.NextAttemptAt.After(time.Now()) {
+			d.NextAttemptAt = time.Now().Add(lease)
+			c := *d
+			ds = append(ds, &c)
+		}
+	}
+	return ds, nil
+}
+
+func (s *fakeStore) SaveWebhookDeliveryAttempt(_ context.Context, d *store.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i] = d
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) delivery(i int) store.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.deliveries[i]
+}
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		// Fail the first request.
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	s := &fakeStore{webhooks: []*store.Webhook{
+		{ID: primitive.NewObjectID(), URL: srv.URL, Secret: "secret"},
+		{ID: primitive.NewObjectID(), URL: srv.URL, EventTypes: []string{events.DeleteUserEvent}, Secret: "secret"},
+		{ID: primitive.NewObjectID(), URL: srv.URL, EventTypes: []string{events.CreateUserEvent}, Secret: "secret",
+			ContentMode: store.WebhookContentModeBinary},
+	}}
+	e := &cloudevents.Event{
+		ID:              "1",
+		Source:          events.DefaultSource,
+		Type:            events.CreateUserEvent,
+		Subject:         "1",
+		Time:            time.Now().UTC(),
+		DataContentType: events.ContentTypeJSON,
+		Data:            []byte(`{"id":"1"}`),
+	}
+	require.NoError(t, NewSink(s).Send(context.Background(), e))
+	require.Len(t, s.deliveries, 2)
+
+	d := NewDispatcher(s, zap.NewNop(), DispatcherOptions{
+		Interval:   5 * time.Millisecond,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		return s.delivery(0).Status == store.WebhookDeliverySucceeded &&
+			s.delivery(1).Status == store.WebhookDeliverySucceeded
+	}, time.Second, 5*time.Millisecond)
+	structured, binary := s.delivery(0), s.delivery(1)
+	assert.Equal(t, 2, structured.Attempts)
+	assert.Equal(t, 1, binary.Attempts)
+	assert.Equal(t, http.StatusOK, structured.ResponseCode)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 3)
+	for i, r := range requests[1:] {
+		body := bodies[i+1]
+		assert.Equal(t, events.CreateUserEvent, r.Header.Get(EventHeader))
+		assert.NoError(t, Verify("secret", r.Header.Get(SignatureHeader), body, time.Minute))
+		received, err := cloudevents.FromHTTP(r.Header, body)
+		require.NoError(t, err)
+		assert.Equal(t, e, received)
+
+		switch r.Header.Get(DeliveryHeader) {
+		case structured.ID.Hex():
+			assert.Equal(t, cloudevents.ContentTypeJSON, r.Header.Get("Content-Type"))
+		case binary.ID.Hex():
+			assert.Equal(t, events.ContentTypeJSON, r.Header.Get("Content-Type"))
+			assert.Equal(t, `{"id":"1"}`, string(body))
+		default:
+			t.Errorf("unexpected delivery: %s", r.Header.Get(DeliveryHeader))
+		}
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 
 	_ "embed"
 
@@ -13,9 +18,15 @@ import (
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	usersvcv1 "github.com/mlukasik-dev/usersvc/gen/go/usersvc/v1"
 	"github.com/mlukasik-dev/usersvc/internal/appconfig"
+	"github.com/mlukasik-dev/usersvc/internal/auth"
 	"github.com/mlukasik-dev/usersvc/internal/controller"
 	"github.com/mlukasik-dev/usersvc/internal/events"
+	"github.com/mlukasik-dev/usersvc/internal/notifier"
+	"github.com/mlukasik-dev/usersvc/internal/password"
+	"github.com/mlukasik-dev/usersvc/internal/purger"
 	"github.com/mlukasik-dev/usersvc/internal/store"
+	"github.com/mlukasik-dev/usersvc/internal/store/backend"
+	"github.com/mlukasik-dev/usersvc/internal/webhooks"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
@@ -35,27 +46,130 @@ func main() {
 	}
 	defer logger.Sync()
 
-	client, err := store.Connect(appconfig.AppConfig.Mongodb.URI)
+	hasher, err := newHasher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, closeStore, err := backend.Open(context.Background(), hasher)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeStore()
+
+	pageTokenKey := []byte(appconfig.AppConfig.Pagination.TokenKey)
+	if len(pageTokenKey) == 0 {
+		logger.Warn("pagination.token_key is not set, page tokens won't be valid after restart or on other instances")
+		pageTokenKey = make([]byte, 32)
+		if _, err := rand.Read(pageTokenKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	twoFactorKey := []byte(appconfig.AppConfig.Auth.TwoFactorKey)
+	if len(twoFactorKey) == 0 {
+		logger.Warn("auth.two_factor_key is not set, two-factor authentication won't work after restart or on other instances")
+	}
+
+	issuer, err := newIssuer(logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := newNotifier(logger)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(context.Background())
 
-	s := store.New(client)
-	if err := s.CreateIndexes(context.Background()); err != nil {
+	e := events.New(s)
+	ctr, err := controller.New(s, logger, e, controller.Options{
+		PageTokenKey:         pageTokenKey,
+		Tokens:               issuer,
+		Notifier:             n,
+		PasswordResetTTL:     appconfig.AppConfig.Auth.PasswordResetTTL,
+		EmailVerificationTTL: appconfig.AppConfig.Auth.EmailVerificationTTL,
+		PasswordPolicy:       passwordPolicy(),
+		Lockout:              lockoutOptions(),
+		TwoFactorKey:         twoFactorKey,
+		TwoFactorIssuer:      appconfig.AppConfig.Auth.Issuer,
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
-	e := events.New()
-	ctr := controller.New(s, logger, e)
+
+	var sinks events.MultiSink
+	for _, name := range appconfig.AppConfig.Events.Sinks {
+		sink, err := newSink(name, s, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	format := appconfig.AppConfig.Events.Format
+	if format != events.FormatJSON && format != events.FormatProtobuf {
+		log.Fatalf("unknown events format: %q", format)
+	}
+	relayCfg := appconfig.AppConfig.Events.Relay
+	relay := events.NewRelay(s, sinks, logger, events.RelayOptions{
+		Format:     format,
+		Source:     appconfig.AppConfig.Events.Source,
+		Interval:   relayCfg.Interval,
+		BatchSize:  relayCfg.BatchSize,
+		Lease:      relayCfg.Lease,
+		MinBackoff: relayCfg.MinBackoff,
+		MaxBackoff: relayCfg.MaxBackoff,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx)
+
+	webhooksCfg := appconfig.AppConfig.Webhooks
+	dispatcher := webhooks.NewDispatcher(s, logger, webhooks.DispatcherOptions{
+		Interval:    webhooksCfg.Interval,
+		BatchSize:   webhooksCfg.BatchSize,
+		Lease:       webhooksCfg.Lease,
+		Timeout:     webhooksCfg.Timeout,
+		MaxAttempts: webhooksCfg.MaxAttempts,
+		MinBackoff:  webhooksCfg.MinBackoff,
+		MaxBackoff:  webhooksCfg.MaxBackoff,
+	})
+	go dispatcher.Run(ctx)
+
+	purgeCfg := appconfig.AppConfig.Users.Purge
+	p := purger.New(s, logger, purger.Options{
+		Interval:  purgeCfg.Interval,
+		Retention: purgeCfg.Retention,
+		BatchSize: purgeCfg.BatchSize,
+	})
+	go p.Run(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle(auth.JWKSPath, issuer.JWKSHandler())
+	go func() {
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", appconfig.AppConfig.HTTPPort), mux))
+	}()
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", appconfig.AppConfig.Port))
 	if err != nil {
 		log.Fatal(err)
 	}
+	accounts, err := serviceAccounts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy := controller.Policy()
+	// reflection is public, so evans-cli can be used without a token.
+	policy["/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"] = auth.Rule{Public: true}
+	authorizer := auth.NewAuthorizer(issuer, accounts, s, policy)
 	grpcServer := grpc.NewServer(
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_recovery.UnaryServerInterceptor(),
 			grpc_zap.UnaryServerInterceptor(logger),
+			authorizer.UnaryServerInterceptor(),
+		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			grpc_recovery.StreamServerInterceptor(),
+			grpc_zap.StreamServerInterceptor(logger),
+			authorizer.StreamServerInterceptor(),
 		)),
 	)
 	usersvcv1.RegisterServiceServer(grpcServer, ctr)
@@ -64,3 +178,128 @@ func main() {
 	fmt.Printf("Listening at %s\n", lis.Addr().String())
 	log.Fatal(grpcServer.Serve(lis))
 }
+
+// newIssuer creates access tokens issuer with keys from the config.
+func newIssuer(logger *zap.Logger) (*auth.Issuer, error) {
+	cfg := appconfig.AppConfig.Auth
+	var keys []*rsa.PrivateKey
+	for _, path := range cfg.KeyFiles {
+		if path == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := auth.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		logger.Warn("auth.key_files is not set, access tokens won't be valid after restart or on other instances")
+		key, err := auth.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return auth.NewIssuer(keys, auth.Options{
+		Issuer:          cfg.Issuer,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+	})
+}
+
+// serviceAccounts reads service accounts from the file set in the config, if any.
+func serviceAccounts() ([]auth.ServiceAccount, error) {
+	path := appconfig.AppConfig.Auth.ServiceAccountsFile
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := auth.ParseServiceAccounts(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return accounts, nil
+}
+
+// newNotifier creates the notifier set in the config.
+func newNotifier(logger *zap.Logger) (notifier.Notifier, error) {
+	cfg := appconfig.AppConfig.Notifier
+	switch cfg.Driver {
+	case "":
+		return nil, errors.New("notifier.driver is not set")
+	case "log":
+		return notifier.NewLog(logger), nil
+	case "file":
+		return notifier.NewFile(cfg.Path), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier driver: %q", cfg.Driver)
+	}
+}
+
+// newHasher creates the password hasher set in the config.
+func newHasher() (password.Hasher, error) {
+	cfg := appconfig.AppConfig.Passwords
+	switch cfg.Hasher {
+	case "argon2id":
+		return password.Argon2id{
+			Time:       cfg.Argon2id.Time,
+			Memory:     cfg.Argon2id.Memory,
+			Threads:    cfg.Argon2id.Threads,
+			KeyLength:  cfg.Argon2id.KeyLength,
+			SaltLength: cfg.Argon2id.SaltLength,
+		}, nil
+	case "bcrypt":
+		return password.Bcrypt{Cost: cfg.Bcrypt.Cost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher: %q", cfg.Hasher)
+	}
+}
+
+// passwordPolicy returns the password policy set in the config.
+func passwordPolicy() *password.Policy {
+	cfg := appconfig.AppConfig.Passwords.Policy
+	return &password.Policy{
+		MinLength:        cfg.MinLength,
+		MaxLength:        cfg.MaxLength,
+		RequireLowercase: cfg.RequireLowercase,
+		RequireUppercase: cfg.RequireUppercase,
+		RequireDigit:     cfg.RequireDigit,
+		RequireSymbol:    cfg.RequireSymbol,
+		DisallowUserInfo: cfg.DisallowUserInfo,
+		DisallowCommon:   cfg.DisallowCommon,
+	}
+}
+
+// newSink creates events sink by its name from the config.
+func newSink(name string, s store.Repository, logger *zap.Logger) (events.Sink, error) {
+	switch name {
+	case "log":
+		return events.NewLogSink(logger), nil
+	case "webhooks":
+		return webhooks.NewSink(s), nil
+	default:
+		return nil, fmt.Errorf("unknown events sink: %q", name)
+	}
+}
+
+// lockoutOptions returns limits of failed password checks set in the config.
+func lockoutOptions() controller.LockoutOptions {
+	cfg := appconfig.AppConfig.Passwords.Lockout
+	return controller.LockoutOptions{
+		MaxFailures:       cfg.MaxFailures,
+		MaxClientFailures: cfg.MaxClientFailures,
+		FreeFailures:      cfg.FreeFailures,
+		Delay:             cfg.Delay,
+		MaxDelay:          cfg.MaxDelay,
+		Duration:          cfg.Duration,
+		Window:            cfg.Window,
+	}
+}
@@ -0,0 +1,16 @@
+package backoff
+
+import "time"
+
+// Exponential returns a delay before the next attempt, doubling min for every
+// previous failed attempt, but never exceeding max.
+func Exponential(attempts int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 0; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
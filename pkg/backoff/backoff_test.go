@@ -0,0 +1,18 @@
+// +build unit
+
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponential(t *testing.T) {
+	assert.Equal(t, time.Second, Exponential(0, time.Second, 10*time.Second))
+	assert.Equal(t, 2*time.Second, Exponential(1, time.Second, 10*time.Second))
+	assert.Equal(t, 8*time.Second, Exponential(3, time.Second, 10*time.Second))
+	assert.Equal(t, 10*time.Second, Exponential(4, time.Second, 10*time.Second))
+	assert.Equal(t, 10*time.Second, Exponential(100, time.Second, 10*time.Second))
+}
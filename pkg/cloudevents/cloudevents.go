@@ -0,0 +1,157 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SpecVersion = "1.0"
+	// ContentTypeJSON is a content type of events encoded in structured mode.
+	ContentTypeJSON = "application/cloudevents+json"
+)
+
+var ErrInvalidEvent = errors.New("invalid cloud event")
+
+// Event is a CloudEvents 1.0 event, see https://github.com/cloudevents/spec.
+type Event struct {
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	Data            []byte
+}
+
+type jsonEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// MarshalJSON encodes the event in structured mode, data is embedded as JSON
+// when its content type is JSON, otherwise it's base64 encoded.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	je := jsonEvent{
+		SpecVersion:     SpecVersion,
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		DataContentType: e.DataContentType,
+	}
+	if !e.Time.IsZero() {
+		je.Time = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if isJSON(e.DataContentType) {
+		je.Data = e.Data
+	} else {
+		je.DataBase64 = e.Data
+	}
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON decodes the event encoded in structured mode.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var je jsonEvent
+	if err := json.Unmarshal(b, &je); err != nil {
+		return err
+	}
+	if je.SpecVersion != SpecVersion {
+		return ErrInvalidEvent
+	}
+	*e = Event{
+		ID:              je.ID,
+		Source:          je.Source,
+		Type:            je.Type,
+		Subject:         je.Subject,
+		DataContentType: je.DataContentType,
+		Data:            je.DataBase64,
+	}
+	if je.Data != nil {
+		e.Data = je.Data
+	}
+	if je.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, je.Time)
+		if err != nil {
+			return ErrInvalidEvent
+		}
+		e.Time = t
+	}
+	return e.validate()
+}
+
+// Header returns HTTP headers of the binary content mode, data should be sent as the body.
+func (e *Event) Header() http.Header {
+	h := http.Header{}
+	h.Set("Ce-Specversion", SpecVersion)
+	h.Set("Ce-Id", e.ID)
+	h.Set("Ce-Source", e.Source)
+	h.Set("Ce-Type", e.Type)
+	if e.Subject != "" {
+		h.Set("Ce-Subject", e.Subject)
+	}
+	if !e.Time.IsZero() {
+		h.Set("Ce-Time", e.Time.UTC().Format(time.RFC3339Nano))
+	}
+	if e.DataContentType != "" {
+		h.Set("Content-Type", e.DataContentType)
+	}
+	return h
+}
+
+// FromHTTP decodes an event received over HTTP either in structured or binary content mode.
+func FromHTTP(h http.Header, body []byte) (*Event, error) {
+	var e Event
+	if mt, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mt == ContentTypeJSON {
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, err
+		}
+		return &e, nil
+	}
+	if h.Get("Ce-Specversion") != SpecVersion {
+		return nil, ErrInvalidEvent
+	}
+	e = Event{
+		ID:              h.Get("Ce-Id"),
+		Source:          h.Get("Ce-Source"),
+		Type:            h.Get("Ce-Type"),
+		Subject:         h.Get("Ce-Subject"),
+		DataContentType: h.Get("Content-Type"),
+		Data:            body,
+	}
+	if t := h.Get("Ce-Time"); t != "" {
+		var err error
+		if e.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, ErrInvalidEvent
+		}
+	}
+	return &e, e.validate()
+}
+
+// validate checks that required attributes are present.
+func (e *Event) validate() error {
+	if e.ID == "" || e.Source == "" || e.Type == "" {
+		return ErrInvalidEvent
+	}
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(contentType string, data []byte) *Event {
+	return &Event{
+		ID:              "1",
+		Source:          "/usersvc",
+		Type:            "faceit.usersvc.v1.users.create",
+		Subject:         "60b7c3f4e1d3c2a1b0a9f8e7",
+		Time:            time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		DataContentType: contentType,
+		Data:            data,
+	}
+}
+
+func TestEvent_MarshalJSON(t *testing.T) {
+	t.Run("json data", func(t *testing.T) {
+		e := testEvent("application/json", []byte(`{"id":"1"}`))
+		b, err := json.Marshal(e)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"specversion": "1.0",
+			"id": "1",
+			"source": "/usersvc",
+			"type": "faceit.usersvc.v1.users.create",
+			"subject": "60b7c3f4e1d3c2a1b0a9f8e7",
+			"time": "2021-06-01T12:00:00Z",
+			"datacontenttype": "application/json",
+			"data": {"id": "1"}
+		}`, string(b))
+
+		var decoded Event
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		assert.Equal(t, e.Time.Unix(), decoded.Time.Unix())
+		decoded.Time = e.Time
+		assert.Equal(t, e, &decoded)
+	})
+
+	t.Run("binary data", func(t *testing.T) {
+		e := testEvent("application/protobuf", []byte{0x0a, 0x01, 0x31})
+		b, err := json.Marshal(e)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), `"data_base64":"CgEx"`)
+
+		var decoded Event
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		assert.Equal(t, e.Data, decoded.Data)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var e Event
+		assert.ErrorIs(t, json.Unmarshal([]byte(`{"specversion":"0.3","id":"1","source":"/","type":"t"}`), &e), ErrInvalidEvent)
+		assert.ErrorIs(t, json.Unmarshal([]byte(`{"specversion":"1.0","id":"1","source":"/"}`), &e), ErrInvalidEvent)
+	})
+}
+
+func TestEvent_Header(t *testing.T) {
+	e := testEvent("application/json", []byte(`{"id":"1"}`))
+	h := e.Header()
+	assert.Equal(t, "1.0", h.Get("ce-specversion"))
+	assert.Equal(t, "faceit.usersvc.v1.users.create", h.Get("ce-type"))
+	assert.Equal(t, "2021-06-01T12:00:00Z", h.Get("ce-time"))
+	assert.Equal(t, "application/json", h.Get("content-type"))
+
+	decoded, err := FromHTTP(h, e.Data)
+	require.NoError(t, err)
+	assert.Equal(t, e, decoded)
+}
+
+func TestFromHTTP(t *testing.T) {
+	e := testEvent("application/json", []byte(`{"id":"1"}`))
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+	h := http.Header{}
+	h.Set("Content-Type", ContentTypeJSON+"; charset=utf-8")
+	decoded, err := FromHTTP(h, b)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, decoded.ID)
+	assert.Equal(t, e.Data, decoded.Data)
+
+	_, err = FromHTTP(http.Header{}, []byte(`{}`))
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
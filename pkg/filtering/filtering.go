@@ -0,0 +1,352 @@
+// Package filtering parses filter expressions in the syntax of https://google.aip.dev/160, e.g.
+//
+//	country = PL OR country = DE AND NOT nickname:pro
+//
+// Restrictions compare a field with a value using one of =, !=, <, <=, >, >= or : (has) operators.
+// Values are bare words or double quoted strings. Restrictions can be negated with NOT or -,
+// combined with AND (also implied by a whitespace) and OR, which binds tighter than AND,
+// and grouped with parentheses.
+package filtering
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Op is a comparison operator of a restriction.
+type Op string
+
+const (
+	Equal          Op = "="
+	NotEqual       Op = "!="
+	Less           Op = "<"
+	LessOrEqual    Op = "<="
+	Greater        Op = ">"
+	GreaterOrEqual Op = ">="
+	// Has tests whether a field starts with the value, or whether it's set when value is *.
+	Has Op = ":"
+)
+
+// Expr is a node of a filter expression.
+type Expr interface {
+	String() string
+}
+
+// And matches when both expressions match.
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches when any of expressions matches.
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches when expression doesn't match.
+type Not struct {
+	Expr Expr
+}
+
+// Restriction compares a field with a value. For Has operator with * value, Value is empty.
+type Restriction struct {
+	Field string
+	Op    Op
+	Value string
+	// Pos and ValuePos are positions of the field and of the value in the filter, starting from 1.
+	Pos      int
+	ValuePos int
+}
+
+func (e *And) String() string { return "(" + e.Left.String() + " AND " + e.Right.String() + ")" }
+func (e *Or) String() string  { return "(" + e.Left.String() + " OR " + e.Right.String() + ")" }
+func (e *Not) String() string { return "NOT " + e.Expr.String() }
+
+func (e *Restriction) String() string {
+	if e.Op == Has && e.Value == "" {
+		return e.Field + ":*"
+	}
+	return e.Field + " " + string(e.Op) + " " + strconv.Quote(e.Value)
+}
+
+// AndAll joins expressions with AND, skipping nil ones. Returns nil when all of them are nil.
+func AndAll(exprs ...Expr) Expr {
+	var result Expr
+	for _, e := range exprs {
+		switch {
+		case e == nil:
+		case result == nil:
+			result = e
+		default:
+			result = &And{result, e}
+		}
+	}
+	return result
+}
+
+// Error is a syntax error or an invalid restriction at a position of the filter, starting from 1.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Parse parses a filter, returns nil expression for an empty filter
+// and *Error when the filter is invalid.
+func Parse(filter string) (Expr, error) {
+	p := &parser{lexer: lexer{src: []rune(filter)}}
+	p.next()
+	if p.err == nil && p.tok.kind == tokEOF {
+		return nil, nil
+	}
+	e, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil || p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokText
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokMinus
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src []rune
+	pos int
+	// afterOp is set after an operator, when - starts a value instead of negating a term.
+	afterOp bool
+}
+
+// scan returns the next token.
+func (l *lexer) scan() (token, error) {
+	tok, err := l.scanToken()
+	l.afterOp = tok.kind == tokOp
+	return tok, err
+}
+
+func (l *lexer) scanToken() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	tok := token{pos: start + 1}
+	if l.pos == len(l.src) {
+		return tok, nil
+	}
+	switch r := l.src[l.pos]; {
+	case r == '(':
+		l.pos++
+		tok.kind = tokLParen
+	case r == ')':
+		l.pos++
+		tok.kind = tokRParen
+	case r == '-' && !l.afterOp:
+		l.pos++
+		tok.kind = tokMinus
+	case r == '=' || r == ':':
+		l.pos++
+		tok.kind, tok.text = tokOp, string(r)
+	case r == '<' || r == '>' || r == '!':
+		l.pos++
+		if l.pos < len(l.src) && l.src[l.pos] == '=' {
+			l.pos++
+		}
+		tok.kind, tok.text = tokOp, string(l.src[start:l.pos])
+		if tok.text == "!" {
+			return tok, &Error{tok.pos, `unexpected "!"`}
+		}
+	case r == '"':
+		var b strings.Builder
+		for l.pos++; ; l.pos++ {
+			if l.pos == len(l.src) {
+				return tok, &Error{tok.pos, "unterminated string"}
+			}
+			r := l.src[l.pos]
+			if r == '"' {
+				l.pos++
+				break
+			}
+			if r == '\\' {
+				l.pos++
+				if l.pos == len(l.src) {
+					return tok, &Error{tok.pos, "unterminated string"}
+				}
+				r = l.src[l.pos]
+			}
+			b.WriteRune(r)
+		}
+		tok.kind, tok.text = tokString, b.String()
+	default:
+		for l.pos < len(l.src) && !strings.ContainsRune(` ()"=:<>!`, l.src[l.pos]) && !unicode.IsSpace(l.src[l.pos]) {
+			l.pos++
+		}
+		tok.kind, tok.text = tokText, string(l.src[start:l.pos])
+	}
+	return tok, nil
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+	err   error
+}
+
+func (p *parser) next() {
+	if p.err == nil {
+		p.tok, p.err = p.lexer.scan()
+	}
+}
+
+func (p *parser) keyword(kw string) bool {
+	return p.tok.kind == tokText && p.tok.text == kw
+}
+
+func (p *parser) unexpected() error {
+	if p.err != nil {
+		return p.err
+	}
+	switch p.tok.kind {
+	case tokEOF:
+		return &Error{p.tok.pos, "unexpected end of filter"}
+	case tokLParen:
+		return &Error{p.tok.pos, `unexpected "("`}
+	case tokRParen:
+		return &Error{p.tok.pos, `unexpected ")"`}
+	case tokMinus:
+		return &Error{p.tok.pos, `unexpected "-"`}
+	}
+	return &Error{p.tok.pos, fmt.Sprintf("unexpected %q", p.tok.text)}
+}
+
+// expression = sequence {"AND" sequence}
+func (p *parser) expression() (Expr, error) {
+	e, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		p.next()
+		right, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		e = &And{e, right}
+	}
+	return e, nil
+}
+
+// sequence = factor {factor}, factors are implicitly joined with AND.
+func (p *parser) sequence() (Expr, error) {
+	e, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.err == nil && p.tok.kind != tokEOF && p.tok.kind != tokRParen && !p.keyword("AND") {
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		e = &And{e, right}
+	}
+	return e, nil
+}
+
+// factor = term {"OR" term}
+func (p *parser) factor() (Expr, error) {
+	e, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		e = &Or{e, right}
+	}
+	return e, nil
+}
+
+// term = ["NOT" | "-"] simple
+func (p *parser) term() (Expr, error) {
+	if p.keyword("NOT") || p.tok.kind == tokMinus {
+		p.next()
+		e, err := p.simple()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{e}, nil
+	}
+	return p.simple()
+}
+
+// simple = restriction | "(" expression ")"
+func (p *parser) simple() (Expr, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind == tokLParen {
+		p.next()
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return e, nil
+	}
+	return p.restriction()
+}
+
+// restriction = field op value
+func (p *parser) restriction() (Expr, error) {
+	if p.tok.kind != tokText || p.keyword("AND") || p.keyword("OR") || p.keyword("NOT") {
+		return nil, p.unexpected()
+	}
+	r := &Restriction{Field: p.tok.text, Pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tokOp {
+		if p.err == nil && p.tok.kind == tokEOF {
+			return nil, &Error{p.tok.pos, "expected an operator"}
+		}
+		return nil, p.unexpected()
+	}
+	r.Op = Op(p.tok.text)
+	p.next()
+	if p.tok.kind != tokText && p.tok.kind != tokString {
+		if p.err == nil && p.tok.kind == tokEOF {
+			return nil, &Error{p.tok.pos, "expected a value"}
+		}
+		return nil, p.unexpected()
+	}
+	r.Value, r.ValuePos = p.tok.text, p.tok.pos
+	if r.Op == Has && p.tok.kind == tokText && r.Value == "*" {
+		r.Value = ""
+	}
+	p.next()
+	return r, nil
+}
@@ -0,0 +1,65 @@
+// +build unit
+
+package filtering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	valid := map[string]string{
+		"":                     "<nil>",
+		"country=PL":           `country = "PL"`,
+		`email = "a b\"c"`:     `email = "a b\"c"`,
+		"nickname:*":           "nickname:*",
+		`nickname:"*"`:         `nickname : "*"`,
+		"id>=60a7 id < 60b0":   `(id >= "60a7" AND id < "60b0")`,
+		"last_name != Doe":     `last_name != "Doe"`,
+		"email=jan-k@x.com":    `email = "jan-k@x.com"`,
+		"NOT a=1 AND -b:2":     `(NOT a = "1" AND NOT b : "2")`,
+		"a=1 OR b=2 AND c=3":   `((a = "1" OR b = "2") AND c = "3")`,
+		"a=1 OR (b=2 AND c=3)": `(a = "1" OR (b = "2" AND c = "3"))`,
+		"a = -1":               `a = "-1"`,
+		"(((a=1)))":            `a = "1"`,
+	}
+	for filter, want := range valid {
+		e, err := Parse(filter)
+		require.NoError(t, err, filter)
+		if e == nil {
+			assert.Equal(t, want, "<nil>", filter)
+			continue
+		}
+		assert.Equal(t, want, e.String(), filter)
+	}
+
+	invalid := map[string]*Error{
+		"country":        {8, "expected an operator"},
+		"country =":      {10, "expected a value"},
+		"country = PL)":  {13, `unexpected ")"`},
+		"(country = PL":  {14, "unexpected end of filter"},
+		"a=1 AND":        {8, "unexpected end of filter"},
+		"a=1 OR OR b=1":  {8, `unexpected "OR"`},
+		`email = "x`:     {9, "unterminated string"},
+		"a ! b":          {3, `unexpected "!"`},
+		"a=1 !":          {5, `unexpected "!"`},
+		"= 1":            {1, `unexpected "="`},
+		"NOT":            {4, "unexpected end of filter"},
+		"żółw = 1 AND (": {15, "unexpected end of filter"},
+	}
+	for filter, want := range invalid {
+		_, err := Parse(filter)
+		assert.Equal(t, want, err, filter)
+	}
+}
+
+func TestAndAll(t *testing.T) {
+	assert.Nil(t, AndAll())
+	assert.Nil(t, AndAll(nil, nil))
+	a := &Restriction{Field: "a", Op: Equal, Value: "1"}
+	b := &Restriction{Field: "b", Op: Equal, Value: "2"}
+	assert.Equal(t, a, AndAll(nil, a))
+	assert.Equal(t, &And{a, b}, AndAll(a, nil, b))
+}
@@ -0,0 +1,55 @@
+// Package pagetoken encodes pagination cursors into opaque page tokens.
+// Tokens are signed with HMAC-SHA256, so clients can neither forge nor alter them.
+package pagetoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalid is returned when a token is malformed or its signature doesn't match.
+var ErrInvalid = errors.New("invalid page token")
+
+// Codec encodes and decodes tokens signed with its key.
+type Codec struct {
+	key []byte
+}
+
+// New creates a codec signing tokens with a given key.
+func New(key []byte) *Codec {
+	return &Codec{key: key}
+}
+
+// Encode returns a token carrying v encoded as JSON.
+func (c *Codec) Encode(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, c.sign(payload)...)), nil
+}
+
+// Decode verifies the token and decodes its content into v.
+func (c *Codec) Decode(token string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < sha256.Size {
+		return ErrInvalid
+	}
+	payload, sig := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(sig, c.sign(payload)) {
+		return ErrInvalid
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (c *Codec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,47 @@
+// +build unit
+
+package pagetoken
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cursor struct {
+	ID string `json:"id"`
+}
+
+func TestCodec(t *testing.T) {
+	c := New([]byte("secret"))
+	token, err := c.Encode(cursor{ID: "60a7b1f2c3d4e5f601234567"})
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		var got cursor
+		require.NoError(t, c.Decode(token, &got))
+		assert.Equal(t, "60a7b1f2c3d4e5f601234567", got.ID)
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		var got cursor
+		assert.ErrorIs(t, New([]byte("other")).Decode(token, &got), ErrInvalid)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		require.NoError(t, err)
+		b[len(`{"id":"`)] = '7'
+		var got cursor
+		assert.ErrorIs(t, c.Decode(base64.RawURLEncoding.EncodeToString(b), &got), ErrInvalid)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		var got cursor
+		assert.ErrorIs(t, c.Decode("", &got), ErrInvalid)
+		assert.ErrorIs(t, c.Decode("not a token!", &got), ErrInvalid)
+		assert.ErrorIs(t, c.Decode(token[:10], &got), ErrInvalid)
+	})
+}
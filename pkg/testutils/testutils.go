@@ -2,33 +2,24 @@ package testutils
 
 import (
 	"context"
-
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readconcern"
-	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"errors"
 )
 
-// WithAbortedTransaction is a helper wrapper that allows to perform idempotent actions on the mongoDB.
-func WithAbortedTransaction(ctx context.Context, client *mongo.Client, f func(ctx context.Context)) {
-	wc := writeconcern.New(writeconcern.WMajority())
-	rc := readconcern.Snapshot()
-	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+// Transactor is implemented by stores supporting transactions.
+type Transactor interface {
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
 
-	session, err := client.StartSession()
-	if err != nil {
-		panic(err)
-	}
-	defer session.EndSession(ctx)
+var errAborted = errors.New("transaction aborted")
 
-	mongo.WithSession(ctx, session, func(sessCtx mongo.SessionContext) error {
-		if err = session.StartTransaction(txnOpts); err != nil {
-			panic(err)
-		}
-		f(sessCtx)
-		if err = session.AbortTransaction(sessCtx); err != nil {
-			panic(err)
-		}
-		return nil
+// WithAbortedTransaction is a helper wrapper that allows to perform idempotent actions on the store,
+// changes made by f are always rolled back.
+func WithAbortedTransaction(ctx context.Context, t Transactor, f func(ctx context.Context)) {
+	err := t.RunInTransaction(ctx, func(ctx context.Context) error {
+		f(ctx)
+		return errAborted
 	})
+	if !errors.Is(err, errAborted) {
+		panic(err)
+	}
 }